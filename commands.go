@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/joho/godotenv"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/services"
+)
+
+const commandUsage = `commands:
+  tms-backend migrate [up]                   apply pending schema migrations
+  tms-backend migrate down <version>         revert migrations newer than version
+  tms-backend migrate status                 list migrations and when they were applied
+  tms-backend restore-archive <file.csv.gz>  re-import an archived temp_log month
+  tms-backend create-admin <user> <password> add an admin login (e.g. a new standalone SQLite site)`
+
+// runCommand runs a maintenance command from the command line and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:])
+
+	case "restore-archive":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: tms-backend restore-archive <file.csv.gz>")
+			return 2
+		}
+		// Resolve the file before moving to the exe directory for .env
+		path, err := filepath.Abs(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := connectForCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := database.Migrate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		read, inserted, err := services.NewRetentionService(database.DB, nil).RestoreArchive(path, "command line")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "restore failed after %d rows: %v\n", read, err)
+			return 1
+		}
+		fmt.Printf("Restored %d rows (%d already present) from %s\n", inserted, read-inserted, path)
+		return 0
+
+	case "create-admin":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "usage: tms-backend create-admin <username> <password>")
+			return 2
+		}
+		if len(args[2]) < 8 {
+			fmt.Fprintln(os.Stderr, "password must be at least 8 characters")
+			return 2
+		}
+		if err := connectForCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := database.Migrate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		hash, err := services.HashPassword(args[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		role := "admin"
+		user := models.MasterUser{Username: args[1], Password: hash, Role: &role}
+		if err := database.DB.Create(&user).Error; err != nil {
+			if database.IsDuplicateKey(err) {
+				fmt.Fprintf(os.Stderr, "user %s already exists\n", args[1])
+				return 1
+			}
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Created admin %s\n", user.Username)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n%s\n", args[0], commandUsage)
+	return 2
+}
+
+// runMigrate handles "migrate [up | down <version> | status]"
+func runMigrate(args []string) int {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	version := 0
+	switch {
+	case action == "down" && len(args) == 2:
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			fmt.Fprintln(os.Stderr, "version must be a number (0 reverts everything)")
+			return 2
+		}
+		version = v
+	case (action == "up" || action == "status") && len(args) <= 1:
+	default:
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+
+	if err := connectForCommand(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var err error
+	switch action {
+	case "up":
+		err = database.Migrate()
+	case "down":
+		err = database.MigrateDown(version)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	states, err := database.MigrationStatus()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, s := range states {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%03d  %-45s %s\n", s.Version, s.Name, applied)
+	}
+	return 0
+}
+
+// connectForCommand loads .env from the exe directory and connects to the database
+func connectForCommand() error {
+	if err := changeToExeDir(); err != nil {
+		return err
+	}
+	godotenv.Load()
+	if err := database.SetupSiteTimezone(); err != nil {
+		return err
+	}
+	if err := database.Connect(); err != nil {
+		return fmt.Errorf("database connection failed: %w", err)
+	}
+	return nil
+}
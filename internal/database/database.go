@@ -4,16 +4,145 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
 var DB *gorm.DB
 
+// Supported DB_DRIVER values
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// gormConfig is shared by every driver
+func gormConfig() *gorm.Config {
+	return &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Info),
+		// Don't add default timestamp values
+		NowFunc: SiteNow,
+	}
+}
+
+// Connect opens the database selected by DB_DRIVER: mysql (default, the legacy
+// MariaDB/MySQL server from DB_HOST, DB_NAME...), postgres (same DB_* settings)
+// or sqlite (a local file at DB_PATH)
 func Connect() error {
+	switch driver := strings.ToLower(os.Getenv("DB_DRIVER")); driver {
+	case "", DriverMySQL:
+		return connectMySQL()
+	case DriverPostgres, "postgresql":
+		return connectPostgres()
+	case DriverSQLite:
+		return connectSQLite()
+	default:
+		return fmt.Errorf("unsupported DB_DRIVER %q (use mysql, postgres or sqlite)", driver)
+	}
+}
+
+// connectPostgres opens the PostgreSQL server from DB_HOST, DB_PORT (default 5432),
+// DB_USER, DB_PASSWORD, DB_NAME and DB_SSLMODE (default disable)
+func connectPostgres() error {
+	host := os.Getenv("DB_HOST")
+	user := os.Getenv("DB_USER")
+	password := os.Getenv("DB_PASSWORD")
+	dbname := os.Getenv("DB_NAME")
+	port := os.Getenv("DB_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	sslmode := os.Getenv("DB_SSLMODE")
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+
+	// Datetimes are stored as timestamptz; TimeZone only affects how the server renders and
+	// truncates them (hour/day buckets), so it must be the site's zone
+	dsn := postgresDSN(map[string]string{
+		"host": host, "port": port, "user": user, "password": password,
+		"dbname": dbname, "sslmode": sslmode, "TimeZone": SiteLocation().String(),
+	})
+	log.Printf("Connecting to PostgreSQL database: %s@%s:%s/%s (sslmode=%s)", user, host, port, dbname, sslmode)
+
+	var err error
+	DB, err = gorm.Open(postgres.Open(dsn), gormConfig())
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL database: %w", err)
+	}
+
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	if err := sqlDB.Ping(); err != nil {
+		return fmt.Errorf("failed to ping PostgreSQL database: %w", err)
+	}
+	sqlDB.SetMaxIdleConns(10)
+	sqlDB.SetMaxOpenConns(100)
+	sqlDB.SetConnMaxLifetime(time.Hour)
+
+	log.Println("Database connected successfully")
+	return nil
+}
+
+// postgresDSN builds a key=value connection string. Every value is quoted so passwords and
+// names with spaces, quotes or backslashes reach the server unchanged.
+func postgresDSN(settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s='%s'", key, quote.Replace(settings[key]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// connectSQLite opens (or creates) the standalone database file. Migrations create every
+// table, including the ones that normally come from the legacy PHP system.
+func connectSQLite() error {
+	path := os.Getenv("DB_PATH")
+	if path == "" {
+		path = "tms.db"
+	}
+	log.Printf("Connecting to SQLite database: %s", path)
+
+	// WAL lets reports read while polling writes; busy_timeout waits out short write locks
+	dsn := path + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	var err error
+	DB, err = gorm.Open(sqlite.Open(dsn), gormConfig())
+	if err != nil {
+		return fmt.Errorf("failed to open SQLite database %s: %w", path, err)
+	}
+
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	if err := sqlDB.Ping(); err != nil {
+		return fmt.Errorf("failed to open SQLite database %s: %w", path, err)
+	}
+	sqlDB.SetMaxIdleConns(4)
+	sqlDB.SetConnMaxLifetime(time.Hour)
+
+	log.Println("Database connected successfully")
+	return nil
+}
+
+func connectMySQL() error {
 	host := os.Getenv("DB_HOST")
 	user := os.Getenv("DB_USER")
 	password := os.Getenv("DB_PASSWORD")
@@ -34,7 +163,7 @@ func Connect() error {
 
 	// Build DSN with explicit charset to avoid mismatch issues
 	// parseTime=True: Parse datetime to time.Time
-	// loc=Local: Use local timezone
+	// loc=Local: DATETIME values are site time (SetupSiteTimezone makes it the local zone)
 	// charset: Set connection charset
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=True&loc=Local&charset=%s",
 		user, password, host, port, dbname, charset)
@@ -52,13 +181,7 @@ func Connect() error {
 		// Don't reject zero dates, let them pass through
 		DontSupportRenameIndex:  true,
 		DontSupportRenameColumn: true,
-	}), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-		// Don't add default timestamp values
-		NowFunc: func() time.Time {
-			return GetThailandTime()
-		},
-	})
+	}), gormConfig())
 
 	if err != nil {
 		// Don't use utils.LogError here to avoid import cycle
@@ -108,15 +231,3 @@ func Connect() error {
 	log.Println("Database connected successfully")
 	return nil
 }
-
-// GetThailandTime returns current time in Thailand timezone (+7)
-func GetThailandTime() time.Time {
-	// Try to load Asia/Bangkok timezone
-	loc, err := time.LoadLocation("Asia/Bangkok")
-	if err != nil {
-		// Fallback: Use fixed offset UTC+7 for Thailand
-		// This works on Windows where timezone database might not be available
-		loc = time.FixedZone("Thailand", 7*60*60)
-	}
-	return time.Now().In(loc)
-}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestSQLite connects DB to a fresh SQLite file for the duration of the test
+func openTestSQLite(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "tms.db"))
+
+	previous := DB
+	if err := connectSQLite(); err != nil {
+		t.Fatalf("open SQLite: %v", err)
+	}
+	DB = DB.Session(&gorm.Session{Logger: logger.Discard})
+	t.Cleanup(func() {
+		if sqlDB, err := DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+		DB = previous
+	})
+}
+
+func TestPostgresDSNQuotesValues(t *testing.T) {
+	password := `p@ss word' \x=1`
+	dsn := postgresDSN(map[string]string{
+		"host": "db.local", "port": "5432", "user": "tms", "password": password,
+		"dbname": "tms test", "sslmode": "disable", "TimeZone": "Asia/Bangkok",
+	})
+
+	config, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("parse %q: %v", dsn, err)
+	}
+	if config.Password != password || config.Database != "tms test" || config.User != "tms" || config.Host != "db.local" {
+		t.Errorf("parsed %q as user=%q password=%q dbname=%q host=%q", dsn, config.User, config.Password, config.Database, config.Host)
+	}
+	if tz := config.RuntimeParams["TimeZone"]; tz != "Asia/Bangkok" {
+		t.Errorf("TimeZone = %q", tz)
+	}
+}
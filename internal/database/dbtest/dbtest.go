@@ -0,0 +1,96 @@
+// Package dbtest connects database.DB to a throwaway database for integration tests.
+//
+// Run executes a test once per driver. SQLite always runs on a fresh temporary file. MySQL
+// and PostgreSQL run when TEST_DB_DRIVER names them, against the server in the usual DB_HOST,
+// DB_PORT, DB_USER, DB_PASSWORD and DB_NAME settings, and are skipped otherwise:
+//
+//	TEST_DB_DRIVER=postgres DB_HOST=localhost DB_USER=tms DB_PASSWORD=... DB_NAME=tms_test go test ./internal/...
+//
+// Every table on the server is emptied before each test, so DB_NAME must name a scratch
+// database containing "test".
+package dbtest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+
+	"tms-backend/internal/database"
+)
+
+// Drivers are the drivers Run tests against
+var Drivers = []string{database.DriverSQLite, database.DriverMySQL, database.DriverPostgres}
+
+// Run runs test as a subtest for each of Drivers, each with its own freshly migrated database
+func Run(t *testing.T, test func(t *testing.T, db *gorm.DB)) {
+	t.Helper()
+	for _, driver := range Drivers {
+		t.Run(driver, func(t *testing.T) {
+			test(t, Open(t, driver))
+		})
+	}
+}
+
+// Open points database.DB at an empty migrated database of driver in the site timezone
+// (SITE_TIMEZONE, default Asia/Bangkok) and restores the previous connection afterwards.
+// Server drivers are skipped unless TEST_DB_DRIVER selects them.
+func Open(t *testing.T, driver string) *gorm.DB {
+	t.Helper()
+	server := driver != database.DriverSQLite
+	if server {
+		if !strings.EqualFold(os.Getenv("TEST_DB_DRIVER"), driver) {
+			t.Skipf("set TEST_DB_DRIVER=%s and DB_HOST, DB_USER, DB_PASSWORD, DB_NAME to run against a server", driver)
+		}
+		if !strings.Contains(strings.ToLower(os.Getenv("DB_NAME")), "test") {
+			t.Fatalf("DB_NAME %q does not look like a test database; its tables are emptied", os.Getenv("DB_NAME"))
+		}
+	} else {
+		t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "tms.db"))
+	}
+	t.Setenv("DB_DRIVER", driver)
+
+	if err := database.SetupSiteTimezone(); err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	if err := database.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	database.DB = database.DB.Session(&gorm.Session{Logger: logger.Discard})
+	t.Cleanup(func() {
+		if sqlDB, err := database.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+		database.DB = previous
+	})
+
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if server {
+		truncate(t, database.DB)
+	}
+	return database.DB
+}
+
+// truncate empties every table except the migration history
+func truncate(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	for _, table := range tables {
+		if table == "schema_migrations" {
+			continue
+		}
+		if err := db.Exec("DELETE FROM ?", clause.Table{Name: table}).Error; err != nil {
+			t.Fatalf("empty %s: %v", table, err)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	gosqlite "github.com/glebarez/go-sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// SQL that differs between MySQL/MariaDB, PostgreSQL and SQLite lives here so queries
+// elsewhere stay driver independent. Bucket expressions return 'YYYY-MM-DD HH:MM:SS' text
+// in site local time; scan them into a string and parse with time.ParseInLocation.
+
+// IsSQLite reports whether the connected database is SQLite
+func IsSQLite() bool {
+	return DB != nil && DB.Dialector.Name() == DriverSQLite
+}
+
+// IsPostgres reports whether the connected database is PostgreSQL
+func IsPostgres() bool {
+	return DB != nil && DB.Dialector.Name() == DriverPostgres
+}
+
+// IsDuplicateKey reports whether err is a primary key or unique constraint violation
+func IsDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062 // ER_DUP_ENTRY
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+	var sqliteErr *gosqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// SQLITE_CONSTRAINT_PRIMARYKEY, SQLITE_CONSTRAINT_UNIQUE
+		return sqliteErr.Code() == 1555 || sqliteErr.Code() == 2067
+	}
+	return false
+}
+
+// IsConnectionError reports whether err means the database could not be reached or is
+// temporarily refusing work (lost connection, server shutting down, unreadable file), as
+// opposed to the statement itself being rejected. Retrying later may succeed. Lock conflicts
+// with other transactions are not connection errors; see IsLockConflict.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, mysqldriver.ErrInvalidConn) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1040, 1053: // too many connections, server shutdown
+			return true
+		}
+		return false
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection_exception, operator_intervention, insufficient_resources
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P") ||
+			strings.HasPrefix(pgErr.Code, "53")
+	}
+	var sqliteErr *gosqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case 10, 13, 14: // SQLITE_IOERR, SQLITE_FULL, SQLITE_CANTOPEN
+			return true
+		}
+	}
+	return false
+}
+
+// IsLockConflict reports whether err means the statement lost a lock conflict with another
+// transaction (deadlock, lock wait timeout, serialization failure, busy SQLite file). The
+// database is up, and running the statement again usually succeeds.
+func IsLockConflict(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1205 || mysqlErr.Number == 1213 // lock wait timeout, deadlock
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "40") // transaction_rollback
+	}
+	var sqliteErr *gosqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case 5, 6: // SQLITE_BUSY, SQLITE_LOCKED
+			return true
+		}
+	}
+	return false
+}
+
+// On SQLite datetimes are stored as text in the writer's local time ("2006-01-02
+// 15:04:05.999-07:00"), so the site wall clock is the leading part of the value and
+// buckets are cut from it directly instead of converting through UTC. PostgreSQL stores
+// timestamptz and truncates in the session TimeZone, which Connect sets to the site zone.
+
+// pgBucket truncates a timestamptz column with date_trunc and formats it like the other drivers
+func pgBucket(field, column string) string {
+	return fmt.Sprintf("to_char(date_trunc('%s', %s), 'YYYY-MM-DD HH24:MI:SS')", field, column)
+}
+
+// HourBucket truncates a datetime column to the start of its hour
+func HourBucket(column string) string {
+	if IsSQLite() {
+		return fmt.Sprintf("substr(%s, 1, 13) || ':00:00'", column)
+	}
+	if IsPostgres() {
+		return pgBucket("hour", column)
+	}
+	return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-%%d %%H:00:00')", column)
+}
+
+// DayBucket truncates a datetime column to the start of its day
+func DayBucket(column string) string {
+	if IsSQLite() {
+		return fmt.Sprintf("substr(%s, 1, 10) || ' 00:00:00'", column)
+	}
+	if IsPostgres() {
+		return pgBucket("day", column)
+	}
+	return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-%%d 00:00:00')", column)
+}
+
+// WeekBucket truncates a datetime column to the Monday starting its week
+func WeekBucket(column string) string {
+	if IsSQLite() {
+		return fmt.Sprintf("date(substr(%s, 1, 10), '-6 days', 'weekday 1') || ' 00:00:00'", column)
+	}
+	if IsPostgres() {
+		return pgBucket("week", column) // ISO weeks start on Monday
+	}
+	return fmt.Sprintf("DATE_FORMAT(DATE_SUB(%[1]s, INTERVAL WEEKDAY(%[1]s) DAY), '%%Y-%%m-%%d 00:00:00')", column)
+}
+
+// SecondsBetween returns the whole seconds from datetime expression from to datetime expression to
+func SecondsBetween(from, to string) string {
+	if IsSQLite() {
+		return fmt.Sprintf("CAST(ROUND((julianday(%s) - julianday(%s)) * 86400) AS INTEGER)", to, from)
+	}
+	if IsPostgres() {
+		return fmt.Sprintf("CAST(ROUND(EXTRACT(EPOCH FROM (%s - %s))) AS INTEGER)", to, from)
+	}
+	return fmt.Sprintf("TIMESTAMPDIFF(SECOND, %s, %s)", from, to)
+}
+
+// Least returns the smaller of two expressions
+func Least(a, b string) string {
+	if IsSQLite() {
+		return fmt.Sprintf("MIN(%s, %s)", a, b)
+	}
+	return fmt.Sprintf("LEAST(%s, %s)", a, b)
+}
+
+// Contains returns a case-insensitive substring match of column against one placeholder
+// argument ("%" + value + "%"); PostgreSQL's LIKE is case-sensitive, unlike MySQL and SQLite
+func Contains(column string) string {
+	if IsPostgres() {
+		return column + " ILIKE ?"
+	}
+	return column + " LIKE ?"
+}
+
+// WherePairs filters query to rows whose (columnA, columnB) is one of pairs. SQLite only accepts
+// a subquery on the right of a row-value IN, so the pairs are expanded into ORed equalities.
+func WherePairs(query *gorm.DB, columnA, columnB string, pairs [][2]interface{}) *gorm.DB {
+	if len(pairs) == 0 {
+		return query.Where("1 = 0")
+	}
+	conditions := make([]string, len(pairs))
+	args := make([]interface{}, 0, len(pairs)*2)
+	for i, p := range pairs {
+		conditions[i] = fmt.Sprintf("(%s = ? AND %s = ?)", columnA, columnB)
+		args = append(args, p[0], p[1])
+	}
+	return query.Where("("+strings.Join(conditions, " OR ")+")", args...)
+}
@@ -0,0 +1,228 @@
+package database_test
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+)
+
+// insertReading stores a temp_log row at insertTime with send_time sent seconds earlier
+func insertReading(t *testing.T, db *gorm.DB, ip string, probeNo int, insertTime time.Time, sent time.Duration) {
+	t.Helper()
+	sendTime := insertTime.Add(-sent)
+	row := models.TempLog{MachineIP: ip, ProbeNo: probeNo, InsertTime: insertTime, SendTime: &sendTime}
+	if err := db.Create(&row).Error; err != nil {
+		t.Fatalf("insert %s/%d at %s: %v", ip, probeNo, insertTime, err)
+	}
+}
+
+// scanExpr evaluates expr against the single temp_log row of ip
+func scanExpr(t *testing.T, db *gorm.DB, expr, ip string, dest interface{}) {
+	t.Helper()
+	if err := db.Model(&models.TempLog{}).Select(expr).Where("machine_ip = ?", ip).Scan(dest).Error; err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		site := database.SiteLocation()
+
+		// The week of Monday 2025-01-06; late evening keeps the site day apart from the UTC day
+		for day := 6; day <= 12; day++ {
+			insertTime := time.Date(2025, 1, day, 23, 45, 30, 0, site)
+			ip := fmt.Sprintf("10.0.1.%d", day)
+			insertReading(t, db, ip, 1, insertTime, 0)
+
+			var hour, dayStart, week string
+			scanExpr(t, db, database.HourBucket("insert_time"), ip, &hour)
+			scanExpr(t, db, database.DayBucket("insert_time"), ip, &dayStart)
+			scanExpr(t, db, database.WeekBucket("insert_time"), ip, &week)
+
+			weekday := insertTime.Weekday()
+			if want := insertTime.Format("2006-01-02 15:00:00"); hour != want {
+				t.Errorf("%s: HourBucket = %q, want %q", weekday, hour, want)
+			}
+			if want := insertTime.Format("2006-01-02 00:00:00"); dayStart != want {
+				t.Errorf("%s: DayBucket = %q, want %q", weekday, dayStart, want)
+			}
+			if want := "2025-01-06 00:00:00"; week != want {
+				t.Errorf("%s: WeekBucket = %q, want %q", weekday, week, want)
+			}
+		}
+	})
+}
+
+func TestSecondsBetween(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+
+		insertReading(t, db, "10.0.0.1", 1, time.Date(2025, 1, 6, 0, 0, 30, 0, database.SiteLocation()), 90*time.Second)
+
+		var seconds int
+		scanExpr(t, db, database.SecondsBetween("send_time", "insert_time"), "10.0.0.1", &seconds)
+		if seconds != 90 {
+			t.Errorf("SecondsBetween = %d, want 90", seconds)
+		}
+	})
+}
+
+func TestWherePairs(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		now := time.Date(2025, 1, 6, 8, 0, 0, 0, database.SiteLocation())
+		insertReading(t, db, "10.0.0.1", 1, now, 0)
+		insertReading(t, db, "10.0.0.1", 2, now, 0)
+		insertReading(t, db, "10.0.0.2", 1, now, 0)
+
+		var rows []models.TempLog
+		query := database.WherePairs(db.Model(&models.TempLog{}), "machine_ip", "probe_no", [][2]interface{}{{"10.0.0.1", 2}, {"10.0.0.2", 1}})
+		if err := query.Order("machine_ip, probe_no").Find(&rows).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 || rows[0].MachineIP != "10.0.0.1" || rows[0].ProbeNo != 2 || rows[1].MachineIP != "10.0.0.2" {
+			t.Errorf("rows = %+v, want 10.0.0.1/2 and 10.0.0.2/1", rows)
+		}
+
+		var count int64
+		if err := database.WherePairs(db.Model(&models.TempLog{}), "machine_ip", "probe_no", nil).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("no pairs matched %d rows", count)
+		}
+	})
+}
+
+func TestIsDuplicateKey(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		now := time.Date(2025, 1, 6, 8, 0, 0, 0, database.SiteLocation())
+
+		insertReading(t, db, "10.0.0.1", 1, now, 0)
+		err := db.Create(&models.TempLog{MachineIP: "10.0.0.1", ProbeNo: 1, InsertTime: now}).Error
+		if !database.IsDuplicateKey(err) {
+			t.Errorf("second reading at the same time: IsDuplicateKey(%v) = false", err)
+		}
+
+		value := "1"
+		if err := db.Create(&models.ConfigValue{ConfigKey: "k", ConfigValue: &value}).Error; err != nil {
+			t.Fatal(err)
+		}
+		err = db.Create(&models.ConfigValue{ConfigKey: "k", ConfigValue: &value}).Error
+		if !database.IsDuplicateKey(err) {
+			t.Errorf("second config_value with the same key: IsDuplicateKey(%v) = false", err)
+		}
+
+		err = db.Exec("INSERT INTO no_such_table VALUES (1)").Error
+		if err == nil || database.IsDuplicateKey(err) {
+			t.Errorf("missing table: IsDuplicateKey(%v) = true", err)
+		}
+	})
+}
+
+func TestIsConnectionError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", fmt.Errorf("insert: %w", refused), true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"mysql lost connection", mysqldriver.ErrInvalidConn, true},
+		{"mysql too many connections", &mysqldriver.MySQLError{Number: 1040}, true},
+		{"mysql lock wait timeout", &mysqldriver.MySQLError{Number: 1205}, false},
+		{"mysql deadlock", &mysqldriver.MySQLError{Number: 1213}, false},
+		{"mysql duplicate", &mysqldriver.MySQLError{Number: 1062}, false},
+		{"mysql data too long", &mysqldriver.MySQLError{Number: 1406}, false},
+		{"postgres connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"postgres shutting down", &pgconn.PgError{Code: "57P01"}, true},
+		{"postgres serialization failure", &pgconn.PgError{Code: "40001"}, false},
+		{"postgres deadlock", &pgconn.PgError{Code: "40P01"}, false},
+		{"postgres unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"nil", nil, false},
+	} {
+		if got := database.IsConnectionError(tc.err); got != tc.want {
+			t.Errorf("%s: IsConnectionError(%v) = %v, want %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		now := time.Date(2025, 1, 6, 8, 0, 0, 0, database.SiteLocation())
+		insertReading(t, db, "10.0.0.1", 1, now, 0)
+		if err := db.Create(&models.TempLog{MachineIP: "10.0.0.1", ProbeNo: 1, InsertTime: now}).Error; database.IsConnectionError(err) {
+			t.Errorf("duplicate reading: IsConnectionError(%v) = true", err)
+		}
+		if err := db.Exec("INSERT INTO no_such_table VALUES (1)").Error; database.IsConnectionError(err) {
+			t.Errorf("missing table: IsConnectionError(%v) = true", err)
+		}
+	})
+}
+
+func TestIsLockConflict(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"mysql lock wait timeout", fmt.Errorf("insert: %w", &mysqldriver.MySQLError{Number: 1205}), true},
+		{"mysql deadlock", &mysqldriver.MySQLError{Number: 1213}, true},
+		{"mysql too many connections", &mysqldriver.MySQLError{Number: 1040}, false},
+		{"postgres serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"postgres deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"postgres connection failure", &pgconn.PgError{Code: "08006"}, false},
+		{"bad connection", driver.ErrBadConn, false},
+		{"nil", nil, false},
+	} {
+		if got := database.IsLockConflict(tc.err); got != tc.want {
+			t.Errorf("%s: IsLockConflict(%v) = %v, want %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestSiteTimeComparison(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		site := database.SiteLocation()
+
+		// 08:00 at the site is 01:00 UTC
+		stored := time.Date(2025, 1, 6, 8, 0, 0, 0, site)
+		insertReading(t, db, "10.0.0.1", 1, stored, 0)
+
+		var got models.TempLog
+		if err := db.Where("machine_ip = ?", "10.0.0.1").Take(&got).Error; err != nil {
+			t.Fatal(err)
+		}
+		if !got.InsertTime.Equal(stored) {
+			t.Errorf("read back %s, want %s", got.InsertTime, stored)
+		}
+
+		count := func(from time.Time) int64 {
+			var n int64
+			if err := db.Model(&models.TempLog{}).Where("insert_time >= ?", from).Count(&n).Error; err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+		before := time.Date(2025, 1, 6, 0, 30, 0, 0, time.UTC)
+		after := time.Date(2025, 1, 6, 2, 0, 0, 0, time.UTC)
+		if n := count(database.SiteTime(before)); n != 1 {
+			t.Errorf("from %s: %d rows, want 1", before, n)
+		}
+		if n := count(database.SiteTime(after)); n != 0 {
+			t.Errorf("from %s: %d rows, want 0", after, n)
+		}
+		// Bound as UTC the text comparison sees "02:00" < "08:00", which is why queries convert first
+		if database.IsSQLite() && count(after) != 1 {
+			t.Error("raw UTC bound compared as an instant; SiteTime may no longer be needed")
+		}
+	})
+}
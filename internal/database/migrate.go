@@ -0,0 +1,121 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned schema change. Up must be safe to re-run: MySQL commits DDL
+// implicitly, so a migration that fails halfway is retried from the start on the next run.
+// A nil Down marks the migration as irreversible.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(db *gorm.DB) error
+	Down    func(db *gorm.DB) error
+}
+
+// SchemaMigration represents the schema_migrations table (one row per applied migration)
+type SchemaMigration struct {
+	Version   int       `gorm:"column:version;primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"column:name;size:255" json:"name"`
+	AppliedAt time.Time `gorm:"column:applied_at" json:"appliedAt"`
+}
+
+// TableName specifies table name for SchemaMigration
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationState is a migration and whether it has been applied
+type MigrationState struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
+
+// appliedMigrations creates schema_migrations if needed and returns the applied versions
+func appliedMigrations() (map[int]SchemaMigration, error) {
+	if err := DB.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	var rows []SchemaMigration
+	if err := DB.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// Migrate applies every pending migration in version order
+func Migrate() error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		log.Printf("Applying migration %03d %s...", m.Version, m.Name)
+		if err := m.Up(DB); err != nil {
+			return fmt.Errorf("migration %03d %s failed: %w", m.Version, m.Name, err)
+		}
+		if err := DB.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error; err != nil {
+			return fmt.Errorf("migration %03d %s applied but not recorded: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts applied migrations newer than version, newest first
+func MigrateDown(version int) error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= version {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return fmt.Errorf("migration %03d %s cannot be reverted", m.Version, m.Name)
+		}
+		log.Printf("Reverting migration %03d %s...", m.Version, m.Name)
+		if err := m.Down(DB); err != nil {
+			return fmt.Errorf("reverting migration %03d %s failed: %w", m.Version, m.Name, err)
+		}
+		if err := DB.Delete(&SchemaMigration{}, m.Version).Error; err != nil {
+			return fmt.Errorf("migration %03d %s reverted but not recorded: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func MigrationStatus() ([]MigrationState, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			at := a.AppliedAt
+			state.AppliedAt = &at
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/models"
+)
+
+// migrations are applied in order; never edit or renumber one that has shipped, add a new one.
+// Legacy tables from the PHP system (master_machine, temp_log, temp_error, config_value,
+// master_user) are only changed with guarded, additive statements: new indexes and nullable
+// columns, never column type or charset changes, so existing TIS-620 data is left untouched.
+// New tables take the database's default charset like the legacy ones.
+var migrations = []Migration{
+	{
+		// A standalone SQLite database starts empty; on the legacy MySQL database these exist
+		Version: 0,
+		Name:    "create_legacy_tables_if_missing",
+		Up: createTables(&models.MasterMachine{}, &models.TempLog{}, &models.TempError{},
+			&models.ConfigValue{}, &models.MasterUser{}),
+		Down: keepLegacy,
+	},
+	{
+		Version: 1,
+		Name:    "create_temp_latest",
+		Up: func(db *gorm.DB) error {
+			if db.Migrator().HasTable(&models.TempLatest{}) {
+				return nil
+			}
+			if err := db.Migrator().CreateTable(&models.TempLatest{}); err != nil {
+				return err
+			}
+			// Backfill from history; the temp_log primary key makes the MAX() per probe cheap
+			result := db.Exec(`INSERT INTO temp_latest (machine_ip, probe_no, temp_value, status, insert_time)
+				SELECT t.machine_ip, t.probe_no, t.temp_value, t.status, t.insert_time
+				FROM temp_log t
+				JOIN (SELECT machine_ip, probe_no, MAX(insert_time) AS insert_time
+				      FROM temp_log GROUP BY machine_ip, probe_no) latest
+				  ON latest.machine_ip = t.machine_ip
+				 AND latest.probe_no = t.probe_no
+				 AND latest.insert_time = t.insert_time`)
+			if result.Error != nil {
+				return result.Error
+			}
+			log.Printf("temp_latest backfilled with %d probes", result.RowsAffected)
+			return nil
+		},
+		Down: dropTables(&models.TempLatest{}),
+	},
+	{
+		Version: 2,
+		Name:    "create_user_device_and_temp_error_ack",
+		Up:      createTables(&models.UserDevice{}, &models.TempErrorAck{}),
+		Down:    dropTables(&models.UserDevice{}, &models.TempErrorAck{}),
+	},
+	{
+		Version: 3,
+		Name:    "create_audit_log",
+		Up:      createTables(&models.AuditLog{}),
+		Down:    dropTables(&models.AuditLog{}),
+	},
+	{
+		// config_value normally comes with the legacy database
+		Version: 4,
+		Name:    "create_config_value_if_missing",
+		Up:      createTables(&models.ConfigValue{}),
+		Down:    keepLegacy,
+	},
+	{
+		Version: 5,
+		Name:    "create_temp_log_rollups",
+		Up:      createTables(&models.TempLogHourly{}, &models.TempLogDaily{}),
+		Down:    dropTables(&models.TempLogHourly{}, &models.TempLogDaily{}),
+	},
+	{
+		Version: 6,
+		Name:    "create_report_schedule_and_report_run",
+		Up:      createTables(&models.ReportSchedule{}, &models.ReportRun{}),
+		Down:    dropTables(&models.ReportSchedule{}, &models.ReportRun{}),
+	},
+	{
+		Version: 7,
+		Name:    "create_temp_log_archive",
+		Up:      createTables(&models.TempLogArchive{}),
+		Down:    dropTables(&models.TempLogArchive{}),
+	},
+	{
+		// Older PHP installs have no role column; NULL means viewer
+		Version: 8,
+		Name:    "add_master_user_role",
+		Up: func(db *gorm.DB) error {
+			if db.Migrator().HasColumn(&models.MasterUser{}, "role") {
+				return nil
+			}
+			return db.Migrator().AddColumn(&models.MasterUser{}, "Role")
+		},
+		Down: keepLegacy,
+	},
+	{
+		// Range scans over all probes (reports, stats, rollups, retention) filter on insert_time alone
+		Version: 9,
+		Name:    "index_temp_log_insert_time",
+		Up:      createIndex(&models.TempLog{}, "idx_temp_log_insert_time", "temp_log", "insert_time"),
+		Down:    dropIndex(&models.TempLog{}, "idx_temp_log_insert_time"),
+	},
+	{
+		Version: 10,
+		Name:    "index_temp_error_error_time",
+		Up:      createIndex(&models.TempError{}, "idx_temp_error_error_time", "temp_error", "error_time"),
+		Down:    dropIndex(&models.TempError{}, "idx_temp_error_error_time"),
+	},
+	{
+		// Config upserts (rollup watermark, MKT settings) need a unique config_key, which the
+		// legacy table may lack; without it every save added a row
+		Version: 11,
+		Name:    "unique_config_value_config_key",
+		Up:      uniqueConfigKey,
+		Down:    keepLegacy,
+	},
+}
+
+// configKeyIndex is the unique index on config_value.config_key
+const configKeyIndex = "idx_config_value_config_key"
+
+// uniqueConfigKey adds a unique index on config_value.config_key unless one exists (under
+// any name). Duplicate keys are not removed: the migration fails listing them so an operator
+// can decide which row to keep, then re-runs it.
+func uniqueConfigKey(db *gorm.DB) error {
+	indexes, err := db.Migrator().GetIndexes(&models.ConfigValue{})
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		unique, _ := index.Unique()
+		if columns := index.Columns(); unique && len(columns) == 1 && columns[0] == "config_key" {
+			return nil
+		}
+	}
+
+	var duplicates []struct {
+		ConfigKey string
+		Copies    int
+	}
+	if err := db.Raw(`SELECT config_key, COUNT(*) AS copies FROM config_value
+		GROUP BY config_key HAVING COUNT(*) > 1 ORDER BY config_key`).Scan(&duplicates).Error; err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		keys := make([]string, len(duplicates))
+		for i, d := range duplicates {
+			keys[i] = fmt.Sprintf("%s (%d rows)", d.ConfigKey, d.Copies)
+		}
+		return fmt.Errorf("config_value has duplicate config_key values, delete all but one row of each and run migrate again: %s",
+			strings.Join(keys, ", "))
+	}
+
+	// A plain index under the same name would block the unique one
+	if db.Migrator().HasIndex(&models.ConfigValue{}, configKeyIndex) {
+		if err := db.Migrator().DropIndex(&models.ConfigValue{}, configKeyIndex); err != nil {
+			return err
+		}
+	}
+	return db.Exec("CREATE UNIQUE INDEX " + configKeyIndex + " ON config_value (config_key)").Error
+}
+
+// createTables creates the tables that do not exist yet
+func createTables(tables ...interface{}) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		for _, t := range tables {
+			if db.Migrator().HasTable(t) {
+				continue
+			}
+			if err := db.Migrator().CreateTable(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// dropTables drops backend-owned tables
+func dropTables(tables ...interface{}) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		return db.Migrator().DropTable(tables...)
+	}
+}
+
+// keepLegacy is the Down of migrations that touch legacy tables the PHP system may
+// already have had (or now relies on); reverting them leaves the table as it is
+func keepLegacy(db *gorm.DB) error {
+	return nil
+}
+
+// createIndex adds a plain index unless one with that name exists. Secondary indexes are
+// built online on MariaDB/MySQL (InnoDB) and CONCURRENTLY on PostgreSQL, so polling keeps
+// writing while it runs.
+func createIndex(model interface{}, name, table, columns string) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		if db.Migrator().HasIndex(model, name) {
+			return nil
+		}
+		log.Printf("Creating index %s on %s(%s), this can take a while on large tables...", name, table, columns)
+		create := "CREATE INDEX "
+		if db.Dialector.Name() == DriverPostgres {
+			create = "CREATE INDEX CONCURRENTLY "
+		}
+		return db.Exec(create + name + " ON " + table + " (" + columns + ")").Error
+	}
+}
+
+// dropIndex drops an index created by createIndex
+func dropIndex(model interface{}, name string) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		if !db.Migrator().HasIndex(model, name) {
+			return nil
+		}
+		return db.Migrator().DropIndex(model, name)
+	}
+}
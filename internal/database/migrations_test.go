@@ -0,0 +1,72 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"tms-backend/internal/models"
+)
+
+func TestMigrateFreshSQLiteTwice(t *testing.T) {
+	openTestSQLite(t)
+
+	for run := 1; run <= 2; run++ {
+		if err := Migrate(); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+	states, err := MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %d %s not applied", s.Version, s.Name)
+		}
+	}
+}
+
+func TestUniqueConfigKeyOnLegacyTable(t *testing.T) {
+	openTestSQLite(t)
+
+	// The legacy PHP table has no unique key, so upserts piled up rows
+	if err := DB.Exec(`CREATE TABLE config_value (id INTEGER PRIMARY KEY AUTOINCREMENT, config_key VARCHAR(255), config_value TEXT)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range [][2]string{
+		{"rollup_watermark", "2025-01-01 00:00:00"},
+		{"mkt_limit", "25"},
+		{"rollup_watermark", "2025-01-02 00:00:00"},
+		{"rollup_watermark", "2025-01-03 00:00:00"},
+	} {
+		if err := DB.Exec("INSERT INTO config_value (config_key, config_value) VALUES (?, ?)", v[0], v[1]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Duplicates are left for the operator to resolve
+	err := uniqueConfigKey(DB)
+	if err == nil || !strings.Contains(err.Error(), "rollup_watermark (3 rows)") || strings.Contains(err.Error(), "mkt_limit") {
+		t.Fatalf("uniqueConfigKey with duplicates: err = %v, want rollup_watermark listed", err)
+	}
+	var count int64
+	if err := DB.Model(&models.ConfigValue{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Fatalf("%d config_value rows after the failed migration, want all 4 kept", count)
+	}
+
+	if err := DB.Exec(`DELETE FROM config_value WHERE config_key = 'rollup_watermark' AND config_value <> '2025-01-03 00:00:00'`).Error; err != nil {
+		t.Fatal(err)
+	}
+	for run := 1; run <= 2; run++ {
+		if err := uniqueConfigKey(DB); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+	err = DB.Exec("INSERT INTO config_value (config_key, config_value) VALUES ('mkt_limit', '8')").Error
+	if !IsDuplicateKey(err) {
+		t.Errorf("second mkt_limit row: err = %v, want a duplicate key error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	// Embedded zone database: Windows has no IANA zoneinfo for time.LoadLocation
+	_ "time/tzdata"
+)
+
+// DefaultSiteTimezone is used when SITE_TIMEZONE is not set
+const DefaultSiteTimezone = "Asia/Bangkok"
+
+// siteLocation is the site's timezone, set by SetupSiteTimezone
+var siteLocation = mustLoadLocation(DefaultSiteTimezone)
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// SetupSiteTimezone loads SITE_TIMEZONE (an IANA name such as Asia/Bangkok or Asia/Vientiane,
+// default Asia/Bangkok) and makes it the process's local time. Everything then uses the
+// site's zone regardless of the server clock: the MySQL DSN (loc=Local), stored
+// datetimes, sDate/sTime, report days and the offsets in JSON output.
+func SetupSiteTimezone() error {
+	name := os.Getenv("SITE_TIMEZONE")
+	if name == "" {
+		name = DefaultSiteTimezone
+	}
+	// "Local" would follow the server clock again, and PostgreSQL does not know the name
+	if name == "Local" {
+		return fmt.Errorf("SITE_TIMEZONE must be an IANA zone name such as %s", DefaultSiteTimezone)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid SITE_TIMEZONE %q: %w", name, err)
+	}
+
+	siteLocation = loc
+	time.Local = loc
+	log.Printf("Site timezone: %s (UTC%s)", loc, time.Now().In(loc).Format("-07:00"))
+	return nil
+}
+
+// SiteLocation returns the site's timezone
+func SiteLocation() *time.Location {
+	return siteLocation
+}
+
+// SiteNow returns the current time in the site's timezone
+func SiteNow() time.Time {
+	return time.Now().In(siteLocation)
+}
+
+// SiteTime returns t in the site's timezone. SQLite stores and compares datetimes as text
+// with their offset, so every value bound to a query must use the same zone.
+func SiteTime(t time.Time) time.Time {
+	return t.In(siteLocation)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// GetArchives reports which temp_log months were archived, when, to which file and whether
+// they were restored. Optional startDate/endDate (YYYY-MM-DD) filter on the archived period.
+func (h *Handler) GetArchives(c *fiber.Ctx) error {
+	loc := database.SiteLocation()
+	query := h.db.Model(&models.TempLogArchive{})
+	if v := c.Query("startDate"); v != "" {
+		start, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "startDate must be YYYY-MM-DD"})
+		}
+		query = query.Where("period_end > ?", start)
+	}
+	if v := c.Query("endDate"); v != "" {
+		end, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "endDate must be YYYY-MM-DD"})
+		}
+		query = query.Where("period_start < ?", end.AddDate(0, 0, 1))
+	}
+
+	var archives []models.TempLogArchive
+	if err := query.Order("period_start DESC, id DESC").Find(&archives).Error; err != nil {
+		utils.LogError("GetArchives failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var rows, size int64
+	for _, a := range archives {
+		rows += a.RowCount
+		size += a.SizeBytes
+	}
+	return c.JSON(fiber.Map{
+		"archives":       archives,
+		"totalRows":      rows,
+		"totalSizeBytes": size,
+	})
+}
+
+// RunRetention applies the retention policy now instead of waiting for the daily run
+func (h *Handler) RunRetention(c *fiber.Ctx) error {
+	if services.GlobalRetentionService == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Retention service not initialized"})
+	}
+	if !services.GlobalRetentionService.IsEnabled() {
+		return c.Status(400).JSON(fiber.Map{"error": "retention is disabled (set RETENTION_RAW_MONTHS and/or RETENTION_ROLLUP_YEARS)"})
+	}
+
+	archives, err := services.GlobalRetentionService.Run()
+	if err != nil {
+		utils.LogError("RunRetention failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error(), "archives": archives})
+	}
+	return c.JSON(fiber.Map{"archives": archives})
+}
+
+// RestoreArchive re-imports an archived month into temp_log
+func (h *Handler) RestoreArchive(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid archive id"})
+	}
+	var archive models.TempLogArchive
+	if err := h.db.First(&archive, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": "Archive not found"})
+		}
+		utils.LogError("RestoreArchive - Failed to load archive %d: %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := os.Stat(archive.FilePath); err != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "archive file is missing: " + archive.FilePath})
+	}
+
+	read, inserted, err := services.GlobalRetentionService.RestoreArchive(archive.FilePath, currentUser(c).Username)
+	if err != nil {
+		utils.LogError("RestoreArchive failed (id=%d): %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error(), "rowsRead": read, "rowsInserted": inserted})
+	}
+	return c.JSON(fiber.Map{"rowsRead": read, "rowsInserted": inserted})
+}
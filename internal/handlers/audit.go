@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/utils"
+)
+
+// maxAuditLimit caps GET /api/audit page size
+const maxAuditLimit = 1000
+
+// GetAuditLogs returns audit_log entries, newest first.
+// Filters (all optional): entity, entityId, machineIp, user, action, from, to (YYYY-MM-DD or datetime), limit.
+func (h *Handler) GetAuditLogs(c *fiber.Ctx) error {
+	query, err := h.scopeQuery(c, h.db.Model(&models.AuditLog{}), "machine_ip")
+	if err != nil {
+		utils.LogError("GetAuditLogs - Failed to load device scope: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if v := c.Query("entity"); v != "" {
+		query = query.Where("entity = ?", v)
+	}
+	if v := c.Query("entityId"); v != "" {
+		query = query.Where("entity_id = ?", v)
+	}
+	if v := c.Query("machineIp"); v != "" {
+		query = query.Where("machine_ip = ?", v)
+	}
+	if v := c.Query("user"); v != "" {
+		query = query.Where("username = ?", v)
+	}
+	if v := c.Query("action"); v != "" {
+		query = query.Where("action IN ?", splitComma(v))
+	}
+	if v := c.Query("from"); v != "" {
+		if len(v) == len("2006-01-02") {
+			v += " 00:00:00"
+		}
+		query = query.Where("created_at >= ?", v)
+	}
+	if v := c.Query("to"); v != "" {
+		if len(v) == len("2006-01-02") {
+			v += " 23:59:59.999"
+		}
+		query = query.Where("created_at <= ?", v)
+	}
+
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	var logs []models.AuditLog
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&logs).Error; err != nil {
+		utils.LogError("GetAuditLogs failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(logs)
+}
+
+// auditReason returns the reason for a change from the X-Audit-Reason header or ?reason=
+func auditReason(c *fiber.Ctx) string {
+	if reason := c.Get("X-Audit-Reason"); reason != "" {
+		return reason
+	}
+	return c.Query("reason")
+}
+
+// machineEntityID identifies a probe in audit_log.entity_id
+func machineEntityID(m models.MasterMachine) string {
+	return fmt.Sprintf("%s:%d", m.MachineIP, m.ProbeNo)
+}
+
+// machineAudit returns the audit of a device change: one audit_log row per probe created,
+// updated or deleted between the before and after snapshots. Unchanged probes are not logged.
+func machineAudit(c *fiber.Ctx, reason string) repository.AuditFunc {
+	return func(before, after []models.MasterMachine) []models.AuditLog {
+		return machineAuditEntries(c, before, after, reason)
+	}
+}
+
+// machineAuditEntries builds the audit_log rows of machineAudit
+func machineAuditEntries(c *fiber.Ctx, before, after []models.MasterMachine, reason string) []models.AuditLog {
+	beforeByID := make(map[string]models.MasterMachine, len(before))
+	for _, m := range before {
+		beforeByID[machineEntityID(m)] = m
+	}
+	afterByID := make(map[string]models.MasterMachine, len(after))
+	for _, m := range after {
+		afterByID[machineEntityID(m)] = m
+	}
+
+	entries := make([]models.AuditLog, 0)
+	ids := make([]string, 0, len(beforeByID)+len(afterByID))
+	for id := range beforeByID {
+		ids = append(ids, id)
+	}
+	for id := range afterByID {
+		if _, ok := beforeByID[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		entry := newAuditEntry(c, "master_machine", id, reason)
+
+		b, hadBefore := beforeByID[id]
+		a, hasAfter := afterByID[id]
+		switch {
+		case hadBefore && hasAfter:
+			entry.Action = models.AuditActionUpdate
+			entry.MachineIP = a.MachineIP
+		case hasAfter:
+			entry.Action = models.AuditActionCreate
+			entry.MachineIP = a.MachineIP
+		default:
+			entry.Action = models.AuditActionDelete
+			entry.MachineIP = b.MachineIP
+		}
+
+		if hadBefore {
+			entry.BeforeValue, _ = json.Marshal(b)
+		}
+		if hasAfter {
+			entry.AfterValue, _ = json.Marshal(a)
+		}
+
+		changed := changedFields(entry.BeforeValue, entry.AfterValue)
+		if entry.Action == models.AuditActionUpdate && len(changed) == 0 {
+			continue
+		}
+		entry.ChangedFields = strings.Join(changed, ",")
+
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// newAuditEntry fills the who/when part of an audit_log row
+func newAuditEntry(c *fiber.Ctx, entity, entityID, reason string) models.AuditLog {
+	user := currentUser(c)
+	return models.AuditLog{
+		CreatedAt: database.SiteNow(),
+		UserID:    user.ID,
+		Username:  user.Username,
+		ClientIP:  c.IP(),
+		Entity:    entity,
+		EntityID:  entityID,
+		Reason:    reason,
+	}
+}
+
+// changedFields lists the JSON fields whose values differ between two snapshots
+func changedFields(before, after json.RawMessage) []string {
+	var b, a map[string]interface{}
+	json.Unmarshal(before, &b)
+	json.Unmarshal(after, &a)
+
+	fields := make([]string, 0)
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || !reflect.DeepEqual(bv, v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
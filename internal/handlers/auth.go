@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// AuthUser is the authenticated user stored in c.Locals("user") by RequireAuth
+type AuthUser struct {
+	ID       int
+	Username string
+	Role     string
+}
+
+// LoginRequest is the body of POST /api/auth/login
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RefreshRequest is the body of POST /api/auth/refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// ChangePasswordRequest is the body of PUT /api/auth/password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// minPasswordLength applies to passwords set through the API
+const minPasswordLength = 8
+
+// Login verifies master_user credentials and returns access/refresh tokens
+func (h *Handler) Login(c *fiber.Ctx) error {
+	var req LoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Username == "" || req.Password == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "username and password are required"})
+	}
+
+	user, tokens, err := h.auth.Login(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			utils.LogError("Login - Failed login for user %q from %s", req.Username, c.IP())
+			return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+		}
+		utils.LogError("Login - Failed to issue tokens (user=%s): %v", req.Username, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"user":   user,
+		"tokens": tokens,
+	})
+}
+
+// RefreshToken exchanges a refresh token for a new token pair
+func (h *Handler) RefreshToken(c *fiber.Ctx) error {
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	user, tokens, err := h.auth.Refresh(req.RefreshToken)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"user":   user,
+		"tokens": tokens,
+	})
+}
+
+// GetMe returns the authenticated user
+func (h *Handler) GetMe(c *fiber.Ctx) error {
+	authUser := currentUser(c)
+
+	user, err := h.repos.Users.Get(authUser.ID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	return c.JSON(user)
+}
+
+// ChangePassword lets the authenticated user change their own password
+func (h *Handler) ChangePassword(c *fiber.Ctx) error {
+	authUser := currentUser(c)
+
+	var req ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(req.NewPassword) < minPasswordLength {
+		return c.Status(400).JSON(fiber.Map{"error": "newPassword must be at least 8 characters"})
+	}
+
+	user, err := h.repos.Users.Get(authUser.ID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if ok, _ := services.VerifyPassword(user.Password, req.CurrentPassword); !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "current password is incorrect"})
+	}
+
+	hash, err := services.HashPassword(req.NewPassword)
+	if err != nil {
+		utils.LogError("ChangePassword - %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := h.repos.Users.Update(user.ID, map[string]interface{}{"password": hash}); err != nil {
+		utils.LogError("ChangePassword - Failed to update password (user=%s): %v", user.Username, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"success": true})
+}
+
+// RequireAuth rejects requests without a valid "Authorization: Bearer ..." access token
+func (h *Handler) RequireAuth(c *fiber.Ctx) error {
+	return h.requireToken(c, strings.TrimPrefix(c.Get("Authorization"), "Bearer "))
+}
+
+// RequireStreamAuth is RequireAuth for the SSE endpoint: EventSource clients cannot
+// set headers, so the token may also come from the access_token query parameter.
+// Other routes do not accept it because URLs end up in logs and browser history.
+func (h *Handler) RequireStreamAuth(c *fiber.Ctx) error {
+	token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = c.Query("access_token")
+	}
+	return h.requireToken(c, token)
+}
+
+// requireToken validates the access token and stores the user for the handler
+func (h *Handler) requireToken(c *fiber.Ctx, token string) error {
+	if token == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
+	}
+
+	claims, err := h.auth.ParseToken(token, services.TokenTypeAccess)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Locals("user", &AuthUser{
+		ID:       claims.UserID,
+		Username: claims.Username,
+		Role:     claims.Role,
+	})
+	return c.Next()
+}
+
+// currentUser returns the user set by RequireAuth (empty when the route is public)
+func currentUser(c *fiber.Ctx) *AuthUser {
+	if user, ok := c.Locals("user").(*AuthUser); ok {
+		return user
+	}
+	return &AuthUser{}
+}
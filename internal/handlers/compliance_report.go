@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// GetComplianceReportPDF renders the monthly compliance PDF for one probe.
+// device is a machine IP, optionally with ":probe" (otherwise ?probe=, default 1); month is YYYY-MM.
+func (h *Handler) GetComplianceReportPDF(c *fiber.Ctx) error {
+	device := c.Query("device")
+	if device == "" || c.Query("month") == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "device and month are required"})
+	}
+
+	machineIP, probeNo := device, c.QueryInt("probe", 1)
+	if ip, probe, ok := strings.Cut(device, ":"); ok {
+		n, err := strconv.Atoi(probe)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "device must be <ip> or <ip>:<probe>"})
+		}
+		machineIP, probeNo = ip, n
+	}
+
+	month, err := services.ParseReportMonth(c.Query("month"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if ok, err := h.canSeeIP(c, machineIP); err != nil || !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Machine not found"})
+	}
+
+	report, err := services.BuildComplianceReport(h.db, h.repos, machineIP, probeNo, month, month.AddDate(0, 1, 0))
+	if errors.Is(err, services.ErrUnknownMachine) {
+		return c.Status(404).JSON(fiber.Map{"error": "Machine not found"})
+	}
+	if err != nil {
+		utils.LogError("GetComplianceReportPDF - Failed to build report (ip=%s, probe=%d, month=%s): %v", machineIP, probeNo, c.Query("month"), err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	report.GeneratedBy = currentUser(c).Username
+
+	var buf bytes.Buffer
+	if err := report.WritePDF(&buf); err != nil {
+		utils.LogError("GetComplianceReportPDF - Failed to render PDF (ip=%s, probe=%d): %v", machineIP, probeNo, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	filename := fmt.Sprintf("compliance_%s_P%d_%s.pdf", machineIP, probeNo, month.Format("2006-01"))
+	c.Set("Content-Type", "application/pdf")
+	c.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	return c.Send(buf.Bytes())
+}
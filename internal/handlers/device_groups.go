@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// maxProbesPerDevice is a sanity limit for ProbeAll (TCP devices report 1-2, gateways may report more)
+const maxProbesPerDevice = 16
+
+// GetDeviceGroups returns one entry per machine IP with its probes, live values,
+// rolled-up online status and worst alarm state
+func (h *Handler) GetDeviceGroups(c *fiber.Ctx) error {
+	groups, err := h.loadDeviceGroups()
+	if err != nil {
+		utils.LogError("GetDeviceGroups failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	ips, err := h.allowedIPs(c)
+	if err != nil {
+		utils.LogError("GetDeviceGroups - Failed to load device scope: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if ips != nil {
+		scoped := make([]models.DeviceGroup, 0, len(groups))
+		for _, g := range groups {
+			if ips[g.MachineIP] {
+				scoped = append(scoped, g)
+			}
+		}
+		groups = scoped
+	}
+	return c.JSON(groups)
+}
+
+// GetDeviceGroup returns a single device group by machine IP
+func (h *Handler) GetDeviceGroup(c *fiber.Ctx) error {
+	machineIP := c.Params("ip")
+
+	if ok, err := h.canSeeIP(c, machineIP); err != nil || !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Device not found"})
+	}
+
+	groups, err := h.loadDeviceGroups()
+	if err != nil {
+		utils.LogError("GetDeviceGroup failed (ip=%s): %v", machineIP, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, g := range groups {
+		if g.MachineIP == machineIP {
+			return c.JSON(g)
+		}
+	}
+	return c.Status(404).JSON(fiber.Map{"error": "Device not found"})
+}
+
+// UpdateDeviceGroupRequest holds group-level changes; omitted fields are left unchanged
+type UpdateDeviceGroupRequest struct {
+	MachineName *string `json:"machineName"`
+	ProbeAll    *int    `json:"probeAll"`
+	Reason      string  `json:"reason"`
+}
+
+// UpdateDeviceGroup renames a device and/or changes ProbeAll for every probe of the IP.
+// Raising ProbeAll creates the missing probes from probe 1's settings;
+// lowering it deletes probes above the new count.
+func (h *Handler) UpdateDeviceGroup(c *fiber.Ctx) error {
+	machineIP := c.Params("ip")
+
+	var req UpdateDeviceGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		utils.LogError("UpdateDeviceGroup - Failed to parse body: %v", err)
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.ProbeAll != nil && (*req.ProbeAll < 1 || *req.ProbeAll > maxProbesPerDevice) {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("probeAll must be between 1 and %d", maxProbesPerDevice)})
+	}
+
+	updates := map[string]interface{}{}
+	if req.MachineName != nil {
+		updates["machine_name"] = *req.MachineName
+	}
+	probeAll := 0
+	if req.ProbeAll != nil {
+		probeAll = *req.ProbeAll
+		updates["probe_all"] = probeAll
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = auditReason(c)
+	}
+
+	// Raising probe_all creates the missing probes from probe 1's settings, lowering it deletes the extra ones
+	err := h.repos.Devices.UpdateGroup(machineIP, updates, probeAll, machineAudit(c, reason))
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Device not found"})
+	}
+	if err != nil {
+		utils.LogError("UpdateDeviceGroup - Failed to update device (ip=%s): %v", machineIP, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return h.GetDeviceGroup(c)
+}
+
+// DeleteDeviceGroup deletes a device with all of its probes
+func (h *Handler) DeleteDeviceGroup(c *fiber.Ctx) error {
+	machineIP := c.Params("ip")
+
+	deleted, err := h.repos.Devices.Delete(machineIP, 0, machineAudit(c, auditReason(c)))
+	if err != nil {
+		utils.LogError("DeleteDeviceGroup - Failed to delete device (ip=%s): %v", machineIP, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if deleted == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Device not found"})
+	}
+
+	return c.JSON(fiber.Map{"success": true, "deletedProbes": deleted})
+}
+
+// loadDeviceGroups groups loadMachinesWithStatus by IP and overlays live values from the polling cache
+func (h *Handler) loadDeviceGroups() ([]models.DeviceGroup, error) {
+	machines, err := h.loadMachinesWithStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]services.LiveReading)
+	if services.GlobalPollingService != nil {
+		for _, r := range services.GlobalPollingService.LiveReadings() {
+			live[fmt.Sprintf("%s:%d", r.MachineIP, r.ProbeNo)] = r
+		}
+	}
+
+	groups := make([]models.DeviceGroup, 0)
+	index := make(map[string]int)
+	for _, m := range machines {
+		// Prefer the live value when it is newer than temp_latest
+		if r, ok := live[fmt.Sprintf("%s:%d", m.MachineIP, m.ProbeNo)]; ok {
+			if r.ReadAt != nil && (m.LastUpdate == nil || isNewerThan(*r.ReadAt, *m.LastUpdate)) {
+				value := *r.Value
+				lastUpdate := database.SiteTime(*r.ReadAt).Format(time.RFC3339)
+				m.CurrentValue = &value
+				m.LastUpdate = &lastUpdate
+				m.AlarmStatus = alarmStatus(&m.MasterMachine, &value)
+				if r.DeviceOnline {
+					m.OnlineStatus = "Online"
+				}
+			}
+		}
+
+		i, ok := index[m.MachineIP]
+		if !ok {
+			i = len(groups)
+			index[m.MachineIP] = i
+			groups = append(groups, models.DeviceGroup{
+				MachineIP:   m.MachineIP,
+				MachineName: m.MachineName,
+				ProbeAll:    m.ProbeAll,
+				Probes:      []models.MachineWithStatus{},
+			})
+		}
+		groups[i].Probes = append(groups[i].Probes, m)
+	}
+
+	for i := range groups {
+		rollUpDeviceGroup(&groups[i])
+	}
+	return groups, nil
+}
+
+// isNewerThan reports whether t is after an RFC3339 lastUpdate timestamp
+func isNewerThan(t time.Time, lastUpdate string) bool {
+	last, err := time.Parse(time.RFC3339, lastUpdate)
+	return err != nil || t.Truncate(time.Second).After(last)
+}
+
+// rollUpDeviceGroup sets the group's probe count, online status and worst alarm state
+func rollUpDeviceGroup(g *models.DeviceGroup) {
+	g.ProbeCount = len(g.Probes)
+
+	online := 0
+	worst := "U"
+	worstExcess := -1.0
+	for _, p := range g.Probes {
+		if p.OnlineStatus == "Online" {
+			online++
+		}
+
+		switch p.AlarmStatus {
+		case "H", "L":
+			// Among alarms, the probe furthest outside its range wins
+			excess := math.Max(p.GetMinTemp()-*p.CurrentValue, *p.CurrentValue-p.GetMaxTemp())
+			if excess > worstExcess {
+				worst, worstExcess = p.AlarmStatus, excess
+			}
+		case "N":
+			if worst == "U" {
+				worst = "N"
+			}
+		}
+	}
+	g.AlarmStatus = worst
+
+	switch {
+	case online == 0:
+		g.OnlineStatus = "Offline"
+	case online == len(g.Probes):
+		g.OnlineStatus = "Online"
+	default:
+		g.OnlineStatus = "Partial"
+	}
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/models"
+)
+
+// DeviceUpdateRequest is the whitelist of master_machine fields a client may change.
+// Omitted (or null) fields are left unchanged.
+type DeviceUpdateRequest struct {
+	MachineName *string  `json:"machineName"`
+	ProbeAll    *int     `json:"probeAll"`
+	Color       *string  `json:"color"`
+	ChkOnline   *string  `json:"chkOnline"`
+	ChkSms      *string  `json:"chkSms"`
+	ChkMail     *string  `json:"chkMail"`
+	ChkMon      *string  `json:"chkMon"`
+	ChkLine     *string  `json:"chkLine"`
+	ChkReport   *string  `json:"chkReport"`
+	MinTemp     *float64 `json:"minTemp"`
+	MaxTemp     *float64 `json:"maxTemp"`
+	AdjTemp     *float64 `json:"adjTemp"`
+	SType       *string  `json:"sType"`
+	Reason      string   `json:"reason"` // audit_log reason, not stored on the machine
+}
+
+// DeviceCreateRequest adds the primary key to DeviceUpdateRequest
+type DeviceCreateRequest struct {
+	MachineIP string `json:"machineIp"`
+	ProbeNo   *int   `json:"probeNo"`
+	DeviceUpdateRequest
+}
+
+// FieldError is one field-level validation failure
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// fieldErrors collects validation failures for a 422 response
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validationFailed responds 422 with the field-level errors
+func validationFailed(c *fiber.Ctx, errs fieldErrors) error {
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return c.Status(422).JSON(fiber.Map{"error": "validation failed", "fields": errs})
+}
+
+var (
+	colorPattern = regexp.MustCompile(`^#?[0-9A-Fa-f]{6}$`)
+
+	// deviceFieldAliases maps master_machine column names, which older clients send, to JSON names
+	deviceFieldAliases = map[string]string{
+		"machine_ip":   "machineIp",
+		"probe_no":     "probeNo",
+		"probe_all":    "probeAll",
+		"machine_name": "machineName",
+		"min_temp":     "minTemp",
+		"max_temp":     "maxTemp",
+		"adj_temp":     "adjTemp",
+	}
+
+	// ignoredDeviceFields are response-only fields that clients echo back; they are dropped silently
+	ignoredDeviceFields = map[string]bool{"port": true}
+)
+
+const (
+	// maxMachineNameLength matches master_machine.machine_name
+	maxMachineNameLength = 50
+	// maxAdjTemp bounds the calibration offset; larger corrections indicate a typo or a broken sensor
+	maxAdjTemp = 50.0
+)
+
+// decodeDeviceBody decodes a JSON object into dst. Column-name aliases are accepted and
+// fields dst does not declare are rejected. fixed holds key fields that may be echoed back
+// unchanged but not modified. A non-nil error means the body is not a JSON object at all.
+func decodeDeviceBody(c *fiber.Ctx, dst interface{}, fixed map[string]interface{}) (fieldErrors, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &raw); err != nil {
+		return nil, fmt.Errorf("request body must be a JSON object: %w", err)
+	}
+
+	allowed := jsonFieldNames(reflect.TypeOf(dst).Elem())
+	accepted := make(map[string]json.RawMessage, len(raw))
+	var errs fieldErrors
+	for key, value := range raw {
+		if alias, ok := deviceFieldAliases[key]; ok {
+			key = alias
+		}
+		current, isFixed := fixed[key]
+		switch {
+		case ignoredDeviceFields[key]:
+		case isFixed:
+			if want, _ := json.Marshal(current); string(want) != strings.TrimSpace(string(value)) {
+				errs.add(key, "cannot be changed")
+			}
+		case !allowed[key]:
+			errs.add(key, "unknown field")
+		default:
+			accepted[key] = value
+		}
+	}
+
+	body, _ := json.Marshal(accepted)
+	if err := json.Unmarshal(body, dst); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			errs.add(typeErr.Field, "must be a %s", jsonTypeName(typeErr.Type))
+		} else {
+			return nil, err
+		}
+	}
+	return errs, nil
+}
+
+// jsonTypeName describes a Go type the way a JSON client sees it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	}
+	return t.String()
+}
+
+// jsonFieldNames returns the JSON names of a struct's fields, including embedded structs
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for name := range jsonFieldNames(f.Type) {
+				names[name] = true
+			}
+			continue
+		}
+		if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// validate checks the request against the machine it will be applied to
+// (the existing row on update, the defaults on create)
+func (r *DeviceUpdateRequest) validate(current models.MasterMachine, errs *fieldErrors) {
+	if r.MachineName != nil {
+		name := strings.TrimSpace(*r.MachineName)
+		switch {
+		case name == "":
+			errs.add("machineName", "is required")
+		case utf8.RuneCountInString(name) > maxMachineNameLength:
+			errs.add("machineName", "must be at most %d characters", maxMachineNameLength)
+		}
+		r.MachineName = &name
+	}
+
+	if r.ProbeAll != nil {
+		switch probeAll := *r.ProbeAll; {
+		case probeAll < 1 || probeAll > maxProbesPerDevice:
+			errs.add("probeAll", "must be between 1 and %d", maxProbesPerDevice)
+		case current.ProbeNo > probeAll:
+			errs.add("probeNo", "must be between 1 and probeAll (%d)", probeAll)
+		}
+	}
+
+	if r.Color != nil {
+		if !colorPattern.MatchString(*r.Color) {
+			errs.add("color", "must be a 6-digit hex color such as 00FF00")
+		} else {
+			color := strings.ToUpper(strings.TrimPrefix(*r.Color, "#"))
+			r.Color = &color
+		}
+	}
+
+	for field, flag := range map[string]*string{
+		"chkOnline": r.ChkOnline,
+		"chkSms":    r.ChkSms,
+		"chkMail":   r.ChkMail,
+		"chkMon":    r.ChkMon,
+		"chkLine":   r.ChkLine,
+		"chkReport": r.ChkReport,
+	} {
+		if flag != nil && *flag != "0" && *flag != "1" {
+			errs.add(field, `must be "0" or "1"`)
+		}
+	}
+
+	if r.SType != nil {
+		switch *r.SType {
+		case "t", "h", "p":
+		default:
+			errs.add("sType", `must be one of "t", "h", "p"`)
+		}
+	}
+
+	if r.AdjTemp != nil && (*r.AdjTemp < -maxAdjTemp || *r.AdjTemp > maxAdjTemp) {
+		errs.add("adjTemp", "must be between %.0f and %.0f", -maxAdjTemp, maxAdjTemp)
+	}
+
+	minTemp, maxTemp := current.MinTemp, current.MaxTemp
+	if r.MinTemp != nil {
+		minTemp = r.MinTemp
+	}
+	if r.MaxTemp != nil {
+		maxTemp = r.MaxTemp
+	}
+	if minTemp != nil && maxTemp != nil && *minTemp >= *maxTemp {
+		field := "minTemp"
+		if r.MinTemp == nil {
+			field = "maxTemp"
+		}
+		errs.add(field, "minTemp (%.2f) must be less than maxTemp (%.2f)", *minTemp, *maxTemp)
+	}
+}
+
+// checkProbeAll rejects a probeAll below a probe the device already has,
+// which would leave that probe outside the device's probe count
+func (h *Handler) checkProbeAll(machineIP string, probeAll *int, errs *fieldErrors) error {
+	if probeAll == nil {
+		return nil
+	}
+	probes, err := h.repos.Devices.ListByIP(machineIP)
+	if err != nil {
+		return err
+	}
+	highest := 0
+	for _, p := range probes {
+		highest = max(highest, p.ProbeNo)
+	}
+	if highest > *probeAll {
+		errs.add("probeAll", "must be at least %d (probe %d exists on this device)", highest, highest)
+	}
+	return nil
+}
+
+// updates returns the column updates for the fields that were supplied
+func (r *DeviceUpdateRequest) updates() map[string]interface{} {
+	updates := make(map[string]interface{})
+	set := func(column string, v interface{}) {
+		if !reflect.ValueOf(v).IsNil() {
+			updates[column] = reflect.ValueOf(v).Elem().Interface()
+		}
+	}
+	set("machine_name", r.MachineName)
+	set("probe_all", r.ProbeAll)
+	set("color", r.Color)
+	set("chkOnline", r.ChkOnline)
+	set("chkSms", r.ChkSms)
+	set("chkMail", r.ChkMail)
+	set("chkMon", r.ChkMon)
+	set("chkLine", r.ChkLine)
+	set("chkReport", r.ChkReport)
+	set("min_temp", r.MinTemp)
+	set("max_temp", r.MaxTemp)
+	set("adj_temp", r.AdjTemp)
+	set("sType", r.SType)
+	return updates
+}
+
+// toMachine validates a create request and builds the new master_machine row
+func (r *DeviceCreateRequest) toMachine(errs *fieldErrors) *models.MasterMachine {
+	machine := &models.MasterMachine{
+		MachineIP: strings.TrimSpace(r.MachineIP),
+		ProbeNo:   1,
+		ProbeAll:  1,
+		Color:     "000000",
+		ChkOnline: "0",
+		ChkSms:    "0",
+		ChkMail:   "0",
+		ChkMon:    "0",
+		ChkLine:   "0",
+		ChkReport: "0",
+		SType:     "t",
+	}
+	if ip := net.ParseIP(machine.MachineIP); ip == nil || ip.To4() == nil {
+		errs.add("machineIp", "must be a valid IPv4 address")
+	}
+	if r.ProbeNo != nil {
+		machine.ProbeNo = *r.ProbeNo
+		if machine.ProbeNo < 1 {
+			errs.add("probeNo", "must be at least 1")
+		}
+	}
+	if r.MachineName == nil {
+		errs.add("machineName", "is required")
+	}
+	if r.ProbeAll == nil && machine.ProbeNo > 1 {
+		// Default ProbeAll to cover the probe being created
+		probeAll := machine.ProbeNo
+		r.ProbeAll = &probeAll
+	}
+
+	r.validate(*machine, errs)
+	if len(*errs) > 0 {
+		return nil
+	}
+
+	// Apply the validated fields over the defaults
+	body, _ := json.Marshal(r.DeviceUpdateRequest)
+	json.Unmarshal(body, machine)
+	return machine
+}
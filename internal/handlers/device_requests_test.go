@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// validationResponse is the 422 body written by validationFailed
+type validationResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// send performs a JSON request against app and decodes the response body
+func send(t *testing.T, app *fiber.App, method, path, body string) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var raw json.RawMessage
+	json.NewDecoder(resp.Body).Decode(&raw)
+	return resp.StatusCode, raw
+}
+
+// fieldNames returns the fields named in a 422 response
+func fieldNames(t *testing.T, body []byte) []string {
+	t.Helper()
+	var resp validationResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	if resp.Error != "validation failed" {
+		t.Errorf("error = %q, want %q", resp.Error, "validation failed")
+	}
+	names := make([]string, 0, len(resp.Fields))
+	for _, f := range resp.Fields {
+		if f.Message == "" {
+			t.Errorf("field %s has no message", f.Field)
+		}
+		names = append(names, f.Field)
+	}
+	return names
+}
+
+func TestCreateDeviceFieldErrors(t *testing.T) {
+	h := New(nil, repository.NewMemory().Set(), nil)
+	app := fiber.New()
+	app.Post("/devices", h.CreateDevice)
+
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"unknown field", `{"machineIp":"10.0.0.5","machineName":"Fridge","colour":"00FF00"}`, []string{"colour"}},
+		{"missing name and bad ip", `{"machineIp":"10.0.0"}`, []string{"machineIp", "machineName"}},
+		{"adjTemp out of range", `{"machineIp":"10.0.0.5","machineName":"Fridge","adjTemp":75}`, []string{"adjTemp"}},
+		{"probeAll out of range", `{"machineIp":"10.0.0.5","machineName":"Fridge","probeAll":17}`, []string{"probeAll"}},
+		{"probeNo above probeAll", `{"machineIp":"10.0.0.5","machineName":"Fridge","probeNo":3,"probeAll":2}`, []string{"probeNo"}},
+		{"minTemp above maxTemp", `{"machineIp":"10.0.0.5","machineName":"Fridge","minTemp":8,"maxTemp":2}`, []string{"minTemp"}},
+		{"flag and sType", `{"machineIp":"10.0.0.5","machineName":"Fridge","chkSms":"yes","sType":"x"}`, []string{"chkSms", "sType"}},
+		{"wrong type", `{"machineIp":"10.0.0.5","machineName":"Fridge","minTemp":"cold"}`, []string{"minTemp"}},
+		{"bad color", `{"machineIp":"10.0.0.5","machineName":"Fridge","color":"red"}`, []string{"color"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := send(t, app, "POST", "/devices", tc.body)
+			if status != 422 {
+				t.Fatalf("status = %d (%s), want 422", status, body)
+			}
+			if got := fieldNames(t, body); !reflect.DeepEqual(got, tc.fields) {
+				t.Errorf("fields = %v, want %v", got, tc.fields)
+			}
+		})
+	}
+}
+
+func TestParseDeviceUpdate(t *testing.T) {
+	minTemp, maxTemp := 2.0, 8.0
+	current := models.MasterMachine{MachineIP: "10.0.0.5", ProbeNo: 2, ProbeAll: 2, MinTemp: &minTemp, MaxTemp: &maxTemp}
+
+	app := fiber.New()
+	app.Put("/devices", func(c *fiber.Ctx) error {
+		req, errs, err := parseDeviceUpdate(c, current)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if len(errs) > 0 {
+			return validationFailed(c, errs)
+		}
+		return c.JSON(req.updates())
+	})
+
+	invalid := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{"key cannot change", `{"machineIp":"10.0.0.6","probe_no":1}`, []string{"machineIp", "probeNo"}},
+		{"maxTemp below current minTemp", `{"maxTemp":1}`, []string{"maxTemp"}},
+		{"minTemp above current maxTemp", `{"min_temp":9}`, []string{"minTemp"}},
+		{"probeAll below own probe", `{"probeAll":1}`, []string{"probeNo"}},
+		{"adjTemp out of range", `{"adjTemp":-50.5}`, []string{"adjTemp"}},
+		{"unknown field", `{"temp_value":4}`, []string{"temp_value"}},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := send(t, app, "PUT", "/devices", tc.body)
+			if status != 422 {
+				t.Fatalf("status = %d (%s), want 422", status, body)
+			}
+			if got := fieldNames(t, body); !reflect.DeepEqual(got, tc.fields) {
+				t.Errorf("fields = %v, want %v", got, tc.fields)
+			}
+		})
+	}
+
+	// Key echoed back unchanged, column aliases and response-only fields are accepted
+	status, body := send(t, app, "PUT", "/devices",
+		`{"machineIp":"10.0.0.5","probeNo":2,"max_temp":10,"adjTemp":-0.5,"color":"#00ff00","port":502,"reason":"calibrated"}`)
+	if status != 200 {
+		t.Fatalf("status = %d (%s), want 200", status, body)
+	}
+	var updates map[string]interface{}
+	json.Unmarshal(body, &updates)
+	want := map[string]interface{}{"max_temp": 10.0, "adj_temp": -0.5, "color": "00FF00"}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("updates = %v, want %v", updates, want)
+	}
+}
@@ -3,61 +3,110 @@ package handlers
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 
 	"tms-backend/internal/database"
 	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
 	"tms-backend/internal/services"
 	"tms-backend/internal/utils"
 )
 
+// Handler serves the API. Devices, readings, incidents, config and users go through repos;
+// reports, statistics, schedules and archives query db directly.
+type Handler struct {
+	db    *gorm.DB
+	repos *repository.Set
+	auth  *services.AuthService
+}
+
+// New creates the API handlers (repository.NewGorm(db) in production, NewMemory in tests)
+func New(db *gorm.DB, repos *repository.Set, auth *services.AuthService) *Handler {
+	return &Handler{db: db, repos: repos, auth: auth}
+}
+
 // GetDevices returns all machines (grouped by IP)
-func GetDevices(c *fiber.Ctx) error {
-	var machines []models.MasterMachine
-	if err := database.DB.Find(&machines).Error; err != nil {
+func (h *Handler) GetDevices(c *fiber.Ctx) error {
+	ips, err := h.allowedIPs(c)
+	if err != nil {
+		utils.LogError("GetDevices - Failed to load device scope: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	machines, err := h.repos.Devices.List()
+	if err != nil {
 		utils.LogError("GetDevices failed: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	if ips != nil {
+		scoped := make([]models.MasterMachine, 0, len(machines))
+		for _, m := range machines {
+			if ips[m.MachineIP] {
+				scoped = append(scoped, m)
+			}
+		}
+		machines = scoped
+	}
 	return c.JSON(machines)
 }
 
 // GetDevice returns a single machine by IP and probe
-func GetDevice(c *fiber.Ctx) error {
+func (h *Handler) GetDevice(c *fiber.Ctx) error {
 	machineIP := c.Params("id") // id is actually machineIP
 	probeNo := c.QueryInt("probeNo", 1)
 
-	var machine models.MasterMachine
-	if err := database.DB.First(&machine, "machine_ip = ? AND probe_no = ?", machineIP, probeNo).Error; err != nil {
+	if ok, err := h.canSeeIP(c, machineIP); err != nil || !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Machine not found"})
+	}
+
+	machine, err := h.repos.Devices.Get(machineIP, probeNo)
+	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Machine not found"})
 	}
 	return c.JSON(machine)
 }
 
 // CreateDevice creates a new machine entry
-func CreateDevice(c *fiber.Ctx) error {
-	machine := new(models.MasterMachine)
-	if err := c.BodyParser(machine); err != nil {
+func (h *Handler) CreateDevice(c *fiber.Ctx) error {
+	var req DeviceCreateRequest
+	errs, err := decodeDeviceBody(c, &req, nil)
+	if err != nil {
 		utils.LogError("CreateDevice - Failed to parse body: %v", err)
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Set default probe_no if not provided
-	if machine.ProbeNo == 0 {
-		machine.ProbeNo = 1
+	// Defaults: probe 1, sType "t", all notification flags off
+	machine := req.toMachine(&errs)
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+	if err := h.checkProbeAll(machine.MachineIP, &machine.ProbeAll, &errs); err != nil {
+		utils.LogError("CreateDevice - Failed to load probes (ip=%s): %v", machine.MachineIP, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
 	}
 
-	// Set default sType if not provided
-	if machine.SType == "" {
-		machine.SType = "t"
+	reason := req.Reason
+	if reason == "" {
+		reason = auditReason(c)
 	}
 
-	if err := database.DB.Create(machine).Error; err != nil {
+	err = h.repos.Devices.Create(machine, machineAudit(c, reason))
+	if errors.Is(err, repository.ErrDuplicate) {
+		return c.Status(409).JSON(fiber.Map{"error": "machine already exists"})
+	}
+	if err != nil {
 		utils.LogError("CreateDevice - Failed to create machine: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -66,7 +115,7 @@ func CreateDevice(c *fiber.Ctx) error {
 }
 
 // UpdateDevice updates a machine
-func UpdateDevice(c *fiber.Ctx) error {
+func (h *Handler) UpdateDevice(c *fiber.Ctx) error {
 	machineIP := c.Params("id") // id is actually machineIP
 	probeNo := c.QueryInt("probeNo", 0)
 
@@ -75,10 +124,15 @@ func UpdateDevice(c *fiber.Ctx) error {
 
 	if probeNo > 0 {
 		// Update specific probe
-		err = database.DB.First(&machine, "machine_ip = ? AND probe_no = ?", machineIP, probeNo).Error
+		machine, err = h.repos.Devices.Get(machineIP, probeNo)
 	} else {
 		// Update first probe found for this IP
-		err = database.DB.First(&machine, "machine_ip = ?", machineIP).Error
+		var probes []models.MasterMachine
+		if probes, err = h.repos.Devices.ListByIP(machineIP); err == nil && len(probes) == 0 {
+			err = repository.ErrNotFound
+		} else if err == nil {
+			machine = probes[0]
+		}
 	}
 
 	if err != nil {
@@ -86,71 +140,94 @@ func UpdateDevice(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "Machine not found"})
 	}
 
-	var updates map[string]interface{}
-	if err := c.BodyParser(&updates); err != nil {
+	req, errs, err := parseDeviceUpdate(c, machine)
+	if err != nil {
 		utils.LogError("UpdateDevice - Failed to parse body: %v", err)
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+	if err := h.checkProbeAll(machine.MachineIP, req.ProbeAll, &errs); err != nil {
+		utils.LogError("UpdateDevice - Failed to load probes (ip=%s): %v", machineIP, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
 
-	if err := database.DB.Model(&machine).Updates(updates).Error; err != nil {
+	machine, err = h.repos.Devices.Update(machine.MachineIP, machine.ProbeNo, req.updates(), machineAudit(c, req.Reason))
+	if err != nil {
 		utils.LogError("UpdateDevice - Failed to update machine (ip=%s, probe=%d): %v", machineIP, probeNo, err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Reload the updated machine
-	database.DB.First(&machine, "machine_ip = ? AND probe_no = ?", machine.MachineIP, machine.ProbeNo)
-
 	return c.JSON(machine)
 }
 
 // DeleteDevice deletes a machine entry
-func DeleteDevice(c *fiber.Ctx) error {
+func (h *Handler) DeleteDevice(c *fiber.Ctx) error {
 	machineIP := c.Params("id") // id is actually machineIP
 	probeNo := c.QueryInt("probeNo", 0)
 
-	if probeNo > 0 {
-		// Delete specific probe
-		if err := database.DB.Delete(&models.MasterMachine{}, "machine_ip = ? AND probe_no = ?", machineIP, probeNo).Error; err != nil {
-			utils.LogError("DeleteDevice - Failed to delete machine (ip=%s, probe=%d): %v", machineIP, probeNo, err)
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-		}
-	} else {
-		// Delete all probes for this IP
-		if err := database.DB.Delete(&models.MasterMachine{}, "machine_ip = ?", machineIP).Error; err != nil {
-			utils.LogError("DeleteDevice - Failed to delete machines (ip=%s): %v", machineIP, err)
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-		}
+	// Delete a specific probe, or all probes for this IP (probeNo 0)
+	if _, err := h.repos.Devices.Delete(machineIP, probeNo, machineAudit(c, auditReason(c))); err != nil {
+		utils.LogError("DeleteDevice - Failed to delete machine (ip=%s, probe=%d): %v", machineIP, probeNo, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.JSON(fiber.Map{"success": true})
 }
 
 // GetMachines returns all machines with latest temperature
-func GetMachines(c *fiber.Ctx) error {
-	var machines []models.MasterMachine
-	if err := database.DB.Find(&machines).Error; err != nil {
+func (h *Handler) GetMachines(c *fiber.Ctx) error {
+	result, err := h.loadMachinesWithStatus()
+	if err != nil {
 		utils.LogError("GetMachines failed: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Get latest value for each machine
-	var result []models.MachineWithStatus
-	for _, m := range machines {
+	ips, err := h.allowedIPs(c)
+	if err != nil {
+		utils.LogError("GetMachines - Failed to load device scope: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if ips != nil {
+		scoped := make([]models.MachineWithStatus, 0, len(result))
+		for _, m := range result {
+			if ips[m.MachineIP] {
+				scoped = append(scoped, m)
+			}
+		}
+		result = scoped
+	}
+
+	return c.JSON(result)
+}
+
+// loadMachinesWithStatus returns every probe with its latest value in one round trip
+func (h *Handler) loadMachinesWithStatus() ([]models.MachineWithStatus, error) {
+	rows, err := h.repos.Devices.ListWithLatest()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.MachineWithStatus, 0, len(rows))
+	for _, row := range rows {
 		mws := models.MachineWithStatus{
-			MasterMachine: m,
+			MasterMachine: row.MasterMachine,
 			OnlineStatus:  "Offline",
+			AlarmStatus:   "U",
 		}
 
-		var tempLog models.TempLog
-		if err := database.DB.Where("machine_ip = ? AND probe_no = ?", m.MachineIP, m.ProbeNo).
-			Order("insert_time DESC").
-			First(&tempLog).Error; err == nil {
-			mws.CurrentValue = tempLog.TempValue
-			lastUpdate := tempLog.InsertTime.Format("2006-01-02 15:04:05")
+		if row.LatestTime != nil {
+			mws.CurrentValue = row.LatestValue
+			mws.AlarmStatus = alarmStatus(&mws.MasterMachine, row.LatestValue)
+			lastUpdate := database.SiteTime(*row.LatestTime).Format(time.RFC3339)
 			mws.LastUpdate = &lastUpdate
 
 			// Check if online (last update within 10 minutes)
-			if time.Since(tempLog.InsertTime) < 10*time.Minute {
+			if time.Since(*row.LatestTime) < 10*time.Minute {
 				mws.OnlineStatus = "Online"
 			}
 		}
@@ -158,153 +235,491 @@ func GetMachines(c *fiber.Ctx) error {
 		result = append(result, mws)
 	}
 
-	return c.JSON(result)
+	return result, nil
+}
+
+// alarmStatus classifies a value against the probe limits (U when there is no value)
+func alarmStatus(m *models.MasterMachine, value *float64) string {
+	switch {
+	case value == nil:
+		return "U"
+	case *value < m.GetMinTemp():
+		return "L"
+	case *value > m.GetMaxTemp():
+		return "H"
+	default:
+		return "N"
+	}
 }
 
 // UpdateMachine updates a machine
-func UpdateMachine(c *fiber.Ctx) error {
+func (h *Handler) UpdateMachine(c *fiber.Ctx) error {
 	machineIP := c.Params("machineIp")
 	probeNoStr := c.Params("probeNo")
 	probeNo, _ := strconv.Atoi(probeNoStr)
 
-	var machine models.MasterMachine
-	if err := database.DB.First(&machine, "machine_ip = ? AND probe_no = ?", machineIP, probeNo).Error; err != nil {
+	machine, err := h.repos.Devices.Get(machineIP, probeNo)
+	if err != nil {
 		utils.LogError("UpdateMachine - Machine not found (ip=%s, probe=%d): %v", machineIP, probeNo, err)
 		return c.Status(404).JSON(fiber.Map{"error": "Machine not found"})
 	}
 
-	var updates map[string]interface{}
-	if err := c.BodyParser(&updates); err != nil {
+	req, errs, err := parseDeviceUpdate(c, machine)
+	if err != nil {
 		utils.LogError("UpdateMachine - Failed to parse body: %v", err)
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+	if err := h.checkProbeAll(machine.MachineIP, req.ProbeAll, &errs); err != nil {
+		utils.LogError("UpdateMachine - Failed to load probes (ip=%s): %v", machineIP, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
 
-	if err := database.DB.Model(&machine).Updates(updates).Error; err != nil {
+	machine, err = h.repos.Devices.Update(machineIP, probeNo, req.updates(), machineAudit(c, req.Reason))
+	if err != nil {
 		utils.LogError("UpdateMachine - Failed to update machine (ip=%s, probe=%d): %v", machineIP, probeNo, err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Reload the updated machine
-	database.DB.First(&machine, "machine_ip = ? AND probe_no = ?", machineIP, probeNo)
-
 	return c.JSON(machine)
 }
 
-// GetTempLogs returns temperature logs
-func GetTempLogs(c *fiber.Ctx) error {
-	startDate := c.Query("startDate")
-	endDate := c.Query("endDate")
-	limit := c.QueryInt("limit", 100)
+// parseDeviceUpdate decodes and validates an update body against the existing machine.
+// The key (machineIp, probeNo) may be echoed back but not changed.
+func parseDeviceUpdate(c *fiber.Ctx, machine models.MasterMachine) (*DeviceUpdateRequest, fieldErrors, error) {
+	var req DeviceUpdateRequest
+	errs, err := decodeDeviceBody(c, &req, map[string]interface{}{
+		"machineIp": machine.MachineIP,
+		"probeNo":   machine.ProbeNo,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	req.validate(machine, &errs)
+	if req.Reason == "" {
+		req.Reason = auditReason(c)
+	}
+	return &req, errs, nil
+}
+
+// tempLogSorts are the ?sort= options of GET /api/temp-logs
+var tempLogSorts = repositorySorts("insert_time")
+
+// GetTempLogs returns temperature logs, newest first, one cursor page at a time.
+// Filters: startDate, endDate, machineIp (comma list), probeNo, machineName (partial), status, sType.
+// Paging: sort (time|machine|value, - for descending), limit, cursor; X-Next-Cursor and X-Total-Count headers.
+func (h *Handler) GetTempLogs(c *fiber.Ctx) error {
+	page, err := parseKeysetPage(c, tempLogSorts, "-time")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	from, before, err := parseListDates(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	query := database.DB.Model(&models.TempLog{})
+	scope, err := h.scopeList(c)
+	if err != nil {
+		utils.LogError("GetTempLogs - Failed to load device scope: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	if startDate != "" && endDate != "" {
-		query = query.Where("insert_time BETWEEN ? AND ?",
-			startDate+" 00:00:00", endDate+" 23:59:59")
+	filter := repository.ReadingFilter{
+		AllowedIPs:  scope,
+		From:        from,
+		Before:      before,
+		MachineIPs:  splitComma(c.Query("machineIp")),
+		ProbeNo:     c.QueryInt("probeNo", 0),
+		Statuses:    splitComma(c.Query("status")),
+		MachineName: c.Query("machineName"),
+		STypes:      splitComma(c.Query("sType")),
 	}
 
-	var logs []models.TempLog
-	if err := query.Order("insert_time DESC").Limit(limit).Find(&logs).Error; err != nil {
+	if err := setTotalCount(c, func() (int64, error) { return h.repos.Readings.Count(filter) }); err != nil {
+		utils.LogError("GetTempLogs - Failed to count: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	logs, err := h.repos.Readings.List(filter, page.repositoryPage())
+	if err != nil {
 		utils.LogError("GetTempLogs failed: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(logs)
+	return sendPage(c, page, logs, func(l models.TempLog) []interface{} {
+		return repository.SortKey(page.sort, l.MachineIP, l.ProbeNo, l.InsertTime, l.TempValue)
+	})
 }
 
-// GetTempLogReport returns temperature logs for report
-func GetTempLogReport(c *fiber.Ctx) error {
-	startDate := c.Query("startDate")
-	endDate := c.Query("endDate")
-	devices := c.Query("devices") // comma-separated device IDs
+// parseListDates reads startDate and endDate (YYYY-MM-DD in site time, both inclusive) as a
+// [from, before) range; missing dates leave that side open
+func parseListDates(c *fiber.Ctx) (from, before time.Time, err error) {
+	loc := database.SiteLocation()
+	if v := c.Query("startDate"); v != "" {
+		if from, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+			return from, before, errors.New("startDate must be YYYY-MM-DD")
+		}
+	}
+	if v := c.Query("endDate"); v != "" {
+		if before, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+			return from, before, errors.New("endDate must be YYYY-MM-DD")
+		}
+		before = before.AddDate(0, 0, 1)
+	}
+	return from, before, nil
+}
 
-	if startDate == "" || endDate == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "startDate and endDate are required"})
+// GetTempLogReport returns temperature logs for report.
+// startDate/endDate are YYYY-MM-DD (whole days in site time, endDate inclusive) or RFC3339
+// datetimes with an offset (endDate exclusive). devices is a comma-separated list of
+// selectors: ip, ip:probe or machine name. Selected probes without readings are returned as
+// empty series unless includeEmpty=false.
+// resolution=raw (default) returns the raw readings in data. hour|day returns the hourly/daily
+// rollups under rollups instead, and auto picks raw up to 7 days, hourly up to 90 days and
+// daily beyond that.
+// format=csv or format=xlsx downloads the raw readings as a spreadsheet, one section/sheet per probe.
+func (h *Handler) GetTempLogReport(c *fiber.Ctx) error {
+	loc := database.SiteLocation()
+	start, err := parseReportTime(c.Query("startDate"), false, loc)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "startDate: " + err.Error()})
+	}
+	end, err := parseReportTime(c.Query("endDate"), true, loc)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "endDate: " + err.Error()})
+	}
+	if !end.After(start) {
+		return c.Status(400).JSON(fiber.Map{"error": "endDate must be after startDate"})
 	}
 
-	// Parse dates
-	start, _ := time.Parse("2006-01-02", startDate)
-	end, _ := time.Parse("2006-01-02", endDate)
-	end = end.Add(24*time.Hour - time.Second) // Include entire end day
+	// Resolve the selected probes, limited to the user's scope
+	selectors := make([]string, 0)
+	for _, d := range splitComma(c.Query("devices")) {
+		if d = strings.TrimSpace(d); d != "" {
+			selectors = append(selectors, d)
+		}
+	}
+	resolved, unknown, err := services.ResolveDeviceSelectors(h.repos.Devices, selectors)
+	if err != nil {
+		utils.LogError("GetTempLogReport - Failed to resolve devices: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	machines := make([]models.MasterMachine, 0, len(resolved))
+	for _, m := range resolved {
+		if ok, err := h.canSeeIP(c, m.MachineIP); err != nil {
+			utils.LogError("GetTempLogReport - Failed to load device scope: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		} else if ok {
+			machines = append(machines, m)
+		}
+	}
+	if len(unknown) > 0 || (len(selectors) > 0 && len(machines) == 0) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   "devices must be machine IPs, ip:probe or machine names of visible devices",
+			"unknown": unknown,
+		})
+	}
 
-	query := database.DB.Model(&models.TempLog{}).
-		Where("insert_time BETWEEN ? AND ?", start, end)
+	resolution := strings.ToLower(c.Query("resolution", reportResolutionRaw))
+	switch resolution {
+	case reportResolutionAuto:
+		resolution = autoReportResolution(end.Sub(start))
+	case reportResolutionRaw, reportResolutionHour, reportResolutionDay:
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "resolution must be auto, raw, hour or day"})
+	}
 
-	// Filter by devices if specified (now using machine_ip)
-	if devices != "" {
-		ips := splitComma(devices)
-		if len(ips) > 0 {
-			query = query.Where("mcu_id IN ?", ips)
+	// filtered applies the scope, probe and time filters to temp_log or a rollup table
+	filtered := func(model interface{}, timeColumn string) (*gorm.DB, error) {
+		query, err := h.scopeQuery(c, h.db.Model(model), "machine_ip")
+		if err != nil {
+			return nil, err
 		}
+		query = query.Where(timeColumn+" >= ? AND "+timeColumn+" < ?", start, end)
+		if len(selectors) > 0 {
+			keys := make([][2]interface{}, len(machines))
+			for i, m := range machines {
+				keys[i] = [2]interface{}{m.MachineIP, m.ProbeNo}
+			}
+			query = database.WherePairs(query, "machine_ip", "probe_no", keys)
+		}
+		return query, nil
 	}
 
-	var logs []models.TempLog
-	if err := query.Order("insert_time ASC").Find(&logs).Error; err != nil {
-		utils.LogError("GetTempLogReport failed: %v", err)
+	format := strings.ToLower(c.Query("format", "json"))
+	if format != "json" || resolution == reportResolutionRaw {
+		query, err := filtered(&models.TempLog{}, "insert_time")
+		if err != nil {
+			utils.LogError("GetTempLogReport - Failed to load device scope: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		// Spreadsheet exports stream straight from the database
+		if format != "json" {
+			lastDay := end.Add(-time.Nanosecond).In(loc)
+			return h.exportTempLogReport(c, query, format, start.In(loc).Format("2006-01-02"), lastDay.Format("2006-01-02"))
+		}
+		var logs []models.TempLog
+		if err := query.Order("insert_time ASC").Find(&logs).Error; err != nil {
+			utils.LogError("GetTempLogReport failed: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return sendTempLogReport(c, resolution, logs, resolved, machines, loc)
+	}
+
+	// Long ranges read the pre-aggregated rollups instead of every reading
+	var rollups []models.TempLogRollup
+	var model interface{} = &models.TempLogHourly{}
+	if resolution == reportResolutionDay {
+		model = &models.TempLogDaily{}
+	}
+	query, err := filtered(model, "bucket_start")
+	if err != nil {
+		utils.LogError("GetTempLogReport - Failed to load device scope: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	if err := query.Order("bucket_start ASC").Find(&rollups).Error; err != nil {
+		utils.LogError("GetTempLogReport - Failed to load rollups: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return sendTempLogReport(c, resolution, rollups, resolved, machines, loc)
+}
 
-	// Build a lookup map of machine_ip:probe_no -> machine_name
-	var allMachines []models.MasterMachine
-	database.DB.Find(&allMachines)
-	machineNameMap := make(map[string]string)
-	for _, m := range allMachines {
-		key := fmt.Sprintf("%s:%d", m.MachineIP, m.ProbeNo)
-		machineNameMap[key] = m.MachineName
+// Chart resolutions of GET /api/temp-logs/report
+const (
+	reportResolutionAuto = "auto"
+	reportResolutionRaw  = "raw"
+	reportResolutionHour = "hour"
+	reportResolutionDay  = "day"
+)
+
+// autoReportResolution picks the finest resolution that keeps a chart of span readable
+func autoReportResolution(span time.Duration) string {
+	switch {
+	case span <= 7*24*time.Hour:
+		return reportResolutionRaw
+	case span <= 90*24*time.Hour:
+		return reportResolutionHour
 	}
+	return reportResolutionDay
+}
 
-	// Build series for chart
+// sendTempLogReport groups raw readings or rollup rows into one chart series per probe.
+// Rollup points carry the bucket average as y plus its min and max. Raw readings are returned
+// in data as before; rollup rows, which clients must opt into, in rollups.
+func sendTempLogReport[T models.TempLog | models.TempLogRollup](c *fiber.Ctx, resolution string, rows []T, resolved, machines []models.MasterMachine, loc *time.Location) error {
 	type SeriesPoint struct {
-		X string  `json:"x"`
-		Y float64 `json:"y"`
+		X   string   `json:"x"`
+		Y   float64  `json:"y"`
+		Min *float64 `json:"min,omitempty"`
+		Max *float64 `json:"max,omitempty"`
 	}
 	type Series struct {
-		Label string        `json:"label"`
-		Data  []SeriesPoint `json:"data"`
+		Label     string        `json:"label"`
+		MachineIP string        `json:"machineIp"`
+		ProbeNo   int           `json:"probeNo"`
+		Data      []SeriesPoint `json:"data"`
 	}
 
+	series := make([]*Series, 0, len(machines))
 	seriesMap := make(map[string]*Series)
-	for _, logItem := range logs {
-		key := fmt.Sprintf("%s:%d", logItem.MachineIP, logItem.ProbeNo)
-		machineName := machineNameMap[key]
+	addSeries := func(ip string, probeNo int, machineName string) *Series {
 		if machineName == "" {
-			machineName = logItem.MachineIP // fallback to IP
+			machineName = ip // fallback to IP
 		}
-		seriesKey := fmt.Sprintf("%s-P%d", machineName, logItem.ProbeNo)
-		if seriesMap[seriesKey] == nil {
-			seriesMap[seriesKey] = &Series{Label: seriesKey, Data: []SeriesPoint{}}
+		s := &Series{
+			Label:     fmt.Sprintf("%s-P%d", machineName, probeNo),
+			MachineIP: ip,
+			ProbeNo:   probeNo,
+			Data:      []SeriesPoint{},
 		}
-		if logItem.TempValue != nil {
-			seriesMap[seriesKey].Data = append(seriesMap[seriesKey].Data, SeriesPoint{
-				X: logItem.InsertTime.Format(time.RFC3339),
-				Y: *logItem.TempValue,
-			})
+		seriesMap[fmt.Sprintf("%s:%d", ip, probeNo)] = s
+		series = append(series, s)
+		return s
+	}
+
+	machineNameMap := make(map[string]string, len(resolved))
+	for _, m := range resolved {
+		machineNameMap[fmt.Sprintf("%s:%d", m.MachineIP, m.ProbeNo)] = m.MachineName
+	}
+	if includeEmpty, _ := strconv.ParseBool(c.Query("includeEmpty", "true")); includeEmpty {
+		for _, m := range machines {
+			addSeries(m.MachineIP, m.ProbeNo, m.MachineName)
 		}
 	}
 
-	var series []Series
-	for _, s := range seriesMap {
-		series = append(series, *s)
+	for _, row := range rows {
+		var ip string
+		var probeNo int
+		var point *SeriesPoint
+		switch r := any(row).(type) {
+		case models.TempLog:
+			ip, probeNo = r.MachineIP, r.ProbeNo
+			if r.TempValue != nil {
+				point = &SeriesPoint{X: r.InsertTime.In(loc).Format(time.RFC3339), Y: *r.TempValue}
+			}
+		case models.TempLogRollup:
+			ip, probeNo = r.MachineIP, r.ProbeNo
+			minValue, maxValue := r.MinValue, r.MaxValue
+			point = &SeriesPoint{X: r.BucketStart.In(loc).Format(time.RFC3339), Y: r.AvgValue, Min: &minValue, Max: &maxValue}
+		}
+
+		key := fmt.Sprintf("%s:%d", ip, probeNo)
+		s := seriesMap[key]
+		if s == nil {
+			s = addSeries(ip, probeNo, machineNameMap[key])
+		}
+		if point != nil {
+			s.Data = append(s.Data, *point)
+		}
 	}
 
-	return c.JSON(fiber.Map{
-		"data":   logs,
-		"series": series,
+	sort.SliceStable(series, func(i, j int) bool {
+		if series[i].MachineIP != series[j].MachineIP {
+			return series[i].MachineIP < series[j].MachineIP
+		}
+		return series[i].ProbeNo < series[j].ProbeNo
 	})
+
+	body := fiber.Map{
+		"resolution": resolution,
+		"series":     series,
+	}
+	if resolution == reportResolutionRaw {
+		body["data"] = rows
+	} else {
+		body["rollups"] = rows
+	}
+	return c.JSON(body)
+}
+
+// parseReportTime parses a report bound: YYYY-MM-DD in loc (the day after it when isEnd,
+// so the whole end day is included) or an RFC3339 datetime, which must carry an offset
+func parseReportTime(value string, isEnd bool, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("is required")
+	}
+	if day, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		if isEnd {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("must be YYYY-MM-DD or an RFC3339 datetime with offset (2025-01-31T23:00:00+07:00)")
+	}
+	return t.In(loc), nil
 }
 
-// GetTempErrors returns temperature errors
-func GetTempErrors(c *fiber.Ctx) error {
-	var errors []models.TempError
-	if err := database.DB.Order("error_time DESC").Limit(100).Find(&errors).Error; err != nil {
+// tempErrorSorts are the ?sort= options of GET /api/temp-errors
+var tempErrorSorts = repositorySorts("error_time")
+
+// GetTempErrors returns temperature errors, newest first, one cursor page at a time.
+// Filters: startDate, endDate, machineIp (comma list), probeNo, machineName (partial), sType,
+// errorType (o|n), state (open|closed). Paging as GetTempLogs.
+func (h *Handler) GetTempErrors(c *fiber.Ctx) error {
+	page, err := parseKeysetPage(c, tempErrorSorts, "-time")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	from, before, err := parseListDates(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	filter := repository.IncidentFilter{
+		From:        from,
+		Before:      before,
+		MachineIPs:  splitComma(c.Query("machineIp")),
+		ProbeNo:     c.QueryInt("probeNo", 0),
+		MachineName: c.Query("machineName"),
+		STypes:      splitComma(c.Query("sType")),
+		ErrorTypes:  splitComma(c.Query("errorType")),
+	}
+	switch c.Query("state") {
+	case "":
+	case "open":
+		filter.State = repository.IncidentOpen
+	case "closed":
+		filter.State = repository.IncidentClosed
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "state must be open or closed"})
+	}
+
+	if filter.AllowedIPs, err = h.scopeList(c); err != nil {
+		utils.LogError("GetTempErrors - Failed to load device scope: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := setTotalCount(c, func() (int64, error) { return h.repos.Incidents.Count(filter) }); err != nil {
+		utils.LogError("GetTempErrors - Failed to count: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	incidents, err := h.repos.Incidents.List(filter, page.repositoryPage())
+	if err != nil {
 		utils.LogError("GetTempErrors failed: %v", err)
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	return c.JSON(errors)
+
+	return sendPage(c, page, incidents, func(e models.TempError) []interface{} {
+		return repository.SortKey(page.sort, e.MachineIP, e.ProbeNo, e.ErrorTime, e.TempValue)
+	})
+}
+
+// AcknowledgeTempErrorRequest is the body of POST /api/temp-errors/ack
+type AcknowledgeTempErrorRequest struct {
+	MachineIP string    `json:"machineIp"`
+	ProbeNo   int       `json:"probeNo"`
+	ErrorTime time.Time `json:"errorTime"`
+	Comment   string    `json:"comment"`
+}
+
+// AcknowledgeTempError marks a temp_error as finished and records who acknowledged it
+func (h *Handler) AcknowledgeTempError(c *fiber.Ctx) error {
+	var req AcknowledgeTempErrorRequest
+	if err := c.BodyParser(&req); err != nil {
+		utils.LogError("AcknowledgeTempError - Failed to parse body: %v", err)
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.MachineIP == "" || req.ErrorTime.IsZero() {
+		return c.Status(400).JSON(fiber.Map{"error": "machineIp and errorTime are required"})
+	}
+	if ok, err := h.canSeeIP(c, req.MachineIP); err != nil || !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Temp error not found"})
+	}
+
+	user := currentUser(c)
+	ack := models.TempErrorAck{
+		MachineIP: req.MachineIP,
+		ProbeNo:   req.ProbeNo,
+		ErrorTime: req.ErrorTime,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Comment:   req.Comment,
+		AckTime:   database.SiteNow(),
+	}
+
+	err := h.repos.Incidents.Acknowledge(&ack)
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Temp error not found"})
+	}
+	if err != nil {
+		utils.LogError("AcknowledgeTempError - Failed (ip=%s, probe=%d): %v", req.MachineIP, req.ProbeNo, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	log.Printf("Temp error acknowledged by %s: %s Probe %d at %s", user.Username, req.MachineIP, req.ProbeNo, req.ErrorTime.Format("2006-01-02 15:04:05"))
+	return c.JSON(ack)
 }
 
 // TriggerPoll manually triggers a poll
-func TriggerPoll(c *fiber.Ctx) error {
+func (h *Handler) TriggerPoll(c *fiber.Ctx) error {
 	log.Println("Manual poll triggered")
 
 	// Run poll in goroutine
@@ -317,12 +732,19 @@ func TriggerPoll(c *fiber.Ctx) error {
 }
 
 // TemperatureStream handles SSE for real-time updates
-func TemperatureStream(c *fiber.Ctx) error {
+func (h *Handler) TemperatureStream(c *fiber.Ctx) error {
 	c.Set("Content-Type", "text/event-stream")
 	c.Set("Cache-Control", "no-cache")
 	c.Set("Connection", "keep-alive")
 	c.Set("Access-Control-Allow-Origin", "*")
 
+	// Resolve the device scope before the handler returns; Locals are not usable in the stream writer
+	scope, err := h.allowedIPs(c)
+	if err != nil {
+		utils.LogError("TemperatureStream - Failed to load device scope: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	// Subscribe to both data saved events and temperature updates from polling service
 	eventChan := services.GlobalPollingService.Subscribe()
 	tempChan := services.GlobalPollingService.SubscribeTemperature()
@@ -353,12 +775,24 @@ func TemperatureStream(c *fiber.Ctx) error {
 				if !ok {
 					return
 				}
+				if scope != nil {
+					scoped := make([]services.TemperatureUpdateEvent, 0, len(tempEvents))
+					for _, e := range tempEvents {
+						if scope[e.MachineIP] {
+							scoped = append(scoped, e)
+						}
+					}
+					if len(scoped) == 0 {
+						continue
+					}
+					tempEvents = scoped
+				}
 				// Send temperature data from polling service (every 5 seconds)
 				data, err := json.Marshal(fiber.Map{
 					"type":        "temperature",
 					"data":        tempEvents,
 					"count":       len(tempEvents),
-					"lastUpdated": time.Now().Format("2006-01-02 15:04:05"),
+					"lastUpdated": database.SiteNow().Format(time.RFC3339),
 				})
 				if err == nil {
 					fmt.Fprintf(w, "data: %s\n\n", data)
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/services"
+)
+
+// testAPI serves the device, temp-log and user-scope routes of main.go on in-memory repositories
+type testAPI struct {
+	app   *fiber.App
+	repos *repository.Set
+	auth  *services.AuthService
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	t.Chdir(t.TempDir()) // utils.LogError writes to ./logs
+	t.Setenv("JWT_SECRET", "test-secret")
+	repos := repository.NewMemory().Set()
+	auth, err := services.NewAuthService(repos.Users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(nil, repos, auth)
+
+	app := fiber.New()
+	api := app.Group("/api")
+	api.Post("/auth/login", h.Login)
+	api.Use(h.RequireAuth)
+	admin := RequireRole(RoleAdmin)
+	api.Get("/users/:id/devices", admin, h.GetUserDevices)
+	api.Put("/users/:id/devices", admin, h.SetUserDevices)
+	api.Get("/devices", h.GetDevices)
+	api.Get("/devices/:id", h.GetDevice)
+	api.Post("/devices", admin, h.CreateDevice)
+	api.Get("/temp-logs", h.GetTempLogs)
+
+	return &testAPI{app: app, repos: repos, auth: auth}
+}
+
+// user creates a master_user and returns an access token for it
+func (a *testAPI) user(t *testing.T, username, role string) (int, string) {
+	t.Helper()
+	hash, err := services.HashPassword("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.MasterUser{Username: username, Password: hash, Role: &role}
+	if err := a.repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := a.auth.IssueTokens(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID, tokens.AccessToken
+}
+
+// device adds probe 1 of ip with one reading
+func (a *testAPI) device(t *testing.T, ip string, at time.Time) {
+	t.Helper()
+	machine := models.MasterMachine{MachineIP: ip, ProbeNo: 1, ProbeAll: 1, MachineName: "Fridge " + ip}
+	if err := a.repos.Devices.Create(&machine, func(before, after []models.MasterMachine) []models.AuditLog { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	value := 4.0
+	if err := a.repos.Readings.Insert(&models.TempLog{MachineIP: ip, ProbeNo: 1, TempValue: &value, InsertTime: at}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// do sends a request and decodes a JSON response into out (when not nil)
+func (a *testAPI) do(t *testing.T, method, path, token, body string, out interface{}) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := a.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			t.Fatalf("%s %s: decode %s: %v", method, path, raw, err)
+		}
+	}
+	return resp
+}
+
+func deviceIPs(machines []models.MasterMachine) string {
+	ips := make([]string, len(machines))
+	for i, m := range machines {
+		ips[i] = m.MachineIP
+	}
+	return strings.Join(ips, ",")
+}
+
+func TestLogin(t *testing.T) {
+	api := newTestAPI(t)
+	api.user(t, "nurse", RoleViewer)
+
+	var body struct {
+		Tokens services.TokenPair `json:"tokens"`
+	}
+	if resp := api.do(t, "POST", "/api/auth/login", "", `{"username":"nurse","password":"password123"}`, &body); resp.StatusCode != 200 {
+		t.Fatalf("login: status %d", resp.StatusCode)
+	}
+	if resp := api.do(t, "GET", "/api/devices", body.Tokens.AccessToken, "", nil); resp.StatusCode != 200 {
+		t.Errorf("GET /devices with the issued token: status %d", resp.StatusCode)
+	}
+	if resp := api.do(t, "POST", "/api/auth/login", "", `{"username":"nurse","password":"wrong-password"}`, nil); resp.StatusCode != 401 {
+		t.Errorf("wrong password: status %d, want 401", resp.StatusCode)
+	}
+	if resp := api.do(t, "GET", "/api/devices", "", "", nil); resp.StatusCode != 401 {
+		t.Errorf("no token: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestDevicesScopedToUser(t *testing.T) {
+	api := newTestAPI(t)
+	at := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+	api.device(t, "10.0.0.1", at)
+	api.device(t, "10.0.0.2", at)
+	_, adminToken := api.user(t, "admin", RoleAdmin)
+	viewerID, viewerToken := api.user(t, "nurse", RoleViewer)
+
+	// No user_device rows: every device is visible
+	var machines []models.MasterMachine
+	api.do(t, "GET", "/api/devices", viewerToken, "", &machines)
+	if got := deviceIPs(machines); got != "10.0.0.1,10.0.0.2" {
+		t.Fatalf("unscoped devices = %s", got)
+	}
+
+	if resp := api.do(t, "PUT", fmt.Sprintf("/api/users/%d/devices", viewerID), viewerToken, `{"machineIps":["10.0.0.1"]}`, nil); resp.StatusCode != 403 {
+		t.Errorf("viewer setting its own scope: status %d, want 403", resp.StatusCode)
+	}
+	var scope struct {
+		MachineIPs []string `json:"machineIps"`
+	}
+	if resp := api.do(t, "PUT", fmt.Sprintf("/api/users/%d/devices", viewerID), adminToken, `{"machineIps":["10.0.0.1"," 10.0.0.1",""]}`, &scope); resp.StatusCode != 200 {
+		t.Fatalf("set scope: status %d", resp.StatusCode)
+	}
+	if strings.Join(scope.MachineIPs, ",") != "10.0.0.1" {
+		t.Errorf("stored scope = %v, want [10.0.0.1]", scope.MachineIPs)
+	}
+	if resp := api.do(t, "PUT", "/api/users/999/devices", adminToken, `{"machineIps":["10.0.0.1"]}`, nil); resp.StatusCode != 404 {
+		t.Errorf("unknown user: status %d, want 404", resp.StatusCode)
+	}
+
+	machines = nil
+	api.do(t, "GET", "/api/devices", viewerToken, "", &machines)
+	if got := deviceIPs(machines); got != "10.0.0.1" {
+		t.Errorf("scoped devices = %s, want 10.0.0.1", got)
+	}
+	if resp := api.do(t, "GET", "/api/devices/10.0.0.2", viewerToken, "", nil); resp.StatusCode != 404 {
+		t.Errorf("device outside the scope: status %d, want 404", resp.StatusCode)
+	}
+	if resp := api.do(t, "GET", "/api/devices/10.0.0.1", viewerToken, "", nil); resp.StatusCode != 200 {
+		t.Errorf("device inside the scope: status %d, want 200", resp.StatusCode)
+	}
+
+	// Admins are never scoped
+	machines = nil
+	api.do(t, "GET", "/api/devices", adminToken, "", &machines)
+	if got := deviceIPs(machines); got != "10.0.0.1,10.0.0.2" {
+		t.Errorf("admin devices = %s", got)
+	}
+}
+
+func TestCreateDeviceValidation(t *testing.T) {
+	api := newTestAPI(t)
+	_, adminToken := api.user(t, "admin", RoleAdmin)
+	_, viewerToken := api.user(t, "nurse", RoleViewer)
+
+	body := `{"machineIp":"10.0.0.3","probeNo":1,"machineName":"Freezer","minTemp":-25,"maxTemp":-15}`
+	if resp := api.do(t, "POST", "/api/devices", viewerToken, body, nil); resp.StatusCode != 403 {
+		t.Errorf("viewer: status %d, want 403", resp.StatusCode)
+	}
+	if resp := api.do(t, "POST", "/api/devices", adminToken, body, nil); resp.StatusCode != 201 {
+		t.Fatalf("create: status %d, want 201", resp.StatusCode)
+	}
+	if resp := api.do(t, "POST", "/api/devices", adminToken, body, nil); resp.StatusCode != 409 {
+		t.Errorf("create again: status %d, want 409", resp.StatusCode)
+	}
+	if resp := api.do(t, "POST", "/api/devices", adminToken, `{"machineIp":"10.0.0.4","minTemp":8,"maxTemp":2}`, nil); resp.StatusCode != 422 {
+		t.Errorf("minTemp above maxTemp: status %d, want 422", resp.StatusCode)
+	}
+	if _, err := api.repos.Devices.Get("10.0.0.3", 1); err != nil {
+		t.Errorf("created device not stored: %v", err)
+	}
+}
+
+func TestTempLogsPagedAndScoped(t *testing.T) {
+	api := newTestAPI(t)
+	at := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+	api.device(t, "10.0.0.1", at)
+	api.device(t, "10.0.0.2", at)
+	value := 5.0
+	for i := 1; i <= 4; i++ {
+		log := models.TempLog{MachineIP: "10.0.0.1", ProbeNo: 1, TempValue: &value, InsertTime: at.Add(time.Duration(i) * time.Minute)}
+		if err := api.repos.Readings.Insert(&log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	viewerID, viewerToken := api.user(t, "nurse", RoleViewer)
+	if err := api.repos.Users.SetDeviceIPs(viewerID, []string{"10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	_, adminToken := api.user(t, "admin", RoleAdmin)
+
+	// Admin pages through all 6 rows, newest first, 4 at a time
+	var logs []models.TempLog
+	resp := api.do(t, "GET", "/api/temp-logs?limit=4", adminToken, "", &logs)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if total := resp.Header.Get("X-Total-Count"); total != "6" {
+		t.Errorf("X-Total-Count = %q, want 6", total)
+	}
+	cursor := resp.Header.Get("X-Next-Cursor")
+	if len(logs) != 4 || cursor == "" {
+		t.Fatalf("first page: %d rows, cursor %q", len(logs), cursor)
+	}
+	if !logs[0].InsertTime.Equal(at.Add(4 * time.Minute)) {
+		t.Errorf("first row at %v, want the newest", logs[0].InsertTime)
+	}
+
+	var rest []models.TempLog
+	resp = api.do(t, "GET", "/api/temp-logs?limit=4&cursor="+cursor, adminToken, "", &rest)
+	if len(rest) != 2 || resp.Header.Get("X-Next-Cursor") != "" {
+		t.Errorf("second page: %d rows, cursor %q; want the last 2 and no cursor", len(rest), resp.Header.Get("X-Next-Cursor"))
+	}
+
+	// The viewer only sees the device in its scope, even when asking for another
+	logs = nil
+	api.do(t, "GET", "/api/temp-logs", viewerToken, "", &logs)
+	if len(logs) != 1 || logs[0].MachineIP != "10.0.0.2" {
+		t.Errorf("scoped temp logs = %d rows, want the one from 10.0.0.2", len(logs))
+	}
+	logs = nil
+	api.do(t, "GET", "/api/temp-logs?machineIp=10.0.0.1", viewerToken, "", &logs)
+	if len(logs) != 0 {
+		t.Errorf("temp logs outside the scope: %d rows, want none", len(logs))
+	}
+}
+
+func TestParseReportTime(t *testing.T) {
+	loc := time.FixedZone("ICT", 7*3600)
+	tests := []struct {
+		value   string
+		isEnd   bool
+		want    time.Time
+		wantErr bool
+	}{
+		{"2025-01-06", false, time.Date(2025, 1, 6, 0, 0, 0, 0, loc), false},
+		{"2025-01-06", true, time.Date(2025, 1, 7, 0, 0, 0, 0, loc), false}, // whole end day
+		{"2025-12-31", true, time.Date(2026, 1, 1, 0, 0, 0, 0, loc), false},
+		{"2025-01-06T08:30:00+07:00", false, time.Date(2025, 1, 6, 8, 30, 0, 0, loc), false},
+		{"2025-01-06T01:30:00Z", true, time.Date(2025, 1, 6, 8, 30, 0, 0, loc), false}, // datetimes are exclusive as given
+		{"", false, time.Time{}, true},
+		{"2025-02-30", false, time.Time{}, true},
+		{"06/01/2025", false, time.Time{}, true},
+		{"2025-01-06 08:30:00", false, time.Time{}, true},
+		{"2025-01-06T08:30:00", false, time.Time{}, true}, // no offset
+	}
+	for _, tc := range tests {
+		got, err := parseReportTime(tc.value, tc.isEnd, loc)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseReportTime(%q) = %v, want an error", tc.value, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("parseReportTime(%q, %v) = %v, %v; want %v", tc.value, tc.isEnd, got, err, tc.want)
+		}
+	}
+}
+
+func TestGetTempLogReportDates(t *testing.T) {
+	h := New(nil, repository.NewMemory().Set(), nil)
+	app := fiber.New()
+	app.Get("/reports/templog", h.GetTempLogReport)
+
+	for _, query := range []string{
+		"",
+		"startDate=2025-01-01",
+		"endDate=2025-01-31",
+		"startDate=2025-01-31&endDate=2025-01-01",
+		"startDate=2025-01-06T10:00:00%2B07:00&endDate=2025-01-06T10:00:00%2B07:00",
+		"startDate=2025-01-06T10:00:00%2B07:00&endDate=2025-01-06T03:00:00Z",
+		"startDate=2025-01-01&endDate=yesterday",
+	} {
+		if status, body := send(t, app, "GET", "/reports/templog?"+query, ""); status != 400 {
+			t.Errorf("%q: status = %d (%s), want 400", query, status, body)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// IngestReadingRequest is one reading pushed by an IoT gateway
+type IngestReadingRequest struct {
+	DeviceID  string   `json:"deviceId"`  // alias for machineIp
+	MachineIP string   `json:"machineIp"` // master_machine.machine_ip
+	ProbeNo   int      `json:"probeNo"`
+	Value     *float64 `json:"value"`
+	RealValue *int     `json:"realValue"` // raw device value, stored as NULL when omitted
+	Timestamp string   `json:"timestamp"` // RFC3339 or "2006-01-02 15:04:05" (site time)
+}
+
+// IngestResult reports what happened to one reading in the request
+type IngestResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"` // accepted, duplicate, rejected
+	Error  string `json:"error,omitempty"`
+}
+
+// Maximum readings per request and how far back/forward a timestamp may be
+const (
+	maxIngestBatch     = 1000
+	maxIngestClockSkew = 5 * time.Minute
+)
+
+// ingestGateway is a gateway and the devices its API key may write for
+type ingestGateway struct {
+	name       string
+	devices    map[string]bool
+	anyDevices bool // "*" in the device list
+}
+
+// allows reports whether the gateway may push readings for the device IP
+func (g ingestGateway) allows(ip string) bool {
+	return g.anyDevices || g.devices[ip]
+}
+
+var (
+	ingestAPIKeys     map[string]ingestGateway // key -> gateway
+	ingestAPIKeysOnce sync.Once
+	ingestMaxAge      = 30 * 24 * time.Hour
+)
+
+// loadIngestConfig reads INGEST_API_KEYS and INGEST_MAX_AGE.
+// Keys are "gateway1=key1:10.0.0.5,10.0.0.6;gateway2=key2:*", where the list after
+// the colon holds the device IPs the key may write for ("*" allows every device).
+func loadIngestConfig() {
+	ingestAPIKeys = make(map[string]ingestGateway)
+	for _, entry := range strings.Split(os.Getenv("INGEST_API_KEYS"), ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rest, _ := strings.Cut(entry, "=")
+		key, devices, _ := strings.Cut(rest, ":")
+		key = strings.TrimSpace(key)
+		if key == "" || strings.TrimSpace(devices) == "" {
+			utils.LogError("INGEST_API_KEYS - Ignoring malformed entry for %q (expected name=key:ip1,ip2)", strings.TrimSpace(name))
+			continue
+		}
+
+		gateway := ingestGateway{name: strings.TrimSpace(name), devices: make(map[string]bool)}
+		for _, ip := range strings.Split(devices, ",") {
+			ip = strings.TrimSpace(ip)
+			if ip == "*" {
+				gateway.anyDevices = true
+			} else if ip != "" {
+				gateway.devices[ip] = true
+			}
+		}
+		ingestAPIKeys[key] = gateway
+	}
+
+	if v := os.Getenv("INGEST_MAX_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			ingestMaxAge = d
+		} else {
+			utils.LogError("INGEST_MAX_AGE - Invalid duration %q: %v", v, err)
+		}
+	}
+}
+
+// IngestAPIKeyAuth authenticates gateways by the X-API-Key header
+func IngestAPIKeyAuth(c *fiber.Ctx) error {
+	ingestAPIKeysOnce.Do(loadIngestConfig)
+
+	if len(ingestAPIKeys) == 0 {
+		return c.Status(503).JSON(fiber.Map{"error": "Ingestion is disabled (INGEST_API_KEYS not configured)"})
+	}
+
+	provided := c.Get("X-API-Key")
+	if provided == "" {
+		provided = strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	}
+
+	for key, gateway := range ingestAPIKeys {
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) == 1 {
+			c.Locals("gateway", gateway)
+			return c.Next()
+		}
+	}
+
+	utils.LogError("IngestReadings - Invalid API key from %s", c.IP())
+	return c.Status(401).JSON(fiber.Map{"error": "Invalid API key"})
+}
+
+// IngestReadings accepts a single reading, an array, or {"readings": [...]} from gateways
+func (h *Handler) IngestReadings(c *fiber.Ctx) error {
+	if services.GlobalPollingService == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Polling service not ready"})
+	}
+
+	readings, err := parseIngestBody(c.Body())
+	if err != nil {
+		utils.LogError("IngestReadings - Failed to parse body: %v", err)
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(readings) > maxIngestBatch {
+		return c.Status(413).JSON(fiber.Map{"error": fmt.Sprintf("batch exceeds %d readings", maxIngestBatch)})
+	}
+
+	gateway, _ := c.Locals("gateway").(ingestGateway)
+	var forbidden []string
+	for _, req := range readings {
+		if ip := req.deviceIP(); ip != "" && !gateway.allows(ip) {
+			forbidden = append(forbidden, ip)
+		}
+	}
+	if len(forbidden) > 0 {
+		utils.LogError("IngestReadings - Gateway %s is not allowed to write for %s", gateway.name, strings.Join(forbidden, ", "))
+		return c.Status(403).JSON(fiber.Map{"error": fmt.Sprintf("API key is not allowed to write for device(s): %s", strings.Join(forbidden, ", "))})
+	}
+
+	now := database.SiteNow()
+
+	results := make([]IngestResult, 0, len(readings))
+	accepted, duplicates, rejected := 0, 0, 0
+
+	for i, req := range readings {
+		result := IngestResult{Index: i, Status: "accepted"}
+
+		reading, err := req.toReading(now)
+		if err == nil {
+			err = services.GlobalPollingService.IngestReading(reading)
+		}
+
+		switch {
+		case err == nil:
+			accepted++
+		case errors.Is(err, services.ErrDuplicateReading):
+			result.Status = "duplicate"
+			duplicates++
+		default:
+			result.Status = "rejected"
+			result.Error = err.Error()
+			rejected++
+			utils.LogError("IngestReadings - Rejected reading %d from gateway %s: %v", i, gateway.name, err)
+		}
+		results = append(results, result)
+	}
+
+	return c.JSON(fiber.Map{
+		"accepted":   accepted,
+		"duplicates": duplicates,
+		"rejected":   rejected,
+		"results":    results,
+	})
+}
+
+// parseIngestBody accepts an object, an array, or an envelope with "readings"
+func parseIngestBody(body []byte) ([]IngestReadingRequest, error) {
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "" {
+		return nil, fmt.Errorf("empty body")
+	}
+
+	if strings.HasPrefix(trimmed, "[") {
+		var readings []IngestReadingRequest
+		if err := json.Unmarshal(body, &readings); err != nil {
+			return nil, err
+		}
+		return readings, nil
+	}
+
+	var envelope struct {
+		Readings []IngestReadingRequest `json:"readings"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Readings != nil {
+		return envelope.Readings, nil
+	}
+
+	var single IngestReadingRequest
+	if err := json.Unmarshal(body, &single); err != nil {
+		return nil, err
+	}
+	return []IngestReadingRequest{single}, nil
+}
+
+// deviceIP returns machineIp, falling back to deviceId
+func (r IngestReadingRequest) deviceIP() string {
+	if r.MachineIP != "" {
+		return r.MachineIP
+	}
+	return r.DeviceID
+}
+
+// toReading validates the request and converts it for the polling pipeline.
+// The timestamp is required: it is the idempotency key together with device and probe.
+func (r IngestReadingRequest) toReading(now time.Time) (services.Reading, error) {
+	ip := r.deviceIP()
+	if ip == "" {
+		return services.Reading{}, fmt.Errorf("machineIp or deviceId is required")
+	}
+	if r.Value == nil {
+		return services.Reading{}, fmt.Errorf("value is required")
+	}
+	if r.Timestamp == "" {
+		return services.Reading{}, fmt.Errorf("timestamp is required")
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, r.Timestamp)
+	if err != nil {
+		ts, err = time.ParseInLocation("2006-01-02 15:04:05", r.Timestamp, now.Location())
+		if err != nil {
+			return services.Reading{}, fmt.Errorf("invalid timestamp %q", r.Timestamp)
+		}
+	}
+	ts = ts.In(now.Location()).Truncate(time.Microsecond)
+
+	if ts.After(now.Add(maxIngestClockSkew)) {
+		return services.Reading{}, fmt.Errorf("timestamp %s is in the future", r.Timestamp)
+	}
+	if ts.Before(now.Add(-ingestMaxAge)) {
+		return services.Reading{}, fmt.Errorf("timestamp %s is older than %v", r.Timestamp, ingestMaxAge)
+	}
+
+	return services.Reading{
+		MachineIP:  ip,
+		ProbeNo:    r.ProbeNo,
+		TempValue:  *r.Value,
+		RealValue:  r.RealValue,
+		SendTime:   ts,
+		InsertTime: ts,
+		Source:     services.ReadingSourceHTTP,
+	}, nil
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// GetMKT returns the rolling 30-day and calendar-month Mean Kinetic Temperature of
+// temperature probes. Query: devices (comma-separated ip or ip:probe, default all),
+// month (YYYY-MM, default the current month).
+func (h *Handler) GetMKT(c *fiber.Ctx) error {
+	now := database.SiteNow()
+	month, err := services.ParseReportMonth(c.Query("month", now.Format("2006-01")))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	settings, err := services.LoadMKTSettings(h.repos.Config)
+	if err != nil {
+		utils.LogError("GetMKT - Failed to load MKT settings: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	machines, err := services.ResolveReportDevices(h.repos.Devices, c.Query("devices"))
+	if err != nil {
+		utils.LogError("GetMKT - Failed to load machines: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	results := make([]services.ProbeMKT, 0, len(machines))
+	for _, m := range machines {
+		if !m.IsTemperatureType() {
+			continue
+		}
+		if ok, err := h.canSeeIP(c, m.MachineIP); err != nil || !ok {
+			continue
+		}
+		result, err := services.CalculateProbeMKT(h.db, m, settings, now, month)
+		if err != nil {
+			utils.LogError("GetMKT - Failed to calculate MKT (ip=%s, probe=%d): %v", m.MachineIP, m.ProbeNo, err)
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		results = append(results, result)
+	}
+
+	return c.JSON(fiber.Map{
+		"activationEnergy": settings.ActivationEnergy,
+		"month":            month.Format("2006-01"),
+		"probes":           results,
+	})
+}
+
+// GetMKTSettings returns the activation energy and MKT limits
+func (h *Handler) GetMKTSettings(c *fiber.Ctx) error {
+	settings, err := services.LoadMKTSettings(h.repos.Config)
+	if err != nil {
+		utils.LogError("GetMKTSettings failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(settings)
+}
+
+// UpdateMKTSettings replaces the activation energy and MKT limits. The change is audited.
+func (h *Handler) UpdateMKTSettings(c *fiber.Ctx) error {
+	var req struct {
+		services.MKTSettings
+		Reason string `json:"reason"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	settings := req.MKTSettings
+
+	var errs fieldErrors
+	if settings.ActivationEnergy <= 0 || settings.ActivationEnergy > 500 {
+		errs.add("activationEnergy", "must be greater than 0 and at most 500 kJ/mol")
+	}
+	if settings.Limit < -100 || settings.Limit > 100 {
+		errs.add("limit", "must be between -100 and 100")
+	}
+	probeLimits := make(map[string]float64, len(settings.ProbeLimits))
+	for probe, limit := range settings.ProbeLimits {
+		ip, no, _ := strings.Cut(probe, ":")
+		if n, err := strconv.Atoi(no); net.ParseIP(ip) == nil || err != nil || n < 1 {
+			errs.add("probeLimits", "%q must be <ip>:<probe>", probe)
+			continue
+		}
+		if limit < -100 || limit > 100 {
+			errs.add("probeLimits", "%q must be between -100 and 100", probe)
+			continue
+		}
+		probeLimits[probe] = limit
+	}
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+	settings.ProbeLimits = probeLimits
+
+	before, err := services.LoadMKTSettings(h.repos.Config)
+	if err != nil {
+		utils.LogError("UpdateMKTSettings - Failed to load MKT settings: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = auditReason(c)
+	}
+	entry := newAuditEntry(c, "config_value", "mkt", reason)
+	entry.Action = models.AuditActionUpdate
+	entry.BeforeValue, _ = json.Marshal(before)
+	entry.AfterValue, _ = json.Marshal(settings)
+	audit := make([]models.AuditLog, 0, 1)
+	if changed := changedFields(entry.BeforeValue, entry.AfterValue); len(changed) > 0 {
+		entry.ChangedFields = strings.Join(changed, ",")
+		audit = append(audit, entry)
+	}
+	err = services.SaveMKTSettings(h.repos.Config, settings, audit)
+	if err != nil {
+		utils.LogError("UpdateMKTSettings failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(settings)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/repository"
+)
+
+// Page size limits for cursor-paginated lists
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// cursorTimeLayout is how datetime keys are written into cursors. They are parsed back into
+// site-time time.Time values so the driver formats them like stored values (SQLite compares
+// datetimes as text).
+const cursorTimeLayout = time.RFC3339Nano
+
+// keysetPage is a cursor (keyset) page over a list sorted by a unique tuple of columns.
+// The cursor holds the sort key of the last row returned, so deep pages cost the same as
+// the first one and rows inserted meanwhile do not shift later pages.
+type keysetPage struct {
+	sort   string   // sort name without the "-"
+	keys   []string // sort key columns; the last ones make the tuple unique
+	desc   bool
+	limit  int
+	cursor []interface{}
+}
+
+// parseKeysetPage reads sort, limit and cursor. sorts maps the sort names a list accepts to
+// their key columns; a leading "-" on ?sort= sorts descending. defaultSort may itself start with "-".
+func parseKeysetPage(c *fiber.Ctx, sorts map[string][]string, defaultSort string) (*keysetPage, error) {
+	sort := c.Query("sort", defaultSort)
+	page := &keysetPage{desc: strings.HasPrefix(sort, "-")}
+	page.sort = strings.TrimPrefix(sort, "-")
+	keys, ok := sorts[page.sort]
+	if !ok {
+		names := make([]string, 0, len(sorts))
+		for name := range sorts {
+			names = append(names, name)
+		}
+		return nil, fmt.Errorf("sort must be one of %s (prefix - for descending)", strings.Join(names, ", "))
+	}
+	page.keys = keys
+
+	page.limit = defaultPageSize
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("limit must be a positive integer (at most %d)", maxPageSize)
+		}
+		page.limit = min(limit, maxPageSize)
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err == nil {
+			err = json.Unmarshal(raw, &page.cursor)
+		}
+		if err != nil || len(page.cursor) != len(keys) {
+			return nil, fmt.Errorf("invalid cursor (it must come from X-Next-Cursor with the same sort)")
+		}
+		for i, v := range page.cursor {
+			if s, ok := v.(string); ok {
+				if t, err := time.Parse(cursorTimeLayout, s); err == nil {
+					page.cursor[i] = database.SiteTime(t)
+				}
+			}
+		}
+	}
+	return page, nil
+}
+
+// repositoryPage is the page as a repository list takes it. One extra row is fetched to tell
+// whether another page follows.
+func (p *keysetPage) repositoryPage() repository.Page {
+	return repository.Page{Sort: p.sort, Desc: p.desc, Limit: p.limit + 1, Cursor: p.cursor}
+}
+
+// repositorySorts are the ?sort= options of a repository list on a table whose time column is timeColumn
+func repositorySorts(timeColumn string) map[string][]string {
+	return map[string][]string{
+		repository.SortTime:    repository.SortColumns(repository.SortTime, timeColumn),
+		repository.SortMachine: repository.SortColumns(repository.SortMachine, timeColumn),
+		repository.SortValue:   repository.SortColumns(repository.SortValue, timeColumn),
+	}
+}
+
+// setTotalCount counts the filtered rows (before paging) into X-Total-Count unless ?count=false
+func setTotalCount(c *fiber.Ctx, count func() (int64, error)) error {
+	if want, _ := strconv.ParseBool(c.Query("count", "true")); !want {
+		return nil
+	}
+	total, err := count()
+	if err != nil {
+		return err
+	}
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
+	return nil
+}
+
+// sendPage trims the extra row, sets X-Next-Cursor when another page follows and sends rows.
+// keyOf returns a row's sort key values in the order of p.keys.
+func sendPage[T any](c *fiber.Ctx, p *keysetPage, rows []T, keyOf func(T) []interface{}) error {
+	if len(rows) > p.limit {
+		rows = rows[:p.limit]
+		key := keyOf(rows[len(rows)-1])
+		for i, v := range key {
+			if t, ok := v.(time.Time); ok {
+				key[i] = t.Format(cursorTimeLayout)
+			}
+		}
+		raw, _ := json.Marshal(key)
+		c.Set("X-Next-Cursor", base64.RawURLEncoding.EncodeToString(raw))
+	}
+	return c.JSON(rows)
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// encodeCursor builds a cursor the way sendPage does
+func encodeCursor(key ...interface{}) string {
+	raw, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func TestParseKeysetPage(t *testing.T) {
+	var got *keysetPage
+	app := fiber.New()
+	app.Get("/logs", func(c *fiber.Ctx) error {
+		page, err := parseKeysetPage(c, tempLogSorts, "-time")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		got = page
+		return c.SendStatus(204)
+	})
+
+	valid := []struct {
+		query  string
+		keys   []string
+		desc   bool
+		limit  int
+		cursor []interface{}
+	}{
+		{"", tempLogSorts["time"], true, defaultPageSize, nil},
+		{"sort=machine&limit=50", tempLogSorts["machine"], false, 50, nil},
+		{"sort=-value&limit=5000", tempLogSorts["value"], true, maxPageSize, nil},
+		{"cursor=" + encodeCursor("2025-01-06T08:30:00.123456+07:00", "10.0.0.5", 2), tempLogSorts["time"], true, defaultPageSize,
+			[]interface{}{database.SiteTime(time.Date(2025, 1, 6, 8, 30, 0, 123456000, time.FixedZone("", 7*3600))), "10.0.0.5", 2.0}},
+	}
+	for _, tc := range valid {
+		got = nil
+		if status, body := send(t, app, "GET", "/logs?"+tc.query, ""); status != 204 {
+			t.Errorf("%q: status = %d (%s), want 204", tc.query, status, body)
+			continue
+		}
+		if !reflect.DeepEqual(got.keys, tc.keys) || got.desc != tc.desc || got.limit != tc.limit || !reflect.DeepEqual(got.cursor, tc.cursor) {
+			t.Errorf("%q: page = %+v, want keys %v desc %v limit %d cursor %v", tc.query, *got, tc.keys, tc.desc, tc.limit, tc.cursor)
+		}
+	}
+
+	for _, query := range []string{
+		"sort=name",
+		"limit=0",
+		"limit=-5",
+		"limit=ten",
+		"cursor=not*base64",
+		"cursor=" + base64.RawURLEncoding.EncodeToString([]byte("{not json")),
+		"cursor=" + encodeCursor("2025-01-06 08:30:00.000000"),             // too short for the sort
+		"sort=machine&cursor=" + encodeCursor(-999999, "2025-01-06", 1, 2), // from another sort
+	} {
+		if status, body := send(t, app, "GET", "/logs?"+query, ""); status != 400 {
+			t.Errorf("%q: status = %d (%s), want 400", query, status, body)
+		}
+	}
+}
+
+func TestSendPageCursor(t *testing.T) {
+	at := time.Date(2025, 1, 6, 8, 30, 0, 123456000, time.FixedZone("ICT", 7*3600))
+	value := 4.5
+	logs := []models.TempLog{
+		{MachineIP: "10.0.0.5", ProbeNo: 1, InsertTime: at, TempValue: &value},
+		{MachineIP: "10.0.0.5", ProbeNo: 2, InsertTime: at}, // same timestamp, NULL value
+		{MachineIP: "10.0.0.6", ProbeNo: 1, InsertTime: at},
+	}
+
+	tests := []struct {
+		name   string
+		sort   string
+		limit  int
+		cursor []interface{} // decoded X-Next-Cursor; nil = last page
+	}{
+		{"time key of the last row", "-time", 2, []interface{}{"2025-01-06T08:30:00.123456+07:00", "10.0.0.5", 2.0}},
+		{"NULL value key", "value", 2, []interface{}{-999999.0, "2025-01-06T08:30:00.123456+07:00", "10.0.0.5", 2.0}},
+		{"last page", "time", 3, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/logs", func(c *fiber.Ctx) error {
+				page, err := parseKeysetPage(c, tempLogSorts, "-time")
+				if err != nil {
+					return err
+				}
+				return sendPage(c, page, logs, func(l models.TempLog) []interface{} {
+					return repository.SortKey(page.sort, l.MachineIP, l.ProbeNo, l.InsertTime, l.TempValue)
+				})
+			})
+
+			req := httptest.NewRequest("GET", "/logs?sort="+tc.sort+"&limit="+strconv.Itoa(tc.limit), nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows []models.TempLog
+			json.NewDecoder(resp.Body).Decode(&rows)
+			if len(rows) != min(tc.limit, len(logs)) {
+				t.Errorf("%d rows, want %d", len(rows), min(tc.limit, len(logs)))
+			}
+
+			next := resp.Header.Get("X-Next-Cursor")
+			if tc.cursor == nil {
+				if next != "" {
+					t.Errorf("X-Next-Cursor = %q on the last page", next)
+				}
+				return
+			}
+			raw, err := base64.RawURLEncoding.DecodeString(next)
+			if err != nil {
+				t.Fatalf("X-Next-Cursor %q: %v", next, err)
+			}
+			var cursor []interface{}
+			json.Unmarshal(raw, &cursor)
+			if !reflect.DeepEqual(cursor, tc.cursor) {
+				t.Errorf("cursor = %v, want %v", cursor, tc.cursor)
+			}
+		})
+	}
+}
+
+// TestGetTempLogsPaging walks every sort one small page at a time over rows that share
+// timestamps and values (some NULL) and expects the same rows, in the same order, as one big page
+func TestGetTempLogsPaging(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		base := time.Date(2025, 1, 6, 8, 0, 0, 0, database.SiteLocation())
+		for i := 0; i < 12; i++ {
+			l := models.TempLog{MachineIP: "10.0.0." + strconv.Itoa(5+i%3), ProbeNo: 1 + i%2, InsertTime: base.Add(time.Duration(i/4) * time.Minute)}
+			if i%3 != 0 {
+				value := float64(i % 4)
+				l.TempValue = &value
+			}
+			if err := db.Create(&l).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		h := New(db, repository.NewGorm(db), nil)
+		app := fiber.New()
+		app.Get("/temp-logs", h.GetTempLogs)
+		get := func(query string) ([]string, string, string) {
+			t.Helper()
+			resp, err := app.Test(httptest.NewRequest("GET", "/temp-logs?"+query, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Fatalf("%s: status %d", query, resp.StatusCode)
+			}
+			var logs []models.TempLog
+			if err := json.NewDecoder(resp.Body).Decode(&logs); err != nil {
+				t.Fatal(err)
+			}
+			keys := make([]string, len(logs))
+			for i, l := range logs {
+				keys[i] = l.MachineIP + ":" + strconv.Itoa(l.ProbeNo) + "@" + l.InsertTime.Format("15:04")
+			}
+			return keys, resp.Header.Get("X-Next-Cursor"), resp.Header.Get("X-Total-Count")
+		}
+
+		for _, sort := range []string{"time", "-time", "machine", "-machine", "value", "-value"} {
+			all, next, total := get("sort=" + sort)
+			if len(all) != 12 || next != "" || total != "12" {
+				t.Fatalf("sort=%s: one page has %d rows, cursor %q, total %q", sort, len(all), next, total)
+			}
+
+			var paged []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > 12 {
+					t.Fatalf("sort=%s: paging does not end", sort)
+				}
+				rows, next, _ := get("sort=" + sort + "&limit=2&count=false&cursor=" + cursor)
+				paged = append(paged, rows...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if !reflect.DeepEqual(paged, all) {
+				t.Errorf("sort=%s: pages = %v, want %v", sort, paged, all)
+			}
+		}
+	})
+}
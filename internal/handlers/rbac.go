@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/repository"
+	"tms-backend/internal/utils"
+)
+
+// Roles stored in master_user.role, from least to most privileged
+const (
+	RoleViewer   = "viewer"   // read-only dashboards and reports
+	RoleOperator = "operator" // + acknowledge alerts, trigger polls
+	RoleAdmin    = "admin"    // + edit thresholds, delete devices, manage users
+)
+
+// roleLevel ranks a role; unknown or empty legacy roles are treated as viewer
+func roleLevel(role string) int {
+	switch strings.ToLower(strings.TrimSpace(role)) {
+	case RoleAdmin:
+		return 3
+	case RoleOperator:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// isValidRole reports whether role is one of the known roles
+func isValidRole(role string) bool {
+	switch role {
+	case RoleViewer, RoleOperator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// RequireRole allows the request only if the authenticated user has at least minRole
+func RequireRole(minRole string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		if roleLevel(user.Role) < roleLevel(minRole) {
+			utils.LogError("Forbidden - user %s (role=%q) needs %s for %s %s", user.Username, user.Role, minRole, c.Method(), c.Path())
+			return c.Status(403).JSON(fiber.Map{"error": "Insufficient permissions", "requiredRole": minRole})
+		}
+		return c.Next()
+	}
+}
+
+// BootstrapAdmin promotes username to admin when no admin exists yet. Legacy databases
+// have no roles at all, so without this nobody could manage users after the upgrade.
+// It does nothing once an admin exists or when username is empty.
+func BootstrapAdmin(users repository.UserRepo, username string) error {
+	all, err := users.List()
+	if err != nil {
+		return err
+	}
+	for _, u := range all {
+		if u.Role != nil && roleLevel(*u.Role) >= roleLevel(RoleAdmin) {
+			return nil
+		}
+	}
+	if username == "" {
+		utils.LogError("BootstrapAdmin - No admin user exists; set BOOTSTRAP_ADMIN to the username to promote")
+		return nil
+	}
+
+	user, err := users.GetByUsername(username)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("user %q not found in master_user", username)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := users.Update(user.ID, map[string]interface{}{"role": RoleAdmin}); err != nil {
+		return err
+	}
+	log.Printf("User %s promoted to admin (BOOTSTRAP_ADMIN)", username)
+	return nil
+}
+
+// allowedIPs returns the machine IPs the user may see, or nil when unrestricted.
+// Admins are never restricted; other users are restricted only if they have user_device rows.
+func (h *Handler) allowedIPs(c *fiber.Ctx) (map[string]bool, error) {
+	if cached, ok := c.Locals("allowedIPs").(map[string]bool); ok {
+		return cached, nil
+	}
+
+	user := currentUser(c)
+	if user.ID == 0 || roleLevel(user.Role) >= roleLevel(RoleAdmin) {
+		return nil, nil
+	}
+
+	scope, err := h.repos.Users.DeviceIPs(user.ID)
+	if err != nil || len(scope) == 0 {
+		return nil, err
+	}
+
+	ips := make(map[string]bool, len(scope))
+	for _, ip := range scope {
+		ips[ip] = true
+	}
+	c.Locals("allowedIPs", ips)
+	return ips, nil
+}
+
+// canSeeIP reports whether the machine IP is within the user's scope
+func (h *Handler) canSeeIP(c *fiber.Ctx, ip string) (bool, error) {
+	ips, err := h.allowedIPs(c)
+	if err != nil {
+		return false, err
+	}
+	return ips == nil || ips[ip], nil
+}
+
+// scopeList returns the user's scope as a list of machine IPs, or nil when unrestricted
+func (h *Handler) scopeList(c *fiber.Ctx) ([]string, error) {
+	ips, err := h.allowedIPs(c)
+	if err != nil || ips == nil {
+		return nil, err
+	}
+
+	list := make([]string, 0, len(ips))
+	for ip := range ips {
+		list = append(list, ip)
+	}
+	return list, nil
+}
+
+// scopeQuery restricts a query on a table with a machine_ip column to the user's scope
+func (h *Handler) scopeQuery(c *fiber.Ctx, query *gorm.DB, column string) (*gorm.DB, error) {
+	list, err := h.scopeList(c)
+	if err != nil || list == nil {
+		return query, err
+	}
+	return query.Where(column+" IN ?", list), nil
+}
+
+// GetUserDevices returns the machine IPs a user is scoped to (empty = all devices)
+func (h *Handler) GetUserDevices(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid user id"})
+	}
+
+	ips, err := h.repos.Users.DeviceIPs(id)
+	if err != nil {
+		utils.LogError("GetUserDevices failed (user=%d): %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"userId": id, "machineIps": ips})
+}
+
+// SetUserDevices replaces a user's device scope; an empty list removes the restriction
+func (h *Handler) SetUserDevices(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid user id"})
+	}
+
+	var req struct {
+		MachineIPs []string `json:"machineIps"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = h.repos.Users.SetDeviceIPs(id, req.MachineIPs)
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		utils.LogError("SetUserDevices failed (user=%d): %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return h.GetUserDevices(c)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/services"
+)
+
+// GetLiveReadings returns the latest cached reading per probe from the acquisition loop.
+// Filters (all optional, comma-separated): ip, sType (t/h/p), status (N/H/L), online (true/false).
+func (h *Handler) GetLiveReadings(c *fiber.Ctx) error {
+	if services.GlobalPollingService == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Polling service not ready"})
+	}
+
+	ips := toSet(splitComma(c.Query("ip")))
+	sTypes := toSet(splitComma(c.Query("sType")))
+	statuses := toSet(splitComma(strings.ToUpper(c.Query("status"))))
+	online := c.Query("online")
+
+	scope, err := h.allowedIPs(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	readings := make([]services.LiveReading, 0)
+	for _, r := range services.GlobalPollingService.LiveReadings() {
+		if scope != nil && !scope[r.MachineIP] {
+			continue
+		}
+		if len(ips) > 0 && !ips[r.MachineIP] {
+			continue
+		}
+		if len(sTypes) > 0 && !sTypes[r.SType] {
+			continue
+		}
+		if len(statuses) > 0 && !statuses[r.Status] {
+			continue
+		}
+		if online != "" && (online == "true") != r.DeviceOnline {
+			continue
+		}
+		readings = append(readings, r)
+	}
+
+	return c.JSON(fiber.Map{
+		"count":    len(readings),
+		"readings": readings,
+	})
+}
+
+// toSet builds a lookup set from trimmed, non-empty values
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
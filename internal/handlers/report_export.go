@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// exportTempLogReport streams query (a scoped temp_log query) as a CSV or XLSX download
+// through services.TempLogExport
+func (h *Handler) exportTempLogReport(c *fiber.Ctx, query *gorm.DB, format, startDate, endDate string) error {
+	export, err := services.OpenTempLogExport(h.repos.Devices, query, format, startDate, endDate)
+	if errors.Is(err, services.ErrUnknownReportFormat) {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be json, csv or xlsx"})
+	}
+	if err != nil {
+		utils.LogError("GetTempLogReport - %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.FileName()))
+	c.Set("Content-Type", export.ContentType())
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := export.Write(w); err != nil {
+			utils.LogError("GetTempLogReport - %s export failed: %v", format, err)
+		}
+		w.Flush()
+	})
+	return nil
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// maxScheduleNameLength matches report_schedule.name
+const maxScheduleNameLength = 100
+
+// ReportScheduleRequest is the body for creating or replacing a report_schedule.
+// devices and recipients are comma-separated lists.
+type ReportScheduleRequest struct {
+	Name       string `json:"name"`
+	ReportType string `json:"reportType"`
+	Frequency  string `json:"frequency"`
+	TimeOfDay  string `json:"timeOfDay"`
+	Weekday    *int   `json:"weekday"`
+	DayOfMonth *int   `json:"dayOfMonth"`
+	Devices    string `json:"devices"`
+	Delivery   string `json:"delivery"`
+	Recipients string `json:"recipients"`
+	Folder     string `json:"folder"`
+	Enabled    *bool  `json:"enabled"`
+}
+
+// apply validates the request and copies it onto schedule
+func (r *ReportScheduleRequest) apply(devices repository.DeviceRepo, schedule *models.ReportSchedule, errs *fieldErrors) {
+	schedule.Name = strings.TrimSpace(r.Name)
+	switch {
+	case schedule.Name == "":
+		errs.add("name", "is required")
+	case utf8.RuneCountInString(schedule.Name) > maxScheduleNameLength:
+		errs.add("name", "must be at most %d characters", maxScheduleNameLength)
+	}
+
+	schedule.ReportType = r.ReportType
+	switch r.ReportType {
+	case models.ReportTypeDailySummary, models.ReportTypeCompliancePDF, models.ReportTypeMonthlyArchive:
+	default:
+		errs.add("reportType", `must be one of "daily_summary", "compliance_pdf", "monthly_archive"`)
+	}
+
+	schedule.TimeOfDay = r.TimeOfDay
+	if schedule.TimeOfDay == "" {
+		schedule.TimeOfDay = "07:00"
+	}
+	if t, err := time.Parse("15:04", schedule.TimeOfDay); err != nil {
+		errs.add("timeOfDay", "must be HH:MM")
+	} else {
+		schedule.TimeOfDay = t.Format("15:04")
+	}
+
+	schedule.Frequency = r.Frequency
+	schedule.Weekday, schedule.DayOfMonth = 0, 0
+	switch r.Frequency {
+	case models.ReportFrequencyDaily:
+	case models.ReportFrequencyWeekly:
+		schedule.Weekday = int(time.Monday)
+		if r.Weekday != nil {
+			schedule.Weekday = *r.Weekday
+		}
+		if schedule.Weekday < 0 || schedule.Weekday > 6 {
+			errs.add("weekday", "must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	case models.ReportFrequencyMonthly:
+		schedule.DayOfMonth = 1
+		if r.DayOfMonth != nil {
+			schedule.DayOfMonth = *r.DayOfMonth
+		}
+		if schedule.DayOfMonth < 1 || schedule.DayOfMonth > 28 {
+			errs.add("dayOfMonth", "must be between 1 and 28")
+		}
+	default:
+		errs.add("frequency", `must be one of "daily", "weekly", "monthly"`)
+	}
+
+	selectors := make([]string, 0)
+	for _, d := range splitComma(r.Devices) {
+		if d = strings.TrimSpace(d); d != "" {
+			selectors = append(selectors, d)
+		}
+	}
+	if len(selectors) > 0 {
+		if _, unknown, err := services.ResolveDeviceSelectors(devices, selectors); err != nil {
+			errs.add("devices", "could not be checked: %v", err)
+		} else {
+			for _, d := range unknown {
+				errs.add("devices", "%q matches no device (use <ip>, <ip>:<probe> or a machine name)", d)
+			}
+		}
+	}
+	schedule.Devices = strings.Join(selectors, ",")
+
+	// Monthly archives are too large to email by default
+	if r.Delivery == "" && r.ReportType == models.ReportTypeMonthlyArchive {
+		r.Delivery = models.ReportDeliveryFolder
+	}
+	schedule.Delivery = r.Delivery
+	schedule.Recipients, schedule.Folder = "", ""
+	switch r.Delivery {
+	case models.ReportDeliveryEmail:
+		recipients, invalid := make([]string, 0), false
+		for _, addr := range splitComma(r.Recipients) {
+			if addr = strings.TrimSpace(addr); addr == "" {
+				continue
+			}
+			if _, err := mail.ParseAddress(addr); err != nil {
+				errs.add("recipients", "%q is not a valid email address", addr)
+				invalid = true
+				continue
+			}
+			recipients = append(recipients, addr)
+		}
+		if len(recipients) == 0 && !invalid {
+			errs.add("recipients", "at least one email address is required")
+		}
+		schedule.Recipients = strings.Join(recipients, ",")
+	case models.ReportDeliveryFolder:
+		schedule.Folder = strings.TrimSpace(r.Folder)
+		if schedule.Folder == "" && services.ReportDropFolder() == "" {
+			errs.add("folder", "is required when REPORT_DROP_FOLDER is not set")
+		}
+	default:
+		errs.add("delivery", `must be one of "email", "folder"`)
+	}
+
+	schedule.Enabled = r.Enabled == nil || *r.Enabled
+}
+
+// GetReportSchedules returns all report schedules
+func (h *Handler) GetReportSchedules(c *fiber.Ctx) error {
+	var schedules []models.ReportSchedule
+	if err := h.db.Order("name ASC, id ASC").Find(&schedules).Error; err != nil {
+		utils.LogError("GetReportSchedules failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(schedules)
+}
+
+// CreateReportSchedule adds a report schedule; its first run is the next matching slot
+func (h *Handler) CreateReportSchedule(c *fiber.Ctx) error {
+	var req ReportScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	schedule := models.ReportSchedule{CreatedBy: currentUser(c).Username}
+	var errs fieldErrors
+	req.apply(h.repos.Devices, &schedule, &errs)
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	next := services.NextReportRun(schedule, database.SiteNow())
+	schedule.NextRunAt = &next
+	if err := h.db.Create(&schedule).Error; err != nil {
+		utils.LogError("CreateReportSchedule failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(schedule)
+}
+
+// UpdateReportSchedule replaces a report schedule and recomputes its next run
+func (h *Handler) UpdateReportSchedule(c *fiber.Ctx) error {
+	schedule, err := h.findReportSchedule(c)
+	if schedule == nil {
+		return err
+	}
+
+	var req ReportScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var errs fieldErrors
+	req.apply(h.repos.Devices, schedule, &errs)
+	if len(errs) > 0 {
+		return validationFailed(c, errs)
+	}
+
+	next := services.NextReportRun(*schedule, database.SiteNow())
+	schedule.NextRunAt = &next
+	if err := h.db.Save(schedule).Error; err != nil {
+		utils.LogError("UpdateReportSchedule failed (id=%d): %v", schedule.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(schedule)
+}
+
+// DeleteReportSchedule removes a report schedule and its run history
+func (h *Handler) DeleteReportSchedule(c *fiber.Ctx) error {
+	schedule, err := h.findReportSchedule(c)
+	if schedule == nil {
+		return err
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("schedule_id = ?", schedule.ID).Delete(&models.ReportRun{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(schedule).Error
+	})
+	if err != nil {
+		utils.LogError("DeleteReportSchedule failed (id=%d): %v", schedule.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Report schedule deleted successfully"})
+}
+
+// RunReportSchedule runs a schedule now and returns the recorded run.
+// ?date=YYYY-MM-DD runs it as if on that day, to re-send a past period.
+func (h *Handler) RunReportSchedule(c *fiber.Ctx) error {
+	schedule, err := h.findReportSchedule(c)
+	if schedule == nil {
+		return err
+	}
+	if services.GlobalReportScheduler == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Report scheduler not initialized"})
+	}
+
+	at := database.SiteNow()
+	if date := c.Query("date"); date != "" {
+		if at, err = time.ParseInLocation("2006-01-02", date, at.Location()); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+		}
+	}
+
+	run, err := services.GlobalReportScheduler.Run(schedule, currentUser(c).Username, at)
+	if run == nil {
+		utils.LogError("RunReportSchedule failed (id=%d): %v", schedule.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error(), "run": run})
+	}
+	return c.JSON(run)
+}
+
+// GetReportRuns returns a schedule's run history, newest first (?limit=, default 50)
+func (h *Handler) GetReportRuns(c *fiber.Ctx) error {
+	schedule, err := h.findReportSchedule(c)
+	if schedule == nil {
+		return err
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	var runs []models.ReportRun
+	if err := h.db.Where("schedule_id = ?", schedule.ID).
+		Order("started_at DESC, id DESC").
+		Limit(limit).
+		Find(&runs).Error; err != nil {
+		utils.LogError("GetReportRuns failed (id=%d): %v", schedule.ID, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(runs)
+}
+
+// findReportSchedule loads the schedule named by :id. When it returns nil the error
+// response has already been written and the returned error should be passed on.
+func (h *Handler) findReportSchedule(c *fiber.Ctx) (*models.ReportSchedule, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid schedule ID"})
+	}
+
+	var schedule models.ReportSchedule
+	if err := h.db.First(&schedule, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, c.Status(404).JSON(fiber.Map{"error": "Report schedule not found"})
+		}
+		utils.LogError("Failed to load report schedule %d: %v", id, err)
+		return nil, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return &schedule, nil
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// GetStats returns per-probe min/max/mean/stddev, time in range and excursions,
+// per bucket and for the whole period, aggregated in the database.
+// Query: startDate, endDate (YYYY-MM-DD, inclusive), bucket (hour|day|week, default day),
+// devices (comma-separated ip or ip:probe, optional).
+func (h *Handler) GetStats(c *fiber.Ctx) error {
+	loc := database.SiteLocation()
+	start, err := time.ParseInLocation("2006-01-02", c.Query("startDate"), loc)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "startDate must be YYYY-MM-DD"})
+	}
+	end, err := time.ParseInLocation("2006-01-02", c.Query("endDate"), loc)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "endDate must be YYYY-MM-DD"})
+	}
+	if end.Before(start) {
+		return c.Status(400).JSON(fiber.Map{"error": "endDate must not be before startDate"})
+	}
+
+	bucket := c.Query("bucket", services.StatsBucketDay)
+	if !services.IsValidStatsBucket(bucket) {
+		return c.Status(400).JSON(fiber.Map{"error": "bucket must be hour, day or week"})
+	}
+
+	devices := make([]string, 0)
+	for _, d := range splitComma(c.Query("devices")) {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+		ip, probe, hasProbe := strings.Cut(d, ":")
+		n, err := strconv.Atoi(probe)
+		if net.ParseIP(ip) == nil || (hasProbe && (err != nil || n < 1)) {
+			return c.Status(400).JSON(fiber.Map{"error": "devices must be a comma-separated list of <ip> or <ip>:<probe>"})
+		}
+		devices = append(devices, d)
+	}
+
+	query := services.StatsQuery{
+		From:    start,
+		To:      end.AddDate(0, 0, 1),
+		Bucket:  bucket,
+		Devices: devices,
+	}
+	scope, err := h.allowedIPs(c)
+	if err != nil {
+		utils.LogError("GetStats - Failed to load device scope: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if scope != nil {
+		query.AllowedIPs = make([]string, 0, len(scope))
+		for ip := range scope {
+			query.AllowedIPs = append(query.AllowedIPs, ip)
+		}
+	}
+
+	stats, err := services.ProbeStatistics(h.db, h.repos.Devices, query)
+	if err != nil {
+		utils.LogError("GetStats failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"startDate": c.Query("startDate"),
+		"endDate":   c.Query("endDate"),
+		"bucket":    bucket,
+		"probes":    stats,
+	})
+}
+
+// RebuildRollups recomputes the hourly and daily rollups between startDate and endDate
+// (YYYY-MM-DD, inclusive), e.g. after importing or correcting older readings
+func (h *Handler) RebuildRollups(c *fiber.Ctx) error {
+	loc := database.SiteLocation()
+	start, err := time.ParseInLocation("2006-01-02", c.Query("startDate"), loc)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "startDate must be YYYY-MM-DD"})
+	}
+	end, err := time.ParseInLocation("2006-01-02", c.Query("endDate"), loc)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "endDate must be YYYY-MM-DD"})
+	}
+	if end.Before(start) {
+		return c.Status(400).JSON(fiber.Map{"error": "endDate must not be before startDate"})
+	}
+	if services.GlobalRollupService == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "rollup service is not running"})
+	}
+
+	end = end.AddDate(0, 0, 1)
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if err := services.GlobalRollupService.Rebuild(day, day.AddDate(0, 0, 1)); err != nil {
+			utils.LogError("RebuildRollups failed for %s: %v", day.Format("2006-01-02"), err)
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	return c.JSON(fiber.Map{
+		"startDate": c.Query("startDate"),
+		"endDate":   c.Query("endDate"),
+		"status":    "rebuilt",
+	})
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/repository"
+)
+
+func TestGetStatsValidation(t *testing.T) {
+	h := New(nil, repository.NewMemory().Set(), nil)
+	app := fiber.New()
+	app.Get("/stats", h.GetStats)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"missing dates", ""},
+		{"bad startDate", "startDate=2025-13-01&endDate=2025-01-31"},
+		{"datetime instead of date", "startDate=2025-01-01T00:00:00&endDate=2025-01-31"},
+		{"endDate before startDate", "startDate=2025-01-31&endDate=2025-01-01"},
+		{"unknown bucket", "startDate=2025-01-01&endDate=2025-01-31&bucket=month"},
+		{"device is not an ip", "startDate=2025-01-01&endDate=2025-01-31&devices=fridge"},
+		{"probe zero", "startDate=2025-01-01&endDate=2025-01-31&devices=10.0.0.5:0"},
+		{"probe not a number", "startDate=2025-01-01&endDate=2025-01-31&devices=10.0.0.5,10.0.0.6:a"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := send(t, app, "GET", "/stats?"+tc.query, "")
+			if status != 400 {
+				t.Errorf("status = %d (%s), want 400", status, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/services"
+	"tms-backend/internal/utils"
+)
+
+// UserRequest is the body for creating or updating a master_user.
+// On update, omitted fields are left unchanged.
+type UserRequest struct {
+	Username *string `json:"username"`
+	Password *string `json:"password"`
+	Fullname *string `json:"fullname"`
+	Role     *string `json:"role"`
+}
+
+// GetUsers returns all users (password hashes are never serialized)
+func (h *Handler) GetUsers(c *fiber.Ctx) error {
+	users, err := h.repos.Users.List()
+	if err != nil {
+		utils.LogError("GetUsers failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(users)
+}
+
+// CreateUser creates a master_user with a bcrypt password
+func (h *Handler) CreateUser(c *fiber.Ctx) error {
+	var req UserRequest
+	if err := c.BodyParser(&req); err != nil {
+		utils.LogError("CreateUser - Failed to parse body: %v", err)
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Username == nil || *req.Username == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "username is required"})
+	}
+	if req.Password == nil || len(*req.Password) < minPasswordLength {
+		return c.Status(400).JSON(fiber.Map{"error": "password must be at least 8 characters"})
+	}
+	if req.Role == nil || !isValidRole(*req.Role) {
+		return c.Status(400).JSON(fiber.Map{"error": "role must be one of viewer, operator, admin"})
+	}
+
+	if taken, err := h.repos.Users.UsernameTaken(*req.Username, 0); err != nil {
+		utils.LogError("CreateUser - Failed to check username %s: %v", *req.Username, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if taken {
+		return c.Status(409).JSON(fiber.Map{"error": "username already exists"})
+	}
+
+	hash, err := services.HashPassword(*req.Password)
+	if err != nil {
+		utils.LogError("CreateUser - %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	user := models.MasterUser{
+		Username: *req.Username,
+		Password: hash,
+		Fullname: req.Fullname,
+		Role:     req.Role,
+	}
+	err = h.repos.Users.Create(&user)
+	if errors.Is(err, repository.ErrDuplicate) {
+		return c.Status(409).JSON(fiber.Map{"error": "username already exists"})
+	}
+	if err != nil {
+		utils.LogError("CreateUser - Failed to create user (username=%s): %v", user.Username, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(201).JSON(user)
+}
+
+// UpdateUser updates a master_user; a new password is re-hashed with bcrypt
+func (h *Handler) UpdateUser(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid user id"})
+	}
+
+	user, err := h.repos.Users.Get(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		utils.LogError("UpdateUser - Failed to load user (id=%d): %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req UserRequest
+	if err := c.BodyParser(&req); err != nil {
+		utils.LogError("UpdateUser - Failed to parse body: %v", err)
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	updates := map[string]interface{}{}
+	if req.Username != nil && *req.Username != user.Username {
+		if *req.Username == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "username cannot be empty"})
+		}
+		if taken, err := h.repos.Users.UsernameTaken(*req.Username, id); err != nil {
+			utils.LogError("UpdateUser - Failed to check username %s: %v", *req.Username, err)
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		} else if taken {
+			return c.Status(409).JSON(fiber.Map{"error": "username already exists"})
+		}
+		updates["username"] = *req.Username
+	}
+	if req.Password != nil {
+		if len(*req.Password) < minPasswordLength {
+			return c.Status(400).JSON(fiber.Map{"error": "password must be at least 8 characters"})
+		}
+		hash, err := services.HashPassword(*req.Password)
+		if err != nil {
+			utils.LogError("UpdateUser - %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		updates["password"] = hash
+	}
+	if req.Fullname != nil {
+		updates["fullname"] = *req.Fullname
+	}
+	if req.Role != nil {
+		if !isValidRole(*req.Role) {
+			return c.Status(400).JSON(fiber.Map{"error": "role must be one of viewer, operator, admin"})
+		}
+		if id == currentUser(c).ID && *req.Role != RoleAdmin {
+			return c.Status(400).JSON(fiber.Map{"error": "cannot remove your own admin role"})
+		}
+		updates["role"] = *req.Role
+	}
+
+	user, err = h.repos.Users.Update(id, updates)
+	if err != nil {
+		utils.LogError("UpdateUser - Failed to update user (id=%d): %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(user)
+}
+
+// DeleteUser deletes a master_user; users cannot delete themselves
+func (h *Handler) DeleteUser(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid user id"})
+	}
+	if id == currentUser(c).ID {
+		return c.Status(400).JSON(fiber.Map{"error": "cannot delete your own account"})
+	}
+
+	err = h.repos.Users.Delete(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		utils.LogError("DeleteUser - Failed to delete user (id=%d): %v", id, err)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"success": true})
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -103,7 +104,7 @@ type TempLog struct {
 	RealValue  *int       `gorm:"column:real_value" json:"realValue"`
 	Status     *string    `gorm:"column:status;size:8" json:"status"`
 	SendTime   *time.Time `gorm:"column:send_time" json:"sendTime"`
-	InsertTime time.Time  `gorm:"column:insert_time;primaryKey;type:datetime" json:"insertTime"`
+	InsertTime time.Time  `gorm:"column:insert_time;primaryKey;precision:3" json:"insertTime"`
 	SDate      *string    `gorm:"column:sDate;size:8" json:"sDate"`
 	STime      *string    `gorm:"column:sTime;size:2" json:"sTime"`
 }
@@ -114,11 +115,22 @@ func (t *TempLog) BeforeCreate(tx *gorm.DB) error {
 	if t.InsertTime.IsZero() {
 		t.InsertTime = time.Now()
 	}
-	// Force GORM to use the exact timestamp value
-	tx.Statement.SetColumn("insert_time", t.InsertTime.Format("2006-01-02 15:04:05.000"))
+	t.InsertTime = setExactTime(tx, "insert_time", t.InsertTime)
 	return nil
 }
 
+// setExactTime truncates a primary-key datetime to milliseconds and moves it to the site
+// timezone (time.Local, see database.SetupSiteTimezone) so the stored value and the struct
+// agree. MySQL gets the site wall clock as text, otherwise the driver would convert it to the
+// DSN's loc; PostgreSQL (timestamptz) and SQLite bind the time.Time as is.
+func setExactTime(tx *gorm.DB, column string, t time.Time) time.Time {
+	t = t.Truncate(time.Millisecond).In(time.Local)
+	if tx.Dialector.Name() == "mysql" {
+		tx.Statement.SetColumn(column, t.Format("2006-01-02 15:04:05.000"))
+	}
+	return t
+}
+
 // TableName specifies table name for TempLog
 func (TempLog) TableName() string {
 	return "temp_log"
@@ -130,7 +142,7 @@ type TempError struct {
 	ProbeNo        int        `gorm:"column:probe_no;primaryKey" json:"probeNo"`
 	MachineName    *string    `gorm:"column:machine_name;size:50" json:"machineName"`
 	TempValue      *float64   `gorm:"column:temp_value" json:"tempValue"`
-	ErrorTime      time.Time  `gorm:"column:error_time;primaryKey;type:datetime" json:"errorTime"`
+	ErrorTime      time.Time  `gorm:"column:error_time;primaryKey;precision:3" json:"errorTime"`
 	SmsStatus      int        `gorm:"column:sms_status;default:0" json:"smsStatus"`
 	SmsSendTime    *time.Time `gorm:"column:sms_send_time" json:"smsSendTime"`
 	SmsSendStatus  int        `gorm:"column:sms_send_status;default:0" json:"smsSendStatus"`
@@ -162,11 +174,57 @@ func (t *TempError) BeforeCreate(tx *gorm.DB) error {
 	if t.ErrorTime.IsZero() {
 		t.ErrorTime = time.Now()
 	}
-	// Force GORM to use the exact timestamp value
-	tx.Statement.SetColumn("error_time", t.ErrorTime.Format("2006-01-02 15:04:05.000"))
+	t.ErrorTime = setExactTime(tx, "error_time", t.ErrorTime)
 	return nil
 }
 
+// TempLatest represents the temp_latest table (latest reading per probe, maintained by the backend)
+type TempLatest struct {
+	MachineIP  string    `gorm:"column:machine_ip;size:20;primaryKey" json:"machineIp"`
+	ProbeNo    int       `gorm:"column:probe_no;primaryKey" json:"probeNo"`
+	TempValue  *float64  `gorm:"column:temp_value" json:"tempValue"`
+	Status     *string   `gorm:"column:status;size:8" json:"status"`
+	InsertTime time.Time `gorm:"column:insert_time;precision:3" json:"insertTime"`
+}
+
+// TableName specifies table name for TempLatest
+func (TempLatest) TableName() string {
+	return "temp_latest"
+}
+
+// TempLogRollup holds the aggregates shared by the temp_log rollup tables
+type TempLogRollup struct {
+	MachineIP       string    `gorm:"column:machine_ip;size:20;primaryKey" json:"machineIp"`
+	ProbeNo         int       `gorm:"column:probe_no;primaryKey" json:"probeNo"`
+	BucketStart     time.Time `gorm:"column:bucket_start;primaryKey" json:"bucketStart"`
+	ReadingCount    int       `gorm:"column:reading_count" json:"readingCount"`
+	MinValue        float64   `gorm:"column:min_value" json:"minValue"`
+	MaxValue        float64   `gorm:"column:max_value" json:"maxValue"`
+	AvgValue        float64   `gorm:"column:avg_value" json:"avgValue"`
+	SumValue        float64   `gorm:"column:sum_value" json:"-"`
+	OutOfRangeCount int       `gorm:"column:out_of_range_count" json:"outOfRangeCount"`
+}
+
+// TempLogHourly represents the temp_log_hourly table (hourly rollup of temp_log, maintained by the backend)
+type TempLogHourly struct {
+	TempLogRollup
+}
+
+// TableName specifies table name for TempLogHourly
+func (TempLogHourly) TableName() string {
+	return "temp_log_hourly"
+}
+
+// TempLogDaily represents the temp_log_daily table (daily rollup of temp_log_hourly, maintained by the backend)
+type TempLogDaily struct {
+	TempLogRollup
+}
+
+// TableName specifies table name for TempLogDaily
+func (TempLogDaily) TableName() string {
+	return "temp_log_daily"
+}
+
 // ConfigValue represents the config_value table
 type ConfigValue struct {
 	ID          int     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
@@ -193,21 +251,176 @@ func (MasterUser) TableName() string {
 	return "master_user"
 }
 
+// UserDevice represents the user_device table (optional per-user device scoping).
+// A user with no rows can see every device.
+type UserDevice struct {
+	UserID    int    `gorm:"column:user_id;primaryKey" json:"userId"`
+	MachineIP string `gorm:"column:machine_ip;size:20;primaryKey" json:"machineIp"`
+}
+
+// TableName specifies table name for UserDevice
+func (UserDevice) TableName() string {
+	return "user_device"
+}
+
+// TempErrorAck represents the temp_error_ack table (acknowledgement of a temp_error)
+type TempErrorAck struct {
+	ID        int       `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	MachineIP string    `gorm:"column:machine_ip;size:15;index:idx_temp_error_ack_error" json:"machineIp"`
+	ProbeNo   int       `gorm:"column:probe_no;index:idx_temp_error_ack_error" json:"probeNo"`
+	ErrorTime time.Time `gorm:"column:error_time;precision:3;index:idx_temp_error_ack_error" json:"errorTime"`
+	UserID    int       `gorm:"column:user_id" json:"userId"`
+	Username  string    `gorm:"column:username;size:255" json:"username"`
+	Comment   string    `gorm:"column:comment;type:text" json:"comment"`
+	AckTime   time.Time `gorm:"column:ack_time;precision:3" json:"ackTime"`
+}
+
+// TableName specifies table name for TempErrorAck
+func (TempErrorAck) TableName() string {
+	return "temp_error_ack"
+}
+
+// Audit actions
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// AuditLog represents the audit_log table (who changed which configuration, and how)
+type AuditLog struct {
+	ID            int             `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	CreatedAt     time.Time       `gorm:"column:created_at;precision:3;index" json:"createdAt"`
+	UserID        int             `gorm:"column:user_id;index" json:"userId"`
+	Username      string          `gorm:"column:username;size:255" json:"username"`
+	ClientIP      string          `gorm:"column:client_ip;size:45" json:"clientIp"`
+	Action        string          `gorm:"column:action;size:10" json:"action"`
+	Entity        string          `gorm:"column:entity;size:50;index:idx_audit_log_entity" json:"entity"`
+	EntityID      string          `gorm:"column:entity_id;size:100;index:idx_audit_log_entity" json:"entityId"`
+	MachineIP     string          `gorm:"column:machine_ip;size:20;index" json:"machineIp,omitempty"`
+	ChangedFields string          `gorm:"column:changed_fields;size:500" json:"changedFields"`
+	BeforeValue   json.RawMessage `gorm:"column:before_value;type:text" json:"before"`
+	AfterValue    json.RawMessage `gorm:"column:after_value;type:text" json:"after"`
+	Reason        string          `gorm:"column:reason;type:text" json:"reason"`
+}
+
+// TableName specifies table name for AuditLog
+func (AuditLog) TableName() string {
+	return "audit_log"
+}
+
+// Report schedule types, frequencies, delivery methods and run statuses
+const (
+	ReportTypeDailySummary   = "daily_summary"
+	ReportTypeCompliancePDF  = "compliance_pdf"
+	ReportTypeMonthlyArchive = "monthly_archive"
+
+	ReportFrequencyDaily   = "daily"
+	ReportFrequencyWeekly  = "weekly"
+	ReportFrequencyMonthly = "monthly"
+
+	ReportDeliveryEmail  = "email"
+	ReportDeliveryFolder = "folder"
+
+	ReportRunRunning = "running"
+	ReportRunSuccess = "success"
+	ReportRunFailed  = "failed"
+)
+
+// ReportSchedule represents the report_schedule table (a report rendered and delivered automatically)
+type ReportSchedule struct {
+	ID         int        `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Name       string     `gorm:"column:name;size:100" json:"name"`
+	ReportType string     `gorm:"column:report_type;size:30" json:"reportType"`
+	Frequency  string     `gorm:"column:frequency;size:10" json:"frequency"`
+	TimeOfDay  string     `gorm:"column:time_of_day;size:5" json:"timeOfDay"`    // HH:MM site time
+	Weekday    int        `gorm:"column:weekday" json:"weekday"`                 // weekly: 0=Sunday .. 6=Saturday
+	DayOfMonth int        `gorm:"column:day_of_month" json:"dayOfMonth"`         // monthly: 1-28
+	Devices    string     `gorm:"column:devices;size:1000" json:"devices"`       // comma-separated ip or ip:probe, empty = all
+	Delivery   string     `gorm:"column:delivery;size:10" json:"delivery"`       // email or folder
+	Recipients string     `gorm:"column:recipients;size:1000" json:"recipients"` // comma-separated email addresses
+	Folder     string     `gorm:"column:folder;size:500" json:"folder"`          // empty = REPORT_DROP_FOLDER
+	Enabled    bool       `gorm:"column:enabled" json:"enabled"`
+	LastRunAt  *time.Time `gorm:"column:last_run_at;precision:3" json:"lastRunAt"`
+	LastStatus string     `gorm:"column:last_status;size:10" json:"lastStatus"`
+	NextRunAt  *time.Time `gorm:"column:next_run_at;precision:3;index" json:"nextRunAt"`
+	CreatedBy  string     `gorm:"column:created_by;size:255" json:"createdBy"`
+	CreatedAt  time.Time  `gorm:"column:created_at;precision:3" json:"createdAt"`
+	UpdatedAt  time.Time  `gorm:"column:updated_at;precision:3" json:"updatedAt"`
+}
+
+// TableName specifies table name for ReportSchedule
+func (ReportSchedule) TableName() string {
+	return "report_schedule"
+}
+
+// ReportRun represents the report_run table (one execution of a ReportSchedule)
+type ReportRun struct {
+	ID          int        `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	ScheduleID  int        `gorm:"column:schedule_id;index" json:"scheduleId"`
+	TriggeredBy string     `gorm:"column:triggered_by;size:255" json:"triggeredBy"` // "scheduler" or the username of a manual run
+	StartedAt   time.Time  `gorm:"column:started_at;precision:3;index" json:"startedAt"`
+	FinishedAt  *time.Time `gorm:"column:finished_at;precision:3" json:"finishedAt"`
+	Status      string     `gorm:"column:status;size:10" json:"status"`
+	PeriodStart time.Time  `gorm:"column:period_start" json:"periodStart"`
+	PeriodEnd   time.Time  `gorm:"column:period_end" json:"periodEnd"` // exclusive
+	Files       string     `gorm:"column:files;type:text" json:"files"`
+	DeliveredTo string     `gorm:"column:delivered_to;size:1000" json:"deliveredTo"`
+	Message     string     `gorm:"column:message;type:text" json:"message"`
+}
+
+// TableName specifies table name for ReportRun
+func (ReportRun) TableName() string {
+	return "report_run"
+}
+
 // ========== Response/DTO Structures ==========
 
 // MachineWithStatus represents a machine with its current status (for API response)
 type MachineWithStatus struct {
 	MasterMachine
 	CurrentValue *float64 `json:"currentValue"`
-	LastUpdate   *string  `json:"lastUpdate"`
+	LastUpdate   *string  `json:"lastUpdate"` // RFC3339 in site time
 	OnlineStatus string   `json:"onlineStatus"`
+	AlarmStatus  string   `json:"alarmStatus"` // N=Normal, H=High, L=Low, U=Unknown (no data)
 }
 
 // DeviceGroup represents a group of probes for the same IP (for API response)
 type DeviceGroup struct {
 	MachineIP    string              `json:"machineIp"`
 	MachineName  string              `json:"machineName"`
+	ProbeAll     int                 `json:"probeAll"`
 	ProbeCount   int                 `json:"probeCount"`
-	OnlineStatus string              `json:"onlineStatus"`
+	OnlineStatus string              `json:"onlineStatus"` // Online, Partial, Offline
+	AlarmStatus  string              `json:"alarmStatus"`  // worst probe state: H/L, then N, then U
 	Probes       []MachineWithStatus `json:"probes"`
 }
+
+// TempLogArchive statuses
+const (
+	ArchiveStatusArchived = "archived" // rows exported and deleted from temp_log
+	ArchiveStatusRestored = "restored" // rows re-imported into temp_log
+)
+
+// TempLogArchive represents the temp_log_archive table (one month of raw temp_log rows
+// exported to a compressed file before deletion)
+type TempLogArchive struct {
+	ID           int        `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	PeriodStart  time.Time  `gorm:"column:period_start;index" json:"periodStart"`
+	PeriodEnd    time.Time  `gorm:"column:period_end" json:"periodEnd"` // exclusive
+	FileName     string     `gorm:"column:file_name;size:255" json:"fileName"`
+	FilePath     string     `gorm:"column:file_path;size:1000" json:"filePath"`
+	RowCount     int64      `gorm:"column:row_count" json:"rowCount"`
+	SizeBytes    int64      `gorm:"column:size_bytes" json:"sizeBytes"`
+	SHA256       string     `gorm:"column:sha256;size:64" json:"sha256"`
+	Status       string     `gorm:"column:status;size:10" json:"status"`
+	ArchivedAt   time.Time  `gorm:"column:archived_at;precision:3" json:"archivedAt"`
+	RestoredAt   *time.Time `gorm:"column:restored_at;precision:3" json:"restoredAt"`
+	RestoredRows int64      `gorm:"column:restored_rows" json:"restoredRows"`
+	RestoredBy   string     `gorm:"column:restored_by;size:255" json:"restoredBy"`
+}
+
+// TableName specifies table name for TempLogArchive
+func (TempLogArchive) TableName() string {
+	return "temp_log_archive"
+}
@@ -0,0 +1,42 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+)
+
+func TestInsertTimeRoundTrip(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		// A UTC time with sub-millisecond digits: stored and returned in site time to the millisecond
+		at := time.Date(2025, 1, 6, 1, 2, 3, 456789123, time.UTC)
+		want := time.Date(2025, 1, 6, 1, 2, 3, 456000000, time.UTC)
+
+		row := models.TempLog{MachineIP: "10.0.0.1", ProbeNo: 1, InsertTime: at}
+		if err := db.Create(&row).Error; err != nil {
+			t.Fatal(err)
+		}
+		if !row.InsertTime.Equal(want) || row.InsertTime.Location() != database.SiteLocation() {
+			t.Errorf("struct after insert = %s, want %s in site time", row.InsertTime, want)
+		}
+
+		var got models.TempLog
+		if err := db.Where("machine_ip = ? AND probe_no = ? AND insert_time = ?", "10.0.0.1", 1, row.InsertTime).Take(&got).Error; err != nil {
+			t.Fatalf("look up by the primary key the insert returned: %v", err)
+		}
+		if !got.InsertTime.Equal(want) {
+			t.Errorf("read back %s, want %s", got.InsertTime, want)
+		}
+
+		// Only the millisecond is part of the key
+		again := models.TempLog{MachineIP: "10.0.0.1", ProbeNo: 1, InsertTime: want.Add(999 * time.Microsecond)}
+		if err := db.Create(&again).Error; !database.IsDuplicateKey(err) {
+			t.Errorf("second reading in the same millisecond: err = %v, want a duplicate key", err)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tms-backend/internal/models"
+)
+
+// gormConfig is the ConfigRepo on config_value
+type gormConfig struct {
+	db *gorm.DB
+}
+
+func (r *gormConfig) Get(key string) (string, error) {
+	// The newest row wins while a legacy table without the unique key still holds duplicates
+	var value models.ConfigValue
+	err := r.db.Where("config_key = ?", key).Order("id DESC").Take(&value).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && value.ConfigValue == nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return *value.ConfigValue, nil
+}
+
+func (r *gormConfig) Set(key, value string) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "config_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"config_value"}),
+	}).Create(&models.ConfigValue{ConfigKey: key, ConfigValue: &value}).Error
+}
+
+func (r *gormConfig) List(keys []string, prefix string) ([]models.ConfigValue, error) {
+	var values []models.ConfigValue
+	err := r.selected(r.db, keys, prefix).Find(&values).Error
+	return values, err
+}
+
+func (r *gormConfig) Replace(keys []string, prefix string, values []models.ConfigValue, audit []models.AuditLog) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := r.selected(tx, keys, prefix).Delete(&models.ConfigValue{}).Error; err != nil {
+			return err
+		}
+		if len(values) > 0 {
+			if err := tx.Create(&values).Error; err != nil {
+				return err
+			}
+		}
+		return writeAudit(tx, audit)
+	})
+}
+
+// selected restricts db to the entries in keys or starting with prefix
+func (r *gormConfig) selected(db *gorm.DB, keys []string, prefix string) *gorm.DB {
+	if prefix == "" {
+		return db.Where("config_key IN ?", keys)
+	}
+	return db.Where("config_key IN ? OR config_key LIKE ?", keys, prefix+"%")
+}
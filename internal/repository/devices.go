@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/models"
+)
+
+// gormDevices is the DeviceRepo on master_machine
+type gormDevices struct {
+	db *gorm.DB
+}
+
+func (r *gormDevices) List() ([]models.MasterMachine, error) {
+	var machines []models.MasterMachine
+	err := r.db.Order("machine_ip, probe_no").Find(&machines).Error
+	return machines, err
+}
+
+func (r *gormDevices) ListByIP(ip string) ([]models.MasterMachine, error) {
+	var machines []models.MasterMachine
+	err := r.db.Where("machine_ip = ?", ip).Order("probe_no ASC").Find(&machines).Error
+	return machines, err
+}
+
+func (r *gormDevices) ListWithLatest() ([]DeviceLatest, error) {
+	var rows []DeviceLatest
+	err := r.db.Model(&models.MasterMachine{}).
+		Select("master_machine.*, temp_latest.temp_value AS latest_value, temp_latest.insert_time AS latest_time").
+		Joins("LEFT JOIN temp_latest ON temp_latest.machine_ip = master_machine.machine_ip AND temp_latest.probe_no = master_machine.probe_no").
+		Order("master_machine.machine_ip, master_machine.probe_no").
+		Scan(&rows).Error
+	return rows, err
+}
+
+func (r *gormDevices) Get(ip string, probeNo int) (models.MasterMachine, error) {
+	var machine models.MasterMachine
+	err := r.db.First(&machine, "machine_ip = ? AND probe_no = ?", ip, probeNo).Error
+	return machine, translateError(err)
+}
+
+func (r *gormDevices) Create(machine *models.MasterMachine, audit AuditFunc) error {
+	return translateError(r.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.MasterMachine{}).
+			Where("machine_ip = ? AND probe_no = ?", machine.MachineIP, machine.ProbeNo).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrDuplicate
+		}
+		if err := tx.Create(machine).Error; err != nil {
+			return err
+		}
+		return writeAudit(tx, audit(nil, []models.MasterMachine{*machine}))
+	}))
+}
+
+func (r *gormDevices) Update(ip string, probeNo int, updates map[string]interface{}, audit AuditFunc) (models.MasterMachine, error) {
+	var machine models.MasterMachine
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&machine, "machine_ip = ? AND probe_no = ?", ip, probeNo).Error; err != nil {
+			return err
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		before := machine
+		if err := tx.Model(&machine).Updates(updates).Error; err != nil {
+			return err
+		}
+		// Reload the updated machine
+		if err := tx.First(&machine, "machine_ip = ? AND probe_no = ?", ip, probeNo).Error; err != nil {
+			return err
+		}
+		return writeAudit(tx, audit([]models.MasterMachine{before}, []models.MasterMachine{machine}))
+	})
+	return machine, translateError(err)
+}
+
+func (r *gormDevices) UpdateGroup(ip string, updates map[string]interface{}, probeAll int, audit AuditFunc) error {
+	return translateError(r.db.Transaction(func(tx *gorm.DB) error {
+		var before []models.MasterMachine
+		if err := tx.Where("machine_ip = ?", ip).Order("probe_no ASC").Find(&before).Error; err != nil {
+			return err
+		}
+		if len(before) == 0 {
+			return ErrNotFound
+		}
+
+		if len(updates) > 0 {
+			if err := tx.Model(&models.MasterMachine{}).Where("machine_ip = ?", ip).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if probeAll > 0 {
+			if err := resizeDevice(tx, ip, probeAll); err != nil {
+				return err
+			}
+		}
+
+		var after []models.MasterMachine
+		if err := tx.Where("machine_ip = ?", ip).Order("probe_no ASC").Find(&after).Error; err != nil {
+			return err
+		}
+		return writeAudit(tx, audit(before, after))
+	}))
+}
+
+// resizeDevice deletes the probes of ip above probeAll and creates missing ones from the
+// settings of its lowest probe
+func resizeDevice(tx *gorm.DB, ip string, probeAll int) error {
+	if err := tx.Where("machine_ip = ? AND probe_no > ?", ip, probeAll).
+		Delete(&models.MasterMachine{}).Error; err != nil {
+		return err
+	}
+
+	var probes []models.MasterMachine
+	if err := tx.Where("machine_ip = ?", ip).Order("probe_no ASC").Find(&probes).Error; err != nil {
+		return err
+	}
+	if len(probes) == 0 {
+		return nil
+	}
+	for _, probe := range missingProbes(probes, probeAll) {
+		if err := tx.Create(&probe).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingProbes returns the probes 1..probeAll that are not in probes, copied from probes[0]
+func missingProbes(probes []models.MasterMachine, probeAll int) []models.MasterMachine {
+	existing := make(map[int]bool, len(probes))
+	for _, p := range probes {
+		existing[p.ProbeNo] = true
+	}
+	missing := make([]models.MasterMachine, 0)
+	for probeNo := 1; probeNo <= probeAll; probeNo++ {
+		if existing[probeNo] {
+			continue
+		}
+		probe := probes[0]
+		probe.ProbeNo = probeNo
+		missing = append(missing, probe)
+	}
+	return missing
+}
+
+func (r *gormDevices) Delete(ip string, probeNo int, audit AuditFunc) (int64, error) {
+	conds := []interface{}{"machine_ip = ?", ip}
+	if probeNo > 0 {
+		conds = []interface{}{"machine_ip = ? AND probe_no = ?", ip, probeNo}
+	}
+
+	var deleted int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var probes []models.MasterMachine
+		if err := tx.Find(&probes, conds...).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.MasterMachine{}, conds...)
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		if err := tx.Delete(&models.TempLatest{}, conds...).Error; err != nil {
+			return err
+		}
+		return writeAudit(tx, audit(probes, nil))
+	})
+	return deleted, translateError(err)
+}
+
+// writeAudit stores audit_log rows inside the caller's transaction
+func writeAudit(tx *gorm.DB, entries []models.AuditLog) error {
+	for i := range entries {
+		if err := tx.Create(&entries[i]).Error; err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+)
+
+// NewGorm returns the repositories backed by db
+func NewGorm(db *gorm.DB) *Set {
+	return &Set{
+		Devices:   &gormDevices{db: db},
+		Readings:  &gormReadings{db: db},
+		Incidents: &gormIncidents{db: db},
+		Config:    &gormConfig{db: db},
+		Users:     &gormUsers{db: db},
+	}
+}
+
+// translateError maps GORM and driver errors onto ErrNotFound and ErrDuplicate
+func translateError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case database.IsDuplicateKey(err):
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	case database.IsConnectionError(err):
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+// lockRetries is how many more times a statement that lost a lock conflict is run
+const lockRetries = 3
+
+// retryLockConflict runs fn again after a short pause while it loses lock conflicts with
+// other transactions (deadlock, lock wait timeout, busy SQLite file). Such errors are not
+// ErrUnavailable: the database is up, so the statement is retried here instead of later.
+func retryLockConflict(fn func() error) error {
+	err := fn()
+	for i := 1; i <= lockRetries && database.IsLockConflict(err); i++ {
+		time.Sleep(time.Duration(i) * 50 * time.Millisecond)
+		err = fn()
+	}
+	return err
+}
+
+// SortColumns returns the key columns of a sort order on a table whose time column is timeColumn
+func SortColumns(sort, timeColumn string) []string {
+	switch sort {
+	case SortMachine:
+		return []string{"machine_ip", "probe_no", timeColumn}
+	case SortValue:
+		return []string{fmt.Sprintf("COALESCE(temp_value, %v)", nullValueKey), timeColumn, "machine_ip", "probe_no"}
+	}
+	return []string{timeColumn, "machine_ip", "probe_no"}
+}
+
+// applyPage adds the keyset condition, order and limit of page
+func applyPage(query *gorm.DB, page Page, timeColumn string) *gorm.DB {
+	keys := SortColumns(page.Sort, timeColumn)
+	dir, cmp := "ASC", ">"
+	if page.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if page.Cursor != nil {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+		query = query.Where(fmt.Sprintf("(%s) %s (%s)", strings.Join(keys, ", "), cmp, placeholders), page.Cursor...)
+	}
+	for _, key := range keys {
+		query = query.Order(key + " " + dir)
+	}
+	if page.Limit > 0 {
+		query = query.Limit(page.Limit)
+	}
+	return query
+}
+
+// whereTimeRange adds the From/Before bounds of a filter in site time; zero bounds are left open
+func whereTimeRange(query *gorm.DB, column string, from, before time.Time) *gorm.DB {
+	if !from.IsZero() {
+		query = query.Where(column+" >= ?", database.SiteTime(from))
+	}
+	if !before.IsZero() {
+		query = query.Where(column+" < ?", database.SiteTime(before))
+	}
+	return query
+}
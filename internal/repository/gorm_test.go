@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/models"
+)
+
+func TestApplyPage(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user@tcp(localhost:3306)/tms", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		page Page
+		sql  string
+		vars []interface{}
+	}{
+		{"first page",
+			Page{Sort: SortTime, Desc: true, Limit: 3},
+			"SELECT * FROM `temp_log` ORDER BY insert_time DESC,machine_ip DESC,probe_no DESC LIMIT ?",
+			[]interface{}{3}},
+		{"ties on the timestamp continue on the remaining key columns",
+			Page{Sort: SortTime, Limit: 3, Cursor: []interface{}{"2025-01-06 08:30:00.000000", "10.0.0.5", 2}},
+			"SELECT * FROM `temp_log` WHERE (insert_time, machine_ip, probe_no) > (?, ?, ?) ORDER BY insert_time ASC,machine_ip ASC,probe_no ASC LIMIT ?",
+			[]interface{}{"2025-01-06 08:30:00.000000", "10.0.0.5", 2, 3}},
+		{"NULL values sort as the lowest value",
+			Page{Sort: SortValue, Desc: true, Limit: 11, Cursor: []interface{}{-999999, "2025-01-06 08:30:00.000000", "10.0.0.5", 2}},
+			"SELECT * FROM `temp_log` WHERE (COALESCE(temp_value, -999999), insert_time, machine_ip, probe_no) < (?, ?, ?, ?) ORDER BY COALESCE(temp_value, -999999) DESC,insert_time DESC,machine_ip DESC,probe_no DESC LIMIT ?",
+			[]interface{}{-999999, "2025-01-06 08:30:00.000000", "10.0.0.5", 2, 11}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs []models.TempLog
+			stmt := applyPage(db.Model(&models.TempLog{}), tc.page, "insert_time").Find(&logs).Statement
+			if sql := stmt.SQL.String(); sql != tc.sql {
+				t.Errorf("SQL = %s\nwant  %s", sql, tc.sql)
+			}
+			if !reflect.DeepEqual(stmt.Vars, tc.vars) {
+				t.Errorf("vars = %v, want %v", stmt.Vars, tc.vars)
+			}
+		})
+	}
+}
+
+func TestRetryLockConflict(t *testing.T) {
+	deadlock := &mysqldriver.MySQLError{Number: 1213}
+	other := errors.New("Error 1406: Data too long for column 'mcu_id'")
+	for _, tc := range []struct {
+		name  string
+		errs  []error // returned by successive calls; nil once they run out
+		calls int
+		want  error
+	}{
+		{"success", nil, 1, nil},
+		{"deadlock then success", []error{deadlock, deadlock}, 3, nil},
+		{"deadlock every time", []error{deadlock, deadlock, deadlock, deadlock, deadlock}, 1 + lockRetries, deadlock},
+		{"other errors are not retried", []error{other}, 1, other},
+	} {
+		calls := 0
+		err := retryLockConflict(func() error {
+			calls++
+			if calls <= len(tc.errs) {
+				return tc.errs[calls-1]
+			}
+			return nil
+		})
+		if err != tc.want || calls != tc.calls {
+			t.Errorf("%s: %d calls, err %v; want %d calls, err %v", tc.name, calls, err, tc.calls, tc.want)
+		}
+	}
+}
@@ -0,0 +1,656 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/schema"
+
+	"tms-backend/internal/models"
+)
+
+// Memory holds in-memory fakes of every repository. It keeps the same keys and ordering as
+// the database so handler and polling tests can run without one.
+type Memory struct {
+	mu        sync.Mutex
+	machines  []models.MasterMachine
+	latest    map[string]models.TempLatest // key: "ip:probe"
+	logs      []models.TempLog
+	incidents []models.TempError
+	acks      []models.TempErrorAck
+	config    map[string]string
+	audit     []models.AuditLog
+	users     []models.MasterUser
+	scopes    map[int][]string // user_device: user id -> machine IPs
+}
+
+// NewMemory returns empty in-memory repositories
+func NewMemory() *Memory {
+	return &Memory{
+		latest: make(map[string]models.TempLatest),
+		config: make(map[string]string),
+		scopes: make(map[int][]string),
+	}
+}
+
+// Set returns the repositories backed by m
+func (m *Memory) Set() *Set {
+	return &Set{
+		Devices:   memoryDevices{m},
+		Readings:  memoryReadings{m},
+		Incidents: memoryIncidents{m},
+		Config:    memoryConfig{m},
+		Users:     memoryUsers{m},
+	}
+}
+
+// AuditLogs returns the audit_log rows written so far
+func (m *Memory) AuditLogs() []models.AuditLog {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.AuditLog(nil), m.audit...)
+}
+
+// Latest returns the temp_latest row of a probe
+func (m *Memory) Latest(ip string, probeNo int) (models.TempLatest, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	latest, ok := m.latest[probeKey(ip, probeNo)]
+	return latest, ok
+}
+
+// Acks returns the temp_error_ack rows written so far
+func (m *Memory) Acks() []models.TempErrorAck {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.TempErrorAck(nil), m.acks...)
+}
+
+func probeKey(ip string, probeNo int) string {
+	return fmt.Sprintf("%s:%d", ip, probeNo)
+}
+
+// sortMachines keeps machines in (machine_ip, probe_no) order, like the primary key
+func (m *Memory) sortMachines() {
+	sort.Slice(m.machines, func(i, j int) bool {
+		if m.machines[i].MachineIP != m.machines[j].MachineIP {
+			return m.machines[i].MachineIP < m.machines[j].MachineIP
+		}
+		return m.machines[i].ProbeNo < m.machines[j].ProbeNo
+	})
+}
+
+// machinesWhere returns copies of the machines match accepts
+func (m *Memory) machinesWhere(match func(models.MasterMachine) bool) []models.MasterMachine {
+	result := make([]models.MasterMachine, 0)
+	for _, machine := range m.machines {
+		if match(machine) {
+			result = append(result, machine)
+		}
+	}
+	return result
+}
+
+// machineSchema and userSchema map columns to struct fields for Update
+var (
+	machineSchema = mustParseSchema(&models.MasterMachine{})
+	userSchema    = mustParseSchema(&models.MasterUser{})
+)
+
+func mustParseSchema(model interface{}) *schema.Schema {
+	s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// applyUpdates sets the column updates on row (a pointer to a model of s) the way GORM's Updates would
+func applyUpdates(s *schema.Schema, row interface{}, updates map[string]interface{}) error {
+	value := reflect.ValueOf(row).Elem()
+	for column, v := range updates {
+		field := s.LookUpField(column)
+		if field == nil {
+			return fmt.Errorf("unknown %s column %q", s.Table, column)
+		}
+		if err := field.Set(context.Background(), value, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryDevices struct{ m *Memory }
+
+func (r memoryDevices) List() ([]models.MasterMachine, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return r.m.machinesWhere(func(models.MasterMachine) bool { return true }), nil
+}
+
+func (r memoryDevices) ListByIP(ip string) ([]models.MasterMachine, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return r.m.machinesWhere(func(machine models.MasterMachine) bool { return machine.MachineIP == ip }), nil
+}
+
+func (r memoryDevices) ListWithLatest() ([]DeviceLatest, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	rows := make([]DeviceLatest, 0, len(r.m.machines))
+	for _, machine := range r.m.machines {
+		row := DeviceLatest{MasterMachine: machine}
+		if latest, ok := r.m.latest[probeKey(machine.MachineIP, machine.ProbeNo)]; ok {
+			t := latest.InsertTime
+			row.LatestValue, row.LatestTime = latest.TempValue, &t
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (r memoryDevices) Get(ip string, probeNo int) (models.MasterMachine, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, machine := range r.m.machines {
+		if machine.MachineIP == ip && machine.ProbeNo == probeNo {
+			return machine, nil
+		}
+	}
+	return models.MasterMachine{}, ErrNotFound
+}
+
+func (r memoryDevices) Create(machine *models.MasterMachine, audit AuditFunc) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, existing := range r.m.machines {
+		if existing.MachineIP == machine.MachineIP && existing.ProbeNo == machine.ProbeNo {
+			return ErrDuplicate
+		}
+	}
+	r.m.machines = append(r.m.machines, *machine)
+	r.m.sortMachines()
+	r.m.audit = append(r.m.audit, audit(nil, []models.MasterMachine{*machine})...)
+	return nil
+}
+
+func (r memoryDevices) Update(ip string, probeNo int, updates map[string]interface{}, audit AuditFunc) (models.MasterMachine, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for i := range r.m.machines {
+		machine := &r.m.machines[i]
+		if machine.MachineIP != ip || machine.ProbeNo != probeNo {
+			continue
+		}
+		if len(updates) == 0 {
+			return *machine, nil
+		}
+		before, after := *machine, *machine
+		if err := applyUpdates(machineSchema, &after, updates); err != nil {
+			return before, err
+		}
+		*machine = after
+		r.m.audit = append(r.m.audit, audit([]models.MasterMachine{before}, []models.MasterMachine{after})...)
+		return after, nil
+	}
+	return models.MasterMachine{}, ErrNotFound
+}
+
+func (r memoryDevices) UpdateGroup(ip string, updates map[string]interface{}, probeAll int, audit AuditFunc) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	byIP := func(machine models.MasterMachine) bool { return machine.MachineIP == ip }
+	before := r.m.machinesWhere(byIP)
+	if len(before) == 0 {
+		return ErrNotFound
+	}
+
+	machines := make([]models.MasterMachine, 0, len(r.m.machines))
+	probes := make([]models.MasterMachine, 0, len(before))
+	for _, machine := range r.m.machines {
+		if byIP(machine) {
+			if err := applyUpdates(machineSchema, &machine, updates); err != nil {
+				return err
+			}
+			if probeAll > 0 && machine.ProbeNo > probeAll {
+				continue
+			}
+			probes = append(probes, machine)
+		}
+		machines = append(machines, machine)
+	}
+	if probeAll > 0 && len(probes) > 0 {
+		machines = append(machines, missingProbes(probes, probeAll)...)
+	}
+	r.m.machines = machines
+	r.m.sortMachines()
+	r.m.audit = append(r.m.audit, audit(before, r.m.machinesWhere(byIP))...)
+	return nil
+}
+
+func (r memoryDevices) Delete(ip string, probeNo int, audit AuditFunc) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	match := func(machine models.MasterMachine) bool {
+		return machine.MachineIP == ip && (probeNo == 0 || machine.ProbeNo == probeNo)
+	}
+	deleted := r.m.machinesWhere(match)
+	r.m.machines = r.m.machinesWhere(func(machine models.MasterMachine) bool { return !match(machine) })
+	for _, machine := range deleted {
+		delete(r.m.latest, probeKey(machine.MachineIP, machine.ProbeNo))
+	}
+	r.m.audit = append(r.m.audit, audit(deleted, nil)...)
+	return int64(len(deleted)), nil
+}
+
+type memoryReadings struct{ m *Memory }
+
+func (r memoryReadings) Insert(log *models.TempLog) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if log.InsertTime.IsZero() {
+		log.InsertTime = time.Now()
+	}
+	log.InsertTime = log.InsertTime.Truncate(time.Millisecond)
+	for _, existing := range r.m.logs {
+		if existing.MachineIP == log.MachineIP && existing.ProbeNo == log.ProbeNo && existing.InsertTime.Equal(log.InsertTime) {
+			return ErrDuplicate
+		}
+	}
+	r.m.logs = append(r.m.logs, *log)
+	return nil
+}
+
+func (r memoryReadings) UpdateLatest(log models.TempLog) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	key := probeKey(log.MachineIP, log.ProbeNo)
+	if latest, ok := r.m.latest[key]; ok && !latest.InsertTime.Before(log.InsertTime) {
+		return nil
+	}
+	r.m.latest[key] = models.TempLatest{
+		MachineIP:  log.MachineIP,
+		ProbeNo:    log.ProbeNo,
+		TempValue:  log.TempValue,
+		Status:     log.Status,
+		InsertTime: log.InsertTime,
+	}
+	return nil
+}
+
+func (r memoryReadings) List(filter ReadingFilter, page Page) ([]models.TempLog, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return pageOf(r.filtered(filter), page, func(l models.TempLog) []interface{} {
+		return SortKey(page.Sort, l.MachineIP, l.ProbeNo, l.InsertTime, l.TempValue)
+	}), nil
+}
+
+func (r memoryReadings) Count(filter ReadingFilter) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return int64(len(r.filtered(filter))), nil
+}
+
+// filtered returns the readings matching f; the caller holds the lock
+func (r memoryReadings) filtered(f ReadingFilter) []models.TempLog {
+	machines := make(map[string]models.MasterMachine, len(r.m.machines))
+	for _, machine := range r.m.machines {
+		machines[probeKey(machine.MachineIP, machine.ProbeNo)] = machine
+	}
+	result := make([]models.TempLog, 0)
+	for _, l := range r.m.logs {
+		if !inList(f.AllowedIPs, l.MachineIP, true) || !inRange(l.InsertTime, f.From, f.Before) ||
+			!inList(f.MachineIPs, l.MachineIP, false) || (f.ProbeNo > 0 && l.ProbeNo != f.ProbeNo) ||
+			(len(f.Statuses) > 0 && (l.Status == nil || !inList(f.Statuses, *l.Status, false))) {
+			continue
+		}
+		if f.MachineName != "" || len(f.STypes) > 0 {
+			machine, ok := machines[probeKey(l.MachineIP, l.ProbeNo)]
+			if !ok || !containsFold(machine.MachineName, f.MachineName) || !inList(f.STypes, machine.SType, false) {
+				continue
+			}
+		}
+		result = append(result, l)
+	}
+	return result
+}
+
+type memoryIncidents struct{ m *Memory }
+
+func (r memoryIncidents) Insert(incident *models.TempError) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if incident.ErrorTime.IsZero() {
+		incident.ErrorTime = time.Now()
+	}
+	incident.ErrorTime = incident.ErrorTime.Truncate(time.Millisecond)
+	for _, existing := range r.m.incidents {
+		if existing.MachineIP == incident.MachineIP && existing.ProbeNo == incident.ProbeNo && existing.ErrorTime.Equal(incident.ErrorTime) {
+			return ErrDuplicate
+		}
+	}
+	r.m.incidents = append(r.m.incidents, *incident)
+	return nil
+}
+
+func (r memoryIncidents) List(filter IncidentFilter, page Page) ([]models.TempError, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return pageOf(r.filtered(filter), page, func(e models.TempError) []interface{} {
+		return SortKey(page.Sort, e.MachineIP, e.ProbeNo, e.ErrorTime, e.TempValue)
+	}), nil
+}
+
+func (r memoryIncidents) Count(filter IncidentFilter) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return int64(len(r.filtered(filter))), nil
+}
+
+// filtered returns the incidents matching f; the caller holds the lock
+func (r memoryIncidents) filtered(f IncidentFilter) []models.TempError {
+	result := make([]models.TempError, 0)
+	for _, e := range r.m.incidents {
+		name := ""
+		if e.MachineName != nil {
+			name = *e.MachineName
+		}
+		if !inList(f.AllowedIPs, e.MachineIP, true) || !inRange(e.ErrorTime, f.From, f.Before) ||
+			!inList(f.MachineIPs, e.MachineIP, false) || (f.ProbeNo > 0 && e.ProbeNo != f.ProbeNo) ||
+			!containsFold(name, f.MachineName) || !inList(f.STypes, e.SType, false) ||
+			!inList(f.ErrorTypes, e.ErrorType, false) ||
+			(f.State == IncidentOpen && e.TempStatus != "p") || (f.State == IncidentClosed && e.TempStatus != "f") {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func (r memoryIncidents) Acknowledge(ack *models.TempErrorAck) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for i := range r.m.incidents {
+		e := &r.m.incidents[i]
+		if e.MachineIP == ack.MachineIP && e.ProbeNo == ack.ProbeNo && e.ErrorTime.Equal(ack.ErrorTime) {
+			e.TempStatus = "f"
+			ack.ID = len(r.m.acks) + 1
+			r.m.acks = append(r.m.acks, *ack)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+type memoryConfig struct{ m *Memory }
+
+func (r memoryConfig) Get(key string) (string, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return r.m.config[key], nil
+}
+
+func (r memoryConfig) Set(key, value string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.config[key] = value
+	return nil
+}
+
+func (r memoryConfig) List(keys []string, prefix string) ([]models.ConfigValue, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	values := make([]models.ConfigValue, 0)
+	for key, value := range r.m.config {
+		if r.selected(key, keys, prefix) {
+			v := value
+			values = append(values, models.ConfigValue{ConfigKey: key, ConfigValue: &v})
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].ConfigKey < values[j].ConfigKey })
+	return values, nil
+}
+
+func (r memoryConfig) Replace(keys []string, prefix string, values []models.ConfigValue, audit []models.AuditLog) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for key := range r.m.config {
+		if r.selected(key, keys, prefix) {
+			delete(r.m.config, key)
+		}
+	}
+	for _, v := range values {
+		value := ""
+		if v.ConfigValue != nil {
+			value = *v.ConfigValue
+		}
+		r.m.config[v.ConfigKey] = value
+	}
+	r.m.audit = append(r.m.audit, audit...)
+	return nil
+}
+
+// selected reports whether key is in keys or starts with prefix
+func (r memoryConfig) selected(key string, keys []string, prefix string) bool {
+	return inList(keys, key, false) && len(keys) > 0 || (prefix != "" && strings.HasPrefix(key, prefix))
+}
+
+type memoryUsers struct{ m *Memory }
+
+func (r memoryUsers) List() ([]models.MasterUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	users := append([]models.MasterUser{}, r.m.users...)
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (r memoryUsers) Get(id int) (models.MasterUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if i := r.index(id); i >= 0 {
+		return r.m.users[i], nil
+	}
+	return models.MasterUser{}, ErrNotFound
+}
+
+func (r memoryUsers) GetByUsername(username string) (models.MasterUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, user := range r.m.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return models.MasterUser{}, ErrNotFound
+}
+
+func (r memoryUsers) UsernameTaken(username string, exceptID int) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, user := range r.m.users {
+		if user.Username == username && user.ID != exceptID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryUsers) Create(user *models.MasterUser) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, u := range r.m.users {
+		if u.Username == user.Username {
+			return fmt.Errorf("%w: username %s", ErrDuplicate, user.Username)
+		}
+	}
+	user.ID = 1
+	for _, u := range r.m.users {
+		if u.ID >= user.ID {
+			user.ID = u.ID + 1
+		}
+	}
+	r.m.users = append(r.m.users, *user)
+	return nil
+}
+
+func (r memoryUsers) Update(id int, updates map[string]interface{}) (models.MasterUser, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	i := r.index(id)
+	if i < 0 {
+		return models.MasterUser{}, ErrNotFound
+	}
+	user := r.m.users[i]
+	if err := applyUpdates(userSchema, &user, updates); err != nil {
+		return models.MasterUser{}, err
+	}
+	r.m.users[i] = user
+	return user, nil
+}
+
+func (r memoryUsers) Delete(id int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	i := r.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.m.users = append(r.m.users[:i], r.m.users[i+1:]...)
+	return nil
+}
+
+func (r memoryUsers) DeviceIPs(userID int) ([]string, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	ips := append([]string{}, r.m.scopes[userID]...)
+	sort.Strings(ips)
+	return ips, nil
+}
+
+func (r memoryUsers) SetDeviceIPs(userID int, ips []string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if r.index(userID) < 0 {
+		return ErrNotFound
+	}
+	r.m.scopes[userID] = scopeIPs(ips)
+	return nil
+}
+
+// index returns the position of user id in m.users, or -1
+func (r memoryUsers) index(id int) int {
+	for i, user := range r.m.users {
+		if user.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// inList reports whether v is in list; an empty list matches everything, and so does a nil
+// list when nilMatchesAll (device scopes use nil for unrestricted)
+func inList(list []string, v string, nilMatchesAll bool) bool {
+	if list == nil && nilMatchesAll || len(list) == 0 && !nilMatchesAll {
+		return true
+	}
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// inRange reports whether t is within [from, before); zero bounds are open
+func inRange(t, from, before time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (before.IsZero() || t.Before(before))
+}
+
+// containsFold reports whether s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// pageOf sorts rows by page.Sort and returns the page after page.Cursor
+func pageOf[T any](rows []T, page Page, keyOf func(T) []interface{}) []T {
+	sort.SliceStable(rows, func(i, j int) bool {
+		c := compareKeys(keyOf(rows[i]), keyOf(rows[j]))
+		if page.Desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	result := make([]T, 0)
+	for _, row := range rows {
+		if page.Cursor != nil {
+			c := compareKeys(keyOf(row), page.Cursor)
+			if (!page.Desc && c <= 0) || (page.Desc && c >= 0) {
+				continue
+			}
+		}
+		if page.Limit > 0 && len(result) == page.Limit {
+			break
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+// compareKeys compares two sort keys column by column
+func compareKeys(a, b []interface{}) int {
+	for i := range a {
+		if i >= len(b) {
+			return 1
+		}
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues compares key values of the same column; cursors decoded from JSON carry
+// numbers as float64
+func compareValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return av.Compare(bv)
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv)
+		}
+	}
+	af, aok := toFloat(a)
+	bf, bok := toFloat(b)
+	if aok && bok {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
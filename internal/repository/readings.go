@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+)
+
+// gormReadings is the ReadingRepo on temp_log and temp_latest
+type gormReadings struct {
+	db *gorm.DB
+}
+
+func (r *gormReadings) Insert(log *models.TempLog) error {
+	return translateError(retryLockConflict(func() error { return r.db.Create(log).Error }))
+}
+
+func (r *gormReadings) UpdateLatest(log models.TempLog) error {
+	latest := models.TempLatest{
+		MachineIP:  log.MachineIP,
+		ProbeNo:    log.ProbeNo,
+		TempValue:  log.TempValue,
+		Status:     log.Status,
+		InsertTime: log.InsertTime,
+	}
+
+	result := r.db.Model(&models.TempLatest{}).
+		Where("machine_ip = ? AND probe_no = ? AND insert_time < ?", latest.MachineIP, latest.ProbeNo, latest.InsertTime).
+		Updates(map[string]interface{}{
+			"temp_value":  latest.TempValue,
+			"status":      latest.Status,
+			"insert_time": latest.InsertTime,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	// No older row to move forward: first reading for this probe (or a late one, which is ignored)
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&latest).Error
+}
+
+func (r *gormReadings) List(filter ReadingFilter, page Page) ([]models.TempLog, error) {
+	var logs []models.TempLog
+	err := applyPage(r.filtered(filter), page, "insert_time").Find(&logs).Error
+	return logs, err
+}
+
+func (r *gormReadings) Count(filter ReadingFilter) (int64, error) {
+	var total int64
+	err := r.filtered(filter).Count(&total).Error
+	return total, err
+}
+
+// filtered returns temp_log restricted by filter
+func (r *gormReadings) filtered(f ReadingFilter) *gorm.DB {
+	query := r.db.Model(&models.TempLog{})
+	if f.AllowedIPs != nil {
+		query = query.Where("machine_ip IN ?", f.AllowedIPs)
+	}
+	query = whereTimeRange(query, "insert_time", f.From, f.Before)
+	if len(f.MachineIPs) > 0 {
+		query = query.Where("machine_ip IN ?", f.MachineIPs)
+	}
+	if f.ProbeNo > 0 {
+		query = query.Where("probe_no = ?", f.ProbeNo)
+	}
+	if len(f.Statuses) > 0 {
+		query = query.Where("status IN ?", f.Statuses)
+	}
+	// machine_name and sType live on master_machine
+	if f.MachineName != "" || len(f.STypes) > 0 {
+		machines := r.db.Model(&models.MasterMachine{}).Select("machine_ip, probe_no")
+		if f.MachineName != "" {
+			machines = machines.Where(database.Contains("machine_name"), "%"+f.MachineName+"%")
+		}
+		if len(f.STypes) > 0 {
+			// map conditions get the mixed-case column quoted (PostgreSQL folds bare names)
+			machines = machines.Where(map[string]interface{}{"sType": f.STypes})
+		}
+		query = query.Where("(machine_ip, probe_no) IN (?)", machines)
+	}
+	return query
+}
+
+// gormIncidents is the IncidentRepo on temp_error and temp_error_ack
+type gormIncidents struct {
+	db *gorm.DB
+}
+
+func (r *gormIncidents) Insert(incident *models.TempError) error {
+	return translateError(r.db.Create(incident).Error)
+}
+
+func (r *gormIncidents) List(filter IncidentFilter, page Page) ([]models.TempError, error) {
+	var incidents []models.TempError
+	err := applyPage(r.filtered(filter), page, "error_time").Find(&incidents).Error
+	return incidents, err
+}
+
+func (r *gormIncidents) Count(filter IncidentFilter) (int64, error) {
+	var total int64
+	err := r.filtered(filter).Count(&total).Error
+	return total, err
+}
+
+// filtered returns temp_error restricted by filter
+func (r *gormIncidents) filtered(f IncidentFilter) *gorm.DB {
+	query := r.db.Model(&models.TempError{})
+	if f.AllowedIPs != nil {
+		query = query.Where("machine_ip IN ?", f.AllowedIPs)
+	}
+	query = whereTimeRange(query, "error_time", f.From, f.Before)
+	if len(f.MachineIPs) > 0 {
+		query = query.Where("machine_ip IN ?", f.MachineIPs)
+	}
+	if f.ProbeNo > 0 {
+		query = query.Where("probe_no = ?", f.ProbeNo)
+	}
+	if f.MachineName != "" {
+		query = query.Where(database.Contains("machine_name"), "%"+f.MachineName+"%")
+	}
+	if len(f.STypes) > 0 {
+		query = query.Where(map[string]interface{}{"sType": f.STypes})
+	}
+	if len(f.ErrorTypes) > 0 {
+		query = query.Where("error_type IN ?", f.ErrorTypes)
+	}
+	switch f.State {
+	case IncidentOpen:
+		query = query.Where("temp_status = ?", "p")
+	case IncidentClosed:
+		query = query.Where("temp_status = ?", "f")
+	}
+	return query
+}
+
+func (r *gormIncidents) Acknowledge(ack *models.TempErrorAck) error {
+	return translateError(r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.TempError{}).
+			Where("machine_ip = ? AND probe_no = ? AND error_time = ?", ack.MachineIP, ack.ProbeNo, ack.ErrorTime).
+			Update("temp_status", "f")
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Create(ack).Error
+	}))
+}
@@ -0,0 +1,83 @@
+package repository_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// testReadings are spread over a few seconds with shared times and values so every sort
+// order has to fall back on its tie-breaking columns
+func testReadings() []models.TempLog {
+	base := time.Date(2025, 1, 6, 8, 0, 0, 0, database.SiteLocation())
+	value := func(v float64) *float64 { return &v }
+	var rows []models.TempLog
+	for i, v := range []*float64{value(4.5), value(-2), nil, value(4.5), value(7.25), nil, value(-2), value(4.5)} {
+		rows = append(rows, models.TempLog{
+			MachineIP:  fmt.Sprintf("10.0.0.%d", 1+i%3),
+			ProbeNo:    1 + i%2,
+			InsertTime: base.Add(time.Duration(i/2) * 1500 * time.Millisecond),
+			TempValue:  v,
+		})
+	}
+	return rows
+}
+
+func readingID(r models.TempLog) string {
+	return fmt.Sprintf("%s/%d/%s", r.MachineIP, r.ProbeNo, r.InsertTime.Format(time.RFC3339Nano))
+}
+
+// listAll pages through readings limit rows at a time, carrying the cursor like the handlers do
+func listAll(t *testing.T, readings repository.ReadingRepo, sort string, desc bool, limit int) []string {
+	t.Helper()
+	var ids []string
+	page := repository.Page{Sort: sort, Desc: desc, Limit: limit}
+	for n := 0; n < 20; n++ {
+		rows, err := readings.List(repository.ReadingFilter{}, page)
+		if err != nil {
+			t.Fatalf("list %s desc=%v: %v", sort, desc, err)
+		}
+		for _, r := range rows {
+			ids = append(ids, readingID(r))
+		}
+		if len(rows) < limit {
+			return ids
+		}
+		last := rows[len(rows)-1]
+		page.Cursor = repository.SortKey(sort, last.MachineIP, last.ProbeNo, database.SiteTime(last.InsertTime), last.TempValue)
+	}
+	t.Fatalf("list %s desc=%v does not end", sort, desc)
+	return nil
+}
+
+func TestReadingKeysetPages(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		stored := repository.NewGorm(db).Readings
+		memory := repository.NewMemory().Set().Readings
+		for _, r := range testReadings() {
+			if err := stored.Insert(&r); err != nil {
+				t.Fatal(err)
+			}
+			if err := memory.Insert(&r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, sort := range []string{repository.SortTime, repository.SortMachine, repository.SortValue} {
+			for _, desc := range []bool{false, true} {
+				want := listAll(t, memory, sort, desc, 100)
+				got := listAll(t, stored, sort, desc, 3)
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("sort %s desc=%v in pages of 3:\n got %v\nwant %v", sort, desc, got, want)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,192 @@
+// Package repository is the data access layer between the HTTP handlers / services and the
+// database. Each repository is an interface with a GORM implementation (NewGorm) and an
+// in-memory fake (NewMemory) so handler and polling code can run without a database.
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"tms-backend/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is returned when an insert hits an existing primary or unique key
+	ErrDuplicate = errors.New("duplicate record")
+	// ErrUnavailable is returned when the database cannot be reached; the call may succeed later
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// Set bundles the repositories handed to handlers and services
+type Set struct {
+	Devices   DeviceRepo
+	Readings  ReadingRepo
+	Incidents IncidentRepo
+	Config    ConfigRepo
+	Users     UserRepo
+}
+
+// AuditFunc returns the audit_log rows describing a device change, given the probes before
+// and after it. Repositories write them in the same transaction as the change.
+type AuditFunc func(before, after []models.MasterMachine) []models.AuditLog
+
+// DeviceLatest is a master_machine row with its temp_latest value, if any
+type DeviceLatest struct {
+	models.MasterMachine
+	LatestValue *float64   `gorm:"column:latest_value"`
+	LatestTime  *time.Time `gorm:"column:latest_time"`
+}
+
+// DeviceRepo reads and changes master_machine. Lists are ordered by machine IP and probe.
+type DeviceRepo interface {
+	List() ([]models.MasterMachine, error)
+	ListByIP(ip string) ([]models.MasterMachine, error)
+	// ListWithLatest returns every probe joined with its temp_latest row
+	ListWithLatest() ([]DeviceLatest, error)
+	Get(ip string, probeNo int) (models.MasterMachine, error)
+	// Create inserts a probe; ErrDuplicate when (ip, probe) exists
+	Create(machine *models.MasterMachine, audit AuditFunc) error
+	// Update applies column updates to one probe and returns it reloaded
+	Update(ip string, probeNo int, updates map[string]interface{}, audit AuditFunc) (models.MasterMachine, error)
+	// UpdateGroup applies column updates to every probe of ip. probeAll > 0 also resizes the
+	// device: probes above it are deleted and missing ones created from the lowest probe.
+	UpdateGroup(ip string, updates map[string]interface{}, probeAll int, audit AuditFunc) error
+	// Delete removes one probe (or every probe of ip when probeNo is 0) with its temp_latest
+	// rows and returns how many probes were deleted
+	Delete(ip string, probeNo int, audit AuditFunc) (int64, error)
+}
+
+// ReadingRepo stores and lists temp_log readings and maintains temp_latest
+type ReadingRepo interface {
+	// Insert stores a reading; ErrDuplicate when (ip, probe, insert_time) exists,
+	// ErrUnavailable when the database cannot be reached. Lock conflicts are retried.
+	Insert(log *models.TempLog) error
+	// UpdateLatest moves the probe's temp_latest row forward to log; older readings never
+	// overwrite a newer row
+	UpdateLatest(log models.TempLog) error
+	List(filter ReadingFilter, page Page) ([]models.TempLog, error)
+	Count(filter ReadingFilter) (int64, error)
+}
+
+// IncidentRepo stores, lists and acknowledges temp_error incidents
+type IncidentRepo interface {
+	// Insert stores an incident; ErrDuplicate when (ip, probe, error_time) exists
+	Insert(incident *models.TempError) error
+	List(filter IncidentFilter, page Page) ([]models.TempError, error)
+	Count(filter IncidentFilter) (int64, error)
+	// Acknowledge closes the incident the ack refers to and stores the ack; ErrNotFound when
+	// there is no such incident
+	Acknowledge(ack *models.TempErrorAck) error
+}
+
+// ConfigRepo reads and writes config_value entries
+type ConfigRepo interface {
+	// Get returns the value of key, or "" when it is not set
+	Get(key string) (string, error)
+	// Set inserts or updates key
+	Set(key, value string) error
+	// List returns the entries named in keys or starting with prefix ("" matches no prefix)
+	List(keys []string, prefix string) ([]models.ConfigValue, error)
+	// Replace deletes the entries List(keys, prefix) would return, stores values and writes
+	// audit, all in one transaction
+	Replace(keys []string, prefix string, values []models.ConfigValue, audit []models.AuditLog) error
+}
+
+// UserRepo reads and changes master_user and the user_device scopes
+type UserRepo interface {
+	// List returns every user ordered by username
+	List() ([]models.MasterUser, error)
+	// Get returns a user; ErrNotFound when there is none with that id
+	Get(id int) (models.MasterUser, error)
+	// GetByUsername returns a user; ErrNotFound when there is none with that username
+	GetByUsername(username string) (models.MasterUser, error)
+	// UsernameTaken reports whether a user other than exceptID has username
+	UsernameTaken(username string, exceptID int) (bool, error)
+	// Create inserts a user; ErrDuplicate when the username exists
+	Create(user *models.MasterUser) error
+	// Update applies column updates to a user and returns it reloaded; ErrNotFound when missing
+	Update(id int, updates map[string]interface{}) (models.MasterUser, error)
+	// Delete removes a user; ErrNotFound when missing
+	Delete(id int) error
+	// DeviceIPs returns the machine IPs a user is scoped to in order; empty means unrestricted
+	DeviceIPs(userID int) ([]string, error)
+	// SetDeviceIPs replaces a user's scope (blank and repeated IPs are dropped); ErrNotFound
+	// when there is no such user
+	SetDeviceIPs(userID int, ips []string) error
+}
+
+// ReadingFilter selects temp_log rows; zero fields do not filter
+type ReadingFilter struct {
+	AllowedIPs  []string  // device scope of the user; nil is unrestricted
+	From        time.Time // insert_time >= From
+	Before      time.Time // insert_time < Before
+	MachineIPs  []string
+	ProbeNo     int
+	Statuses    []string
+	MachineName string   // partial, case-insensitive match on master_machine
+	STypes      []string // sType of the probe on master_machine
+}
+
+// Incident states of IncidentFilter
+const (
+	IncidentOpen   = "open"   // temp_status p (process)
+	IncidentClosed = "closed" // temp_status f (finished)
+)
+
+// IncidentFilter selects temp_error rows; zero fields do not filter
+type IncidentFilter struct {
+	AllowedIPs  []string  // device scope of the user; nil is unrestricted
+	From        time.Time // error_time >= From
+	Before      time.Time // error_time < Before
+	MachineIPs  []string
+	ProbeNo     int
+	MachineName string // partial, case-insensitive
+	STypes      []string
+	ErrorTypes  []string
+	State       string // IncidentOpen or IncidentClosed
+}
+
+// Sort orders of reading and incident lists. Each ends in columns that make the key unique.
+const (
+	SortTime    = "time"    // time, machine IP, probe
+	SortMachine = "machine" // machine IP, probe, time
+	SortValue   = "value"   // value (NULL first), time, machine IP, probe
+)
+
+// nullValueKey stands in for a NULL temp_value in value sort keys
+const nullValueKey = -999999.0
+
+// Page is one keyset page of a list: at most Limit rows in Sort order (descending when Desc)
+// that come strictly after Cursor, the sort key of the previous page's last row
+type Page struct {
+	Sort   string
+	Desc   bool
+	Limit  int
+	Cursor []interface{}
+}
+
+// IsValidSort reports whether sort is one of the list sort orders
+func IsValidSort(sort string) bool {
+	switch sort {
+	case SortTime, SortMachine, SortValue:
+		return true
+	}
+	return false
+}
+
+// SortKey returns the sort key of a reading or incident, in the order cursors hold it
+func SortKey(sort string, machineIP string, probeNo int, t time.Time, value *float64) []interface{} {
+	switch sort {
+	case SortMachine:
+		return []interface{}{machineIP, probeNo, t}
+	case SortValue:
+		v := nullValueKey
+		if value != nil {
+			v = *value
+		}
+		return []interface{}{v, t, machineIP, probeNo}
+	}
+	return []interface{}{t, machineIP, probeNo}
+}
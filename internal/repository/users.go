@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/models"
+)
+
+// gormUsers is the UserRepo on master_user and user_device
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r *gormUsers) List() ([]models.MasterUser, error) {
+	var users []models.MasterUser
+	err := r.db.Order("username ASC").Find(&users).Error
+	return users, err
+}
+
+func (r *gormUsers) Get(id int) (models.MasterUser, error) {
+	var user models.MasterUser
+	err := r.db.First(&user, id).Error
+	return user, translateError(err)
+}
+
+func (r *gormUsers) GetByUsername(username string) (models.MasterUser, error) {
+	var user models.MasterUser
+	err := r.db.Where("username = ?", username).First(&user).Error
+	return user, translateError(err)
+}
+
+func (r *gormUsers) UsernameTaken(username string, exceptID int) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.MasterUser{}).Where("username = ? AND id <> ?", username, exceptID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *gormUsers) Create(user *models.MasterUser) error {
+	return translateError(r.db.Create(user).Error)
+}
+
+func (r *gormUsers) Update(id int, updates map[string]interface{}) (models.MasterUser, error) {
+	user, err := r.Get(id)
+	if err != nil {
+		return user, err
+	}
+	if len(updates) > 0 {
+		if err := r.db.Model(&user).Updates(updates).Error; err != nil {
+			return user, translateError(err)
+		}
+	}
+	return r.Get(id)
+}
+
+func (r *gormUsers) Delete(id int) error {
+	result := r.db.Delete(&models.MasterUser{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormUsers) DeviceIPs(userID int) ([]string, error) {
+	ips := make([]string, 0)
+	err := r.db.Model(&models.UserDevice{}).Where("user_id = ?", userID).Order("machine_ip").Pluck("machine_ip", &ips).Error
+	return ips, err
+}
+
+func (r *gormUsers) SetDeviceIPs(userID int, ips []string) error {
+	return translateError(r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&models.MasterUser{}, userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UserDevice{}).Error; err != nil {
+			return err
+		}
+		for _, ip := range scopeIPs(ips) {
+			if err := tx.Create(&models.UserDevice{UserID: userID, MachineIP: ip}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// scopeIPs trims ips and drops blank and repeated ones, keeping the first occurrence
+func scopeIPs(ips []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		ip = strings.TrimSpace(ip)
+		if ip == "" || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		result = append(result, ip)
+	}
+	return result
+}
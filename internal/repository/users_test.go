@@ -0,0 +1,77 @@
+package repository_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// testUsers exercises a UserRepo; the GORM repositories and the memory fake must agree
+func testUsers(t *testing.T, users repository.UserRepo) {
+	role := "viewer"
+	nurse := models.MasterUser{Username: "nurse", Password: "x", Role: &role}
+	if err := users.Create(&nurse); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if nurse.ID == 0 {
+		t.Fatal("create did not set the id")
+	}
+	if err := users.Create(&models.MasterUser{Username: "nurse", Password: "y"}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Errorf("repeated username: err = %v, want ErrDuplicate", err)
+	}
+	if taken, err := users.UsernameTaken("nurse", nurse.ID); err != nil || taken {
+		t.Errorf("UsernameTaken by the same user = %v, %v", taken, err)
+	}
+	if taken, err := users.UsernameTaken("nurse", 0); err != nil || !taken {
+		t.Errorf("UsernameTaken = %v, %v; want true", taken, err)
+	}
+
+	updated, err := users.Update(nurse.ID, map[string]interface{}{"role": "operator"})
+	if err != nil || updated.Role == nil || *updated.Role != "operator" {
+		t.Errorf("update = %+v, %v", updated, err)
+	}
+	if _, err := users.Get(nurse.ID + 100); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("get unknown user: err = %v, want ErrNotFound", err)
+	}
+
+	if ips, err := users.DeviceIPs(nurse.ID); err != nil || len(ips) != 0 {
+		t.Errorf("initial scope = %v, %v; want none", ips, err)
+	}
+	if err := users.SetDeviceIPs(nurse.ID, []string{"10.0.0.2", " 10.0.0.1", "", "10.0.0.2"}); err != nil {
+		t.Fatalf("set scope: %v", err)
+	}
+	if ips, _ := users.DeviceIPs(nurse.ID); strings.Join(ips, ",") != "10.0.0.1,10.0.0.2" {
+		t.Errorf("scope = %v, want [10.0.0.1 10.0.0.2]", ips)
+	}
+	if err := users.SetDeviceIPs(nurse.ID, []string{"10.0.0.3"}); err != nil {
+		t.Fatalf("replace scope: %v", err)
+	}
+	if ips, _ := users.DeviceIPs(nurse.ID); strings.Join(ips, ",") != "10.0.0.3" {
+		t.Errorf("replaced scope = %v, want [10.0.0.3]", ips)
+	}
+	if err := users.SetDeviceIPs(nurse.ID+100, []string{"10.0.0.1"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("scope of unknown user: err = %v, want ErrNotFound", err)
+	}
+
+	if err := users.Delete(nurse.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := users.Delete(nurse.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("delete again: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testUsers(t, repository.NewMemory().Set().Users)
+	})
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		testUsers(t, repository.NewGorm(db).Users)
+	})
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/utils"
+)
+
+// Token types carried in the "typ" claim
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+var (
+	// ErrInvalidCredentials is returned for an unknown user or wrong password
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrInvalidToken is returned for an expired, malformed or wrongly typed token
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrJWTSecretMissing is returned when JWT_SECRET is not configured
+	ErrJWTSecretMissing = errors.New("JWT_SECRET is not configured")
+)
+
+// AuthClaims are the JWT claims issued for a master_user
+type AuthClaims struct {
+	UserID   int    `json:"uid"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Type     string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair is returned by login and refresh
+type TokenPair struct {
+	AccessToken      string    `json:"accessToken"`
+	RefreshToken     string    `json:"refreshToken"`
+	AccessExpiresAt  time.Time `json:"accessExpiresAt"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+}
+
+// AuthService verifies master_user passwords and issues JWT sessions
+type AuthService struct {
+	users      repository.UserRepo
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	dummyHash  []byte // compared against for unknown users so timing doesn't reveal them
+}
+
+// NewAuthService creates an auth service checking users, configured from JWT_SECRET,
+// JWT_ACCESS_TTL and JWT_REFRESH_TTL. JWT_SECRET is required so that sessions stay valid
+// across restarts.
+func NewAuthService(users repository.UserRepo) (*AuthService, error) {
+	secret := []byte(os.Getenv("JWT_SECRET"))
+	if len(secret) == 0 {
+		return nil, ErrJWTSecretMissing
+	}
+
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("tms-backend"), bcrypt.DefaultCost)
+
+	return &AuthService{
+		users:      users,
+		secret:     secret,
+		dummyHash:  dummyHash,
+		accessTTL:  durationFromEnv("JWT_ACCESS_TTL", 15*time.Minute),
+		refreshTTL: durationFromEnv("JWT_REFRESH_TTL", 7*24*time.Hour),
+	}, nil
+}
+
+// durationFromEnv parses a Go duration from the environment, falling back to def
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		utils.LogError("%s - Invalid duration %q, using %v: %v", key, v, def, err)
+		return def
+	}
+	return d
+}
+
+// Login verifies the password and returns a token pair.
+// Legacy (MD5, SHA1 or plain text) passwords are upgraded to bcrypt on success.
+func (s *AuthService) Login(username, password string) (*models.MasterUser, *TokenPair, error) {
+	user, err := s.users.GetByUsername(username)
+	if err != nil {
+		// Spend the same time as a real check so usernames can't be probed
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	ok, legacy := VerifyPassword(user.Password, password)
+	if !ok {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	if legacy {
+		if hash, err := HashPassword(password); err == nil {
+			if _, err := s.users.Update(user.ID, map[string]interface{}{"password": hash}); err != nil {
+				utils.LogError("Login - Failed to upgrade legacy password hash (user=%s): %v", user.Username, err)
+			} else {
+				log.Printf("Upgraded legacy password hash to bcrypt for user %s", user.Username)
+			}
+		}
+	}
+
+	tokens, err := s.IssueTokens(&user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, tokens, nil
+}
+
+// Refresh exchanges a valid refresh token for a new token pair.
+// The user is reloaded so role changes and deletions take effect.
+func (s *AuthService) Refresh(refreshToken string) (*models.MasterUser, *TokenPair, error) {
+	claims, err := s.ParseToken(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.users.Get(claims.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	tokens, err := s.IssueTokens(&user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, tokens, nil
+}
+
+// IssueTokens signs a new access/refresh token pair for the user
+func (s *AuthService) IssueTokens(user *models.MasterUser) (*TokenPair, error) {
+	now := time.Now()
+	pair := &TokenPair{
+		AccessExpiresAt:  now.Add(s.accessTTL),
+		RefreshExpiresAt: now.Add(s.refreshTTL),
+	}
+
+	var err error
+	if pair.AccessToken, err = s.sign(user, TokenTypeAccess, now, pair.AccessExpiresAt); err != nil {
+		return nil, err
+	}
+	if pair.RefreshToken, err = s.sign(user, TokenTypeRefresh, now, pair.RefreshExpiresAt); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// sign creates one HS256 token
+func (s *AuthService) sign(user *models.MasterUser, tokenType string, issuedAt, expiresAt time.Time) (string, error) {
+	role := ""
+	if user.Role != nil {
+		role = *user.Role
+	}
+
+	claims := AuthClaims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     role,
+		Type:     tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Issuer:    "tms-backend",
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign %s token: %w", tokenType, err)
+	}
+	return token, nil
+}
+
+// ParseToken validates the signature, expiry and token type
+func (s *AuthService) ParseToken(tokenString, expectedType string) (*AuthClaims, error) {
+	claims := &AuthClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || claims.Type != expectedType {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// HashPassword returns a bcrypt hash for storing in master_user.password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword checks a password against a stored hash.
+// legacy is true when the stored value is not bcrypt and should be upgraded.
+func VerifyPassword(stored, password string) (ok bool, legacy bool) {
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+
+	if stored == "" {
+		return false, false
+	}
+
+	// Legacy PHP system: MD5 or SHA1 hex digests, or plain text
+	candidate := password
+	switch {
+	case len(stored) == 32 && isHex(stored):
+		sum := md5.Sum([]byte(password))
+		candidate = hex.EncodeToString(sum[:])
+		stored = strings.ToLower(stored)
+	case len(stored) == 40 && isHex(stored):
+		sum := sha1.Sum([]byte(password))
+		candidate = hex.EncodeToString(sum[:])
+		stored = strings.ToLower(stored)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(candidate)) == 1, true
+}
+
+// isHex reports whether s only contains hexadecimal digits
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/text/encoding/charmap"
+
+	"tms-backend/internal/utils"
+)
+
+// pdfFontFamily is the family name registered for the Thai-capable TrueType font
+const pdfFontFamily = "thai"
+
+// Default Thai-capable fonts, tried in order when PDF_FONT_PATH is not set
+var (
+	defaultPDFFonts = []string{
+		`C:\Windows\Fonts\tahoma.ttf`,
+		`C:\Windows\Fonts\LeelawUI.ttf`,
+		"/usr/share/fonts/truetype/tlwg/Garuda.ttf",
+		"/usr/share/fonts/truetype/thai-tlwg/Garuda.ttf",
+	}
+	defaultPDFBoldFonts = []string{
+		`C:\Windows\Fonts\tahomabd.ttf`,
+		`C:\Windows\Fonts\LeelaUIb.ttf`,
+		"/usr/share/fonts/truetype/tlwg/Garuda-Bold.ttf",
+		"/usr/share/fonts/truetype/thai-tlwg/Garuda-Bold.ttf",
+	}
+)
+
+var (
+	pdfFontOnce    sync.Once
+	pdfFontRegular []byte
+	pdfFontBold    []byte
+)
+
+// loadPDFFonts reads PDF_FONT_PATH / PDF_FONT_BOLD_PATH, or the first default font found.
+// Without a font the report falls back to Helvetica, which cannot render Thai.
+func loadPDFFonts() {
+	pdfFontOnce.Do(func() {
+		pdfFontRegular = readFirstFont(os.Getenv("PDF_FONT_PATH"), defaultPDFFonts)
+		pdfFontBold = readFirstFont(os.Getenv("PDF_FONT_BOLD_PATH"), defaultPDFBoldFonts)
+		if pdfFontRegular == nil {
+			utils.LogError("PDF reports - No Thai font found; set PDF_FONT_PATH to a .ttf such as tahoma.ttf")
+		}
+		if pdfFontBold == nil {
+			pdfFontBold = pdfFontRegular
+		}
+	})
+}
+
+func readFirstFont(configured string, defaults []string) []byte {
+	paths := defaults
+	if configured != "" {
+		paths = []string{configured}
+	}
+	for _, path := range paths {
+		if data, err := os.ReadFile(path); err == nil {
+			log.Printf("PDF reports using font %s", path)
+			return data
+		}
+	}
+	return nil
+}
+
+// compliancePDF wraps fpdf with the report's font handling
+type compliancePDF struct {
+	*fpdf.Fpdf
+	unicode bool
+}
+
+func newCompliancePDF() *compliancePDF {
+	loadPDFFonts()
+
+	pdf := &compliancePDF{Fpdf: fpdf.New("P", "mm", "A4", "")}
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 18)
+	if pdfFontRegular != nil {
+		pdf.AddUTF8FontFromBytes(pdfFontFamily, "", pdfFontRegular)
+		pdf.AddUTF8FontFromBytes(pdfFontFamily, "B", pdfFontBold)
+		pdf.unicode = true
+	}
+	return pdf
+}
+
+// font sets the report font; style is "" or "B"
+func (p *compliancePDF) font(style string, size float64) {
+	if p.unicode {
+		p.SetFont(pdfFontFamily, style, size)
+	} else {
+		p.SetFont("Helvetica", style, size)
+	}
+}
+
+// text converts s for the active font. Machine names read over a tis620 connection
+// arrive as TIS-620 bytes and are decoded first.
+func (p *compliancePDF) text(s string) string {
+	if !utf8.ValidString(s) {
+		if decoded, err := charmap.Windows874.NewDecoder().String(s); err == nil {
+			s = decoded
+		}
+	}
+	if p.unicode {
+		return s
+	}
+	return p.UnicodeTranslatorFromDescriptor("")(s)
+}
+
+// cell writes a bordered table cell
+func (p *compliancePDF) cell(w float64, s string, align string, fill bool) {
+	p.CellFormat(w, 6, p.text(s), "1", 0, align, fill, 0, "")
+}
+
+// WritePDF renders the compliance report as an A4 PDF
+func (r *ComplianceReport) WritePDF(w io.Writer) error {
+	pdf := newCompliancePDF()
+	m := r.Machine
+	unit := m.GetUnit()
+	period := fmt.Sprintf("%s - %s", r.From.Format("2006-01-02"), r.To.AddDate(0, 0, -1).Format("2006-01-02"))
+	if r.From.Day() == 1 && r.To.Equal(r.From.AddDate(0, 1, 0)) {
+		period = fmt.Sprintf("%s (%s)", r.From.Format("January 2006"), period)
+	}
+
+	pdf.SetTitle(fmt.Sprintf("Compliance report %s P%d %s", m.MachineIP, m.ProbeNo, r.From.Format("2006-01")), true)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.font("", 8)
+		footer := fmt.Sprintf("Generated %s", r.GeneratedAt.Format("2006-01-02 15:04:05"))
+		if r.GeneratedBy != "" {
+			footer += " by " + r.GeneratedBy
+		}
+		pdf.CellFormat(0, 5, pdf.text(footer), "", 0, "L", false, 0, "")
+		pdf.SetX(15)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	// Title and device block
+	pdf.font("B", 15)
+	pdf.CellFormat(0, 8, pdf.text("รายงานการควบคุม"+thaiTypeLabel(m.SType)+" / "+m.GetTypeLabel()+" Compliance Report"), "", 1, "L", false, 0, "")
+	pdf.font("", 10)
+	info := [][2]string{
+		{"Device / เครื่อง", m.MachineName},
+		{"IP / Probe", fmt.Sprintf("%s / %d", m.MachineIP, m.ProbeNo)},
+		{"Period / ช่วงเวลา", period},
+		{"Limits in force / เกณฑ์", fmt.Sprintf("%.2f - %.2f %s (adjust %+.2f)", m.GetMinTemp(), m.GetMaxTemp(), unit, m.GetAdjTemp())},
+	}
+	for _, row := range info {
+		pdf.font("B", 10)
+		pdf.CellFormat(45, 6, pdf.text(row[0]), "", 0, "L", false, 0, "")
+		pdf.font("", 10)
+		pdf.CellFormat(0, 6, pdf.text(row[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	// Summary
+	pdf.font("B", 11)
+	pdf.CellFormat(0, 7, pdf.text("Summary / สรุป"), "", 1, "L", false, 0, "")
+	pdf.font("", 10)
+	if r.Readings == 0 {
+		pdf.CellFormat(0, 6, pdf.text("No readings recorded in this period / ไม่มีข้อมูลในช่วงเวลานี้"), "", 1, "L", false, 0, "")
+	} else {
+		pdf.CellFormat(0, 6, pdf.text(fmt.Sprintf(
+			"Readings %d | Min %.2f | Max %.2f | Avg %.2f %s | In range %.2f%% | Out of range %d | Excursions %d",
+			r.Readings, r.Min, r.Max, r.Avg, unit, r.InRangePercent(), r.OutOfRange, len(r.Excursions))), "", 1, "L", false, 0, "")
+	}
+	if r.MKT != nil && r.MKT.MKT != nil {
+		line := fmt.Sprintf("Mean Kinetic Temperature (MKT, Ea %.3f kJ/mol) %.2f %s | limit %.2f %s",
+			r.MKTSettings.ActivationEnergy, *r.MKT.MKT, unit, r.MKTSettings.LimitFor(m.MachineIP, m.ProbeNo), unit)
+		if r.MKT.Exceeded {
+			line += " | EXCEEDED / เกินเกณฑ์"
+			pdf.SetTextColor(200, 0, 0)
+		}
+		pdf.CellFormat(0, 6, pdf.text(line), "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	}
+	pdf.Ln(2)
+
+	r.drawChart(pdf)
+	r.drawDailyTable(pdf)
+	r.drawExcursions(pdf)
+	r.drawThresholdChanges(pdf)
+	drawSignOff(pdf)
+
+	return pdf.Output(w)
+}
+
+// thaiTypeLabel is the Thai word for the sensor type used in the title
+func thaiTypeLabel(sType string) string {
+	switch sType {
+	case "h":
+		return "ความชื้น"
+	case "p":
+		return "พลังงาน"
+	default:
+		return "อุณหภูมิ"
+	}
+}
+
+// drawChart plots hourly averages with the min/max limits as dashed lines
+func (r *ComplianceReport) drawChart(pdf *compliancePDF) {
+	const (
+		x0, width  = 27.0, 168.0
+		height     = 62.0
+		gridLines  = 5
+		labelWidth = 12.0
+	)
+	m := r.Machine
+	minTemp, maxTemp := m.GetMinTemp(), m.GetMaxTemp()
+
+	lo, hi := minTemp, maxTemp
+	if r.Readings > 0 {
+		lo, hi = math.Min(lo, r.Min), math.Max(hi, r.Max)
+	}
+	pad := math.Max((hi-lo)*0.1, 1)
+	lo, hi = math.Floor(lo-pad), math.Ceil(hi+pad)
+
+	if pdf.GetY()+height+14 > 297-18 {
+		pdf.AddPage()
+	}
+	y0 := pdf.GetY() + 2
+
+	span := r.To.Sub(r.From).Seconds()
+	xOf := func(sec float64) float64 { return x0 + width*sec/span }
+	yOf := func(v float64) float64 { return y0 + height - height*(v-lo)/(hi-lo) }
+
+	// Grid and Y labels
+	pdf.font("", 7)
+	pdf.SetDrawColor(220, 220, 220)
+	pdf.SetLineWidth(0.1)
+	for i := 0; i <= gridLines; i++ {
+		v := lo + (hi-lo)*float64(i)/gridLines
+		y := yOf(v)
+		pdf.Line(x0, y, x0+width, y)
+		pdf.SetXY(x0-labelWidth-1, y-2)
+		pdf.CellFormat(labelWidth, 4, fmt.Sprintf("%.1f", v), "", 0, "R", false, 0, "")
+	}
+	// X labels every day for short periods, otherwise every 5 days
+	everyDay := r.To.Sub(r.From) <= 10*24*time.Hour
+	for d := r.From; d.Before(r.To); d = d.AddDate(0, 0, 1) {
+		if !everyDay && d.Day() != 1 && d.Day()%5 != 0 {
+			continue
+		}
+		x := xOf(d.Sub(r.From).Seconds())
+		pdf.Line(x, y0, x, y0+height)
+		pdf.SetXY(x-5, y0+height+0.5)
+		pdf.CellFormat(10, 4, d.Format("02"), "", 0, "C", false, 0, "")
+	}
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.Rect(x0, y0, width, height, "D")
+
+	// Limits
+	pdf.SetDrawColor(200, 0, 0)
+	pdf.SetLineWidth(0.3)
+	pdf.SetDashPattern([]float64{1.5, 1}, 0)
+	pdf.Line(x0, yOf(maxTemp), x0+width, yOf(maxTemp))
+	pdf.Line(x0, yOf(minTemp), x0+width, yOf(minTemp))
+	pdf.SetDashPattern([]float64{}, 0)
+
+	// Hourly averages; gaps longer than 3 hours are not joined
+	pdf.SetDrawColor(0, 90, 200)
+	pdf.SetLineWidth(0.25)
+	for i := 1; i < len(r.Chart); i++ {
+		prev, cur := r.Chart[i-1], r.Chart[i]
+		if cur.Time.Sub(prev.Time).Hours() > 3 {
+			continue
+		}
+		pdf.Line(xOf(prev.Time.Sub(r.From).Seconds()), yOf(prev.Value),
+			xOf(cur.Time.Sub(r.From).Seconds()), yOf(cur.Value))
+	}
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetLineWidth(0.2)
+
+	pdf.SetXY(15, y0+height+5)
+	pdf.font("", 8)
+	pdf.CellFormat(0, 5, pdf.text(fmt.Sprintf("Hourly average (%s); dashed red lines are the limits / ค่าเฉลี่ยรายชั่วโมง เส้นประสีแดงคือเกณฑ์", m.GetUnit())), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+}
+
+// drawDailyTable prints min/max/avg per day
+func (r *ComplianceReport) drawDailyTable(pdf *compliancePDF) {
+	widths := []float64{35, 27, 27, 27, 32, 32}
+	header := []string{"Date / วันที่", "Min", "Max", "Avg", "Readings", "Out of range"}
+
+	pdf.font("B", 11)
+	pdf.CellFormat(0, 7, pdf.text("Daily statistics / สถิติรายวัน"), "", 1, "L", false, 0, "")
+	drawHeader := func() {
+		pdf.font("B", 9)
+		pdf.SetFillColor(230, 230, 230)
+		for i, h := range header {
+			pdf.cell(widths[i], h, "C", true)
+		}
+		pdf.Ln(-1)
+		pdf.font("", 9)
+	}
+	drawHeader()
+
+	for _, d := range r.Days {
+		if pdf.GetY() > 297-18-6 {
+			pdf.AddPage()
+			drawHeader()
+		}
+		values := []string{d.Date.Format("2006-01-02 Mon"), "-", "-", "-", "0", "0"}
+		if d.Readings > 0 {
+			values = []string{
+				d.Date.Format("2006-01-02 Mon"),
+				fmt.Sprintf("%.2f", d.Min),
+				fmt.Sprintf("%.2f", d.Max),
+				fmt.Sprintf("%.2f", d.Avg),
+				fmt.Sprintf("%d", d.Readings),
+				fmt.Sprintf("%d", d.OutOfRange),
+			}
+		}
+		fill := d.OutOfRange > 0
+		pdf.SetFillColor(255, 199, 206)
+		for i, v := range values {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			pdf.cell(widths[i], v, align, fill)
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(3)
+}
+
+// drawExcursions lists temp_error rows with who acknowledged them and why
+func (r *ComplianceReport) drawExcursions(pdf *compliancePDF) {
+	pdf.font("B", 11)
+	pdf.CellFormat(0, 7, pdf.text("Excursions / เหตุการณ์ค่าเกินเกณฑ์"), "", 1, "L", false, 0, "")
+	pdf.font("", 9)
+	if len(r.Excursions) == 0 {
+		pdf.CellFormat(0, 6, pdf.text("None / ไม่มี"), "", 1, "L", false, 0, "")
+		pdf.Ln(3)
+		return
+	}
+
+	unit := r.Machine.GetUnit()
+	for _, e := range r.Excursions {
+		value := "-"
+		if e.TempValue != nil {
+			value = fmt.Sprintf("%.2f %s", *e.TempValue, unit)
+		}
+		limits := ""
+		if e.MinTemp != nil && e.MaxTemp != nil {
+			limits = fmt.Sprintf(" (limits %.2f - %.2f)", *e.MinTemp, *e.MaxTemp)
+		}
+		pdf.font("B", 9)
+		pdf.MultiCell(0, 5, pdf.text(fmt.Sprintf("%s  %s%s", e.ErrorTime.Format("2006-01-02 15:04:05"), value, limits)), "", "L", false)
+		pdf.font("", 9)
+
+		if len(e.Acks) == 0 {
+			pdf.SetX(22)
+			pdf.MultiCell(0, 5, pdf.text("Not acknowledged / ยังไม่ได้รับทราบ"), "", "L", false)
+		}
+		for _, a := range e.Acks {
+			pdf.SetX(22)
+			line := fmt.Sprintf("Acknowledged by %s at %s", a.Username, a.AckTime.Format("2006-01-02 15:04"))
+			if a.Comment != "" {
+				line += ": " + a.Comment
+			}
+			pdf.MultiCell(0, 5, pdf.text(line), "", "L", false)
+		}
+	}
+	pdf.Ln(3)
+}
+
+// drawThresholdChanges lists limit changes made during the period (from audit_log)
+func (r *ComplianceReport) drawThresholdChanges(pdf *compliancePDF) {
+	if len(r.ThresholdChanges) == 0 {
+		return
+	}
+
+	pdf.font("B", 11)
+	pdf.CellFormat(0, 7, pdf.text("Limit changes in period / การเปลี่ยนเกณฑ์ในช่วงเวลา"), "", 1, "L", false, 0, "")
+	pdf.font("", 9)
+	for _, e := range r.ThresholdChanges {
+		var before, after struct {
+			MinTemp *float64 `json:"minTemp"`
+			MaxTemp *float64 `json:"maxTemp"`
+			AdjTemp *float64 `json:"adjTemp"`
+		}
+		json.Unmarshal(e.BeforeValue, &before)
+		json.Unmarshal(e.AfterValue, &after)
+
+		changes := []string{}
+		for _, f := range []struct {
+			name     string
+			from, to *float64
+		}{
+			{"min", before.MinTemp, after.MinTemp},
+			{"max", before.MaxTemp, after.MaxTemp},
+			{"adjust", before.AdjTemp, after.AdjTemp},
+		} {
+			if from, to := formatOptional(f.from), formatOptional(f.to); from != to {
+				changes = append(changes, fmt.Sprintf("%s %s -> %s", f.name, from, to))
+			}
+		}
+
+		line := fmt.Sprintf("%s  %s  %s", e.CreatedAt.Format("2006-01-02 15:04"), e.Username, strings.Join(changes, ", "))
+		if e.Reason != "" {
+			line += "  (" + e.Reason + ")"
+		}
+		pdf.MultiCell(0, 5, pdf.text(line), "", "L", false)
+	}
+	pdf.Ln(3)
+}
+
+func formatOptional(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", *v)
+}
+
+// drawSignOff adds the review/approval signature block
+func drawSignOff(pdf *compliancePDF) {
+	if pdf.GetY() > 297-18-40 {
+		pdf.AddPage()
+	}
+	pdf.Ln(6)
+	pdf.font("B", 11)
+	pdf.CellFormat(0, 7, pdf.text("Sign-off / การลงนาม"), "", 1, "L", false, 0, "")
+	pdf.font("", 10)
+	for _, role := range []string{"Reviewed by / ผู้ตรวจสอบ", "Approved by / ผู้อนุมัติ"} {
+		pdf.Ln(6)
+		pdf.CellFormat(50, 6, pdf.text(role), "", 0, "L", false, 0, "")
+		pdf.CellFormat(70, 6, "", "B", 0, "L", false, 0, "")
+		pdf.CellFormat(15, 6, pdf.text("Date"), "", 0, "R", false, 0, "")
+		pdf.CellFormat(35, 6, "", "B", 1, "L", false, 0, "")
+	}
+}
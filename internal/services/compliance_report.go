@@ -0,0 +1,269 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// ComplianceDay is one row of the daily min/max/avg table
+type ComplianceDay struct {
+	Date        time.Time
+	Min         float64
+	Max         float64
+	Avg         float64
+	Readings    int
+	OutOfRange  int
+	sum         float64
+	initialized bool
+}
+
+// ComplianceChartPoint is an hourly average plotted on the report chart
+type ComplianceChartPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// ComplianceExcursion is a temp_error row with its acknowledgements
+type ComplianceExcursion struct {
+	models.TempError
+	Acks []models.TempErrorAck
+}
+
+// ComplianceReport holds everything printed on a compliance PDF for one probe and period
+type ComplianceReport struct {
+	Machine          models.MasterMachine
+	From             time.Time // inclusive, midnight site time
+	To               time.Time // exclusive, midnight site time
+	Days             []ComplianceDay
+	Chart            []ComplianceChartPoint
+	Excursions       []ComplianceExcursion
+	ThresholdChanges []models.AuditLog
+	MKT              *MKTWindow  // temperature probes only
+	MKTSettings      MKTSettings // activation energy and limits the MKT was calculated with
+	Readings         int
+	OutOfRange       int
+	Min              float64
+	Max              float64
+	Avg              float64
+	GeneratedAt      time.Time
+	GeneratedBy      string
+}
+
+// InRangePercent is the share of readings inside the thresholds
+func (r *ComplianceReport) InRangePercent() float64 {
+	if r.Readings == 0 {
+		return 0
+	}
+	return 100 * float64(r.Readings-r.OutOfRange) / float64(r.Readings)
+}
+
+// ParseReportMonth parses "YYYY-MM" as the first instant of that month in site time
+func ParseReportMonth(month string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01", month, database.SiteLocation())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("month must be YYYY-MM: %w", err)
+	}
+	return t, nil
+}
+
+// BuildComplianceReport gathers temp_log, temp_error (with acknowledgements) and
+// threshold changes from audit_log on db for one probe of repos.Devices over whole days [from, to)
+func BuildComplianceReport(db *gorm.DB, repos *repository.Set, machineIP string, probeNo int, from, to time.Time) (*ComplianceReport, error) {
+	machine, err := repos.Devices.Get(machineIP, probeNo)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s probe %d", ErrUnknownMachine, machineIP, probeNo)
+		}
+		return nil, err
+	}
+
+	report := &ComplianceReport{
+		Machine:     machine,
+		From:        from,
+		To:          to,
+		GeneratedAt: database.SiteNow(),
+	}
+	if err := report.loadReadings(db); err != nil {
+		return nil, fmt.Errorf("failed to load readings: %w", err)
+	}
+	if err := report.loadExcursions(db); err != nil {
+		return nil, fmt.Errorf("failed to load excursions: %w", err)
+	}
+	if err := report.loadThresholdChanges(db); err != nil {
+		return nil, fmt.Errorf("failed to load threshold changes: %w", err)
+	}
+	if machine.IsTemperatureType() {
+		if err := report.loadMKT(db, repos.Config); err != nil {
+			return nil, fmt.Errorf("failed to calculate MKT: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// loadReadings streams the period's temp_log rows into daily stats and hourly chart points
+func (r *ComplianceReport) loadReadings(db *gorm.DB) error {
+	rows, err := db.Model(&models.TempLog{}).
+		Select("temp_value, insert_time").
+		Where("machine_ip = ? AND probe_no = ? AND insert_time >= ? AND insert_time < ?",
+			r.Machine.MachineIP, r.Machine.ProbeNo, r.From, r.To).
+		Order("insert_time").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loc := r.From.Location()
+	days := make([]ComplianceDay, 0, 31)
+	dayIndex := make(map[string]int)
+	for d := r.From; d.Before(r.To); d = d.AddDate(0, 0, 1) {
+		dayIndex[d.Format("2006-01-02")] = len(days)
+		days = append(days, ComplianceDay{Date: d})
+	}
+
+	minTemp, maxTemp := r.Machine.GetMinTemp(), r.Machine.GetMaxTemp()
+	var hour time.Time
+	var hourSum float64
+	var hourCount int
+	var total float64
+
+	for rows.Next() {
+		var value *float64
+		var at time.Time
+		if err := rows.Scan(&value, &at); err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		v := *value
+		at = at.In(loc)
+
+		day := &days[dayIndex[at.Format("2006-01-02")]]
+		if !day.initialized {
+			day.Min, day.Max, day.initialized = v, v, true
+		}
+		day.Min = math.Min(day.Min, v)
+		day.Max = math.Max(day.Max, v)
+		day.sum += v
+		day.Readings++
+
+		if r.Readings == 0 {
+			r.Min, r.Max = v, v
+		}
+		r.Min = math.Min(r.Min, v)
+		r.Max = math.Max(r.Max, v)
+		total += v
+		r.Readings++
+
+		if v < minTemp || v > maxTemp {
+			day.OutOfRange++
+			r.OutOfRange++
+		}
+
+		if h := at.Truncate(time.Hour); !h.Equal(hour) {
+			if hourCount > 0 {
+				r.Chart = append(r.Chart, ComplianceChartPoint{Time: hour, Value: hourSum / float64(hourCount)})
+			}
+			hour, hourSum, hourCount = h, 0, 0
+		}
+		hourSum += v
+		hourCount++
+	}
+	if hourCount > 0 {
+		r.Chart = append(r.Chart, ComplianceChartPoint{Time: hour, Value: hourSum / float64(hourCount)})
+	}
+
+	for i := range days {
+		if days[i].Readings > 0 {
+			days[i].Avg = days[i].sum / float64(days[i].Readings)
+		}
+	}
+	if r.Readings > 0 {
+		r.Avg = total / float64(r.Readings)
+	}
+	r.Days = days
+	return rows.Err()
+}
+
+// loadExcursions loads temp_error rows for the period and attaches their acknowledgements
+func (r *ComplianceReport) loadExcursions(db *gorm.DB) error {
+	var tempErrors []models.TempError
+	if err := db.
+		Where("machine_ip = ? AND probe_no = ? AND error_time >= ? AND error_time < ?",
+			r.Machine.MachineIP, r.Machine.ProbeNo, r.From, r.To).
+		Order("error_time").
+		Find(&tempErrors).Error; err != nil {
+		return err
+	}
+
+	var acks []models.TempErrorAck
+	if err := db.
+		Where("machine_ip = ? AND probe_no = ? AND error_time >= ? AND error_time < ?",
+			r.Machine.MachineIP, r.Machine.ProbeNo, r.From, r.To).
+		Order("ack_time").
+		Find(&acks).Error; err != nil {
+		return err
+	}
+
+	byErrorTime := make(map[int64][]models.TempErrorAck)
+	for _, a := range acks {
+		key := a.ErrorTime.Truncate(time.Millisecond).UnixMilli()
+		byErrorTime[key] = append(byErrorTime[key], a)
+	}
+
+	r.Excursions = make([]ComplianceExcursion, 0, len(tempErrors))
+	for _, e := range tempErrors {
+		r.Excursions = append(r.Excursions, ComplianceExcursion{
+			TempError: e,
+			Acks:      byErrorTime[e.ErrorTime.Truncate(time.Millisecond).UnixMilli()],
+		})
+	}
+	return nil
+}
+
+// loadThresholdChanges loads audit_log entries for this probe that changed its limits
+func (r *ComplianceReport) loadThresholdChanges(db *gorm.DB) error {
+	var entries []models.AuditLog
+	if err := db.
+		Where("entity = ? AND entity_id = ? AND created_at >= ? AND created_at < ?",
+			"master_machine", fmt.Sprintf("%s:%d", r.Machine.MachineIP, r.Machine.ProbeNo), r.From, r.To).
+		Order("created_at").
+		Find(&entries).Error; err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		for _, field := range strings.Split(e.ChangedFields, ",") {
+			if field == "minTemp" || field == "maxTemp" || field == "adjTemp" {
+				r.ThresholdChanges = append(r.ThresholdChanges, e)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// loadMKT calculates the Mean Kinetic Temperature over the report period
+func (r *ComplianceReport) loadMKT(db *gorm.DB, config repository.ConfigRepo) error {
+	settings, err := LoadMKTSettings(config)
+	if err != nil {
+		return err
+	}
+	window, err := CalculateMKT(db, r.Machine.MachineIP, r.Machine.ProbeNo, r.From, r.To,
+		settings.ActivationEnergy, settings.LimitFor(r.Machine.MachineIP, r.Machine.ProbeNo))
+	if err != nil {
+		return err
+	}
+	r.MKT, r.MKTSettings = &window, settings
+	return nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// ResolveDeviceSelectors matches device selectors against the probes in devices. A selector is a
+// machine IP (all its probes), "ip:probe", or a machine name (case-insensitive, all probes
+// with that name). It returns the matching probes ordered by IP and probe, and the
+// selectors that matched nothing.
+func ResolveDeviceSelectors(devices repository.DeviceRepo, selectors []string) ([]models.MasterMachine, []string, error) {
+	all, err := devices.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	machines, unknown := matchDeviceSelectors(all, selectors)
+	return machines, unknown, nil
+}
+
+// matchDeviceSelectors filters probes by selectors (see ResolveDeviceSelectors); no selectors
+// select every probe
+func matchDeviceSelectors(all []models.MasterMachine, selectors []string) ([]models.MasterMachine, []string) {
+	if len(selectors) == 0 {
+		return all, nil
+	}
+
+	matched := make(map[string]bool, len(selectors))
+	machines := make([]models.MasterMachine, 0, len(selectors))
+	for _, m := range all {
+		keys := []string{m.MachineIP, m.MachineIP + ":" + strconv.Itoa(m.ProbeNo)}
+		name := strings.ToLower(strings.TrimSpace(m.MachineName))
+		selected := false
+		for _, sel := range selectors {
+			if sel == keys[0] || sel == keys[1] || (name != "" && strings.ToLower(sel) == name) {
+				matched[sel] = true
+				selected = true
+			}
+		}
+		if selected {
+			machines = append(machines, m)
+		}
+	}
+
+	unknown := make([]string, 0)
+	for _, sel := range selectors {
+		if !matched[sel] {
+			unknown = append(unknown, sel)
+		}
+	}
+	return machines, unknown
+}
+
+// ResolveReportDevices expands a comma-separated selector list (see ResolveDeviceSelectors)
+// into the probes of devices, ignoring selectors that match nothing; an empty list selects every probe
+func ResolveReportDevices(devices repository.DeviceRepo, selectors string) ([]models.MasterMachine, error) {
+	machines, _, err := ResolveDeviceSelectors(devices, splitList(selectors))
+	return machines, err
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+func TestMatchDeviceSelectors(t *testing.T) {
+	all := []models.MasterMachine{
+		{MachineIP: "10.0.0.5", ProbeNo: 1, MachineName: "Fridge A"},
+		{MachineIP: "10.0.0.5", ProbeNo: 2, MachineName: "Fridge A"},
+		{MachineIP: "10.0.0.6", ProbeNo: 1, MachineName: "Freezer"},
+		{MachineIP: "10.0.0.7", ProbeNo: 1, MachineName: ""},
+	}
+
+	tests := []struct {
+		name      string
+		selectors []string
+		want      []string // ip:probe of the selected probes
+		unknown   []string
+	}{
+		{"no selectors select everything", nil, []string{"10.0.0.5:1", "10.0.0.5:2", "10.0.0.6:1", "10.0.0.7:1"}, nil},
+		{"ip selects all its probes", []string{"10.0.0.5"}, []string{"10.0.0.5:1", "10.0.0.5:2"}, []string{}},
+		{"ip:probe selects one probe", []string{"10.0.0.5:2"}, []string{"10.0.0.5:2"}, []string{}},
+		{"name is case-insensitive", []string{"fridge a", "FREEZER"}, []string{"10.0.0.5:1", "10.0.0.5:2", "10.0.0.6:1"}, []string{}},
+		{"overlapping selectors return each probe once", []string{"10.0.0.5", "10.0.0.5:1", "Fridge A"}, []string{"10.0.0.5:1", "10.0.0.5:2"}, []string{}},
+		{"unknown selectors are reported", []string{"10.0.0.6", "10.0.0.6:2", "10.0.0.9", "Cold room"},
+			[]string{"10.0.0.6:1"}, []string{"10.0.0.6:2", "10.0.0.9", "Cold room"}},
+		{"an empty name matches nothing", []string{""}, []string{}, []string{""}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			machines, unknown := matchDeviceSelectors(all, tc.selectors)
+			got := make([]string, 0, len(machines))
+			for _, m := range machines {
+				got = append(got, fmt.Sprintf("%s:%d", m.MachineIP, m.ProbeNo))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("probes = %v, want %v", got, tc.want)
+			}
+			if !reflect.DeepEqual(unknown, tc.unknown) {
+				t.Errorf("unknown = %v, want %v", unknown, tc.unknown)
+			}
+		})
+	}
+}
+
+func TestResolveReportDevices(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		for _, m := range []models.MasterMachine{
+			{MachineIP: "10.0.0.6", ProbeNo: 1, ProbeAll: 1, MachineName: "Freezer"},
+			{MachineIP: "10.0.0.5", ProbeNo: 2, ProbeAll: 2, MachineName: "Fridge A"},
+			{MachineIP: "10.0.0.5", ProbeNo: 1, ProbeAll: 2, MachineName: "Fridge A"},
+		} {
+			if err := db.Create(&m).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			devices string
+			want    []string
+		}{
+			{"", []string{"10.0.0.5:1", "10.0.0.5:2", "10.0.0.6:1"}},
+			{"10.0.0.5:2, freezer", []string{"10.0.0.5:2", "10.0.0.6:1"}},
+			{"Fridge A,10.0.0.9", []string{"10.0.0.5:1", "10.0.0.5:2"}},
+			{"10.0.0.9", []string{}},
+		}
+		for _, tc := range tests {
+			machines, err := ResolveReportDevices(repository.NewGorm(db).Devices, tc.devices)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(machines))
+			for _, m := range machines {
+				got = append(got, fmt.Sprintf("%s:%d", m.MachineIP, m.ProbeNo))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("devices %q: probes = %v, want %v", tc.devices, got, tc.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/utils"
+)
+
+// GlobalReadingJournal buffers readings while the database is unreachable
+var GlobalReadingJournal *ReadingJournal
+
+// defaultJournalPath is the journal file next to the exe (the working directory)
+const defaultJournalPath = "reading_journal.jsonl"
+
+// maxJournalAttempts is how many replays may reject an entry before it is moved to the
+// dead-letter file, so one bad row cannot hold up the rest of the journal
+const maxJournalAttempts = 5
+
+// ReadingJournal is a local store-and-forward buffer for temp_log. When an insert fails
+// because the database is unreachable the reading is appended (one JSON line, fsynced) to an
+// append-only file; a background loop replays the file into temp_log in order once the
+// database accepts writes again, so an outage leaves no gap in the compliance record.
+// Entries the database keeps rejecting end up in the dead-letter file next to it
+// (reading_journal_failed.jsonl) for manual review.
+type ReadingJournal struct {
+	path     string
+	deadPath string
+	readings repository.ReadingRepo
+	interval time.Duration
+	file     *os.File     // open for appending; nil until the first append after a replay
+	backlog  atomic.Int64 // entries waiting in the file
+	fileMu   sync.Mutex   // guards file and the journal file on disk
+	replayMu sync.Mutex   // one replay at a time
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+	running  bool
+	mu       sync.Mutex
+}
+
+// journalEntry is one line of the journal: the reading and how many replays rejected it
+type journalEntry struct {
+	models.TempLog
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// NewReadingJournal opens the journal at READING_JOURNAL_PATH (default reading_journal.jsonl)
+// and counts the entries left by a previous run
+func NewReadingJournal(readings repository.ReadingRepo) *ReadingJournal {
+	path := os.Getenv("READING_JOURNAL_PATH")
+	if path == "" {
+		path = defaultJournalPath
+	}
+	ext := filepath.Ext(path)
+	j := &ReadingJournal{
+		path:     path,
+		deadPath: strings.TrimSuffix(path, ext) + "_failed" + ext,
+		readings: readings,
+		interval: 30 * time.Second,
+		stopChan: make(chan struct{}),
+	}
+
+	entries, _, err := j.load(0)
+	if err != nil {
+		utils.LogError("ReadingJournal - Failed to read %s: %v", path, err)
+	}
+	j.backlog.Store(int64(len(entries)))
+	if len(entries) > 0 {
+		log.Printf("Reading journal %s has %d readings waiting to be replayed", path, len(entries))
+	}
+	return j
+}
+
+// Append stores a reading that could not be inserted. It returns once the entry is on disk.
+func (j *ReadingJournal) Append(tempLog models.TempLog) error {
+	line, err := json.Marshal(journalEntry{TempLog: tempLog})
+	if err != nil {
+		return err
+	}
+
+	j.fileMu.Lock()
+	defer j.fileMu.Unlock()
+
+	if j.file == nil {
+		f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open reading journal: %w", err)
+		}
+		// A crash may have cut the last line short; start on a fresh line so only that entry is lost
+		if info, err := f.Stat(); err == nil && info.Size() > 0 && !endsWithNewline(j.path, info.Size()) {
+			f.Write([]byte("\n"))
+		}
+		j.file = f
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write reading journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync reading journal: %w", err)
+	}
+	j.backlog.Add(1)
+	return nil
+}
+
+// endsWithNewline reports whether the file's last byte is a newline
+func endsWithNewline(path string, size int64) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return true
+	}
+	return last[0] == '\n'
+}
+
+// Backlog returns the number of readings waiting to be replayed
+func (j *ReadingJournal) Backlog() int {
+	return int(j.backlog.Load())
+}
+
+// Start the replay loop; a journal left by a previous run is replayed right away
+func (j *ReadingJournal) Start() {
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		return
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	log.Printf("Starting reading journal replay (every %v, file %s)...", j.interval, j.path)
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		j.runReplay()
+		for {
+			select {
+			case <-ticker.C:
+				j.runReplay()
+			case <-j.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the replay loop and close the journal file
+func (j *ReadingJournal) Stop() {
+	j.mu.Lock()
+	if !j.running {
+		j.mu.Unlock()
+		return
+	}
+	j.running = false
+	j.mu.Unlock()
+
+	close(j.stopChan)
+	j.wg.Wait()
+
+	j.fileMu.Lock()
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	j.fileMu.Unlock()
+	log.Println("Reading journal stopped")
+}
+
+// runReplay replays the journal when it has a backlog
+func (j *ReadingJournal) runReplay() {
+	defer func() {
+		if r := recover(); r != nil {
+			utils.LogError("PANIC in reading journal replay: %v", r)
+			log.Printf("PANIC in reading journal replay: %v", r)
+		}
+	}()
+
+	if j.Backlog() == 0 {
+		return
+	}
+	replayed, remaining, err := j.Replay()
+	if err != nil {
+		log.Printf("Reading journal replay stopped (%d replayed, %d waiting): %v", replayed, remaining, err)
+		return
+	}
+	log.Printf("Reading journal replayed %d readings into temp_log", replayed)
+}
+
+// Replay inserts the journal into temp_log in the order it was written. Readings already in
+// temp_log count as replayed. It stops when the database is unreachable and keeps that
+// reading and everything after it for the next attempt; a reading the database rejects is
+// kept and skipped, and moved to the dead-letter file after maxJournalAttempts replays.
+func (j *ReadingJournal) Replay() (replayed, remaining int, err error) {
+	replayed, remaining, oldest, newest, err := j.replayFile()
+
+	// Rollups only look back a few hours; re-aggregate the hours the outage covered
+	if replayed > 0 && GlobalRollupService != nil {
+		if err := GlobalRollupService.Rebuild(oldest, newest.Truncate(time.Hour).Add(time.Hour)); err != nil {
+			utils.LogError("ReadingJournal - Failed to rebuild rollups for %v - %v: %v", oldest, newest, err)
+		}
+	}
+	return replayed, remaining, err
+}
+
+// replayFile inserts the journal entries and rewrites the file with the ones left over.
+// oldest and newest are the insert_time range of the replayed readings. The file is only
+// locked while it is read and rewritten, so readings keep being appended during the inserts.
+func (j *ReadingJournal) replayFile() (replayed, remaining int, oldest, newest time.Time, err error) {
+	j.replayMu.Lock()
+	defer j.replayMu.Unlock()
+
+	j.fileMu.Lock()
+	entries, end, err := j.load(0)
+	j.fileMu.Unlock()
+	if err != nil {
+		return 0, j.Backlog(), oldest, newest, err
+	}
+
+	var keep, dead []journalEntry
+	for i, entry := range entries {
+		tempLog := entry.TempLog
+		if insertErr := j.readings.Insert(&tempLog); insertErr != nil && !errors.Is(insertErr, repository.ErrDuplicate) {
+			if errors.Is(insertErr, repository.ErrUnavailable) {
+				keep = append(keep, entries[i:]...)
+				err = insertErr
+				break
+			}
+			entry.Attempts++
+			utils.LogError("ReadingJournal - Replay rejected (ip=%s, probe=%d, insert_time=%v, attempt %d of %d): %v",
+				tempLog.MachineIP, tempLog.ProbeNo, tempLog.InsertTime, entry.Attempts, maxJournalAttempts, insertErr)
+			if entry.Attempts >= maxJournalAttempts {
+				dead = append(dead, entry)
+			} else {
+				keep = append(keep, entry)
+			}
+			continue
+		}
+		if err := j.readings.UpdateLatest(tempLog); err != nil {
+			utils.LogError("ReadingJournal - Failed to update temp_latest (ip=%s, probe=%d): %v", tempLog.MachineIP, tempLog.ProbeNo, err)
+		}
+		if oldest.IsZero() || tempLog.InsertTime.Before(oldest) {
+			oldest = tempLog.InsertTime
+		}
+		if tempLog.InsertTime.After(newest) {
+			newest = tempLog.InsertTime
+		}
+		replayed++
+	}
+
+	// Dead letters are on disk before they leave the journal; if that fails they stay in it
+	if len(dead) > 0 {
+		if deadErr := appendEntries(j.deadPath, dead); deadErr != nil {
+			utils.LogError("ReadingJournal - Failed to write %s: %v", j.deadPath, deadErr)
+			keep = append(keep, dead...)
+		} else {
+			utils.LogError("ReadingJournal - Moved %d readings rejected %d times to %s", len(dead), maxJournalAttempts, j.deadPath)
+		}
+	}
+
+	j.fileMu.Lock()
+	defer j.fileMu.Unlock()
+
+	// Windows cannot replace a file that is still open
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	// Readings appended during the inserts follow the ones kept, so the order is unchanged
+	appended, _, loadErr := j.load(end)
+	if loadErr != nil {
+		// Leave the file as it is; the replayed entries are skipped as duplicates next time
+		utils.LogError("ReadingJournal - Failed to read %s: %v", j.path, loadErr)
+		return replayed, j.Backlog(), oldest, newest, err
+	}
+	keep = append(keep, appended...)
+
+	remaining = len(keep)
+	if rewriteErr := j.rewrite(keep); rewriteErr != nil {
+		// The replayed entries stay in the file and are skipped as duplicates next time
+		utils.LogError("ReadingJournal - Failed to rewrite %s: %v", j.path, rewriteErr)
+	} else {
+		j.backlog.Store(int64(remaining))
+	}
+	return replayed, remaining, oldest, newest, err
+}
+
+// load reads every complete entry of the journal file from byte offset on and returns them
+// with the offset of the end of the file. Lines cut short by a crash are skipped.
+func (j *ReadingJournal) load(offset int64) ([]journalEntry, int64, error) {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, offset, nil
+	}
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+
+	entries := make([]journalEntry, 0)
+	reader := bufio.NewReader(f)
+	end := offset
+	for {
+		start := end
+		line, err := reader.ReadBytes('\n')
+		end += int64(len(line))
+		if len(line) > 0 && !(len(line) == 1 && line[0] == '\n') {
+			var entry journalEntry
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				utils.LogError("ReadingJournal - Skipping unreadable entry at byte %d of %s: %v", start, j.path, jsonErr)
+			} else {
+				entries = append(entries, entry)
+			}
+		}
+		if err == io.EOF {
+			return entries, end, nil
+		}
+		if err != nil {
+			return entries, end, err
+		}
+	}
+}
+
+// rewrite replaces the journal with entries, removing it when nothing is left
+func (j *ReadingJournal) rewrite(entries []journalEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	tmpPath := j.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := writeEntries(f, entries); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, j.path)
+}
+
+// appendEntries adds entries to the end of the file at path, creating it if needed
+func appendEntries(path string, entries []journalEntry) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := writeEntries(f, entries); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeEntries writes entries as JSON lines and syncs f
+func writeEntries(f *os.File, entries []journalEntry) error {
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		line, _ := json.Marshal(entry)
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
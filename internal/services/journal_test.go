@@ -0,0 +1,377 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// flakyReadings records inserts in order and fails any insert fail returns an error for
+type flakyReadings struct {
+	repository.ReadingRepo
+	fail     func(models.TempLog) error
+	inserted []time.Time
+}
+
+func (r *flakyReadings) Insert(log *models.TempLog) error {
+	if r.fail != nil {
+		if err := r.fail(*log); err != nil {
+			return err
+		}
+	}
+	r.inserted = append(r.inserted, log.InsertTime)
+	return r.ReadingRepo.Insert(log)
+}
+
+var (
+	errDown     = fmt.Errorf("%w: dial tcp: connection refused", repository.ErrUnavailable)
+	errRejected = errors.New("Error 1406: Data too long for column 'mcu_id'")
+)
+
+// newTestJournal opens a journal in a temporary directory, which is also the working
+// directory so utils.LogError writes there
+func newTestJournal(t *testing.T, readings *flakyReadings) *ReadingJournal {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("READING_JOURNAL_PATH", filepath.Join(dir, "reading_journal.jsonl"))
+	return NewReadingJournal(readings)
+}
+
+// reading is a temp_log row of 10.0.0.1 probe 1, minute minutes past 08:00
+func reading(minute int) models.TempLog {
+	value := float64(minute)
+	return models.TempLog{
+		MachineIP:  "10.0.0.1",
+		ProbeNo:    1,
+		TempValue:  &value,
+		InsertTime: time.Date(2025, 1, 6, 8, minute, 0, 0, time.UTC),
+	}
+}
+
+// minutes lists the minute past 08:00 of each insert time
+func minutes(times []time.Time) []int {
+	result := make([]int, len(times))
+	for i, t := range times {
+		result[i] = t.Minute()
+	}
+	return result
+}
+
+// fileEntries reads the journal lines of path
+func fileEntries(t *testing.T, path string) []journalEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: %v in %q", path, err, scanner.Text())
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestJournalAppendAndReplayInOrder(t *testing.T) {
+	readings := &flakyReadings{ReadingRepo: repository.NewMemory().Set().Readings}
+	j := newTestJournal(t, readings)
+
+	for _, minute := range []int{5, 1, 3} {
+		if err := j.Append(reading(minute)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if j.Backlog() != 3 {
+		t.Errorf("backlog = %d, want 3", j.Backlog())
+	}
+	j.Stop()
+
+	// A restart finds the same entries
+	restarted := NewReadingJournal(readings)
+	if restarted.Backlog() != 3 {
+		t.Fatalf("backlog after restart = %d, want 3", restarted.Backlog())
+	}
+	replayed, remaining, err := restarted.Replay()
+	if err != nil || replayed != 3 || remaining != 0 {
+		t.Fatalf("replay = %d, %d, %v; want 3, 0, nil", replayed, remaining, err)
+	}
+	if got := fmt.Sprint(minutes(readings.inserted)); got != "[5 1 3]" {
+		t.Errorf("replayed minutes %s, want the append order [5 1 3]", got)
+	}
+	if _, err := os.Stat(restarted.path); !os.IsNotExist(err) {
+		t.Errorf("journal file left after a full replay: %v", err)
+	}
+	if restarted.Backlog() != 0 {
+		t.Errorf("backlog = %d, want 0", restarted.Backlog())
+	}
+
+	// Replaying again after a crash that kept the file only meets duplicates
+	if err := restarted.Append(reading(1)); err != nil {
+		t.Fatal(err)
+	}
+	if replayed, remaining, err := restarted.Replay(); err != nil || replayed != 1 || remaining != 0 {
+		t.Errorf("replay of an already stored reading = %d, %d, %v; want 1, 0, nil", replayed, remaining, err)
+	}
+}
+
+func TestJournalTruncatedLastLine(t *testing.T) {
+	readings := &flakyReadings{ReadingRepo: repository.NewMemory().Set().Readings}
+	j := newTestJournal(t, readings)
+	for _, minute := range []int{1, 2} {
+		if err := j.Append(reading(minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Stop()
+
+	// A crash cut the third entry short
+	line, _ := json.Marshal(journalEntry{TempLog: reading(3)})
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(line[:len(line)/2])
+	f.Close()
+
+	restarted := NewReadingJournal(readings)
+	if restarted.Backlog() != 2 {
+		t.Errorf("backlog = %d, want the 2 complete entries", restarted.Backlog())
+	}
+	if err := restarted.Append(reading(4)); err != nil {
+		t.Fatal(err)
+	}
+	replayed, remaining, err := restarted.Replay()
+	if err != nil || replayed != 3 || remaining != 0 {
+		t.Fatalf("replay = %d, %d, %v; want 3, 0, nil", replayed, remaining, err)
+	}
+	if got := fmt.Sprint(minutes(readings.inserted)); got != "[1 2 4]" {
+		t.Errorf("replayed minutes %s, want [1 2 4]", got)
+	}
+}
+
+func TestJournalReplayStopsWhileUnavailable(t *testing.T) {
+	readings := &flakyReadings{ReadingRepo: repository.NewMemory().Set().Readings}
+	j := newTestJournal(t, readings)
+	for minute := 1; minute <= 4; minute++ {
+		if err := j.Append(reading(minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The database goes away after two inserts
+	readings.fail = func(log models.TempLog) error {
+		if log.InsertTime.Minute() >= 3 {
+			return errDown
+		}
+		return nil
+	}
+	replayed, remaining, err := j.Replay()
+	if !errors.Is(err, repository.ErrUnavailable) || replayed != 2 || remaining != 2 {
+		t.Fatalf("replay = %d, %d, %v; want 2, 2, ErrUnavailable", replayed, remaining, err)
+	}
+
+	// The file was rewritten with only the entries still waiting, and appends continue after them
+	if got := fileEntries(t, j.path); len(got) != 2 || got[0].InsertTime.Minute() != 3 || got[1].InsertTime.Minute() != 4 {
+		t.Fatalf("journal after partial replay = %+v, want minutes 3 and 4", got)
+	}
+	if got := fileEntries(t, j.path); got[0].Attempts != 0 {
+		t.Errorf("an unreachable database counted as attempt %d", got[0].Attempts)
+	}
+	if err := j.Append(reading(5)); err != nil {
+		t.Fatal(err)
+	}
+	if j.Backlog() != 3 {
+		t.Errorf("backlog = %d, want 3", j.Backlog())
+	}
+
+	readings.fail = nil
+	if replayed, remaining, err := j.Replay(); err != nil || replayed != 3 || remaining != 0 {
+		t.Fatalf("replay = %d, %d, %v; want 3, 0, nil", replayed, remaining, err)
+	}
+	if got := fmt.Sprint(minutes(readings.inserted)); got != "[1 2 3 4 5]" {
+		t.Errorf("replayed minutes %s, want [1 2 3 4 5]", got)
+	}
+}
+
+func TestJournalAppendDuringReplay(t *testing.T) {
+	readings := &flakyReadings{ReadingRepo: repository.NewMemory().Set().Readings}
+	j := newTestJournal(t, readings)
+	for minute := 1; minute <= 3; minute++ {
+		if err := j.Append(reading(minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A poll stores a reading while the first entry is being inserted, and the database
+	// goes away before the second one
+	readings.fail = func(log models.TempLog) error {
+		switch log.InsertTime.Minute() {
+		case 1:
+			done := make(chan error, 1)
+			go func() { done <- j.Append(reading(9)) }()
+			select {
+			case err := <-done:
+				return err
+			case <-time.After(5 * time.Second):
+				t.Fatal("Append blocked while the journal was being replayed")
+			}
+		case 2:
+			return errDown
+		}
+		return nil
+	}
+	replayed, remaining, err := j.Replay()
+	if !errors.Is(err, repository.ErrUnavailable) || replayed != 1 || remaining != 3 {
+		t.Fatalf("replay = %d, %d, %v; want 1, 3, ErrUnavailable", replayed, remaining, err)
+	}
+
+	// The reading appended meanwhile follows the ones still waiting
+	got := fileEntries(t, j.path)
+	if len(got) != 3 || got[0].InsertTime.Minute() != 2 || got[1].InsertTime.Minute() != 3 || got[2].InsertTime.Minute() != 9 {
+		t.Fatalf("journal after replay = %+v, want minutes 2, 3 and 9", got)
+	}
+	if j.Backlog() != 3 {
+		t.Errorf("backlog = %d, want 3", j.Backlog())
+	}
+
+	readings.fail = nil
+	if replayed, remaining, err := j.Replay(); err != nil || replayed != 3 || remaining != 0 {
+		t.Fatalf("replay = %d, %d, %v; want 3, 0, nil", replayed, remaining, err)
+	}
+	if got := fmt.Sprint(minutes(readings.inserted)); got != "[1 2 3 9]" {
+		t.Errorf("replayed minutes %s, want [1 2 3 9]", got)
+	}
+}
+
+func TestJournalDeadLetter(t *testing.T) {
+	readings := &flakyReadings{ReadingRepo: repository.NewMemory().Set().Readings}
+	j := newTestJournal(t, readings)
+	for minute := 1; minute <= 3; minute++ {
+		if err := j.Append(reading(minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The database rejects the second reading outright; it must not hold up the third
+	readings.fail = func(log models.TempLog) error {
+		if log.InsertTime.Minute() == 2 {
+			return errRejected
+		}
+		return nil
+	}
+	replayed, remaining, err := j.Replay()
+	if err != nil || replayed != 2 || remaining != 1 {
+		t.Fatalf("replay = %d, %d, %v; want 2, 1, nil", replayed, remaining, err)
+	}
+	if got := fileEntries(t, j.path); len(got) != 1 || got[0].Attempts != 1 {
+		t.Fatalf("journal = %+v, want the rejected reading with 1 attempt", got)
+	}
+
+	for attempt := 2; attempt <= maxJournalAttempts; attempt++ {
+		if _, _, err := j.Replay(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if j.Backlog() != 0 {
+		t.Errorf("backlog = %d after %d rejections, want 0", j.Backlog(), maxJournalAttempts)
+	}
+	if _, err := os.Stat(j.path); !os.IsNotExist(err) {
+		t.Errorf("journal file left after dead-lettering: %v", err)
+	}
+	dead := fileEntries(t, filepath.Join(filepath.Dir(j.path), "reading_journal_failed.jsonl"))
+	if len(dead) != 1 || dead[0].InsertTime.Minute() != 2 || dead[0].Attempts != maxJournalAttempts {
+		t.Errorf("dead letters = %+v, want minute 2 after %d attempts", dead, maxJournalAttempts)
+	}
+}
+
+// failingDevices serves master_machine until down is set
+type failingDevices struct {
+	repository.DeviceRepo
+	down bool
+}
+
+func (r *failingDevices) List() ([]models.MasterMachine, error) {
+	if r.down {
+		return nil, errDown
+	}
+	return r.DeviceRepo.List()
+}
+
+func (r *failingDevices) ListByIP(ip string) ([]models.MasterMachine, error) {
+	if r.down {
+		return nil, errDown
+	}
+	return r.DeviceRepo.ListByIP(ip)
+}
+
+func TestReadingsJournaledWhileDatabaseDown(t *testing.T) {
+	repos := repository.NewMemory().Set()
+	fridge(t, repos, "10.9.0.3")
+	devices := &failingDevices{DeviceRepo: repos.Devices}
+	readings := &flakyReadings{ReadingRepo: repos.Readings}
+	repos.Devices, repos.Readings = devices, readings
+	j := newTestJournal(t, readings)
+	p := NewPollingService(nil, repos, j)
+	at := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+
+	// Nothing has been loaded yet, so a reading cannot be matched to its device
+	devices.down = true
+	readings.fail = func(models.TempLog) error { return errDown }
+	if err := p.IngestReading(Reading{MachineIP: "10.9.0.3", TempValue: 5, InsertTime: at}); err == nil {
+		t.Error("ingest before any device load succeeded")
+	}
+
+	devices.down = false
+	if machines, err := p.loadDevices(); err != nil || len(machines) != 2 {
+		t.Fatalf("load = %d probes, %v", len(machines), err)
+	}
+
+	// MySQL goes down: the last device list is used and readings are journaled
+	devices.down = true
+	machines, err := p.loadDevices()
+	if !errors.Is(err, repository.ErrUnavailable) || len(machines) != 2 {
+		t.Errorf("load while down = %d probes, %v; want the 2 loaded last and the error", len(machines), err)
+	}
+	if err := p.IngestReading(Reading{MachineIP: "10.9.0.3", ProbeNo: 2, TempValue: 5, InsertTime: at}); err != nil {
+		t.Errorf("ingest while down: %v", err)
+	}
+	if err := p.IngestReading(Reading{MachineIP: "10.9.0.99", TempValue: 5, InsertTime: at}); err == nil || errors.Is(err, ErrUnknownMachine) {
+		t.Errorf("ingest from a device not loaded while down: err = %v, want the load error", err)
+	}
+	if j.Backlog() != 1 {
+		t.Errorf("backlog = %d, want 1", j.Backlog())
+	}
+
+	// A reading the database rejects is not journaled; it would only be rejected again
+	readings.fail = func(models.TempLog) error { return errRejected }
+	probe, _ := devices.DeviceRepo.Get("10.9.0.3", 1)
+	if got := p.processReading(probe, Reading{MachineIP: "10.9.0.3", ProbeNo: 1, TempValue: 5, InsertTime: at.Add(time.Minute)}); got != readingFailed {
+		t.Errorf("rejected insert: outcome = %v, want failed", got)
+	}
+	if j.Backlog() != 1 {
+		t.Errorf("backlog = %d after a rejected insert, want 1", j.Backlog())
+	}
+
+	devices.down, readings.fail = false, nil
+	if replayed, _, err := j.Replay(); err != nil || replayed != 1 {
+		t.Errorf("replay = %d, %v; want 1, nil", replayed, err)
+	}
+}
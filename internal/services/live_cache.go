@@ -0,0 +1,141 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"tms-backend/internal/models"
+)
+
+// DeviceOfflineAfter is how long a device may go without answering or pushing before it is reported offline
+const DeviceOfflineAfter = 10 * time.Minute
+
+// LiveReading is the latest value of one probe as seen by the acquisition loop.
+// Value, ReadAt and AgeSeconds are nil for a probe that has not reported since startup.
+type LiveReading struct {
+	MachineIP    string     `json:"machineIp"`
+	ProbeNo      int        `json:"probeNo"`
+	MachineName  string     `json:"machineName"`
+	SType        string     `json:"sType"`
+	Unit         string     `json:"unit"`
+	Value        *float64   `json:"value"`
+	Status       string     `json:"status"` // N=Normal, H=High, L=Low, empty without a reading
+	MinTemp      float64    `json:"minTemp"`
+	MaxTemp      float64    `json:"maxTemp"`
+	Source       string     `json:"source"`
+	ReadAt       *time.Time `json:"readAt"`
+	AgeSeconds   *float64   `json:"ageSeconds"`
+	DeviceOnline bool       `json:"deviceOnline"`
+	LastSeen     *time.Time `json:"lastSeen"`
+}
+
+// LiveCache holds the latest reading per probe in memory
+type LiveCache struct {
+	mu       sync.RWMutex
+	readings map[string]LiveReading // key: "ip:probeNo"
+	lastSeen map[string]time.Time   // key: ip; last successful TCP answer or push
+}
+
+// NewLiveCache creates an empty live cache
+func NewLiveCache() *LiveCache {
+	return &LiveCache{
+		readings: make(map[string]LiveReading),
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// Update stores the latest value for a probe and marks its device as seen
+func (lc *LiveCache) Update(machine models.MasterMachine, probeNo int, value float64, status, source string, readAt time.Time) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	key := fmt.Sprintf("%s:%d", machine.MachineIP, probeNo)
+	if prev, ok := lc.readings[key]; ok && prev.ReadAt != nil && prev.ReadAt.After(readAt) {
+		return
+	}
+
+	r := newLiveReading(machine, probeNo)
+	r.Value = &value
+	r.Status = status
+	r.Source = source
+	r.ReadAt = &readAt
+	lc.readings[key] = r
+
+	lc.markSeen(machine.MachineIP, readAt)
+}
+
+// Seed adds every master_machine probe that has not reported yet, so devices that stay
+// offline from startup are listed (as offline) too
+func (lc *LiveCache) Seed(machines []models.MasterMachine) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	for _, machine := range machines {
+		key := fmt.Sprintf("%s:%d", machine.MachineIP, machine.ProbeNo)
+		if prev, ok := lc.readings[key]; ok && prev.ReadAt != nil {
+			continue
+		}
+		lc.readings[key] = newLiveReading(machine, machine.ProbeNo)
+	}
+}
+
+// newLiveReading returns the probe's settings without a reading
+func newLiveReading(machine models.MasterMachine, probeNo int) LiveReading {
+	sType := machine.SType
+	if sType == "" {
+		sType = "t"
+	}
+	return LiveReading{
+		MachineIP:   machine.MachineIP,
+		ProbeNo:     probeNo,
+		MachineName: machine.MachineName,
+		SType:       sType,
+		Unit:        machine.GetUnit(),
+		MinTemp:     machine.GetMinTemp(),
+		MaxTemp:     machine.GetMaxTemp(),
+	}
+}
+
+// MarkDeviceSeen records that a device answered a TCP request
+func (lc *LiveCache) MarkDeviceSeen(ip string, at time.Time) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.markSeen(ip, at)
+}
+
+// markSeen must be called with mu held
+func (lc *LiveCache) markSeen(ip string, at time.Time) {
+	if at.After(lc.lastSeen[ip]) {
+		lc.lastSeen[ip] = at
+	}
+}
+
+// Snapshot returns all cached readings with age and connectivity computed at now,
+// sorted by IP and probe number
+func (lc *LiveCache) Snapshot(now time.Time) []LiveReading {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	result := make([]LiveReading, 0, len(lc.readings))
+	for _, r := range lc.readings {
+		if r.ReadAt != nil {
+			age := now.Sub(*r.ReadAt).Seconds()
+			r.AgeSeconds = &age
+		}
+		if lastSeen, ok := lc.lastSeen[r.MachineIP]; ok {
+			r.LastSeen = &lastSeen
+			r.DeviceOnline = now.Sub(lastSeen) < DeviceOfflineAfter
+		}
+		result = append(result, r)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].MachineIP != result[j].MachineIP {
+			return result[i].MachineIP < result[j].MachineIP
+		}
+		return result[i].ProbeNo < result[j].ProbeNo
+	})
+	return result
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"tms-backend/internal/models"
+)
+
+func TestLiveCacheSeedListsOfflineDevices(t *testing.T) {
+	lc := NewLiveCache()
+	now := time.Date(2025, 1, 31, 8, 0, 0, 0, time.UTC)
+	online := models.MasterMachine{MachineIP: "10.0.0.1", ProbeNo: 1, MachineName: "Fridge 1"}
+	offline := models.MasterMachine{MachineIP: "10.0.0.2", ProbeNo: 1, MachineName: "Fridge 2"}
+
+	lc.Update(online, 1, 4.5, "N", ReadingSourceTCP, now.Add(-time.Minute))
+	lc.Seed([]models.MasterMachine{online, offline})
+
+	snapshot := lc.Snapshot(now)
+	if len(snapshot) != 2 {
+		t.Fatalf("got %d probes, want 2", len(snapshot))
+	}
+
+	got := snapshot[0]
+	if got.Value == nil || *got.Value != 4.5 || !got.DeviceOnline || got.AgeSeconds == nil || *got.AgeSeconds != 60 {
+		t.Errorf("seeding overwrote the reported probe: %+v", got)
+	}
+
+	got = snapshot[1]
+	if got.MachineName != "Fridge 2" || got.Value != nil || got.ReadAt != nil || got.AgeSeconds != nil || got.DeviceOnline {
+		t.Errorf("unreported probe = %+v, want offline without a value", got)
+	}
+
+	// The first reading replaces the seeded entry
+	lc.Update(offline, 1, 5, "N", ReadingSourceTCP, now)
+	if got := lc.Snapshot(now)[1]; got.Value == nil || *got.Value != 5 || !got.DeviceOnline {
+		t.Errorf("reading after seed = %+v", got)
+	}
+}
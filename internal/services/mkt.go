@@ -0,0 +1,310 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/utils"
+)
+
+// Mean Kinetic Temperature defaults (USP <1079>: ΔH = 83.144 kJ/mol, controlled room temperature limit 25 °C)
+const (
+	DefaultMKTActivationEnergy = 83.144 // kJ/mol
+	DefaultMKTLimit            = 25.0   // °C
+	MKTRollingDays             = 30
+
+	gasConstant = 8.3144598e-3 // kJ/(mol·K)
+	kelvin      = 273.15
+)
+
+// config_value keys for MKT settings; per-probe limits use mktLimitKeyPrefix + "ip:probe".
+// mktAlertKeyPrefix + "ip:probe" is "1" while the probe's 30-day MKT is over its limit.
+const (
+	mktActivationEnergyKey = "mkt_activation_energy"
+	mktLimitKey            = "mkt_limit"
+	mktLimitKeyPrefix      = "mkt_limit:"
+	mktAlertKeyPrefix      = "mkt_alert:"
+)
+
+// mktConfigKeys are the fixed MKT keys; per-probe limits are selected by mktLimitKeyPrefix
+var mktConfigKeys = []string{mktActivationEnergyKey, mktLimitKey}
+
+// MKTSettings are the activation energy and limits used for MKT, stored in config_value
+type MKTSettings struct {
+	ActivationEnergy float64            `json:"activationEnergy"` // kJ/mol
+	Limit            float64            `json:"limit"`            // °C, default for every probe
+	ProbeLimits      map[string]float64 `json:"probeLimits"`      // "ip:probe" -> °C
+}
+
+// LimitFor returns the MKT limit of one probe
+func (s MKTSettings) LimitFor(machineIP string, probeNo int) float64 {
+	if limit, ok := s.ProbeLimits[fmt.Sprintf("%s:%d", machineIP, probeNo)]; ok {
+		return limit
+	}
+	return s.Limit
+}
+
+// LoadMKTSettings reads MKT settings from config_value, falling back to the defaults
+func LoadMKTSettings(config repository.ConfigRepo) (MKTSettings, error) {
+	settings := MKTSettings{
+		ActivationEnergy: DefaultMKTActivationEnergy,
+		Limit:            DefaultMKTLimit,
+		ProbeLimits:      make(map[string]float64),
+	}
+
+	values, err := config.List(mktConfigKeys, mktLimitKeyPrefix)
+	if err != nil {
+		return settings, err
+	}
+
+	for _, v := range values {
+		if v.ConfigValue == nil {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(*v.ConfigValue), 64)
+		if err != nil {
+			utils.LogError("LoadMKTSettings - Ignoring %s=%q: %v", v.ConfigKey, *v.ConfigValue, err)
+			continue
+		}
+		switch {
+		case v.ConfigKey == mktActivationEnergyKey:
+			settings.ActivationEnergy = f
+		case v.ConfigKey == mktLimitKey:
+			settings.Limit = f
+		case strings.HasPrefix(v.ConfigKey, mktLimitKeyPrefix):
+			settings.ProbeLimits[strings.TrimPrefix(v.ConfigKey, mktLimitKeyPrefix)] = f
+		}
+	}
+	return settings, nil
+}
+
+// SaveMKTSettings replaces the MKT settings in config_value, writing audit in the same transaction
+func SaveMKTSettings(config repository.ConfigRepo, settings MKTSettings, audit []models.AuditLog) error {
+	values := []models.ConfigValue{
+		{ConfigKey: mktActivationEnergyKey, ConfigValue: formatConfigFloat(settings.ActivationEnergy)},
+		{ConfigKey: mktLimitKey, ConfigValue: formatConfigFloat(settings.Limit)},
+	}
+	for probe, limit := range settings.ProbeLimits {
+		values = append(values, models.ConfigValue{ConfigKey: mktLimitKeyPrefix + probe, ConfigValue: formatConfigFloat(limit)})
+	}
+	return config.Replace(mktConfigKeys, mktLimitKeyPrefix, values, audit)
+}
+
+func formatConfigFloat(f float64) *string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	return &s
+}
+
+// MKTWindow is the MKT of one probe over [From, To)
+type MKTWindow struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Readings int       `json:"readings"`
+	MKT      *float64  `json:"mkt"` // °C, nil without readings
+	Exceeded bool      `json:"exceeded"`
+}
+
+// CalculateMKT computes the Mean Kinetic Temperature of a probe over [from, to) in SQL:
+// MKT = (ΔH/R) / -ln(Σ exp(-ΔH/(R·Tᵢ)) / n), with Tᵢ in kelvin
+func CalculateMKT(db *gorm.DB, machineIP string, probeNo int, from, to time.Time, activationEnergy, limit float64) (MKTWindow, error) {
+	window := MKTWindow{From: from, To: to}
+	ratio := activationEnergy / gasConstant
+
+	var row struct {
+		Readings int      `gorm:"column:readings"`
+		SumExp   *float64 `gorm:"column:sum_exp"`
+	}
+	if err := db.Model(&models.TempLog{}).
+		Select("COUNT(temp_value) AS readings, SUM(EXP(? / (temp_value + ?))) AS sum_exp", -ratio, kelvin).
+		Where("machine_ip = ? AND probe_no = ? AND insert_time >= ? AND insert_time < ? AND temp_value > ?",
+			machineIP, probeNo, from, to, -kelvin).
+		Scan(&row).Error; err != nil {
+		return window, err
+	}
+
+	window.Readings = row.Readings
+	if row.Readings > 0 && row.SumExp != nil && *row.SumExp > 0 {
+		mkt := ratio/-math.Log(*row.SumExp/float64(row.Readings)) - kelvin
+		window.MKT = &mkt
+		window.Exceeded = mkt > limit
+	}
+	return window, nil
+}
+
+// ProbeMKT is the rolling 30-day and calendar-month MKT of one temperature probe
+type ProbeMKT struct {
+	MachineIP        string    `json:"machineIp"`
+	ProbeNo          int       `json:"probeNo"`
+	MachineName      string    `json:"machineName"`
+	ActivationEnergy float64   `json:"activationEnergy"`
+	Limit            float64   `json:"limit"`
+	Rolling30Day     MKTWindow `json:"rolling30Day"`
+	Month            MKTWindow `json:"month"`
+}
+
+// CalculateProbeMKT returns the MKT over the 30 days before at and over the calendar month
+// starting at month (up to at when the month is still running)
+func CalculateProbeMKT(db *gorm.DB, machine models.MasterMachine, settings MKTSettings, at, month time.Time) (ProbeMKT, error) {
+	result := ProbeMKT{
+		MachineIP:        machine.MachineIP,
+		ProbeNo:          machine.ProbeNo,
+		MachineName:      machine.MachineName,
+		ActivationEnergy: settings.ActivationEnergy,
+		Limit:            settings.LimitFor(machine.MachineIP, machine.ProbeNo),
+	}
+
+	var err error
+	result.Rolling30Day, err = CalculateMKT(db, machine.MachineIP, machine.ProbeNo,
+		at.AddDate(0, 0, -MKTRollingDays), at, result.ActivationEnergy, result.Limit)
+	if err != nil {
+		return result, err
+	}
+
+	monthEnd := month.AddDate(0, 1, 0)
+	if monthEnd.After(at) {
+		monthEnd = at
+	}
+	result.Month, err = CalculateMKT(db, machine.MachineIP, machine.ProbeNo, month, monthEnd, result.ActivationEnergy, result.Limit)
+	return result, err
+}
+
+// mktAlertStates remembers which probes are over their MKT limit, keyed by "ip:probeNo".
+// It is loaded from config_value on the first check so a restart neither repeats an alert
+// nor misses the return to normal of a probe that recovered while the backend was down.
+var mktAlertStates map[string]bool
+var mktAlertStatesMu sync.Mutex
+
+// loadMKTAlertStates reads the persisted alert states once
+func (p *PollingService) loadMKTAlertStates() error {
+	mktAlertStatesMu.Lock()
+	defer mktAlertStatesMu.Unlock()
+	if mktAlertStates != nil {
+		return nil
+	}
+
+	values, err := p.repos.Config.List(nil, mktAlertKeyPrefix)
+	if err != nil {
+		return err
+	}
+	states := make(map[string]bool, len(values))
+	for _, v := range values {
+		states[strings.TrimPrefix(v.ConfigKey, mktAlertKeyPrefix)] = v.ConfigValue != nil && *v.ConfigValue == "1"
+	}
+	mktAlertStates = states
+	return nil
+}
+
+// saveMKTAlertState records a probe's alert state in memory and in config_value
+func (p *PollingService) saveMKTAlertState(key string, exceeded bool) {
+	mktAlertStatesMu.Lock()
+	mktAlertStates[key] = exceeded
+	mktAlertStatesMu.Unlock()
+
+	value := "0"
+	if exceeded {
+		value = "1"
+	}
+	if err := p.repos.Config.Set(mktAlertKeyPrefix+key, value); err != nil {
+		utils.LogError("checkMKT - Failed to save MKT alert state of %s: %v", key, err)
+	}
+}
+
+// checkMKT recomputes the rolling 30-day MKT of every temperature probe and sends an alert
+// when a probe goes over (or back under) its limit
+func (p *PollingService) checkMKT() {
+	defer func() {
+		if r := recover(); r != nil {
+			utils.LogError("PANIC in checkMKT: %v", r)
+			log.Printf("PANIC in checkMKT: %v", r)
+		}
+	}()
+
+	// Without the configured limits every probe would be judged against the defaults
+	settings, err := LoadMKTSettings(p.repos.Config)
+	if err != nil {
+		utils.LogError("checkMKT - Failed to load MKT settings: %v", err)
+		return
+	}
+	if err := p.loadMKTAlertStates(); err != nil {
+		utils.LogError("checkMKT - Failed to load MKT alert states: %v", err)
+		return
+	}
+
+	machines, err := p.repos.Devices.List()
+	if err != nil {
+		utils.LogError("checkMKT - Failed to load machines: %v", err)
+		return
+	}
+
+	now := database.SiteNow()
+	for _, machine := range machines {
+		if !machine.IsTemperatureType() {
+			continue
+		}
+		limit := settings.LimitFor(machine.MachineIP, machine.ProbeNo)
+		window, err := CalculateMKT(p.db, machine.MachineIP, machine.ProbeNo, now.AddDate(0, 0, -MKTRollingDays), now,
+			settings.ActivationEnergy, limit)
+		if err != nil {
+			utils.LogError("checkMKT - Failed to calculate MKT (machine=%s, probe=%d): %v", machine.MachineIP, machine.ProbeNo, err)
+			continue
+		}
+		if window.MKT == nil {
+			continue
+		}
+
+		key := fmt.Sprintf("%s:%d", machine.MachineIP, machine.ProbeNo)
+		mktAlertStatesMu.Lock()
+		wasExceeded := mktAlertStates[key]
+		mktAlertStatesMu.Unlock()
+		if window.Exceeded == wasExceeded {
+			continue
+		}
+		p.saveMKTAlertState(key, window.Exceeded)
+
+		var message, alertType, status string
+		if window.Exceeded {
+			message = fmt.Sprintf("MKT %d วันเกินเกณฑ์ (MKT: %.2f°C, เกณฑ์: %.2f°C) %s(%d)",
+				MKTRollingDays, *window.MKT, limit, machine.MachineName, machine.ProbeNo)
+			alertType, status = "mkt", "00000010"
+			log.Printf("ALERT: %s Probe %d - %d-day MKT %.2f°C exceeds limit %.2f°C",
+				machine.MachineName, machine.ProbeNo, MKTRollingDays, *window.MKT, limit)
+		} else {
+			message = fmt.Sprintf("MKT %d วันกลับเข้าเกณฑ์แล้ว (MKT: %.2f°C, เกณฑ์: %.2f°C) %s(%d)",
+				MKTRollingDays, *window.MKT, limit, machine.MachineName, machine.ProbeNo)
+			alertType, status = "mkt_normal", "00000001"
+			log.Printf("NORMAL: %s Probe %d - %d-day MKT %.2f°C back within limit %.2f°C",
+				machine.MachineName, machine.ProbeNo, MKTRollingDays, *window.MKT, limit)
+		}
+
+		if !p.apiNotificationService.IsLegacyAPIEnabled() {
+			continue
+		}
+		payload := AlertPayload{
+			McuID:       machine.MachineName,
+			Status:      status,
+			TempValue:   *window.MKT,
+			RealValue:   int(*window.MKT * 100),
+			Date:        now.Format("20060102"),
+			Time:        now.Format("15:04:05"),
+			Message:     message,
+			AlertType:   alertType,
+			MachineName: machine.MachineName,
+			ProbeNo:     machine.ProbeNo,
+			MinTemp:     machine.GetMinTemp(),
+			MaxTemp:     limit,
+		}
+		if err := p.apiNotificationService.SendAlert(payload); err != nil {
+			utils.LogError("checkMKT - Failed to send MKT alert (machine=%s, probe=%d): %v", machine.MachineName, machine.ProbeNo, err)
+		}
+	}
+}
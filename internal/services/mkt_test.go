@@ -0,0 +1,33 @@
+package services
+
+import (
+	"testing"
+
+	"tms-backend/internal/repository"
+)
+
+func TestMKTAlertStatesSurviveRestart(t *testing.T) {
+	repos := repository.NewMemory().Set()
+	repos.Config.Set(mktAlertKeyPrefix+"10.0.0.1:1", "1")
+	mktAlertStates = nil
+
+	p := NewPollingService(nil, repos, nil)
+	if err := p.loadMKTAlertStates(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !mktAlertStates["10.0.0.1:1"] {
+		t.Fatal("probe that was over its limit before the restart is not remembered")
+	}
+
+	p.saveMKTAlertState("10.0.0.1:1", false)
+	p.saveMKTAlertState("10.0.0.2:1", true)
+
+	// A second restart sees the new states
+	mktAlertStates = nil
+	if err := p.loadMKTAlertStates(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if mktAlertStates["10.0.0.1:1"] || !mktAlertStates["10.0.0.2:1"] {
+		t.Errorf("reloaded states = %v", mktAlertStates)
+	}
+}
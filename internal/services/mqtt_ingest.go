@@ -0,0 +1,283 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/utils"
+)
+
+// MQTTIngestMapping describes how an inbound MQTT message maps to a master_machine probe.
+//
+// Fields are resolved from the topic first (TopicTemplate, e.g. "sensors/{ip}/{probe}")
+// and then from the JSON payload using dotted paths (e.g. "data.sensors.0.temp").
+// A payload that is a bare number is taken as the value.
+type MQTTIngestMapping struct {
+	TopicTemplate string
+	IPPath        string
+	ProbePath     string
+	ValuePath     string
+	TimePath      string
+}
+
+// newMQTTIngestMapping loads the inbound mapping from environment variables
+func newMQTTIngestMapping() MQTTIngestMapping {
+	return MQTTIngestMapping{
+		TopicTemplate: os.Getenv("MQTT_INGEST_TOPIC_TEMPLATE"),
+		IPPath:        getEnvDefault("MQTT_INGEST_IP_PATH", "ip"),
+		ProbePath:     getEnvDefault("MQTT_INGEST_PROBE_PATH", "probe"),
+		ValuePath:     getEnvDefault("MQTT_INGEST_VALUE_PATH", "value"),
+		TimePath:      getEnvDefault("MQTT_INGEST_TIME_PATH", "timestamp"),
+	}
+}
+
+// getEnvDefault returns the environment variable or a fallback when it is empty
+func getEnvDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// IsIngestEnabled returns whether inbound topics are configured
+func (m *MQTTService) IsIngestEnabled() bool {
+	return m.enabled && len(m.ingestTopics) > 0
+}
+
+// ingestQueueSize is how many inbound messages may wait for the database before the
+// client stops reading from the broker
+const ingestQueueSize = 1000
+
+// SetReadingHandler registers the callback for readings pushed by devices and
+// subscribes to the inbound topics if the client is already connected.
+func (m *MQTTService) SetReadingHandler(handler func(Reading) error) {
+	m.mu.Lock()
+	m.readingHandler = handler
+	if m.ingestQueue == nil {
+		m.ingestQueue = make(chan mqtt.Message, ingestQueueSize)
+		go m.runIngest(m.ingestQueue)
+	}
+	m.mu.Unlock()
+
+	if m.IsConnected() {
+		m.subscribeIngest()
+	}
+}
+
+// subscribeIngest subscribes to all inbound topics. Called on every (re)connect
+// because the session is clean and subscriptions do not survive a reconnect.
+func (m *MQTTService) subscribeIngest() {
+	if !m.IsIngestEnabled() {
+		return
+	}
+
+	m.mu.Lock()
+	hasHandler := m.readingHandler != nil
+	m.mu.Unlock()
+	if !hasHandler {
+		return
+	}
+
+	for _, topic := range m.ingestTopics {
+		token := m.client.Subscribe(topic, 1, m.handleIngestMessage)
+		if token.Wait() && token.Error() != nil {
+			utils.LogError("MQTT subscribe to %s failed: %v", topic, token.Error())
+			continue
+		}
+		log.Printf("MQTT subscribed to inbound topic: %s", topic)
+	}
+}
+
+// handleIngestMessage queues an inbound message for runIngest. The client delivers messages
+// in order, so readings of a probe reach the pipeline in the order the device sent them,
+// while the network loop only waits when the queue is full.
+func (m *MQTTService) handleIngestMessage(client mqtt.Client, msg mqtt.Message) {
+	m.mu.Lock()
+	queue := m.ingestQueue
+	m.mu.Unlock()
+	if queue != nil {
+		queue <- msg
+	}
+}
+
+// runIngest processes queued inbound messages one at a time
+func (m *MQTTService) runIngest(queue <-chan mqtt.Message) {
+	for msg := range queue {
+		m.processIngestMessage(msg)
+	}
+}
+
+// processIngestMessage maps an inbound message to a Reading and hands it to the pipeline
+func (m *MQTTService) processIngestMessage(msg mqtt.Message) {
+	m.mu.Lock()
+	handler := m.readingHandler
+	m.mu.Unlock()
+	if handler == nil {
+		return
+	}
+
+	reading, err := m.ingestMapping.Parse(msg.Topic(), msg.Payload())
+	if err != nil {
+		utils.LogError("MQTT ingest - Failed to map message on %s: %v", msg.Topic(), err)
+		return
+	}
+
+	if err := handler(reading); err != nil && !errors.Is(err, ErrDuplicateReading) {
+		utils.LogError("MQTT ingest - Failed to process reading from %s (probe=%d): %v", reading.MachineIP, reading.ProbeNo, err)
+	}
+}
+
+// Parse converts a topic and payload into a Reading
+func (mp MQTTIngestMapping) Parse(topic string, payload []byte) (Reading, error) {
+	reading := Reading{Source: ReadingSourceMQTT}
+	fields := mp.topicFields(topic)
+
+	var doc interface{}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		// Allow plain-text numbers as payload
+		doc = strings.TrimSpace(string(payload))
+	}
+
+	reading.MachineIP = fields["ip"]
+	if reading.MachineIP == "" {
+		if v, ok := lookupPath(doc, mp.IPPath); ok {
+			reading.MachineIP = fmt.Sprint(v)
+		}
+	}
+	if reading.MachineIP == "" {
+		return reading, fmt.Errorf("no device IP in topic or payload")
+	}
+
+	reading.ProbeNo = 1
+	probeStr := fields["probe"]
+	if probeStr == "" {
+		if v, ok := lookupPath(doc, mp.ProbePath); ok {
+			probeStr = fmt.Sprint(v)
+		}
+	}
+	if probeStr != "" {
+		probeNo, err := strconv.Atoi(probeStr)
+		if err != nil {
+			return reading, fmt.Errorf("invalid probe number %q", probeStr)
+		}
+		reading.ProbeNo = probeNo
+	}
+
+	value, ok := lookupPath(doc, mp.ValuePath)
+	if !ok {
+		// Bare number payload
+		value = doc
+	}
+	temp, err := toFloat(value)
+	if err != nil {
+		return reading, fmt.Errorf("invalid value: %v", err)
+	}
+	reading.TempValue = temp
+
+	if v, ok := lookupPath(doc, mp.TimePath); ok {
+		ts, err := parseReadingTime(v)
+		if err != nil {
+			return reading, fmt.Errorf("invalid timestamp: %v", err)
+		}
+		reading.SendTime = ts
+		reading.InsertTime = ts
+	}
+
+	return reading, nil
+}
+
+// topicFields extracts {placeholders} from the topic according to TopicTemplate
+func (mp MQTTIngestMapping) topicFields(topic string) map[string]string {
+	fields := make(map[string]string)
+	if mp.TopicTemplate == "" {
+		return fields
+	}
+
+	templateLevels := strings.Split(mp.TopicTemplate, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range templateLevels {
+		if i >= len(topicLevels) {
+			break
+		}
+		if strings.HasPrefix(level, "{") && strings.HasSuffix(level, "}") {
+			fields[strings.Trim(level, "{}")] = topicLevels[i]
+		}
+	}
+	return fields
+}
+
+// lookupPath walks a decoded JSON document using a dotted path.
+// Numeric segments index into arrays.
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+
+	current := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, current != nil
+}
+
+// toFloat converts a JSON number or numeric string to float64
+func toFloat(v interface{}) (float64, error) {
+	switch val := v.(type) {
+	case float64:
+		return val, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(val), 64)
+	default:
+		return 0, fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// parseReadingTime accepts unix seconds/milliseconds, RFC3339, or
+// "2006-01-02 15:04:05" in site time.
+func parseReadingTime(v interface{}) (time.Time, error) {
+	loc := database.SiteLocation()
+
+	switch val := v.(type) {
+	case float64:
+		if val > 1e12 {
+			return time.UnixMilli(int64(val)).In(loc), nil
+		}
+		sec, frac := math.Modf(val)
+		return time.Unix(int64(sec), int64(frac*1e9)).In(loc).Truncate(time.Microsecond), nil
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+			return t.In(loc).Truncate(time.Microsecond), nil
+		}
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", val, loc); err == nil {
+			return t, nil
+		}
+		return time.Time{}, fmt.Errorf("unrecognised time format %q", val)
+	default:
+		return time.Time{}, fmt.Errorf("unsupported type %T", v)
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"tms-backend/internal/database"
+)
+
+func TestMQTTIngestMappingParse(t *testing.T) {
+	loc := database.SiteLocation()
+	defaults := MQTTIngestMapping{IPPath: "ip", ProbePath: "probe", ValuePath: "value", TimePath: "timestamp"}
+	nested := MQTTIngestMapping{IPPath: "device.ip", ProbePath: "device.probe", ValuePath: "data.sensors.1.temp", TimePath: "data.ts"}
+	fromTopic := defaults
+	fromTopic.TopicTemplate = "sensors/{ip}/{probe}"
+
+	tests := []struct {
+		name    string
+		mapping MQTTIngestMapping
+		topic   string
+		payload string
+		ip      string
+		probe   int
+		value   float64
+		at      time.Time
+		wantErr bool
+	}{
+		{name: "flat payload", mapping: defaults, topic: "tms/ingest",
+			payload: `{"ip":"10.0.0.5","probe":2,"value":4.25,"timestamp":"2025-01-06 08:30:00"}`,
+			ip:      "10.0.0.5", probe: 2, value: 4.25, at: time.Date(2025, 1, 6, 8, 30, 0, 0, loc)},
+		{name: "probe defaults to 1", mapping: defaults, topic: "tms/ingest",
+			payload: `{"ip":"10.0.0.5","value":"3.5"}`, ip: "10.0.0.5", probe: 1, value: 3.5},
+		{name: "nested paths and array index", mapping: nested, topic: "tms/ingest",
+			payload: `{"device":{"ip":"10.0.0.6","probe":"3"},"data":{"ts":1736127000,"sensors":[{"temp":1},{"temp":-18.5}]}}`,
+			ip:      "10.0.0.6", probe: 3, value: -18.5, at: time.Unix(1736127000, 0)},
+		{name: "ip and probe from topic, bare number payload", mapping: fromTopic, topic: "sensors/10.0.0.7/2",
+			payload: ` 5.75 `, ip: "10.0.0.7", probe: 2, value: 5.75},
+		{name: "topic wins over payload", mapping: fromTopic, topic: "sensors/10.0.0.7/1",
+			payload: `{"ip":"10.9.9.9","probe":4,"value":2}`, ip: "10.0.0.7", probe: 1, value: 2},
+		{name: "no ip", mapping: defaults, topic: "tms/ingest", payload: `{"value":2}`, wantErr: true},
+		{name: "bad probe", mapping: defaults, topic: "tms/ingest", payload: `{"ip":"10.0.0.5","probe":"a","value":2}`, wantErr: true},
+		{name: "no value", mapping: defaults, topic: "tms/ingest", payload: `{"ip":"10.0.0.5"}`, wantErr: true},
+		{name: "bad timestamp", mapping: defaults, topic: "tms/ingest", payload: `{"ip":"10.0.0.5","value":2,"timestamp":"yesterday"}`, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := tc.mapping.Parse(tc.topic, []byte(tc.payload))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Parse = %+v, want an error", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.MachineIP != tc.ip || r.ProbeNo != tc.probe || r.TempValue != tc.value || r.Source != ReadingSourceMQTT {
+				t.Errorf("Parse = %s/%d %v (%s), want %s/%d %v", r.MachineIP, r.ProbeNo, r.TempValue, r.Source, tc.ip, tc.probe, tc.value)
+			}
+			if !r.InsertTime.Equal(tc.at) || !r.SendTime.Equal(tc.at) {
+				t.Errorf("times = %v / %v, want %v", r.SendTime, r.InsertTime, tc.at)
+			}
+		})
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"a": map[string]interface{}{
+			"list": []interface{}{"x", map[string]interface{}{"b": 2.0}},
+			"nil":  nil,
+		},
+	}
+	tests := []struct {
+		path string
+		want interface{}
+		ok   bool
+	}{
+		{"a.list.0", "x", true},
+		{"a.list.1.b", 2.0, true},
+		{"a.list.2", nil, false},
+		{"a.list.-1", nil, false},
+		{"a.list.b", nil, false},
+		{"a.missing", nil, false},
+		{"a.nil", nil, false},
+		{"a.list.0.deeper", nil, false},
+		{"", nil, false},
+	}
+	for _, tc := range tests {
+		got, ok := lookupPath(doc, tc.path)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("lookupPath(%q) = %v, %v; want %v, %v", tc.path, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestParseReadingTime(t *testing.T) {
+	loc := database.SiteLocation()
+	want := time.Date(2025, 1, 6, 8, 30, 0, 0, loc)
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    time.Time
+		wantErr bool
+	}{
+		{"unix seconds", float64(want.Unix()), want, false},
+		{"unix seconds with fraction", float64(want.Unix()) + 0.25, want.Add(250 * time.Millisecond), false},
+		{"unix milliseconds", float64(want.UnixMilli() + 5), want.Add(5 * time.Millisecond), false},
+		{"RFC3339 in UTC", "2025-01-06T01:30:00Z", want, false},
+		{"RFC3339 with offset", "2025-01-06T08:30:00.5+07:00", want.Add(500 * time.Millisecond), false},
+		{"local wall clock", "2025-01-06 08:30:00", want, false},
+		{"unknown format", "06/01/2025", time.Time{}, true},
+		{"unsupported type", true, time.Time{}, true},
+	}
+	for _, tc := range tests {
+		got, err := parseReadingTime(tc.value)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: parseReadingTime(%v) = %v, want an error", tc.name, tc.value, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tc.want) || got.Location().String() != loc.String() {
+			t.Errorf("%s: parseReadingTime(%v) = %v, %v; want %v", tc.name, tc.value, got, err, tc.want)
+		}
+	}
+}
+
+// testMessage is an inbound mqtt.Message
+type testMessage struct {
+	topic   string
+	payload string
+}
+
+func (m testMessage) Duplicate() bool   { return false }
+func (m testMessage) Qos() byte         { return 1 }
+func (m testMessage) Retained() bool    { return false }
+func (m testMessage) Topic() string     { return m.topic }
+func (m testMessage) MessageID() uint16 { return 0 }
+func (m testMessage) Payload() []byte   { return []byte(m.payload) }
+func (m testMessage) Ack()              {}
+
+func TestIngestMessagesKeepOrder(t *testing.T) {
+	m := &MQTTService{ingestMapping: MQTTIngestMapping{TopicTemplate: "sensors/{ip}/{probe}"}}
+	got := make(chan float64)
+	m.SetReadingHandler(func(r Reading) error {
+		got <- r.TempValue
+		return nil
+	})
+
+	// The handler for the first message is still running while the rest arrive
+	for i := 0; i < 50; i++ {
+		m.handleIngestMessage(nil, testMessage{topic: "sensors/10.0.0.1/1", payload: fmt.Sprint(i)})
+	}
+	for i := 0; i < 50; i++ {
+		if v := <-got; v != float64(i) {
+			t.Fatalf("reading %d has value %v; readings were handled out of order", i, v)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +33,16 @@ type MQTTService struct {
 	topic    string
 	enabled  bool
 	mu       sync.Mutex
+
+	// Inbound readings from devices that publish to the broker themselves
+	ingestTopics   []string
+	ingestMapping  MQTTIngestMapping
+	readingHandler func(Reading) error
+	ingestQueue    chan mqtt.Message // drained in arrival order by runIngest
+
+	// Optional Sparkplug B publisher for SCADA (nil when disabled)
+	sparkplug   *SparkplugEncoder
+	sparkplugMu sync.Mutex // orders NBIRTH before DBIRTH/DDATA
 }
 
 // Global MQTT service instance
@@ -62,14 +73,25 @@ func NewMQTTService() *MQTTService {
 		topic = "tms/temperature"
 	}
 
+	// Comma-separated inbound topics, wildcards allowed (e.g. "tms/ingest/#")
+	var ingestTopics []string
+	for _, t := range strings.Split(os.Getenv("MQTT_INGEST_TOPICS"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			ingestTopics = append(ingestTopics, t)
+		}
+	}
+
 	return &MQTTService{
-		broker:   broker,
-		port:     port,
-		clientID: clientID,
-		username: username,
-		password: password,
-		topic:    topic,
-		enabled:  true,
+		broker:        broker,
+		port:          port,
+		clientID:      clientID,
+		username:      username,
+		password:      password,
+		topic:         topic,
+		enabled:       true,
+		ingestTopics:  ingestTopics,
+		ingestMapping: newMQTTIngestMapping(),
+		sparkplug:     newSparkplugEncoder(clientID),
 	}
 }
 
@@ -103,8 +125,13 @@ func (m *MQTTService) Connect() error {
 
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
 		log.Println("MQTT reconnected to broker")
+		go m.subscribeIngest()
+		// OnConnect already runs in its own goroutine; NBIRTH goes out before any DDATA
+		m.startSparkplugSession()
 	})
 
+	m.configureSparkplug(opts)
+
 	m.client = mqtt.NewClient(opts)
 
 	token := m.client.Connect()
@@ -115,6 +142,12 @@ func (m *MQTTService) Connect() error {
 
 	log.Printf("MQTT connected to %s:%s (clientID: %s)", m.broker, m.port, m.clientID)
 	log.Printf("Topic: %s", m.topic)
+	if len(m.ingestTopics) > 0 {
+		log.Printf("Inbound topics: %s", strings.Join(m.ingestTopics, ", "))
+	}
+	if m.sparkplug != nil {
+		log.Printf("Sparkplug B: group=%s, edge node=%s", m.sparkplug.groupID, m.sparkplug.edgeNodeID)
+	}
 	return nil
 }
 
@@ -1,17 +1,20 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
+	"gorm.io/gorm"
+
 	"tms-backend/internal/database"
 	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
 	"tms-backend/internal/tcpclient"
 	"tms-backend/internal/utils"
 )
@@ -30,15 +33,18 @@ func init() {
 
 // Event types for SSE
 type DataSavedEvent struct {
-	Saved  int `json:"saved"`
-	Errors int `json:"errors"`
+	Saved     int `json:"saved"`
+	Journaled int `json:"journaled"` // buffered locally while the database is unreachable
+	Errors    int `json:"errors"`
 }
 
 // MaxSensorTemp is the maximum valid sensor temperature; readings above this are considered sensor errors
 const MaxSensorTemp = 80.0
 
-// TemperatureUpdateEvent represents real-time temperature data (same as MQTT payload)
+// TemperatureUpdateEvent represents real-time temperature data (as the MQTT payload, with an RFC3339 timestamp)
 type TemperatureUpdateEvent struct {
+	MachineIP   string  `json:"machineIp"`
+	ProbeNo     int     `json:"probeNo"`
 	MachineName string  `json:"machineName"`
 	TempValue   float64 `json:"tempValue"`
 	Status      string  `json:"status"` // N=Normal, H=High, L=Low
@@ -49,6 +55,7 @@ type TemperatureUpdateEvent struct {
 type PollingService struct {
 	pollInterval           time.Duration
 	alertInterval          time.Duration
+	mktInterval            time.Duration
 	stopChan               chan struct{}
 	wg                     sync.WaitGroup
 	running                bool
@@ -58,25 +65,42 @@ type PollingService struct {
 	subMu                  sync.Mutex
 	apiNotificationService *APINotificationService
 	mqttService            *MQTTService
+	liveCache              *LiveCache
+	db                     *gorm.DB // MKT calculations
+	repos                  *repository.Set
+	journal                *ReadingJournal        // buffers readings while the database is unreachable; may be nil
+	lastDevices            []models.MasterMachine // last successful master_machine load
+	devicesMu              sync.Mutex
 }
 
 // Device alert state tracking
 var alertStates = make(map[string]string) // key: "ip:probeNo", value: "H", "L", "N"
 var alertStatesMu sync.Mutex
 
-// NewPollingService creates a new polling service
-func NewPollingService() *PollingService {
+// NewPollingService creates a new polling service that stores through repos, computes MKT
+// on db and falls back to journal while the database is unreachable
+func NewPollingService(db *gorm.DB, repos *repository.Set, journal *ReadingJournal) *PollingService {
 	return &PollingService{
+		db:                     db,
+		repos:                  repos,
+		journal:                journal,
 		pollInterval:           5 * time.Minute,
 		alertInterval:          5 * time.Second,
+		mktInterval:            time.Hour,
 		stopChan:               make(chan struct{}),
 		subscribers:            make([]chan DataSavedEvent, 0),
 		temperatureSubscribers: make([]chan []TemperatureUpdateEvent, 0),
 		apiNotificationService: NewAPINotificationService(),
 		mqttService:            GlobalMQTTService,
+		liveCache:              NewLiveCache(),
 	}
 }
 
+// LiveReadings returns the latest cached reading per probe
+func (p *PollingService) LiveReadings() []LiveReading {
+	return p.liveCache.Snapshot(database.SiteNow())
+}
+
 // Subscribe to data saved events
 func (p *PollingService) Subscribe() chan DataSavedEvent {
 	p.subMu.Lock()
@@ -150,6 +174,7 @@ func (p *PollingService) Start() {
 	log.Println("Starting background polling service...")
 	log.Printf("- Poll & Save interval: every %v", p.pollInterval)
 	log.Printf("- Alert check interval: every %v", p.alertInterval)
+	log.Printf("- MKT check interval: every %v", p.mktInterval)
 
 	// Log API status
 	if p.apiNotificationService.IsLegacyAPIEnabled() {
@@ -163,6 +188,10 @@ func (p *PollingService) Start() {
 	if p.mqttService != nil && p.mqttService.IsEnabled() {
 		log.Println("- MQTT: ENABLED")
 		log.Println("  • Publish temperature every 5 seconds")
+		if p.mqttService.IsIngestEnabled() {
+			log.Println("  • Ingest readings pushed by devices")
+			p.mqttService.SetReadingHandler(p.IngestReading)
+		}
 	} else {
 		log.Println("- MQTT: DISABLED (MQTT_BROKER not configured)")
 	}
@@ -171,20 +200,10 @@ func (p *PollingService) Start() {
 	log.Println("Waiting for database connection to stabilize...")
 	time.Sleep(3 * time.Second)
 
-	// ทดสอบ GetThailandTime() และ database connection
-	testTime := database.GetThailandTime()
+	// แสดง timezone ของ site ที่ใช้บันทึกข้อมูล
+	testTime := database.SiteNow()
 	log.Printf("Timezone test: %v", testTime.Format("2006-01-02 15:04:05.000 MST"))
 
-	// Test database connection
-	sqlDB, err := database.DB.DB()
-	if err == nil {
-		if err := sqlDB.Ping(); err != nil {
-			log.Printf("Database ping failed: %v", err)
-		} else {
-			log.Println("Database connection verified")
-		}
-	}
-
 	// Initial poll with error handling
 	log.Println("Running initial poll and save...")
 	func() {
@@ -240,6 +259,24 @@ func (p *PollingService) Start() {
 			}
 		}
 	}()
+
+	// Start MKT checker (rolling 30-day Mean Kinetic Temperature against its limit)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.mktInterval)
+		defer ticker.Stop()
+
+		p.checkMKT()
+		for {
+			select {
+			case <-ticker.C:
+				p.checkMKT()
+			case <-p.stopChan:
+				return
+			}
+		}
+	}()
 }
 
 // Stop the polling service
@@ -273,14 +310,18 @@ func (p *PollingService) pollAndSave() {
 	log.Println("=== Starting Poll & Save cycle ===")
 
 	// Get all machines grouped by IP
-	var machines []models.MasterMachine
-	if err := database.DB.Find(&machines).Error; err != nil {
+	machines, err := p.loadDevices()
+	if err != nil {
 		utils.LogError("pollAndSave - Failed to load machines: %v", err)
-		log.Printf("Error loading machines: %v", err)
-		log.Println("This might be a charset encoding issue")
-		log.Println("Check if DB_CHARSET in .env matches your database charset")
-		return
+		if machines == nil {
+			log.Printf("Error loading machines: %v", err)
+			log.Println("This might be a charset encoding issue")
+			log.Println("Check if DB_CHARSET in .env matches your database charset")
+			return
+		}
+		log.Printf("Database unreachable - polling the %d probes loaded last", len(machines))
 	}
+	p.liveCache.Seed(machines)
 
 	// Group machines by IP for polling
 	machinesByIP := make(map[string][]models.MasterMachine)
@@ -291,10 +332,9 @@ func (p *PollingService) pollAndSave() {
 	log.Printf("Found %d unique IPs to poll (%d total probes)", len(machinesByIP), len(machines))
 
 	savedCount := 0
+	journaledCount := 0
 	errorCount := 0
-	now := database.GetThailandTime().Truncate(time.Microsecond)
-	sDate := now.Format("20060102")
-	sTime := now.Format("15")
+	now := database.SiteNow().Truncate(time.Microsecond)
 
 	for ip, probes := range machinesByIP {
 		// Get machine name from first probe
@@ -310,6 +350,9 @@ func (p *PollingService) pollAndSave() {
 			"A",
 			5*time.Second,
 		)
+		if response.Connected {
+			p.liveCache.MarkDeviceSeen(ip, now)
+		}
 
 		// Create a map of probe configs for quick lookup
 		probeConfigs := make(map[int]models.MasterMachine)
@@ -332,111 +375,75 @@ func (p *PollingService) pollAndSave() {
 				probeConfig = probes[0]
 				probeConfig.ProbeNo = probeData.ProbeNo
 			}
-			// Set default sType if not set
-			if probeConfig.SType == "" {
-				probeConfig.SType = "t"
-			}
 
-			// Apply temperature adjustment and round to 2 decimal places
-			adjustedTemp := probeData.TempValue + probeConfig.GetAdjTemp()
-			adjustedTemp = math.Round(adjustedTemp*100) / 100
-
-			// Validate sensor reading - skip if temp exceeds threshold (likely sensor error)
-			if adjustedTemp > MaxSensorTemp {
-				log.Printf("Skipping sensor error: %s Probe %d temp=%.2f°C exceeds %.0f°C threshold",
-					probeConfig.MachineName, probeData.ProbeNo, adjustedTemp, MaxSensorTemp)
-				continue
-			}
-
-			tempStatus := "N" // Normal
-			if adjustedTemp < probeConfig.GetMinTemp() {
-				tempStatus = "L" // Low
-			} else if adjustedTemp > probeConfig.GetMaxTemp() {
-				tempStatus = "H" // High
-			}
-
-			// Convert RealValue to int (as per database schema)
-			realValueInt := probeData.RealValue
-
-			// Create unique timestamp for insert_time to avoid duplicate key
-			// Truncate to microsecond precision (6 decimal places) for MySQL DATETIME compatibility
-			insertTime := database.GetThailandTime().Truncate(time.Microsecond)
-
-			// Debug: Log the timestamp being used
-			log.Printf("InsertTime for %s Probe %d: %v", machineName, probeData.ProbeNo, insertTime)
-
-			// Create temp log entry
-			tempLog := models.TempLog{
-				MachineIP:  ip,
-				ProbeNo:    probeData.ProbeNo,
-				McuID:      &probeConfig.MachineName,
-				TempValue:  &adjustedTemp,
-				RealValue:  &realValueInt,
-				Status:     &tempStatus,
-				SendTime:   &now,
-				InsertTime: insertTime,
-				SDate:      &sDate,
-				STime:      &sTime,
-			}
-
-			// Insert the log - if duplicate, skip it
-			if err := database.DB.Create(&tempLog).Error; err != nil {
-				// Check if it's a duplicate key error
-				if strings.Contains(err.Error(), "Duplicate entry") || strings.Contains(err.Error(), "1062") {
-					// Skip duplicate - this is expected if polling faster than microsecond precision
-					log.Printf("Duplicate log entry skipped for %s Probe %d", probeConfig.MachineName, probeData.ProbeNo)
-				} else {
-					utils.LogError("pollAndSave - Failed to save temp log (machine=%s, probe=%d): %v", probeConfig.MachineName, probeData.ProbeNo, err)
-					log.Printf("Error saving temp log: %v", err)
-					errorCount++
-				}
-			} else {
-				unit := probeConfig.GetUnit()
-				log.Printf("%s Probe %d: %.2f%s [%s]", probeConfig.MachineName, probeData.ProbeNo, adjustedTemp, unit, probeConfig.GetTypeLabel())
+			realValue := probeData.RealValue
+			switch p.processReading(probeConfig, Reading{
+				MachineIP: ip,
+				ProbeNo:   probeData.ProbeNo,
+				TempValue: probeData.TempValue,
+				RealValue: &realValue,
+				SendTime:  now,
+				Source:    ReadingSourceTCP,
+			}) {
+			case readingSaved:
 				savedCount++
-
-				// ส่งข้อมูลไป Legacy API
-				if p.apiNotificationService.IsLegacyAPIEnabled() {
-					payload := TempLogPayload{
-						McuID:     probeConfig.MachineName, // ใช้ชื่อของ probe นี้โดยเฉพาะ
-						Status:    "00000110",              // Normal status
-						TempValue: adjustedTemp,
-						RealValue: realValueInt,
-						Date:      sDate,
-						Time:      sTime,
-					}
-					go func(pl TempLogPayload, probeName string, probeNo int) {
-						if err := p.apiNotificationService.SendTempLog(pl); err != nil {
-							utils.LogError("pollAndSave - Failed to send to Legacy API (machine=%s, probe=%d): %v", probeName, probeNo, err)
-							log.Printf("Failed to send to Legacy API: %v", err)
-						}
-					}(payload, probeConfig.MachineName, probeData.ProbeNo)
-				}
+			case readingJournaled:
+				journaledCount++
+			case readingFailed:
+				errorCount++
 			}
-
-			// Check alerts using this probe's config
-			p.checkProbeAlert(probeConfig, probeData.ProbeNo, adjustedTemp)
 		}
 	}
 
 	elapsed := time.Since(startTime)
 	log.Printf("=== Poll & Save completed in %v ===", elapsed)
-	log.Printf("   Saved: %d logs, %d errors", savedCount, errorCount)
+	log.Printf("   Saved: %d logs, %d journaled, %d errors", savedCount, journaledCount, errorCount)
 
 	// Notify subscribers
 	p.notifySubscribers(DataSavedEvent{
-		Saved:  savedCount,
-		Errors: errorCount,
+		Saved:     savedCount,
+		Journaled: journaledCount,
+		Errors:    errorCount,
 	})
 }
 
+// loadDevices returns all probes from master_machine. While the database is unreachable it
+// returns the last successful load along with the error, so devices keep being polled and
+// their readings journaled; machines is nil only if nothing has been loaded yet.
+func (p *PollingService) loadDevices() ([]models.MasterMachine, error) {
+	machines, err := p.repos.Devices.List()
+
+	p.devicesMu.Lock()
+	defer p.devicesMu.Unlock()
+	if err != nil {
+		return p.lastDevices, err
+	}
+	p.lastDevices = machines
+	return machines, nil
+}
+
+// lastDevicesOf returns the probes of ip from the last successful master_machine load
+func (p *PollingService) lastDevicesOf(ip string) []models.MasterMachine {
+	p.devicesMu.Lock()
+	defer p.devicesMu.Unlock()
+
+	var probes []models.MasterMachine
+	for _, m := range p.lastDevices {
+		if m.MachineIP == ip {
+			probes = append(probes, m)
+		}
+	}
+	return probes
+}
+
 // checkAlerts checks for temperature alerts on current readings
 func (p *PollingService) checkAlerts() {
 	// Get all machines grouped by IP
-	var machines []models.MasterMachine
-	if err := database.DB.Find(&machines).Error; err != nil {
+	machines, err := p.loadDevices()
+	if err != nil && machines == nil {
 		return
 	}
+	p.liveCache.Seed(machines)
 
 	// Group machines by IP
 	machinesByIP := make(map[string][]models.MasterMachine)
@@ -446,7 +453,9 @@ func (p *PollingService) checkAlerts() {
 
 	// Collect MQTT payloads for batch publish
 	var mqttPayloads []MQTTTemperaturePayload
-	now := database.GetThailandTime()
+	var sparkplugDevices []SparkplugDevice
+	var sseEvents []TemperatureUpdateEvent
+	now := database.SiteNow()
 
 	for ip, probes := range machinesByIP {
 		machineName := probes[0].MachineName
@@ -461,6 +470,9 @@ func (p *PollingService) checkAlerts() {
 			"A",
 			3*time.Second,
 		)
+		if response.Connected {
+			p.liveCache.MarkDeviceSeen(ip, now)
+		}
 
 		// Create probe config map
 		probeConfigs := make(map[int]models.MasterMachine)
@@ -468,6 +480,8 @@ func (p *PollingService) checkAlerts() {
 			probeConfigs[probe.ProbeNo] = probe
 		}
 
+		spDevice := SparkplugDevice{DeviceID: ip, Timestamp: now}
+
 		for _, probeData := range response.Probes {
 			// Skip broken sensor data (0xFFFF = 65535 or -1)
 			if probeData.RealValue == 65535 || probeData.RealValue == -1 {
@@ -501,6 +515,8 @@ func (p *PollingService) checkAlerts() {
 				tempStatus = "H" // High
 			}
 
+			p.liveCache.Update(probeConfig, probeData.ProbeNo, adjustedTemp, tempStatus, ReadingSourceTCP, now)
+
 			// Collect temperature data payload for MQTT
 			mqttPayloads = append(mqttPayloads, MQTTTemperaturePayload{
 				Probe:     probeConfig.MachineName,
@@ -508,6 +524,30 @@ func (p *PollingService) checkAlerts() {
 				Status:    tempStatus,
 				Timestamp: now.Format("2006-01-02 15:04:05"),
 			})
+
+			sseEvents = append(sseEvents, TemperatureUpdateEvent{
+				MachineIP:   ip,
+				ProbeNo:     probeData.ProbeNo,
+				MachineName: probeConfig.MachineName,
+				TempValue:   adjustedTemp,
+				Status:      tempStatus,
+				Timestamp:   now.Format(time.RFC3339),
+			})
+
+			spDevice.Probes = append(spDevice.Probes, SparkplugProbe{
+				ProbeNo: probeData.ProbeNo,
+				Name:    probeConfig.MachineName,
+				Unit:    probeConfig.GetUnit(),
+				Type:    probeConfig.GetTypeLabel(),
+				MinTemp: probeConfig.GetMinTemp(),
+				MaxTemp: probeConfig.GetMaxTemp(),
+				Value:   adjustedTemp,
+				Status:  tempStatus,
+			})
+		}
+
+		if len(spDevice.Probes) > 0 {
+			sparkplugDevices = append(sparkplugDevices, spDevice)
 		}
 	}
 
@@ -529,25 +569,20 @@ func (p *PollingService) checkAlerts() {
 					log.Printf("MQTT published %d temperature readings", len(payloads))
 				}
 			}(mqttPayloads)
+
+			// Sparkplug B DDATA for SCADA (DBIRTH is sent first for new devices)
+			if p.mqttService.IsSparkplugEnabled() && len(sparkplugDevices) > 0 {
+				go func(devices []SparkplugDevice) {
+					if err := p.mqttService.PublishSparkplugDevices(devices); err != nil {
+						utils.LogError("Sparkplug publish failed: %v", err)
+					}
+				}(sparkplugDevices)
+			}
 		}
 	}
 
-	// Send temperature data via SSE (keep separate structure for SSE)
-	if len(mqttPayloads) > 0 {
-		// Build SSE events from probe data (need to recalculate status and timestamp)
-		sseEvents := make([]TemperatureUpdateEvent, 0, len(mqttPayloads))
-		for _, mqtt := range mqttPayloads {
-			// Determine status based on temp
-			tempStatus := "N"
-			// Note: We'd need min/max temps here, but for SSE we'll just use "N" for now
-			// as the status was already determined earlier in the loop
-			sseEvents = append(sseEvents, TemperatureUpdateEvent{
-				MachineName: mqtt.Probe,
-				TempValue:   mqtt.Temp,
-				Status:      tempStatus,
-				Timestamp:   now.Format("2006-01-02 15:04:05"),
-			})
-		}
+	// Send temperature data via SSE
+	if len(sseEvents) > 0 {
 		p.notifyTemperatureSubscribers(sseEvents)
 	}
 }
@@ -574,7 +609,7 @@ func (p *PollingService) checkProbeAlert(machine models.MasterMachine, probeNo i
 
 	// Check for state change
 	if currentState != prevState {
-		now := database.GetThailandTime().Truncate(time.Microsecond)
+		now := database.SiteNow().Truncate(time.Microsecond)
 		dateStr := now.Format("20060102")
 		timeStr := now.Format("15:04:05")
 
@@ -598,7 +633,7 @@ func (p *PollingService) checkProbeAlert(machine models.MasterMachine, probeNo i
 
 			// Create unique timestamp to avoid duplicate key
 			// Truncate to microsecond precision (6 decimal places) for MySQL DATETIME compatibility
-			errorTime := database.GetThailandTime().Truncate(time.Microsecond)
+			errorTime := database.SiteNow().Truncate(time.Microsecond)
 
 			// Create temp error record
 			tempError := models.TempError{
@@ -615,8 +650,8 @@ func (p *PollingService) checkProbeAlert(machine models.MasterMachine, probeNo i
 			}
 
 			// Insert temp error - skip if duplicate
-			if err := database.DB.Create(&tempError).Error; err != nil {
-				if !strings.Contains(err.Error(), "Duplicate entry") && !strings.Contains(err.Error(), "1062") {
+			if err := p.repos.Incidents.Insert(&tempError); err != nil {
+				if !errors.Is(err, repository.ErrDuplicate) {
 					utils.LogError("checkAlerts - Failed to create temp_error: %v", err)
 				}
 			}
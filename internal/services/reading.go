@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/utils"
+)
+
+// Reading sources
+const (
+	ReadingSourceTCP  = "tcp"  // polled from the device over TCP
+	ReadingSourceMQTT = "mqtt" // pushed by the device to the MQTT broker
+	ReadingSourceHTTP = "http" // pushed by a gateway to POST /api/ingest
+)
+
+var (
+	// ErrDuplicateReading is returned when (device, probe, timestamp) is already in temp_log
+	ErrDuplicateReading = errors.New("duplicate reading")
+	// ErrReadingRejected is returned when the value fails sensor validation
+	ErrReadingRejected = errors.New("reading rejected as sensor error")
+	// ErrUnknownMachine is returned when the device is not registered in master_machine
+	ErrUnknownMachine = errors.New("unknown machine")
+)
+
+// Latest reading time per probe, so late or out-of-order pushed data is
+// stored but does not drive the live alert state backwards.
+var latestReadingTimes = make(map[string]time.Time) // key: "ip:probeNo"
+var latestReadingTimesMu sync.Mutex
+
+// markLatestReading records t for the probe and reports whether it is the newest seen
+func markLatestReading(ip string, probeNo int, t time.Time) bool {
+	key := fmt.Sprintf("%s:%d", ip, probeNo)
+
+	latestReadingTimesMu.Lock()
+	defer latestReadingTimesMu.Unlock()
+
+	if last, ok := latestReadingTimes[key]; ok && !t.After(last) {
+		return false
+	}
+	latestReadingTimes[key] = t
+	return true
+}
+
+// Reading is a single raw probe value entering the save/alert pipeline,
+// regardless of whether it was polled or pushed by the device.
+type Reading struct {
+	MachineIP string
+	ProbeNo   int
+	TempValue float64   // raw value before AdjTemp correction
+	RealValue *int      // raw device value; nil when the source does not report one
+	SendTime  time.Time // when the value was produced; drives sDate/sTime
+	// InsertTime is the temp_log key. Zero means "stamp at insert time",
+	// which is what polling wants; pushed readings use the device timestamp.
+	InsertTime time.Time
+	Source     string
+}
+
+// readingOutcome reports what processReading did with a reading
+type readingOutcome int
+
+const (
+	readingSaved     readingOutcome = iota
+	readingDuplicate                // already in temp_log
+	readingSkipped                  // rejected as a sensor error
+	readingFailed                   // database rejected the insert, or was down and the journal could not take it
+	readingJournaled                // database unreachable, buffered in the reading journal
+)
+
+// processReading applies AdjTemp, validates against MaxSensorTemp, saves to
+// temp_log, forwards to the Legacy API and runs the alert check for one probe.
+func (p *PollingService) processReading(probeConfig models.MasterMachine, r Reading) readingOutcome {
+	// Set default sType if not set
+	if probeConfig.SType == "" {
+		probeConfig.SType = "t"
+	}
+
+	// Apply temperature adjustment and round to 2 decimal places
+	adjustedTemp := r.TempValue + probeConfig.GetAdjTemp()
+	adjustedTemp = math.Round(adjustedTemp*100) / 100
+
+	// Validate sensor reading - skip if temp exceeds threshold (likely sensor error)
+	if adjustedTemp > MaxSensorTemp {
+		log.Printf("Skipping sensor error: %s Probe %d temp=%.2f°C exceeds %.0f°C threshold",
+			probeConfig.MachineName, r.ProbeNo, adjustedTemp, MaxSensorTemp)
+		return readingSkipped
+	}
+
+	tempStatus := "N" // Normal
+	if adjustedTemp < probeConfig.GetMinTemp() {
+		tempStatus = "L" // Low
+	} else if adjustedTemp > probeConfig.GetMaxTemp() {
+		tempStatus = "H" // High
+	}
+
+	sendTime := r.SendTime
+	if sendTime.IsZero() {
+		sendTime = database.SiteNow().Truncate(time.Microsecond)
+	}
+	// sDate/sTime are the site's calendar day and hour, whatever zone the device reported in
+	sendTime = database.SiteTime(sendTime)
+	sDate := sendTime.Format("20060102")
+	sTime := sendTime.Format("15")
+
+	// Create unique timestamp for insert_time to avoid duplicate key
+	// Truncate to microsecond precision (6 decimal places) for MySQL DATETIME compatibility
+	insertTime := r.InsertTime
+	if insertTime.IsZero() {
+		insertTime = database.SiteNow().Truncate(time.Microsecond)
+	}
+
+	// Debug: Log the timestamp being used
+	log.Printf("InsertTime for %s Probe %d: %v", probeConfig.MachineName, r.ProbeNo, insertTime)
+
+	tempLog := models.TempLog{
+		MachineIP:  r.MachineIP,
+		ProbeNo:    r.ProbeNo,
+		McuID:      &probeConfig.MachineName,
+		TempValue:  &adjustedTemp,
+		RealValue:  r.RealValue,
+		Status:     &tempStatus,
+		SendTime:   &sendTime,
+		InsertTime: insertTime,
+		SDate:      &sDate,
+		STime:      &sTime,
+	}
+
+	outcome := readingSaved
+
+	// Insert the log - if duplicate, skip it
+	if err := p.repos.Readings.Insert(&tempLog); err != nil {
+		// Check if it's a duplicate key error
+		if errors.Is(err, repository.ErrDuplicate) {
+			// Skip duplicate - this is expected if polling faster than microsecond precision
+			log.Printf("Duplicate log entry skipped for %s Probe %d", probeConfig.MachineName, r.ProbeNo)
+			outcome = readingDuplicate
+		} else {
+			utils.LogError("pollAndSave - Failed to save temp log (machine=%s, probe=%d, source=%s): %v", probeConfig.MachineName, r.ProbeNo, r.Source, err)
+			log.Printf("Error saving temp log: %v", err)
+			outcome = readingFailed
+			// Keep the reading in the local journal until the database is back. Readings the
+			// database rejects would be rejected again on replay, so they are only logged.
+			if p.journal != nil && errors.Is(err, repository.ErrUnavailable) {
+				if err := p.journal.Append(tempLog); err != nil {
+					utils.LogError("pollAndSave - Failed to journal temp log (machine=%s, probe=%d): %v", probeConfig.MachineName, r.ProbeNo, err)
+				} else {
+					log.Printf("%s Probe %d buffered in the reading journal (%d waiting)", probeConfig.MachineName, r.ProbeNo, p.journal.Backlog())
+					outcome = readingJournaled
+				}
+			}
+		}
+	} else {
+		unit := probeConfig.GetUnit()
+		log.Printf("%s Probe %d: %.2f%s [%s] (%s)", probeConfig.MachineName, r.ProbeNo, adjustedTemp, unit, probeConfig.GetTypeLabel(), r.Source)
+
+		// Move temp_latest forward; older (late) entries never overwrite a newer row
+		if err := p.repos.Readings.UpdateLatest(tempLog); err != nil {
+			utils.LogError("pollAndSave - Failed to update temp_latest (machine=%s, probe=%d): %v", probeConfig.MachineName, r.ProbeNo, err)
+		}
+
+		// Store-and-forward readings older than the rollup lookback need their hour re-aggregated
+		if GlobalRollupService != nil {
+			GlobalRollupService.MarkLate(insertTime)
+		}
+
+		// ส่งข้อมูลไป Legacy API
+		if p.apiNotificationService.IsLegacyAPIEnabled() {
+			// The legacy API requires a raw value, so derive one when the source has none
+			realValue := int(math.Round(r.TempValue * 100))
+			if r.RealValue != nil {
+				realValue = *r.RealValue
+			}
+			payload := TempLogPayload{
+				McuID:     probeConfig.MachineName, // ใช้ชื่อของ probe นี้โดยเฉพาะ
+				Status:    "00000110",              // Normal status
+				TempValue: adjustedTemp,
+				RealValue: realValue,
+				Date:      sDate,
+				Time:      sTime,
+			}
+			go func(pl TempLogPayload, probeName string, probeNo int) {
+				if err := p.apiNotificationService.SendTempLog(pl); err != nil {
+					utils.LogError("pollAndSave - Failed to send to Legacy API (machine=%s, probe=%d): %v", probeName, probeNo, err)
+					log.Printf("Failed to send to Legacy API: %v", err)
+				}
+			}(payload, probeConfig.MachineName, r.ProbeNo)
+		}
+	}
+
+	// Check alerts using this probe's config - late data is stored only
+	if markLatestReading(r.MachineIP, r.ProbeNo, insertTime) {
+		p.liveCache.Update(probeConfig, r.ProbeNo, adjustedTemp, tempStatus, r.Source, insertTime)
+		p.checkProbeAlert(probeConfig, r.ProbeNo, adjustedTemp)
+	} else {
+		log.Printf("Late reading for %s Probe %d at %v stored without alert check",
+			probeConfig.MachineName, r.ProbeNo, insertTime)
+	}
+
+	return outcome
+}
+
+// IngestReading feeds a reading pushed by a device into the same pipeline as
+// polled data. The device must be registered in master_machine; unknown probe
+// numbers on a known device fall back to the first probe's config like polling does.
+func (p *PollingService) IngestReading(r Reading) error {
+	if r.MachineIP == "" {
+		return fmt.Errorf("reading has no machine IP")
+	}
+	if r.ProbeNo <= 0 {
+		r.ProbeNo = 1
+	}
+
+	probes, err := p.repos.Devices.ListByIP(r.MachineIP)
+	if err != nil {
+		// Keep accepting (and journaling) readings from known devices while the database is down
+		if probes = p.lastDevicesOf(r.MachineIP); len(probes) == 0 {
+			return fmt.Errorf("failed to load machine %s: %w", r.MachineIP, err)
+		}
+	}
+	if len(probes) == 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownMachine, r.MachineIP)
+	}
+
+	probeConfig := probes[0]
+	probeConfig.ProbeNo = r.ProbeNo
+	for _, probe := range probes {
+		if probe.ProbeNo == r.ProbeNo {
+			probeConfig = probe
+			break
+		}
+	}
+
+	switch p.processReading(probeConfig, r) {
+	case readingDuplicate:
+		return ErrDuplicateReading
+	case readingSkipped:
+		return ErrReadingRejected
+	case readingFailed:
+		return fmt.Errorf("failed to save reading for %s probe %d", r.MachineIP, r.ProbeNo)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+func float(v float64) *float64 { return &v }
+
+func noAudit(before, after []models.MasterMachine) []models.AuditLog { return nil }
+
+// fridge registers a two-probe device at ip with a 2-8 range on both probes
+func fridge(t *testing.T, repos *repository.Set, ip string) {
+	t.Helper()
+	for probe := 1; probe <= 2; probe++ {
+		machine := models.MasterMachine{
+			MachineIP: ip, ProbeNo: probe, ProbeAll: 2, MachineName: "Fridge",
+			MinTemp: float(2), MaxTemp: float(8), AdjTemp: float(0), SType: "t",
+		}
+		if err := repos.Devices.Create(&machine, noAudit); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcessReading(t *testing.T) {
+	repos := repository.NewMemory().Set()
+	p := NewPollingService(nil, repos, nil)
+	probe := models.MasterMachine{
+		MachineIP: "10.9.0.1", ProbeNo: 1, MachineName: "Fridge",
+		MinTemp: float(2), MaxTemp: float(8), AdjTemp: float(0.5),
+	}
+	at := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+
+	if got := p.processReading(probe, Reading{MachineIP: "10.9.0.1", ProbeNo: 1, TempValue: 4, SendTime: at, InsertTime: at}); got != readingSaved {
+		t.Fatalf("outcome = %v, want saved", got)
+	}
+	if got := p.processReading(probe, Reading{MachineIP: "10.9.0.1", ProbeNo: 1, TempValue: 4, InsertTime: at}); got != readingDuplicate {
+		t.Errorf("same insert time: outcome = %v, want duplicate", got)
+	}
+	if got := p.processReading(probe, Reading{MachineIP: "10.9.0.1", ProbeNo: 1, TempValue: MaxSensorTemp + 1, InsertTime: at.Add(time.Minute)}); got != readingSkipped {
+		t.Errorf("sensor error: outcome = %v, want skipped", got)
+	}
+	if got := p.processReading(probe, Reading{MachineIP: "10.9.0.1", ProbeNo: 1, TempValue: 9, InsertTime: at.Add(2 * time.Minute)}); got != readingSaved {
+		t.Fatalf("outcome = %v, want saved", got)
+	}
+
+	logs, err := repos.Readings.List(repository.ReadingFilter{MachineIPs: []string{"10.9.0.1"}}, repository.Page{Sort: repository.SortTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("%d temp_log rows, want 2", len(logs))
+	}
+	if *logs[0].TempValue != 4.5 || *logs[0].Status != "N" || *logs[0].SDate != "20250106" || *logs[0].STime != "15" {
+		t.Errorf("first row = %.2f %s %s %s, want 4.50 N 20250106 15 (AdjTemp applied, site time)",
+			*logs[0].TempValue, *logs[0].Status, *logs[0].SDate, *logs[0].STime)
+	}
+	if *logs[1].Status != "H" {
+		t.Errorf("9.5 against max 8: status = %s, want H", *logs[1].Status)
+	}
+
+	open, err := repos.Incidents.Count(repository.IncidentFilter{MachineIPs: []string{"10.9.0.1"}, State: repository.IncidentOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open != 1 {
+		t.Errorf("%d open temp_error rows, want 1 for the high reading", open)
+	}
+}
+
+func TestIngestReading(t *testing.T) {
+	repos := repository.NewMemory().Set()
+	fridge(t, repos, "10.9.0.2")
+	p := NewPollingService(nil, repos, nil)
+	at := time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC)
+
+	if err := p.IngestReading(Reading{MachineIP: "10.9.0.2", ProbeNo: 2, TempValue: 5, InsertTime: at}); err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	if err := p.IngestReading(Reading{MachineIP: "10.9.0.2", ProbeNo: 2, TempValue: 5, InsertTime: at}); !errors.Is(err, ErrDuplicateReading) {
+		t.Errorf("repeated reading: err = %v, want ErrDuplicateReading", err)
+	}
+	if err := p.IngestReading(Reading{MachineIP: "10.9.0.99", TempValue: 5}); !errors.Is(err, ErrUnknownMachine) {
+		t.Errorf("unregistered device: err = %v, want ErrUnknownMachine", err)
+	}
+}
+
+func TestPollAndSave(t *testing.T) {
+	// A device answering "A\r" with two probes: 4.50 and 12.00 degrees
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 8)
+			conn.Read(buf)
+			conn.Write([]byte{0x41, 0x41, 0x5a, 0x03, 0x5a, 0x11, 0x62, 0x5a, 0x14, 0x50, 0x5a, 0x0d})
+			conn.Close()
+		}
+	}()
+
+	port := defaultTCPPort
+	defaultTCPPort = listener.Addr().(*net.TCPAddr).Port
+	defer func() { defaultTCPPort = port }()
+
+	memory := repository.NewMemory()
+	repos := memory.Set()
+	fridge(t, repos, "127.0.0.1")
+	p := NewPollingService(nil, repos, nil)
+	events := p.Subscribe()
+	defer p.Unsubscribe(events)
+
+	p.pollAndSave()
+
+	select {
+	case event := <-events:
+		if event.Saved != 2 || event.Errors != 0 {
+			t.Errorf("event = %+v, want 2 saved", event)
+		}
+	default:
+		t.Error("subscribers were not notified")
+	}
+
+	for probe, want := range map[int]string{1: "N", 2: "H"} {
+		latest, ok := memory.Latest("127.0.0.1", probe)
+		if !ok {
+			t.Errorf("probe %d has no temp_latest row", probe)
+			continue
+		}
+		if *latest.Status != want {
+			t.Errorf("probe %d status = %s, want %s", probe, *latest.Status, want)
+		}
+	}
+	if live := p.LiveReadings(); len(live) != 2 {
+		t.Errorf("%d live readings, want 2", len(live))
+	}
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultReportEmailMaxMB is the attachment limit when REPORT_EMAIL_MAX_MB is not set
+const defaultReportEmailMaxMB = 10
+
+// ReportFile is one rendered report attachment. Small files are kept in Data; large ones
+// (monthly archives) are streamed to a temporary file at Path instead.
+type ReportFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	Path        string
+}
+
+// spoolReportFile streams write into a temporary file; remove it with removeReportFiles
+func spoolReportFile(name, contentType string, write func(io.Writer) error) (ReportFile, error) {
+	f, err := os.CreateTemp("", "tms-report-*")
+	if err != nil {
+		return ReportFile{}, err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return ReportFile{}, err
+	}
+	return ReportFile{Name: name, ContentType: contentType, Path: f.Name()}, nil
+}
+
+// size returns the file's size in bytes
+func (f ReportFile) size() (int64, error) {
+	if f.Path == "" {
+		return int64(len(f.Data)), nil
+	}
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// open returns the file's content
+func (f ReportFile) open() (io.ReadCloser, error) {
+	if f.Path == "" {
+		return io.NopCloser(bytes.NewReader(f.Data)), nil
+	}
+	return os.Open(f.Path)
+}
+
+// bytes returns the file's content
+func (f ReportFile) bytes() ([]byte, error) {
+	if f.Path == "" {
+		return f.Data, nil
+	}
+	return os.ReadFile(f.Path)
+}
+
+// removeReportFiles deletes the temporary files of spooled reports
+func removeReportFiles(files []ReportFile) {
+	for _, f := range files {
+		if f.Path != "" {
+			os.Remove(f.Path)
+		}
+	}
+}
+
+// reportEmailMaxBytes is the largest total attachment size that is emailed (REPORT_EMAIL_MAX_MB)
+func reportEmailMaxBytes() int64 {
+	mb, err := strconv.Atoi(os.Getenv("REPORT_EMAIL_MAX_MB"))
+	if err != nil || mb <= 0 {
+		mb = defaultReportEmailMaxMB
+	}
+	return int64(mb) << 20
+}
+
+// smtpConfig reads SMTP_* settings from the environment
+type smtpConfig struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func loadSMTPConfig() smtpConfig {
+	cfg := smtpConfig{
+		host:     os.Getenv("SMTP_HOST"),
+		port:     os.Getenv("SMTP_PORT"),
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("SMTP_FROM"),
+	}
+	if cfg.port == "" {
+		cfg.port = "587"
+	}
+	if cfg.from == "" {
+		cfg.from = cfg.username
+	}
+	return cfg
+}
+
+// IsEmailDeliveryEnabled reports whether SMTP_HOST and a sender address are configured
+func IsEmailDeliveryEnabled() bool {
+	cfg := loadSMTPConfig()
+	return cfg.host != "" && cfg.from != ""
+}
+
+// ReportDropFolder is the default folder for folder delivery (REPORT_DROP_FOLDER)
+func ReportDropFolder() string {
+	return os.Getenv("REPORT_DROP_FOLDER")
+}
+
+// sendReportEmail mails the files as attachments. net/smtp upgrades to STARTTLS
+// when the server offers it; credentials are only sent over TLS or to localhost.
+func sendReportEmail(recipients []string, subject, body string, files []ReportFile) error {
+	cfg := loadSMTPConfig()
+	if cfg.host == "" || cfg.from == "" {
+		return fmt.Errorf("email delivery is not configured (SMTP_HOST, SMTP_FROM)")
+	}
+
+	// The whole message is built in memory, and mail servers reject large messages anyway
+	var total int64
+	for _, f := range files {
+		size, err := f.size()
+		if err != nil {
+			return err
+		}
+		total += size
+	}
+	if limit := reportEmailMaxBytes(); total > limit {
+		return fmt.Errorf("attachments are %.1f MB, over the %d MB email limit (REPORT_EMAIL_MAX_MB); use folder delivery",
+			float64(total)/(1<<20), limit>>20)
+	}
+
+	var msg bytes.Buffer
+	mw := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	part.Write([]byte(body))
+
+	for _, f := range files {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {f.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": f.Name})},
+		})
+		if err != nil {
+			return err
+		}
+		data, err := f.bytes()
+		if err != nil {
+			return err
+		}
+		// 76-character lines as required by RFC 2045
+		encoded := base64.StdEncoding.EncodeToString(data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if cfg.username != "" {
+		auth = smtp.PlainAuth("", cfg.username, cfg.password, cfg.host)
+	}
+	sender := cfg.from
+	if addr, err := mail.ParseAddress(cfg.from); err == nil {
+		sender = addr.Address
+	}
+	return smtp.SendMail(cfg.host+":"+cfg.port, auth, sender, recipients, msg.Bytes())
+}
+
+// writeReportFiles saves the files into folder. Each file is written under a temporary
+// name and renamed, so anything watching the drop folder never picks up a partial file.
+func writeReportFiles(folder string, files []ReportFile) error {
+	if folder == "" {
+		return fmt.Errorf("no drop folder configured (schedule folder or REPORT_DROP_FOLDER)")
+	}
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		path := filepath.Join(folder, f.Name)
+		tmp := path + ".part"
+		if err := copyReportFile(tmp, f); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
+
+// copyReportFile writes f's content to path. Spooled files are copied rather than
+// renamed since the drop folder is often on another drive or a network share.
+func copyReportFile(path string, f ReportFile) error {
+	src, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpooledReportFolderDelivery(t *testing.T) {
+	file, err := spoolReportFile("templog_2025-01-01_2025-01-31.csv", "text/csv; charset=utf-8", func(w io.Writer) error {
+		_, err := io.WriteString(w, "Time,Value,Status\n")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("spool: %v", err)
+	}
+	defer removeReportFiles([]ReportFile{file})
+
+	folder := t.TempDir()
+	if err := writeReportFiles(folder, []ReportFile{file, {Name: "summary.csv", Data: []byte("x")}}); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(folder, file.Name))
+	if err != nil || string(got) != "Time,Value,Status\n" {
+		t.Errorf("delivered %q, %v", got, err)
+	}
+
+	removeReportFiles([]ReportFile{file})
+	if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+		t.Errorf("spooled file left behind: %v", err)
+	}
+}
+
+func TestReportEmailSizeLimit(t *testing.T) {
+	t.Setenv("SMTP_HOST", "smtp.invalid")
+	t.Setenv("SMTP_FROM", "tms@example.com")
+	t.Setenv("REPORT_EMAIL_MAX_MB", "1")
+
+	big := ReportFile{Name: "templog.csv", Data: make([]byte, 1<<20+1)}
+	err := sendReportEmail([]string{"qa@example.com"}, "report", "body", []ReportFile{big})
+	if err == nil || !strings.Contains(err.Error(), "REPORT_EMAIL_MAX_MB") {
+		t.Fatalf("oversized attachment: err = %v, want the size limit", err)
+	}
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/repository"
+)
+
+// Report export formats
+const (
+	ReportFormatCSV  = "csv"
+	ReportFormatXLSX = "xlsx"
+)
+
+// ErrUnknownReportFormat is returned for an export format other than csv or xlsx
+var ErrUnknownReportFormat = errors.New("format must be csv or xlsx")
+
+// reportExportColumns are the temp_log columns writeReportCSV/writeReportXLSX expect,
+// ordered by reportExportOrder so each probe forms one contiguous section
+const (
+	reportExportColumns = "machine_ip, probe_no, temp_value, insert_time"
+	reportExportOrder   = "machine_ip, probe_no, insert_time"
+)
+
+// TempLogExport is an open cursor over a temp_log query, written as CSV or XLSX with one
+// section/sheet per probe. Rows are streamed, so month-long ranges are never loaded into
+// memory at once. Used by the report download and by scheduled monthly archives.
+type TempLogExport struct {
+	Format    string
+	StartDate string
+	EndDate   string
+	db        *gorm.DB // scans the cursor
+	rows      *sql.Rows
+	probes    map[string]ReportProbe
+}
+
+// OpenTempLogExport starts an export of query (a scoped temp_log query) with the probe
+// headers of devices. The caller must Write or Close it.
+func OpenTempLogExport(devices repository.DeviceRepo, query *gorm.DB, format, startDate, endDate string) (*TempLogExport, error) {
+	if format != ReportFormatCSV && format != ReportFormatXLSX {
+		return nil, ErrUnknownReportFormat
+	}
+
+	probes, err := LoadReportProbes(devices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load machines for export: %w", err)
+	}
+	rows, err := query.Select(reportExportColumns).Order(reportExportOrder).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query export rows: %w", err)
+	}
+	return &TempLogExport{Format: format, StartDate: startDate, EndDate: endDate, db: query, rows: rows, probes: probes}, nil
+}
+
+// FileName is the download/attachment name of the export
+func (e *TempLogExport) FileName() string {
+	return fmt.Sprintf("templog_%s_%s.%s", e.StartDate, e.EndDate, e.Format)
+}
+
+// ContentType is the MIME type of the export
+func (e *TempLogExport) ContentType() string {
+	if e.Format == ReportFormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+
+// Write writes the whole export to w and closes the cursor
+func (e *TempLogExport) Write(w io.Writer) error {
+	defer e.rows.Close()
+	if e.Format == ReportFormatCSV {
+		return writeReportCSV(w, e.db, e.rows, e.probes, e.StartDate, e.EndDate)
+	}
+	return writeReportXLSX(w, e.db, e.rows, e.probes, e.StartDate, e.EndDate)
+}
+
+// Close releases the cursor of an export that is not written
+func (e *TempLogExport) Close() error {
+	return e.rows.Close()
+}
+
+// exportRow is one temp_log row read from the export cursor
+type exportRow struct {
+	MachineIP  string    `gorm:"column:machine_ip"`
+	ProbeNo    int       `gorm:"column:probe_no"`
+	TempValue  *float64  `gorm:"column:temp_value"`
+	InsertTime time.Time `gorm:"column:insert_time"`
+}
+
+// ReportProbe is the per-probe header of an exported report section/sheet
+type ReportProbe struct {
+	MachineIP   string
+	ProbeNo     int
+	MachineName string
+	TypeLabel   string
+	Unit        string
+	MinTemp     float64
+	MaxTemp     float64
+	Configured  bool // false for readings of probes no longer in master_machine
+}
+
+// rangeFlag returns "HIGH"/"LOW" for an out-of-range value, "" otherwise
+func (p ReportProbe) rangeFlag(value float64) string {
+	switch {
+	case !p.Configured:
+		return ""
+	case value > p.MaxTemp:
+		return "HIGH"
+	case value < p.MinTemp:
+		return "LOW"
+	}
+	return ""
+}
+
+// LoadReportProbes returns the settings of every probe in devices keyed by "ip:probe"
+func LoadReportProbes(devices repository.DeviceRepo) (map[string]ReportProbe, error) {
+	machines, err := devices.List()
+	if err != nil {
+		return nil, err
+	}
+
+	probes := make(map[string]ReportProbe, len(machines))
+	for _, m := range machines {
+		probes[fmt.Sprintf("%s:%d", m.MachineIP, m.ProbeNo)] = ReportProbe{
+			MachineIP:   m.MachineIP,
+			ProbeNo:     m.ProbeNo,
+			MachineName: m.MachineName,
+			TypeLabel:   m.GetTypeLabel(),
+			Unit:        m.GetUnit(),
+			MinTemp:     m.GetMinTemp(),
+			MaxTemp:     m.GetMaxTemp(),
+			Configured:  true,
+		}
+	}
+	return probes, nil
+}
+
+// probeFor returns the settings for a row, falling back to the IP for unknown probes
+func probeFor(probes map[string]ReportProbe, row exportRow) ReportProbe {
+	if p, ok := probes[fmt.Sprintf("%s:%d", row.MachineIP, row.ProbeNo)]; ok {
+		return p
+	}
+	return ReportProbe{MachineIP: row.MachineIP, ProbeNo: row.ProbeNo, MachineName: row.MachineIP}
+}
+
+// eachProbeSection walks the cursor, calling start when a new probe begins and row for every reading
+func eachProbeSection(db *gorm.DB, rows *sql.Rows, probes map[string]ReportProbe, start func(ReportProbe) error, row func(ReportProbe, exportRow) error) error {
+	var current ReportProbe
+	started := false
+	for rows.Next() {
+		var r exportRow
+		if err := db.ScanRows(rows, &r); err != nil {
+			return err
+		}
+		if !started || r.MachineIP != current.MachineIP || r.ProbeNo != current.ProbeNo {
+			current = probeFor(probes, r)
+			started = true
+			if err := start(current); err != nil {
+				return err
+			}
+		}
+		if r.TempValue == nil {
+			continue
+		}
+		if err := row(current, r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// writeReportCSV writes one section per probe: a header block, then Time/Value/Status rows.
+// A UTF-8 BOM is written first so Excel shows Thai machine names correctly.
+func writeReportCSV(w io.Writer, db *gorm.DB, rows *sql.Rows, probes map[string]ReportProbe, startDate, endDate string) error {
+	io.WriteString(w, "\uFEFF")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Temperature report", startDate, endDate})
+
+	err := eachProbeSection(db, rows, probes,
+		func(p ReportProbe) error {
+			cw.Write([]string{})
+			cw.Write([]string{"Machine", p.MachineName, "Probe", strconv.Itoa(p.ProbeNo), "IP", p.MachineIP})
+			cw.Write([]string{"Type", p.TypeLabel, "Unit", p.Unit})
+			cw.Write([]string{"Min", p.limit(p.MinTemp), "Max", p.limit(p.MaxTemp)})
+			cw.Write([]string{"Time", "Value", "Status"})
+			return cw.Error()
+		},
+		func(p ReportProbe, r exportRow) error {
+			cw.Write([]string{
+				r.InsertTime.Format("2006-01-02 15:04:05"),
+				formatReportValue(*r.TempValue),
+				p.rangeFlag(*r.TempValue),
+			})
+			return cw.Error()
+		})
+
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// writeReportXLSX writes one sheet per probe with out-of-range values highlighted.
+// excelize's stream writer spills large sheets to temp files instead of memory.
+func writeReportXLSX(w io.Writer, db *gorm.DB, rows *sql.Rows, probes map[string]ReportProbe, startDate, endDate string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	boldStyle, _ := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	timeStyle, _ := f.NewStyle(&excelize.Style{CustomNumFmt: strPtr("yyyy-mm-dd hh:mm:ss")})
+	valueStyle, _ := f.NewStyle(&excelize.Style{NumFmt: 2}) // 0.00
+	alarmStyle, _ := f.NewStyle(&excelize.Style{
+		NumFmt: 2,
+		Font:   &excelize.Font{Color: "9C0006"},
+		Fill:   excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFC7CE"}},
+	})
+
+	var sw *excelize.StreamWriter
+	rowNum := 0
+	usedNames := make(map[string]bool)
+
+	err := eachProbeSection(db, rows, probes,
+		func(p ReportProbe) error {
+			if sw != nil {
+				if err := sw.Flush(); err != nil {
+					return err
+				}
+			}
+
+			name := reportSheetName(p, usedNames)
+			if _, err := f.NewSheet(name); err != nil {
+				return err
+			}
+			var err error
+			if sw, err = f.NewStreamWriter(name); err != nil {
+				return err
+			}
+			sw.SetColWidth(1, 1, 22)
+			sw.SetColWidth(2, 3, 12)
+
+			header := [][]interface{}{
+				{excelize.Cell{StyleID: boldStyle, Value: "Machine"}, p.MachineName, "Probe", p.ProbeNo, "IP", p.MachineIP},
+				{excelize.Cell{StyleID: boldStyle, Value: "Type"}, p.TypeLabel, "Unit", p.Unit},
+				{excelize.Cell{StyleID: boldStyle, Value: "Min"}, p.limit(p.MinTemp), "Max", p.limit(p.MaxTemp)},
+				{excelize.Cell{StyleID: boldStyle, Value: "Period"}, startDate, endDate},
+				{},
+				{
+					excelize.Cell{StyleID: boldStyle, Value: "Time"},
+					excelize.Cell{StyleID: boldStyle, Value: "Value (" + p.Unit + ")"},
+					excelize.Cell{StyleID: boldStyle, Value: "Status"},
+				},
+			}
+			for i, values := range header {
+				cell, _ := excelize.CoordinatesToCellName(1, i+1)
+				if err := sw.SetRow(cell, values); err != nil {
+					return err
+				}
+			}
+			rowNum = len(header)
+			return nil
+		},
+		func(p ReportProbe, r exportRow) error {
+			rowNum++
+			style := valueStyle
+			flag := p.rangeFlag(*r.TempValue)
+			if flag != "" {
+				style = alarmStyle
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, rowNum)
+			return sw.SetRow(cell, []interface{}{
+				excelize.Cell{StyleID: timeStyle, Value: excelWallClock(r.InsertTime)},
+				excelize.Cell{StyleID: style, Value: *r.TempValue},
+				flag,
+			})
+		})
+	if err != nil {
+		return err
+	}
+
+	if sw != nil {
+		if err := sw.Flush(); err != nil {
+			return err
+		}
+		// Drop the default empty sheet once there is real data
+		f.DeleteSheet("Sheet1")
+		f.SetActiveSheet(0)
+	} else {
+		f.SetCellValue("Sheet1", "A1", "No data for "+startDate+" - "+endDate)
+	}
+
+	return f.Write(w)
+}
+
+// reportSheetName builds a unique Excel sheet name (max 31 chars, no []:*?/\)
+func reportSheetName(p ReportProbe, used map[string]bool) string {
+	name := strings.NewReplacer("[", "(", "]", ")", ":", "-", "*", "", "?", "", "/", "-", "\\", "-").
+		Replace(p.MachineName)
+	suffix := fmt.Sprintf(" P%d", p.ProbeNo)
+	if runes := []rune(name); len(runes)+len(suffix) > 31 {
+		name = string(runes[:31-len(suffix)])
+	}
+	name += suffix
+
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		extra := fmt.Sprintf("~%d", i)
+		runes := []rune(name)
+		if len(runes)+len(extra) > 31 {
+			runes = runes[:31-len(extra)]
+		}
+		name = string(runes) + extra
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// excelWallClock keeps the local wall-clock time; excelize converts time.Time from UTC
+func excelWallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// limit formats a threshold, blank when the probe is not configured
+func (p ReportProbe) limit(v float64) string {
+	if !p.Configured {
+		return ""
+	}
+	return formatReportValue(v)
+}
+
+// formatReportValue formats a reading with two decimals
+func formatReportValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/utils"
+)
+
+// GlobalReportScheduler runs report_schedule entries in the background
+var GlobalReportScheduler *ReportScheduler
+
+// ReportScheduler checks report_schedule every minute and runs the schedules that are due
+type ReportScheduler struct {
+	db            *gorm.DB
+	repos         *repository.Set
+	checkInterval time.Duration
+	stopChan      chan struct{}
+	wg            sync.WaitGroup
+	running       bool
+	mu            sync.Mutex
+	runMu         sync.Mutex // one report run at a time, scheduled or manual
+}
+
+// NewReportScheduler creates a report scheduler reading schedules and report data from db
+// and devices and MKT settings from repos
+func NewReportScheduler(db *gorm.DB, repos *repository.Set) *ReportScheduler {
+	return &ReportScheduler{
+		db:            db,
+		repos:         repos,
+		checkInterval: time.Minute,
+		stopChan:      make(chan struct{}),
+	}
+}
+
+// Start the scheduler loop
+func (s *ReportScheduler) Start() {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	log.Println("Starting report scheduler...")
+	if IsEmailDeliveryEnabled() {
+		log.Println("- Report email delivery: ENABLED")
+	} else {
+		log.Println("- Report email delivery: DISABLED (SMTP_HOST not configured)")
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.checkInterval)
+		defer ticker.Stop()
+
+		s.runDue()
+		for {
+			select {
+			case <-ticker.C:
+				s.runDue()
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the scheduler loop and wait for a run in progress to finish
+func (s *ReportScheduler) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	s.mu.Unlock()
+
+	close(s.stopChan)
+	s.wg.Wait()
+	log.Println("Report scheduler stopped")
+}
+
+// runDue runs every enabled schedule whose next_run_at has passed. A schedule missed while
+// the backend was down runs once on the next check, then continues from its next slot.
+func (s *ReportScheduler) runDue() {
+	defer func() {
+		if r := recover(); r != nil {
+			utils.LogError("PANIC in report scheduler: %v", r)
+			log.Printf("PANIC in report scheduler: %v", r)
+		}
+	}()
+
+	now := database.SiteNow()
+	var schedules []models.ReportSchedule
+	if err := s.db.Where("enabled = ? AND (next_run_at IS NULL OR next_run_at <= ?)", true, now).
+		Order("next_run_at, id").
+		Find(&schedules).Error; err != nil {
+		utils.LogError("ReportScheduler - Failed to load schedules: %v", err)
+		return
+	}
+
+	for i := range schedules {
+		schedule := &schedules[i]
+		if schedule.NextRunAt == nil {
+			// Not scheduled yet (e.g. inserted directly into the table): wait for the first slot
+			next := NextReportRun(*schedule, now)
+			s.db.Model(schedule).Update("next_run_at", next)
+			continue
+		}
+
+		run, err := s.Run(schedule, "scheduler", now)
+		if err != nil {
+			utils.LogError("ReportScheduler - Schedule %d (%s) failed: %v", schedule.ID, schedule.Name, err)
+		} else {
+			log.Printf("Report schedule %d (%s) delivered: %s", schedule.ID, schedule.Name, run.Files)
+		}
+
+		next := NextReportRun(*schedule, now)
+		if err := s.db.Model(schedule).Update("next_run_at", next).Error; err != nil {
+			utils.LogError("ReportScheduler - Failed to set next run of schedule %d: %v", schedule.ID, err)
+		}
+	}
+}
+
+// Run renders and delivers one schedule for the period ending at the start of at's day,
+// recording the attempt in report_run. The run is returned even when it failed.
+func (s *ReportScheduler) Run(schedule *models.ReportSchedule, triggeredBy string, at time.Time) (*models.ReportRun, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	from, to := ReportPeriod(*schedule, at)
+	run := &models.ReportRun{
+		ScheduleID:  schedule.ID,
+		TriggeredBy: triggeredBy,
+		StartedAt:   database.SiteNow(),
+		Status:      models.ReportRunRunning,
+		PeriodStart: from,
+		PeriodEnd:   to,
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to record report run: %w", err)
+	}
+
+	deliveredTo, files, runErr := s.renderAndDeliver(schedule, from, to)
+
+	finished := database.SiteNow()
+	run.FinishedAt = &finished
+	run.Files = strings.Join(files, ",")
+	run.DeliveredTo = deliveredTo
+	run.Status = models.ReportRunSuccess
+	if runErr != nil {
+		run.Status = models.ReportRunFailed
+		run.Message = runErr.Error()
+	}
+	if err := s.db.Save(run).Error; err != nil {
+		utils.LogError("ReportScheduler - Failed to update report run %d: %v", run.ID, err)
+	}
+
+	schedule.LastRunAt = &finished
+	schedule.LastStatus = run.Status
+	if err := s.db.Model(schedule).Updates(map[string]interface{}{
+		"last_run_at": finished,
+		"last_status": run.Status,
+	}).Error; err != nil {
+		utils.LogError("ReportScheduler - Failed to update schedule %d: %v", schedule.ID, err)
+	}
+
+	return run, runErr
+}
+
+// renderAndDeliver renders the schedule's files and sends them. It returns where they went
+// and the file names, which are kept even when delivery fails.
+func (s *ReportScheduler) renderAndDeliver(schedule *models.ReportSchedule, from, to time.Time) (string, []string, error) {
+	files, err := s.renderScheduledReport(schedule, from, to)
+	if err != nil {
+		return "", nil, err
+	}
+	defer removeReportFiles(files)
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+
+	period := fmt.Sprintf("%s - %s", from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))
+	switch schedule.Delivery {
+	case models.ReportDeliveryEmail:
+		recipients := splitList(schedule.Recipients)
+		subject := fmt.Sprintf("[TMS] %s (%s)", schedule.Name, period)
+		body := fmt.Sprintf("รายงาน %s\r\nช่วงเวลา %s\r\n\r\nไฟล์แนบ:\r\n- %s\r\n",
+			schedule.Name, period, strings.Join(names, "\r\n- "))
+		if err := sendReportEmail(recipients, subject, body, files); err != nil {
+			return "", names, fmt.Errorf("email delivery failed: %w", err)
+		}
+		return strings.Join(recipients, ","), names, nil
+
+	case models.ReportDeliveryFolder:
+		folder := schedule.Folder
+		if folder == "" {
+			folder = ReportDropFolder()
+		}
+		if err := writeReportFiles(folder, files); err != nil {
+			return "", names, fmt.Errorf("folder delivery failed: %w", err)
+		}
+		return folder, names, nil
+	}
+	return "", names, fmt.Errorf("unknown delivery %q", schedule.Delivery)
+}
+
+// renderScheduledReport renders the files for one run over [from, to)
+func (s *ReportScheduler) renderScheduledReport(schedule *models.ReportSchedule, from, to time.Time) ([]ReportFile, error) {
+	machines, err := ResolveReportDevices(s.repos.Devices, schedule.Devices)
+	if err != nil {
+		return nil, err
+	}
+	if len(machines) == 0 {
+		return nil, fmt.Errorf("no devices match %q", schedule.Devices)
+	}
+
+	startDate, endDate := from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02")
+	var buf bytes.Buffer
+
+	switch schedule.ReportType {
+	case models.ReportTypeDailySummary:
+		if err := WriteSummaryCSV(&buf, s.db, s.repos.Config, machines, from, to); err != nil {
+			return nil, err
+		}
+		return []ReportFile{{
+			Name:        fmt.Sprintf("summary_%s_%s.csv", startDate, endDate),
+			ContentType: "text/csv; charset=utf-8",
+			Data:        buf.Bytes(),
+		}}, nil
+
+	case models.ReportTypeCompliancePDF:
+		files := make([]ReportFile, 0, len(machines))
+		for _, m := range machines {
+			report, err := BuildComplianceReport(s.db, s.repos, m.MachineIP, m.ProbeNo, from, to)
+			if err != nil {
+				return nil, fmt.Errorf("%s probe %d: %w", m.MachineIP, m.ProbeNo, err)
+			}
+			report.GeneratedBy = "scheduler: " + schedule.Name
+
+			var pdf bytes.Buffer
+			if err := report.WritePDF(&pdf); err != nil {
+				return nil, fmt.Errorf("%s probe %d: %w", m.MachineIP, m.ProbeNo, err)
+			}
+			files = append(files, ReportFile{
+				Name:        fmt.Sprintf("compliance_%s_P%d_%s_%s.pdf", m.MachineIP, m.ProbeNo, startDate, endDate),
+				ContentType: "application/pdf",
+				Data:        pdf.Bytes(),
+			})
+		}
+		return files, nil
+
+	case models.ReportTypeMonthlyArchive:
+		keys := make([][2]interface{}, len(machines))
+		for i, m := range machines {
+			keys[i] = [2]interface{}{m.MachineIP, m.ProbeNo}
+		}
+		query := s.db.Model(&models.TempLog{}).
+			Where("insert_time >= ? AND insert_time < ?", from, to)
+		export, err := OpenTempLogExport(s.repos.Devices, database.WherePairs(query, "machine_ip", "probe_no", keys),
+			ReportFormatCSV, startDate, endDate)
+		if err != nil {
+			return nil, err
+		}
+		// A month of raw readings for every probe: stream it to disk, not into memory
+		file, err := spoolReportFile(export.FileName(), export.ContentType(), func(w io.Writer) error {
+			return export.Write(w)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []ReportFile{file}, nil
+	}
+	return nil, fmt.Errorf("unknown report type %q", schedule.ReportType)
+}
+
+// ReportPeriod returns the whole-day period [from, to) a schedule reports on when run at at:
+// the previous day, the previous 7 days, or the previous calendar month
+func ReportPeriod(schedule models.ReportSchedule, at time.Time) (time.Time, time.Time) {
+	to := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	switch schedule.Frequency {
+	case models.ReportFrequencyWeekly:
+		return to.AddDate(0, 0, -7), to
+	case models.ReportFrequencyMonthly:
+		to = time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, at.Location())
+		return to.AddDate(0, -1, 0), to
+	}
+	return to.AddDate(0, 0, -1), to
+}
+
+// NextReportRun returns the first scheduled time strictly after after
+func NextReportRun(schedule models.ReportSchedule, after time.Time) time.Time {
+	hour, minute := 7, 0
+	if t, err := time.Parse("15:04", schedule.TimeOfDay); err == nil {
+		hour, minute = t.Hour(), t.Minute()
+	}
+
+	day := time.Date(after.Year(), after.Month(), after.Day(), hour, minute, 0, 0, after.Location())
+	for i := 0; i < 62; i++ {
+		candidate := day.AddDate(0, 0, i)
+		if !candidate.After(after) {
+			continue
+		}
+		switch schedule.Frequency {
+		case models.ReportFrequencyWeekly:
+			if int(candidate.Weekday()) != schedule.Weekday {
+				continue
+			}
+		case models.ReportFrequencyMonthly:
+			if candidate.Day() != schedule.DayOfMonth {
+				continue
+			}
+		}
+		return candidate
+	}
+	return day.AddDate(0, 0, 1)
+}
+
+// splitList splits a comma-separated setting, dropping blanks
+func splitList(s string) []string {
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// summaryStats is one probe's aggregate over the summary period
+type summaryStats struct {
+	MachineIP  string  `gorm:"column:machine_ip"`
+	ProbeNo    int     `gorm:"column:probe_no"`
+	Readings   int     `gorm:"column:readings"`
+	MinValue   float64 `gorm:"column:min_value"`
+	MaxValue   float64 `gorm:"column:max_value"`
+	AvgValue   float64 `gorm:"column:avg_value"`
+	OutOfRange int     `gorm:"column:out_of_range"`
+}
+
+// WriteSummaryCSV writes one line per probe with the reading count, min/avg/max,
+// out-of-range count and excursions (temp_error rows) over [from, to), read from db with the
+// MKT settings of config
+func WriteSummaryCSV(w io.Writer, db *gorm.DB, config repository.ConfigRepo, machines []models.MasterMachine, from, to time.Time) error {
+	var stats []summaryStats
+	if err := db.Raw(`SELECT t.machine_ip, t.probe_no,
+			COUNT(t.temp_value) AS readings,
+			MIN(t.temp_value) AS min_value,
+			MAX(t.temp_value) AS max_value,
+			AVG(t.temp_value) AS avg_value,
+			SUM(CASE WHEN t.temp_value < COALESCE(m.min_temp, 0) OR t.temp_value > COALESCE(m.max_temp, 100) THEN 1 ELSE 0 END) AS out_of_range
+		FROM temp_log t
+		JOIN master_machine m ON m.machine_ip = t.machine_ip AND m.probe_no = t.probe_no
+		WHERE t.insert_time >= ? AND t.insert_time < ? AND t.temp_value IS NOT NULL
+		GROUP BY t.machine_ip, t.probe_no`, from, to).
+		Scan(&stats).Error; err != nil {
+		return fmt.Errorf("failed to aggregate temp_log: %w", err)
+	}
+	statsByProbe := make(map[string]summaryStats, len(stats))
+	for _, s := range stats {
+		statsByProbe[fmt.Sprintf("%s:%d", s.MachineIP, s.ProbeNo)] = s
+	}
+
+	var excursions []struct {
+		MachineIP string `gorm:"column:machine_ip"`
+		ProbeNo   int    `gorm:"column:probe_no"`
+		Count     int    `gorm:"column:count"`
+	}
+	if err := db.Model(&models.TempError{}).
+		Select("machine_ip, probe_no, COUNT(*) AS count").
+		Where("error_time >= ? AND error_time < ?", from, to).
+		Group("machine_ip, probe_no").
+		Scan(&excursions).Error; err != nil {
+		return fmt.Errorf("failed to count temp_error: %w", err)
+	}
+	excursionsByProbe := make(map[string]int, len(excursions))
+	for _, e := range excursions {
+		excursionsByProbe[fmt.Sprintf("%s:%d", e.MachineIP, e.ProbeNo)] = e.Count
+	}
+
+	mktSettings, err := LoadMKTSettings(config)
+	if err != nil {
+		return fmt.Errorf("failed to load MKT settings: %w", err)
+	}
+
+	io.WriteString(w, "\uFEFF")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Daily summary", from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04")})
+	cw.Write([]string{"Machine IP", "Probe", "Machine Name", "Type", "Unit", "Min Limit", "Max Limit",
+		"Readings", "Min", "Avg", "Max", "Out of Range", "In Range %", "Excursions",
+		fmt.Sprintf("MKT %d Days", MKTRollingDays), "MKT Limit"})
+
+	for _, m := range machines {
+		key := fmt.Sprintf("%s:%d", m.MachineIP, m.ProbeNo)
+		line := []string{m.MachineIP, strconv.Itoa(m.ProbeNo), m.MachineName, m.GetTypeLabel(), m.GetUnit(),
+			formatReportValue(m.GetMinTemp()), formatReportValue(m.GetMaxTemp())}
+
+		if s, ok := statsByProbe[key]; ok && s.Readings > 0 {
+			inRange := 100 * float64(s.Readings-s.OutOfRange) / float64(s.Readings)
+			line = append(line, strconv.Itoa(s.Readings), formatReportValue(s.MinValue), formatReportValue(s.AvgValue),
+				formatReportValue(s.MaxValue), strconv.Itoa(s.OutOfRange), strconv.FormatFloat(inRange, 'f', 1, 64))
+		} else {
+			line = append(line, "0", "", "", "", "0", "")
+		}
+		line = append(line, strconv.Itoa(excursionsByProbe[key]))
+
+		// Rolling MKT up to the end of the summary period, for temperature probes
+		if m.IsTemperatureType() {
+			limit := mktSettings.LimitFor(m.MachineIP, m.ProbeNo)
+			window, err := CalculateMKT(db, m.MachineIP, m.ProbeNo, to.AddDate(0, 0, -MKTRollingDays), to, mktSettings.ActivationEnergy, limit)
+			if err != nil {
+				return fmt.Errorf("failed to calculate MKT for %s: %w", key, err)
+			}
+			mkt := ""
+			if window.MKT != nil {
+				mkt = formatReportValue(*window.MKT)
+			}
+			line = append(line, mkt, formatReportValue(limit))
+		} else {
+			line = append(line, "", "")
+		}
+		cw.Write(line)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,554 @@
+package services
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/utils"
+)
+
+// GlobalRetentionService archives and deletes old temp_log rows
+var GlobalRetentionService *RetentionService
+
+// archiveColumns are the columns of an archive file, in order
+var archiveColumns = []string{"machine_ip", "probe_no", "mcu_id", "temp_value", "real_value", "status", "send_time", "insert_time", "sDate", "sTime"}
+
+// archiveTimeLayout is how datetimes are written into archive files (site local time)
+const archiveTimeLayout = "2006-01-02 15:04:05.000"
+
+// restoredKeepDays is how long a restored month stays in temp_log before it is archived again
+const restoredKeepDays = 30
+
+// RetentionService enforces the retention policy once a day:
+//   - raw temp_log rows older than RETENTION_RAW_MONTHS whole months are exported, one file per
+//     month, to ARCHIVE_DIR as CSV.gz and then deleted (the month's rollups are rebuilt first)
+//   - temp_log_hourly/temp_log_daily rows older than RETENTION_ROLLUP_YEARS are deleted
+//
+// A value of 0 (the default) keeps that data forever.
+type RetentionService struct {
+	db          *gorm.DB
+	rollups     *RollupService // rebuilt for a month before its rows are archived; may be nil
+	rawMonths   int
+	rollupYears int
+	archiveDir  string
+	interval    time.Duration
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+	running     bool
+	mu          sync.Mutex
+	runMu       sync.Mutex
+}
+
+// NewRetentionService creates a retention service on db, configured from the environment
+func NewRetentionService(db *gorm.DB, rollups *RollupService) *RetentionService {
+	return &RetentionService{
+		db:          db,
+		rollups:     rollups,
+		rawMonths:   intFromEnv("RETENTION_RAW_MONTHS", 0),
+		rollupYears: intFromEnv("RETENTION_ROLLUP_YEARS", 0),
+		archiveDir:  getEnvDefault("ARCHIVE_DIR", "archive"),
+		interval:    24 * time.Hour,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// intFromEnv parses a non-negative integer from the environment, falling back to def
+func intFromEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		utils.LogError("%s - Invalid value %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// IsEnabled returns whether any retention limit is configured
+func (s *RetentionService) IsEnabled() bool {
+	return s.rawMonths > 0 || s.rollupYears > 0
+}
+
+// Start the daily retention loop
+func (s *RetentionService) Start() {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	if !s.IsEnabled() {
+		log.Println("- Data retention: DISABLED (RETENTION_RAW_MONTHS / RETENTION_ROLLUP_YEARS not set)")
+		return
+	}
+	log.Printf("- Data retention: raw %d months, rollups %d years, archive dir %s", s.rawMonths, s.rollupYears, s.archiveDir)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		s.runCycle()
+		for {
+			select {
+			case <-ticker.C:
+				s.runCycle()
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the retention loop; an archive in progress finishes its current month first
+func (s *RetentionService) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	s.mu.Unlock()
+
+	close(s.stopChan)
+	s.wg.Wait()
+	log.Println("Retention service stopped")
+}
+
+func (s *RetentionService) runCycle() {
+	defer func() {
+		if r := recover(); r != nil {
+			utils.LogError("PANIC in retention service: %v", r)
+			log.Printf("PANIC in retention service: %v", r)
+		}
+	}()
+
+	if _, err := s.Run(); err != nil {
+		utils.LogError("Retention - %v", err)
+	}
+}
+
+// Run applies the retention policy now and returns the archives it created
+func (s *RetentionService) Run() ([]models.TempLogArchive, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	now := database.SiteNow()
+	archives := make([]models.TempLogArchive, 0)
+
+	if s.rawMonths > 0 {
+		cutoff := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -s.rawMonths, 0)
+		oldest, err := oldestReading(s.db.Where("insert_time < ?", cutoff))
+		if err != nil {
+			return archives, fmt.Errorf("failed to find oldest temp_log row: %w", err)
+		}
+		if !oldest.IsZero() {
+			first := oldest.In(now.Location())
+			for month := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, now.Location()); month.Before(cutoff); month = month.AddDate(0, 1, 0) {
+				select {
+				case <-s.stopChan:
+					return archives, nil
+				default:
+				}
+
+				archive, err := s.archiveMonth(month, now)
+				if err != nil {
+					return archives, fmt.Errorf("failed to archive %s: %w", month.Format("2006-01"), err)
+				}
+				if archive != nil {
+					archives = append(archives, *archive)
+				}
+			}
+		}
+	}
+
+	if s.rollupYears > 0 {
+		cutoff := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location()).AddDate(-s.rollupYears, 0, 0)
+		for _, model := range []interface{}{&models.TempLogHourly{}, &models.TempLogDaily{}} {
+			result := s.db.Where("bucket_start < ?", cutoff).Delete(model)
+			if result.Error != nil {
+				return archives, fmt.Errorf("failed to delete old rollups: %w", result.Error)
+			}
+			if result.RowsAffected > 0 {
+				log.Printf("Retention: deleted %d rollup rows before %s", result.RowsAffected, cutoff.Format("2006-01-02"))
+			}
+		}
+	}
+	return archives, nil
+}
+
+// archiveMonth exports the temp_log rows of one month to a CSV.gz file and deletes them.
+// Only the rows in the file are deleted: readings stored for the month while it was being
+// exported stay in temp_log and go into another file on the next run.
+func (s *RetentionService) archiveMonth(month, now time.Time) (*models.TempLogArchive, error) {
+	monthEnd := month.AddDate(0, 1, 0)
+
+	var recent int64
+	if err := s.db.Model(&models.TempLogArchive{}).
+		Where("period_start = ? AND status = ? AND restored_at > ?", month, models.ArchiveStatusRestored, now.AddDate(0, 0, -restoredKeepDays)).
+		Count(&recent).Error; err != nil {
+		return nil, err
+	}
+	if recent > 0 {
+		return nil, nil // restored on request; keep it around for a while
+	}
+
+	monthQuery := func() *gorm.DB {
+		return s.db.Model(&models.TempLog{}).Where("insert_time >= ? AND insert_time < ?", month, monthEnd)
+	}
+	var count int64
+	if err := monthQuery().Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	// Keep the long-range charts complete before the raw rows go
+	if s.rollups != nil {
+		if err := s.rollups.Rebuild(month, monthEnd); err != nil {
+			return nil, fmt.Errorf("failed to rebuild rollups: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(s.archiveDir, 0755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("temp_log_%s.csv.gz", month.Format("2006-01"))
+	if _, err := os.Stat(filepath.Join(s.archiveDir, name)); err == nil {
+		name = fmt.Sprintf("temp_log_%s_%s.csv.gz", month.Format("2006-01"), now.Format("20060102T150405"))
+	}
+	path := filepath.Join(s.archiveDir, name)
+
+	log.Printf("Retention: archiving %d temp_log rows of %s to %s...", count, month.Format("2006-01"), path)
+	written, size, sum, err := writeArchiveFile(path, monthQuery())
+	if err != nil {
+		return nil, err
+	}
+
+	absPath, _ := filepath.Abs(path)
+	archive := models.TempLogArchive{
+		PeriodStart: month,
+		PeriodEnd:   monthEnd,
+		FileName:    name,
+		FilePath:    absPath,
+		RowCount:    written,
+		SizeBytes:   size,
+		SHA256:      sum,
+		Status:      models.ArchiveStatusArchived,
+		ArchivedAt:  now,
+	}
+	if err := s.db.Create(&archive).Error; err != nil {
+		return nil, fmt.Errorf("failed to record archive: %w", err)
+	}
+
+	deleted, err := s.deleteArchivedRows(path)
+	if err != nil {
+		return &archive, fmt.Errorf("archived to %s but failed to delete the rows: %w", path, err)
+	}
+	log.Printf("Retention: archived %d and deleted %d rows of %s", written, deleted, month.Format("2006-01"))
+	return &archive, nil
+}
+
+// writeArchiveFile streams the rows of query into a gzip-compressed CSV at path and returns the
+// row count, file size and SHA-256. The file is written under a temporary name, flushed to
+// disk and renamed, and the rename is flushed too: the rows are deleted right after, so the
+// archive must survive a power loss.
+func writeArchiveFile(path string, query *gorm.DB) (int64, int64, string, error) {
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	fail := func(err error) (int64, int64, string, error) {
+		f.Close()
+		os.Remove(tmp)
+		return 0, 0, "", err
+	}
+
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(f, hash))
+	w := csv.NewWriter(gz)
+	if err := w.Write(archiveColumns); err != nil {
+		return fail(err)
+	}
+
+	rows, err := query.Order("insert_time, machine_ip, probe_no").Rows()
+	if err != nil {
+		return fail(err)
+	}
+	defer rows.Close()
+
+	var written int64
+	for rows.Next() {
+		var t models.TempLog
+		if err := query.ScanRows(rows, &t); err != nil {
+			return fail(err)
+		}
+		if err := w.Write(archiveRecord(t)); err != nil {
+			return fail(err)
+		}
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fail(err)
+	}
+	if err := gz.Close(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, 0, "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, 0, "", err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		os.Remove(path)
+		return 0, 0, "", fmt.Errorf("failed to flush %s: %w", filepath.Dir(path), err)
+	}
+	return written, info.Size(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// syncDir flushes a directory so a file renamed into it is on disk. Windows cannot flush a
+// directory handle; NTFS journals the rename itself.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// archiveRecord formats a temp_log row as archiveColumns; NULL is written as an empty field
+func archiveRecord(t models.TempLog) []string {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	record := []string{t.MachineIP, strconv.Itoa(t.ProbeNo), str(t.McuID), "", "", str(t.Status), "", t.InsertTime.Format(archiveTimeLayout), str(t.SDate), str(t.STime)}
+	if t.TempValue != nil {
+		record[3] = strconv.FormatFloat(*t.TempValue, 'f', -1, 64)
+	}
+	if t.RealValue != nil {
+		record[4] = strconv.Itoa(*t.RealValue)
+	}
+	if t.SendTime != nil {
+		record[6] = t.SendTime.Format(archiveTimeLayout)
+	}
+	return record
+}
+
+// parseArchiveRecord is the inverse of archiveRecord
+func parseArchiveRecord(record []string, loc *time.Location) (models.TempLog, error) {
+	var t models.TempLog
+	if len(record) != len(archiveColumns) {
+		return t, fmt.Errorf("expected %d fields, got %d", len(archiveColumns), len(record))
+	}
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+
+	var err error
+	t.MachineIP = record[0]
+	if t.ProbeNo, err = strconv.Atoi(record[1]); err != nil {
+		return t, fmt.Errorf("probe_no: %w", err)
+	}
+	t.McuID = optional(record[2])
+	if record[3] != "" {
+		v, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return t, fmt.Errorf("temp_value: %w", err)
+		}
+		t.TempValue = &v
+	}
+	if record[4] != "" {
+		v, err := strconv.Atoi(record[4])
+		if err != nil {
+			return t, fmt.Errorf("real_value: %w", err)
+		}
+		t.RealValue = &v
+	}
+	t.Status = optional(record[5])
+	if record[6] != "" {
+		v, err := time.ParseInLocation(archiveTimeLayout, record[6], loc)
+		if err != nil {
+			return t, fmt.Errorf("send_time: %w", err)
+		}
+		t.SendTime = &v
+	}
+	if t.InsertTime, err = time.ParseInLocation(archiveTimeLayout, record[7], loc); err != nil {
+		return t, fmt.Errorf("insert_time: %w", err)
+	}
+	t.SDate = optional(record[8])
+	t.STime = optional(record[9])
+	return t, nil
+}
+
+// readArchiveFile calls fn for each row of an archive file and returns the number of rows read
+func readArchiveFile(path string, fn func(models.TempLog) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, fmt.Errorf("not a gzip file: %w", err)
+	}
+	defer gz.Close()
+
+	r := csv.NewReader(gz)
+	header, err := r.Read()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read header: %w", err)
+	}
+	if len(header) != len(archiveColumns) {
+		return 0, errors.New("not a temp_log archive (unexpected header)")
+	}
+	for i, column := range archiveColumns {
+		if header[i] != column {
+			return 0, errors.New("not a temp_log archive (unexpected header)")
+		}
+	}
+
+	loc := database.SiteLocation()
+	var read int64
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return read, nil
+		}
+		if err != nil {
+			return read, fmt.Errorf("line %d: %w", read+2, err)
+		}
+		t, err := parseArchiveRecord(record, loc)
+		if err != nil {
+			return read, fmt.Errorf("line %d: %w", read+2, err)
+		}
+		read++
+		if err := fn(t); err != nil {
+			return read, err
+		}
+	}
+}
+
+// deleteArchivedRows deletes the temp_log rows listed in an archive file by primary key,
+// so rows stored after the file was written are never deleted unarchived
+func (s *RetentionService) deleteArchivedRows(path string) (int64, error) {
+	var deleted int64
+	keys := make([][]interface{}, 0, 500)
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		result := s.db.Where("(machine_ip, probe_no, insert_time) IN ?", keys).Delete(&models.TempLog{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted += result.RowsAffected
+		keys = keys[:0]
+		return nil
+	}
+	_, err := readArchiveFile(path, func(t models.TempLog) error {
+		keys = append(keys, []interface{}{t.MachineIP, t.ProbeNo, t.InsertTime})
+		if len(keys) == cap(keys) {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return deleted, err
+}
+
+// RestoreArchive re-imports an archive file into temp_log. Rows already present are skipped.
+// The matching temp_log_archive record (by file name) is marked restored, which keeps the month
+// out of the next archive runs for restoredKeepDays. Returns rows read and rows inserted.
+func (s *RetentionService) RestoreArchive(path, restoredBy string) (int64, int64, error) {
+	var inserted int64
+	batch := make([]models.TempLog, 0, 500)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&batch)
+		if result.Error != nil {
+			return result.Error
+		}
+		inserted += result.RowsAffected
+		batch = batch[:0]
+		return nil
+	}
+	read, err := readArchiveFile(path, func(t models.TempLog) error {
+		batch = append(batch, t)
+		if len(batch) == cap(batch) {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return read, inserted, err
+	}
+
+	now := database.SiteNow()
+	if err := s.db.Model(&models.TempLogArchive{}).Where("file_name = ?", filepath.Base(path)).
+		Updates(map[string]interface{}{
+			"status":        models.ArchiveStatusRestored,
+			"restored_at":   now,
+			"restored_rows": inserted,
+			"restored_by":   restoredBy,
+		}).Error; err != nil {
+		return read, inserted, fmt.Errorf("restored but failed to update temp_log_archive: %w", err)
+	}
+	log.Printf("Restored %d of %d rows from %s", inserted, read, path)
+	return read, inserted, nil
+}
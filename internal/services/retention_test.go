@@ -0,0 +1,89 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+)
+
+func TestArchiveDeleteAndRestore(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		loc := database.SiteLocation()
+		month := time.Date(2025, 1, 1, 0, 0, 0, 0, loc)
+		monthEnd := month.AddDate(0, 1, 0)
+
+		value, real := 4.5, 450
+		status := "normal"
+		logs := []models.TempLog{
+			{MachineIP: "10.0.0.5", ProbeNo: 1, TempValue: &value, RealValue: &real, Status: &status, InsertTime: month.Add(time.Hour)},
+			{MachineIP: "10.0.0.5", ProbeNo: 2, InsertTime: month.Add(time.Hour + 123*time.Millisecond)},
+			{MachineIP: "10.0.0.6", ProbeNo: 1, TempValue: &value, InsertTime: monthEnd.Add(-time.Minute)},
+			{MachineIP: "10.0.0.6", ProbeNo: 1, TempValue: &value, InsertTime: monthEnd}, // next month
+		}
+		if err := db.Create(&logs).Error; err != nil {
+			t.Fatal(err)
+		}
+		count := func() int64 {
+			var n int64
+			if err := db.Model(&models.TempLog{}).Count(&n).Error; err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+
+		path := filepath.Join(t.TempDir(), "temp_log_2025-01.csv.gz")
+		written, _, _, err := writeArchiveFile(path, db.Model(&models.TempLog{}).Where("insert_time >= ? AND insert_time < ?", month, monthEnd))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written != 3 {
+			t.Fatalf("written = %d, want 3", written)
+		}
+
+		// A reading of the month stored while the file was being written is not in it
+		late := models.TempLog{MachineIP: "10.0.0.5", ProbeNo: 1, TempValue: &value, InsertTime: month.Add(2 * time.Hour)}
+		if err := db.Create(&late).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		s := NewRetentionService(db, nil)
+		deleted, err := s.deleteArchivedRows(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 3 || count() != 2 {
+			t.Fatalf("deleted %d rows, %d left; want 3 deleted and 2 left", deleted, count())
+		}
+		var kept models.TempLog
+		if err := db.Where("insert_time >= ? AND insert_time < ?", month, monthEnd).First(&kept).Error; err != nil {
+			t.Fatalf("late reading was deleted: %v", err)
+		}
+
+		read, inserted, err := s.RestoreArchive(path, "tester")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read != 3 || inserted != 3 || count() != 5 {
+			t.Fatalf("restore read %d, inserted %d, %d rows in temp_log; want 3, 3, 5", read, inserted, count())
+		}
+		var restored models.TempLog
+		if err := db.Where("machine_ip = ? AND probe_no = ? AND insert_time = ?", "10.0.0.5", 1, month.Add(time.Hour)).First(&restored).Error; err != nil {
+			t.Fatal(err)
+		}
+		if restored.TempValue == nil || *restored.TempValue != value || restored.RealValue == nil || *restored.RealValue != real ||
+			restored.Status == nil || *restored.Status != status {
+			t.Errorf("restored row = %+v, want value %v, real %d, status %s", restored, value, real, status)
+		}
+
+		// Restoring again skips the rows already present
+		if _, inserted, err := s.RestoreArchive(path, "tester"); err != nil || inserted != 0 {
+			t.Errorf("second restore inserted %d (err %v), want 0", inserted, err)
+		}
+	})
+}
@@ -0,0 +1,421 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+	"tms-backend/internal/utils"
+)
+
+// GlobalRollupService maintains temp_log_hourly and temp_log_daily
+var GlobalRollupService *RollupService
+
+// rollupWatermarkKey is the config_value key holding the time up to which temp_log has been rolled up
+const rollupWatermarkKey = "rollup_watermark"
+
+// rollupWatermarkLayout is how the watermark is stored in config_value
+const rollupWatermarkLayout = "2006-01-02 15:04:05"
+
+// rollupLateHoursKey is the config_value key listing the hours (comma-separated, in
+// rollupWatermarkLayout) that received readings older than the lookback and still have to be
+// re-aggregated
+const rollupLateHoursKey = "rollup_late_hours"
+
+// RollupService aggregates temp_log into hourly and daily rollups in the background.
+// Each cycle re-aggregates from the watermark (or the lookback ago, whichever is earlier)
+// up to now, one chunk at a time. With an empty watermark the first cycles backfill the
+// whole history, so existing installations need no separate migration step.
+type RollupService struct {
+	db       *gorm.DB
+	config   repository.ConfigRepo // holds the rollup watermark
+	interval time.Duration
+	lookback time.Duration // late readings within this window are picked up automatically
+	chunk    time.Duration
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+	running  bool
+	mu       sync.Mutex
+	runMu    sync.Mutex
+
+	lateHours   map[string]bool // nil until loaded from rollupLateHoursKey
+	lateHoursMu sync.Mutex
+}
+
+// NewRollupService creates a rollup service aggregating on db and keeping its progress in config
+func NewRollupService(db *gorm.DB, config repository.ConfigRepo) *RollupService {
+	return &RollupService{
+		db:       db,
+		config:   config,
+		interval: 5 * time.Minute,
+		lookback: 3 * time.Hour,
+		chunk:    24 * time.Hour,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start the rollup loop
+func (s *RollupService) Start() {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	log.Printf("Starting rollup service (every %v)...", s.interval)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		s.runCycle()
+		for {
+			select {
+			case <-ticker.C:
+				s.runCycle()
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the rollup loop; a backfill in progress stops after its current chunk
+func (s *RollupService) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	s.mu.Unlock()
+
+	close(s.stopChan)
+	s.wg.Wait()
+	log.Println("Rollup service stopped")
+}
+
+// runCycle rolls up everything from the watermark to now
+func (s *RollupService) runCycle() {
+	defer func() {
+		if r := recover(); r != nil {
+			utils.LogError("PANIC in rollup service: %v", r)
+			log.Printf("PANIC in rollup service: %v", r)
+		}
+	}()
+
+	now := time.Now()
+	from, err := s.cycleStart(now)
+	if err != nil {
+		utils.LogError("Rollup - Failed to determine start: %v", err)
+		return
+	}
+	if from.IsZero() {
+		return // temp_log is empty
+	}
+
+	backfill := now.Sub(from) > s.chunk
+	if backfill {
+		log.Printf("Rollup backfill from %s...", from.Format(rollupWatermarkLayout))
+	}
+	for chunkStart := from; chunkStart.Before(now); chunkStart = chunkStart.Add(s.chunk) {
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+
+		chunkEnd := chunkStart.Add(s.chunk)
+		if chunkEnd.After(now) {
+			chunkEnd = now
+		}
+		if err := s.Rebuild(chunkStart, chunkEnd); err != nil {
+			utils.LogError("Rollup - Failed for %s - %s: %v", chunkStart.Format(rollupWatermarkLayout), chunkEnd.Format(rollupWatermarkLayout), err)
+			return
+		}
+		if err := s.config.Set(rollupWatermarkKey, chunkEnd.Format(rollupWatermarkLayout)); err != nil {
+			utils.LogError("Rollup - Failed to save watermark: %v", err)
+			return
+		}
+	}
+	if backfill {
+		log.Println("Rollup backfill complete")
+	}
+
+	s.rebuildLateHours()
+}
+
+// MarkLate records the hour of a reading stored after the lookback had already passed it
+// (gateway store-and-forward accepts readings up to 30 days old). The next cycle
+// re-aggregates those hours; the list is kept in config_value so a restart does not lose it.
+func (s *RollupService) MarkLate(insertTime time.Time) {
+	// The normal cycle still covers readings inside the lookback; an hour's margin for the
+	// time until it runs
+	if database.SiteNow().Sub(insertTime) < s.lookback-time.Hour {
+		return
+	}
+	hour := database.SiteTime(insertTime).Truncate(time.Hour).Format(rollupWatermarkLayout)
+
+	s.lateHoursMu.Lock()
+	defer s.lateHoursMu.Unlock()
+	if err := s.loadLateHours(); err != nil {
+		utils.LogError("Rollup - Failed to load %s: %v", rollupLateHoursKey, err)
+	}
+	if s.lateHours[hour] {
+		return
+	}
+	s.lateHours[hour] = true
+	if err := s.saveLateHours(); err != nil {
+		utils.LogError("Rollup - Failed to save %s: %v", rollupLateHoursKey, err)
+	}
+}
+
+// rebuildLateHours re-aggregates the hours recorded by MarkLate, consecutive hours together
+func (s *RollupService) rebuildLateHours() {
+	s.lateHoursMu.Lock()
+	if err := s.loadLateHours(); err != nil {
+		s.lateHoursMu.Unlock()
+		utils.LogError("Rollup - Failed to load %s: %v", rollupLateHoursKey, err)
+		return
+	}
+	hours := make([]time.Time, 0, len(s.lateHours))
+	for h := range s.lateHours {
+		t, err := time.ParseInLocation(rollupWatermarkLayout, h, database.SiteLocation())
+		if err != nil {
+			utils.LogError("Rollup - Ignoring invalid late hour %q: %v", h, err)
+			delete(s.lateHours, h)
+			continue
+		}
+		hours = append(hours, t)
+	}
+	s.lateHoursMu.Unlock()
+	if len(hours) == 0 {
+		return
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+
+	done := make([]time.Time, 0, len(hours))
+	for i := 0; i < len(hours); {
+		from, to := hours[i], hours[i].Add(time.Hour)
+		j := i + 1
+		for ; j < len(hours) && !hours[j].After(to); j++ {
+			to = hours[j].Add(time.Hour)
+		}
+		if err := s.Rebuild(from, to); err != nil {
+			utils.LogError("Rollup - Failed for late readings %s - %s: %v", from.Format(rollupWatermarkLayout), to.Format(rollupWatermarkLayout), err)
+			break
+		}
+		done = append(done, hours[i:j]...)
+		i = j
+	}
+	if len(done) > 0 {
+		log.Printf("Rollup re-aggregated %d hours with late readings", len(done))
+	}
+
+	s.lateHoursMu.Lock()
+	defer s.lateHoursMu.Unlock()
+	for _, h := range done {
+		delete(s.lateHours, h.Format(rollupWatermarkLayout))
+	}
+	if err := s.saveLateHours(); err != nil {
+		utils.LogError("Rollup - Failed to save %s: %v", rollupLateHoursKey, err)
+	}
+}
+
+// loadLateHours reads rollupLateHoursKey once. Must be called with lateHoursMu held.
+func (s *RollupService) loadLateHours() error {
+	if s.lateHours != nil {
+		return nil
+	}
+	value, err := s.config.Get(rollupLateHoursKey)
+	if err != nil {
+		return err
+	}
+	s.lateHours = make(map[string]bool)
+	for _, h := range splitList(value) {
+		s.lateHours[h] = true
+	}
+	return nil
+}
+
+// saveLateHours writes the pending hours back. Must be called with lateHoursMu held.
+func (s *RollupService) saveLateHours() error {
+	hours := make([]string, 0, len(s.lateHours))
+	for h := range s.lateHours {
+		hours = append(hours, h)
+	}
+	sort.Strings(hours)
+	return s.config.Set(rollupLateHoursKey, strings.Join(hours, ","))
+}
+
+// cycleStart returns the hour to start rolling up from: the watermark minus the lookback,
+// or the oldest temp_log reading when nothing has been rolled up yet
+func (s *RollupService) cycleStart(now time.Time) (time.Time, error) {
+	watermark, err := s.config.Get(rollupWatermarkKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if watermark != "" {
+		t, err := time.ParseInLocation(rollupWatermarkLayout, watermark, database.SiteLocation())
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s %q: %w", rollupWatermarkKey, watermark, err)
+		}
+		if lookback := now.Add(-s.lookback); lookback.Before(t) {
+			t = lookback
+		}
+		return t.Truncate(time.Hour), nil
+	}
+
+	oldest, err := oldestReading(s.db)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return oldest.Truncate(time.Hour), nil
+}
+
+// oldestReading returns the insert_time of the oldest temp_log row matching query, or the
+// zero time when there is none. It reads the row itself rather than MIN() so the value comes
+// back as a datetime on every driver (and the insert_time index serves it).
+func oldestReading(query *gorm.DB) (time.Time, error) {
+	var times []time.Time
+	if err := query.Model(&models.TempLog{}).Order("insert_time").Limit(1).Pluck("insert_time", &times).Error; err != nil {
+		return time.Time{}, err
+	}
+	if len(times) == 0 {
+		return time.Time{}, nil
+	}
+	return times[0], nil
+}
+
+// rollupRow is one aggregated (probe, bucket) row; the bucket comes back as text from
+// the database's truncation function
+type rollupRow struct {
+	MachineIP       string  `gorm:"column:machine_ip"`
+	ProbeNo         int     `gorm:"column:probe_no"`
+	Bucket          string  `gorm:"column:bucket"`
+	ReadingCount    int     `gorm:"column:reading_count"`
+	MinValue        float64 `gorm:"column:min_value"`
+	MaxValue        float64 `gorm:"column:max_value"`
+	SumValue        float64 `gorm:"column:sum_value"`
+	OutOfRangeCount int     `gorm:"column:out_of_range_count"`
+}
+
+// rollup converts the row into a TempLogRollup
+func (r rollupRow) rollup(loc *time.Location) (models.TempLogRollup, error) {
+	start, err := time.ParseInLocation(rollupWatermarkLayout, r.Bucket, loc)
+	if err != nil {
+		return models.TempLogRollup{}, fmt.Errorf("unexpected bucket %q: %w", r.Bucket, err)
+	}
+	rollup := models.TempLogRollup{
+		MachineIP:       r.MachineIP,
+		ProbeNo:         r.ProbeNo,
+		BucketStart:     start,
+		ReadingCount:    r.ReadingCount,
+		MinValue:        r.MinValue,
+		MaxValue:        r.MaxValue,
+		SumValue:        r.SumValue,
+		OutOfRangeCount: r.OutOfRangeCount,
+	}
+	if r.ReadingCount > 0 {
+		rollup.AvgValue = r.SumValue / float64(r.ReadingCount)
+	}
+	return rollup, nil
+}
+
+// Rebuild recomputes the hourly rollups of the hours in [from, to) and the daily rollups of the
+// days they fall on. Use it after importing or correcting older temp_log rows. Rollup rows in
+// the range are replaced, so probes whose readings were deleted lose their buckets too.
+func (s *RollupService) Rebuild(from, to time.Time) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	// Buckets are wall-clock hours and days in site time, like insert_time
+	loc := database.SiteLocation()
+	from = from.In(loc).Truncate(time.Hour)
+	to = to.In(loc)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("bucket_start >= ? AND bucket_start < ?", from, to).Delete(&models.TempLogHourly{}).Error; err != nil {
+			return fmt.Errorf("failed to clear temp_log_hourly: %w", err)
+		}
+		var rows []rollupRow
+		if err := tx.Table("temp_log t").
+			Select(fmt.Sprintf(`t.machine_ip, t.probe_no,
+				%s AS bucket,
+				COUNT(*) AS reading_count,
+				MIN(t.temp_value) AS min_value,
+				MAX(t.temp_value) AS max_value,
+				SUM(t.temp_value) AS sum_value,
+				SUM(CASE WHEN t.temp_value < COALESCE(m.min_temp, 0) OR t.temp_value > COALESCE(m.max_temp, 100) THEN 1 ELSE 0 END) AS out_of_range_count`,
+				database.HourBucket("t.insert_time"))).
+			Joins("LEFT JOIN master_machine m ON m.machine_ip = t.machine_ip AND m.probe_no = t.probe_no").
+			Where("t.insert_time >= ? AND t.insert_time < ? AND t.temp_value IS NOT NULL", from, to).
+			Group("t.machine_ip, t.probe_no, bucket").
+			Scan(&rows).Error; err != nil {
+			return fmt.Errorf("failed to aggregate temp_log: %w", err)
+		}
+		hourly := make([]models.TempLogHourly, 0, len(rows))
+		for _, r := range rows {
+			rollup, err := r.rollup(loc)
+			if err != nil {
+				return err
+			}
+			hourly = append(hourly, models.TempLogHourly{TempLogRollup: rollup})
+		}
+		if len(hourly) > 0 {
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&hourly, 500).Error; err != nil {
+				return fmt.Errorf("failed to save temp_log_hourly: %w", err)
+			}
+		}
+
+		// Daily rows are recomputed for whole days from the hourly rows already stored
+		dayFrom := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+		last := to.Add(-time.Nanosecond)
+		dayTo := time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+		if err := tx.Where("bucket_start >= ? AND bucket_start < ?", dayFrom, dayTo).Delete(&models.TempLogDaily{}).Error; err != nil {
+			return fmt.Errorf("failed to clear temp_log_daily: %w", err)
+		}
+		rows = nil
+		if err := tx.Model(&models.TempLogHourly{}).
+			Select(fmt.Sprintf(`machine_ip, probe_no,
+				%s AS bucket,
+				SUM(reading_count) AS reading_count,
+				MIN(min_value) AS min_value,
+				MAX(max_value) AS max_value,
+				SUM(sum_value) AS sum_value,
+				SUM(out_of_range_count) AS out_of_range_count`,
+				database.DayBucket("bucket_start"))).
+			Where("bucket_start >= ? AND bucket_start < ?", dayFrom, dayTo).
+			Group("machine_ip, probe_no, bucket").
+			Scan(&rows).Error; err != nil {
+			return fmt.Errorf("failed to aggregate temp_log_hourly: %w", err)
+		}
+		daily := make([]models.TempLogDaily, 0, len(rows))
+		for _, r := range rows {
+			rollup, err := r.rollup(loc)
+			if err != nil {
+				return err
+			}
+			daily = append(daily, models.TempLogDaily{TempLogRollup: rollup})
+		}
+		if len(daily) > 0 {
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&daily, 500).Error; err != nil {
+				return fmt.Errorf("failed to save temp_log_daily: %w", err)
+			}
+		}
+		return nil
+	})
+}
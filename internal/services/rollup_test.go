@@ -0,0 +1,104 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+func TestRollupMarkLateKeepsOldHours(t *testing.T) {
+	config := repository.NewMemory().Set().Config
+	s := NewRollupService(nil, config)
+	now := database.SiteNow()
+
+	older := now.Add(-72 * time.Hour).Truncate(time.Hour)
+	old := now.Add(-48 * time.Hour).Truncate(time.Hour)
+
+	s.MarkLate(now.Add(-10 * time.Minute)) // the normal cycle covers it
+	s.MarkLate(old.Add(5 * time.Minute))   // gateway backlog
+	s.MarkLate(old.Add(50 * time.Minute))  // same hour
+	s.MarkLate(older.Add(30 * time.Minute))
+
+	value, err := config.Get(rollupLateHoursKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := older.Format(rollupWatermarkLayout) + "," + old.Format(rollupWatermarkLayout)
+	if value != want {
+		t.Errorf("%s = %q, want %q", rollupLateHoursKey, value, want)
+	}
+
+	// A restarted service picks the list up again
+	restarted := NewRollupService(nil, config)
+	restarted.lateHoursMu.Lock()
+	err = restarted.loadLateHours()
+	pending := len(restarted.lateHours)
+	restarted.lateHoursMu.Unlock()
+	if err != nil || pending != 2 {
+		t.Errorf("after restart %d late hours pending (%v), want 2", pending, err)
+	}
+}
+
+func TestRollupRebuild(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		minTemp, maxTemp := 2.0, 8.0
+		if err := db.Create(&models.MasterMachine{MachineIP: "10.0.0.1", ProbeNo: 1, MinTemp: &minTemp, MaxTemp: &maxTemp}).Error; err != nil {
+			t.Fatal(err)
+		}
+		// Two hours late in the evening of the 6th (site time) and one reading on the 7th
+		site := database.SiteLocation()
+		day := time.Date(2025, 1, 6, 0, 0, 0, 0, site)
+		for _, r := range []struct {
+			at    time.Duration
+			value float64
+		}{
+			{22*time.Hour + 10*time.Minute, 4},
+			{22*time.Hour + 40*time.Minute, 9}, // above max_temp
+			{23*time.Hour + 5*time.Minute, 5},
+			{24*time.Hour + 30*time.Minute, 1}, // below min_temp
+		} {
+			value := r.value
+			if err := db.Create(&models.TempLog{MachineIP: "10.0.0.1", ProbeNo: 1, InsertTime: day.Add(r.at), TempValue: &value}).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s := NewRollupService(db, repository.NewGorm(db).Config)
+		if err := s.Rebuild(day, day.AddDate(0, 0, 2)); err != nil {
+			t.Fatalf("rebuild: %v", err)
+		}
+
+		var hourly []models.TempLogHourly
+		if err := db.Order("bucket_start").Find(&hourly).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(hourly) != 3 {
+			t.Fatalf("%d hourly rows, want 3", len(hourly))
+		}
+		h := hourly[0]
+		if !h.BucketStart.Equal(day.Add(22*time.Hour)) || h.ReadingCount != 2 || h.MinValue != 4 || h.MaxValue != 9 || h.AvgValue != 6.5 || h.OutOfRangeCount != 1 {
+			t.Errorf("22:00 hour = %+v", h.TempLogRollup)
+		}
+
+		var daily []models.TempLogDaily
+		if err := db.Order("bucket_start").Find(&daily).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(daily) != 2 {
+			t.Fatalf("%d daily rows, want 2", len(daily))
+		}
+		d := daily[0]
+		if !d.BucketStart.Equal(day) || d.ReadingCount != 3 || d.MinValue != 4 || d.MaxValue != 9 || d.AvgValue != 6 || d.OutOfRangeCount != 1 {
+			t.Errorf("6th = %+v", d.TempLogRollup)
+		}
+		if d := daily[1]; !d.BucketStart.Equal(day.AddDate(0, 0, 1)) || d.ReadingCount != 1 || d.OutOfRangeCount != 1 {
+			t.Errorf("7th = %+v", d.TempLogRollup)
+		}
+	})
+}
@@ -0,0 +1,481 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"tms-backend/internal/utils"
+)
+
+// Sparkplug B data types (subset used by TMS)
+const (
+	spTypeUInt64  = 8
+	spTypeDouble  = 10
+	spTypeBoolean = 11
+	spTypeString  = 12
+)
+
+const sparkplugNamespace = "spBv1.0"
+
+// SparkplugProbe is one master_machine probe as reported in DBIRTH/DDATA
+type SparkplugProbe struct {
+	ProbeNo int
+	Name    string
+	Unit    string
+	Type    string // Temperature, Humidity, Power
+	MinTemp float64
+	MaxTemp float64
+	Value   float64
+	Status  string // N=Normal, H=High, L=Low
+}
+
+// SparkplugDevice groups the probes of one machine IP; the IP is the Sparkplug device ID
+type SparkplugDevice struct {
+	DeviceID  string
+	Probes    []SparkplugProbe
+	Timestamp time.Time
+}
+
+// sparkplugMetric is a single metric in a Sparkplug B payload
+type sparkplugMetric struct {
+	name       string
+	datatype   uint32
+	value      interface{}
+	properties map[string]string
+}
+
+// sparkplugMessage is an encoded payload ready to publish
+type sparkplugMessage struct {
+	topic   string
+	payload []byte
+}
+
+// SparkplugEncoder keeps the edge node session state: bdSeq, seq and which devices have been birthed
+type SparkplugEncoder struct {
+	groupID     string
+	edgeNodeID  string
+	bdSeq       uint64 // bdSeq of the current session, announced in NDEATH and NBIRTH
+	nextBdSeq   uint64
+	seq         uint64
+	nodeBirthed bool              // NBIRTH of the current session reached the broker
+	birthed     map[string]string // deviceID -> metric signature at DBIRTH
+	mu          sync.Mutex
+}
+
+// newSparkplugEncoder reads SPARKPLUG_* settings; returns nil when Sparkplug is disabled
+func newSparkplugEncoder(clientID string) *SparkplugEncoder {
+	if enabled, _ := strconv.ParseBool(os.Getenv("MQTT_SPARKPLUG")); !enabled {
+		return nil
+	}
+
+	groupID := getEnvDefault("SPARKPLUG_GROUP_ID", "TMS")
+	edgeNodeID := os.Getenv("SPARKPLUG_EDGE_NODE_ID")
+	if edgeNodeID == "" {
+		edgeNodeID = clientID
+	}
+
+	return &SparkplugEncoder{
+		groupID:    groupID,
+		edgeNodeID: edgeNodeID,
+		birthed:    make(map[string]string),
+	}
+}
+
+// topic builds spBv1.0/{group}/{type}/{edge node}[/{device}]
+func (e *SparkplugEncoder) topic(msgType, deviceID string) string {
+	t := fmt.Sprintf("%s/%s/%s/%s", sparkplugNamespace, e.groupID, msgType, e.edgeNodeID)
+	if deviceID != "" {
+		t += "/" + deviceID
+	}
+	return t
+}
+
+// nextSeq returns the next message sequence number (0-255). Must be called with mu held.
+func (e *SparkplugEncoder) nextSeq() uint64 {
+	s := e.seq
+	e.seq = (e.seq + 1) % 256
+	return s
+}
+
+// newSession starts a new broker session: takes the next bdSeq and returns the NDEATH will.
+// Previously birthed devices must be birthed again after NBIRTH.
+func (e *SparkplugEncoder) newSession() (string, []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.bdSeq = e.nextBdSeq
+	e.nextBdSeq = (e.nextBdSeq + 1) % 256
+	e.nodeBirthed = false
+	e.birthed = make(map[string]string)
+
+	payload := encodeSparkplugPayload(time.Now(), nil, []sparkplugMetric{
+		{name: "bdSeq", datatype: spTypeUInt64, value: e.bdSeq},
+	})
+	return e.topic("NDEATH", ""), payload
+}
+
+// nodeBirth builds NBIRTH, which always restarts seq at 0
+func (e *SparkplugEncoder) nodeBirth() (string, []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.seq = 0
+	seq := e.nextSeq()
+	payload := encodeSparkplugPayload(time.Now(), &seq, []sparkplugMetric{
+		{name: "bdSeq", datatype: spTypeUInt64, value: e.bdSeq},
+		{name: "Node Control/Rebirth", datatype: spTypeBoolean, value: false},
+	})
+	return e.topic("NBIRTH", ""), payload
+}
+
+// setNodeBirthed records that NBIRTH of the current session was published
+func (e *SparkplugEncoder) setNodeBirthed() {
+	e.mu.Lock()
+	e.nodeBirthed = true
+	e.mu.Unlock()
+}
+
+// isNodeBirthed reports whether NBIRTH of the current session was published
+func (e *SparkplugEncoder) isNodeBirthed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.nodeBirthed
+}
+
+// deviceMessages builds DBIRTH (when the device is new or its probe set changed) and DDATA
+func (e *SparkplugEncoder) deviceMessages(device SparkplugDevice) []sparkplugMessage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var msgs []sparkplugMessage
+
+	signature := sparkplugSignature(device)
+	if e.birthed[device.DeviceID] != signature {
+		seq := e.nextSeq()
+		msgs = append(msgs, sparkplugMessage{
+			topic:   e.topic("DBIRTH", device.DeviceID),
+			payload: encodeSparkplugPayload(device.Timestamp, &seq, sparkplugBirthMetrics(device)),
+		})
+		e.birthed[device.DeviceID] = signature
+	}
+
+	seq := e.nextSeq()
+	msgs = append(msgs, sparkplugMessage{
+		topic:   e.topic("DDATA", device.DeviceID),
+		payload: encodeSparkplugPayload(device.Timestamp, &seq, sparkplugDataMetrics(device)),
+	})
+
+	return msgs
+}
+
+// forgetDevices clears DBIRTH state so every device is birthed again (used on Rebirth)
+func (e *SparkplugEncoder) forgetDevices() {
+	e.mu.Lock()
+	e.birthed = make(map[string]string)
+	e.mu.Unlock()
+}
+
+// sparkplugSignature identifies the birth metric set; changing limits or names requires a new DBIRTH
+func sparkplugSignature(device SparkplugDevice) string {
+	var sb strings.Builder
+	for _, p := range device.Probes {
+		fmt.Fprintf(&sb, "%d|%s|%s|%s|%g|%g;", p.ProbeNo, p.Name, p.Unit, p.Type, p.MinTemp, p.MaxTemp)
+	}
+	return sb.String()
+}
+
+// sparkplugBirthMetrics declares every metric of the device with its current value
+func sparkplugBirthMetrics(device SparkplugDevice) []sparkplugMetric {
+	var metrics []sparkplugMetric
+	for _, p := range device.Probes {
+		prefix := fmt.Sprintf("Probe %d/", p.ProbeNo)
+		unit := map[string]string{"engUnit": p.Unit}
+		metrics = append(metrics,
+			sparkplugMetric{name: prefix + "Name", datatype: spTypeString, value: p.Name},
+			sparkplugMetric{name: prefix + "Type", datatype: spTypeString, value: p.Type},
+			sparkplugMetric{name: prefix + "Min", datatype: spTypeDouble, value: p.MinTemp, properties: unit},
+			sparkplugMetric{name: prefix + "Max", datatype: spTypeDouble, value: p.MaxTemp, properties: unit},
+			sparkplugMetric{name: prefix + "Value", datatype: spTypeDouble, value: p.Value, properties: unit},
+			sparkplugMetric{name: prefix + "Status", datatype: spTypeString, value: p.Status},
+		)
+	}
+	return metrics
+}
+
+// sparkplugDataMetrics reports the current value and status of each probe
+func sparkplugDataMetrics(device SparkplugDevice) []sparkplugMetric {
+	var metrics []sparkplugMetric
+	for _, p := range device.Probes {
+		prefix := fmt.Sprintf("Probe %d/", p.ProbeNo)
+		metrics = append(metrics,
+			sparkplugMetric{name: prefix + "Value", datatype: spTypeDouble, value: p.Value},
+			sparkplugMetric{name: prefix + "Status", datatype: spTypeString, value: p.Status},
+		)
+	}
+	return metrics
+}
+
+// encodeSparkplugPayload encodes an org.eclipse.tahu.protobuf.Payload.
+// seq is nil for NDEATH, which carries no sequence number.
+func encodeSparkplugPayload(ts time.Time, seq *uint64, metrics []sparkplugMetric) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType) // timestamp
+	b = protowire.AppendVarint(b, uint64(ts.UnixMilli()))
+	for _, m := range metrics {
+		b = protowire.AppendTag(b, 2, protowire.BytesType) // metrics
+		b = protowire.AppendBytes(b, encodeSparkplugMetric(ts, m))
+	}
+	if seq != nil {
+		b = protowire.AppendTag(b, 3, protowire.VarintType) // seq
+		b = protowire.AppendVarint(b, *seq)
+	}
+	return b
+}
+
+// encodeSparkplugMetric encodes a Payload.Metric
+func encodeSparkplugMetric(ts time.Time, m sparkplugMetric) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType) // name
+	b = protowire.AppendString(b, m.name)
+	b = protowire.AppendTag(b, 3, protowire.VarintType) // timestamp
+	b = protowire.AppendVarint(b, uint64(ts.UnixMilli()))
+	b = protowire.AppendTag(b, 4, protowire.VarintType) // datatype
+	b = protowire.AppendVarint(b, uint64(m.datatype))
+
+	if len(m.properties) > 0 {
+		b = protowire.AppendTag(b, 9, protowire.BytesType) // properties
+		b = protowire.AppendBytes(b, encodeSparkplugProperties(m.properties))
+	}
+
+	switch v := m.value.(type) {
+	case uint64:
+		b = protowire.AppendTag(b, 11, protowire.VarintType) // long_value
+		b = protowire.AppendVarint(b, v)
+	case float64:
+		b = protowire.AppendTag(b, 13, protowire.Fixed64Type) // double_value
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case bool:
+		b = protowire.AppendTag(b, 14, protowire.VarintType) // boolean_value
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case string:
+		b = protowire.AppendTag(b, 15, protowire.BytesType) // string_value
+		b = protowire.AppendString(b, v)
+	}
+	return b
+}
+
+// decodeSparkplugMetrics reads the metric names and values of a Payload (NCMD/DCMD).
+// Only scalar values are decoded; other fields are skipped.
+func decodeSparkplugMetrics(b []byte) ([]sparkplugMetric, error) {
+	var metrics []sparkplugMetric
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 2 && typ == protowire.BytesType { // metrics
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m, err := decodeSparkplugMetric(v)
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, m)
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return metrics, nil
+}
+
+// decodeSparkplugMetric reads one Payload.Metric
+func decodeSparkplugMetric(b []byte) (sparkplugMetric, error) {
+	var m sparkplugMetric
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			switch num {
+			case 4: // datatype
+				m.datatype = uint32(v)
+			case 10, 11: // int_value, long_value
+				m.value = v
+			case 14: // boolean_value
+				m.value = protowire.DecodeBool(v)
+			}
+		case protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(b)
+			if num == 13 { // double_value
+				m.value = math.Float64frombits(v)
+			}
+		case protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			switch num {
+			case 1: // name
+				m.name = string(v)
+			case 15: // string_value
+				m.value = string(v)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return m, nil
+}
+
+// encodeSparkplugProperties encodes a PropertySet of string properties
+func encodeSparkplugProperties(props map[string]string) []byte {
+	var b []byte
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = protowire.AppendTag(b, 1, protowire.BytesType) // keys
+		b = protowire.AppendString(b, k)
+	}
+	for _, k := range keys {
+		var v []byte
+		v = protowire.AppendTag(v, 1, protowire.VarintType) // type
+		v = protowire.AppendVarint(v, spTypeString)
+		v = protowire.AppendTag(v, 8, protowire.BytesType) // string_value
+		v = protowire.AppendString(v, props[k])
+		b = protowire.AppendTag(b, 2, protowire.BytesType) // values
+		b = protowire.AppendBytes(b, v)
+	}
+	return b
+}
+
+// ========== MQTTService integration ==========
+
+// IsSparkplugEnabled returns whether the Sparkplug B encoder is active
+func (m *MQTTService) IsSparkplugEnabled() bool {
+	return m.enabled && m.sparkplug != nil
+}
+
+// configureSparkplug sets the NDEATH will before every (re)connect with a fresh bdSeq
+func (m *MQTTService) configureSparkplug(opts *mqtt.ClientOptions) {
+	if m.sparkplug == nil {
+		return
+	}
+
+	topic, payload := m.sparkplug.newSession()
+	opts.SetBinaryWill(topic, payload, 1, false)
+
+	opts.SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+		topic, payload := m.sparkplug.newSession()
+		opts.SetBinaryWill(topic, payload, 1, false)
+	})
+}
+
+// startSparkplugSession subscribes to NCMD and publishes NBIRTH. Called from OnConnect
+// before anything else is published in the session; devices are birthed again on their
+// next DDATA.
+func (m *MQTTService) startSparkplugSession() {
+	if m.sparkplug == nil {
+		return
+	}
+
+	cmdTopic := m.sparkplug.topic("NCMD", "")
+	if token := m.client.Subscribe(cmdTopic, 1, m.handleSparkplugCommand); token.Wait() && token.Error() != nil {
+		utils.LogError("Sparkplug subscribe to %s failed: %v", cmdTopic, token.Error())
+	}
+	m.publishNodeBirth()
+}
+
+// publishNodeBirth publishes NBIRTH. sparkplugMu keeps DBIRTH/DDATA of the alert loop
+// from going out before it.
+func (m *MQTTService) publishNodeBirth() {
+	m.sparkplugMu.Lock()
+	defer m.sparkplugMu.Unlock()
+
+	topic, payload := m.sparkplug.nodeBirth()
+	token := m.client.Publish(topic, 0, false, payload)
+	if token.Wait() && token.Error() != nil {
+		utils.LogError("Sparkplug NBIRTH publish failed: %v", token.Error())
+		return
+	}
+	m.sparkplug.setNodeBirthed()
+	log.Printf("Sparkplug NBIRTH published: %s", topic)
+}
+
+// handleSparkplugCommand answers "Node Control/Rebirth" by re-sending all births;
+// other node commands are not supported and only logged
+func (m *MQTTService) handleSparkplugCommand(client mqtt.Client, msg mqtt.Message) {
+	metrics, err := decodeSparkplugMetrics(msg.Payload())
+	if err != nil {
+		utils.LogError("Sparkplug NCMD on %s could not be decoded: %v", msg.Topic(), err)
+		return
+	}
+
+	for _, metric := range metrics {
+		if metric.name == "Node Control/Rebirth" && metric.value == true {
+			log.Printf("Sparkplug Rebirth requested on %s - rebirthing", msg.Topic())
+			m.sparkplug.forgetDevices()
+			m.publishNodeBirth()
+			return
+		}
+		log.Printf("Sparkplug NCMD %q ignored (not supported)", metric.name)
+	}
+}
+
+// PublishSparkplugDevices publishes DDATA for each device, preceded by DBIRTH when needed
+func (m *MQTTService) PublishSparkplugDevices(devices []SparkplugDevice) error {
+	if !m.IsSparkplugEnabled() {
+		return nil
+	}
+	if !m.IsConnected() {
+		return fmt.Errorf("MQTT not connected")
+	}
+
+	m.sparkplugMu.Lock()
+	defer m.sparkplugMu.Unlock()
+
+	// Nothing may precede NBIRTH in a session; the next cycle publishes once it is out
+	if !m.sparkplug.isNodeBirthed() {
+		return fmt.Errorf("Sparkplug NBIRTH not published yet")
+	}
+
+	for _, device := range devices {
+		for _, msg := range m.sparkplug.deviceMessages(device) {
+			token := m.client.Publish(msg.topic, 0, false, msg.payload)
+			token.Wait()
+			if token.Error() != nil {
+				return fmt.Errorf("Sparkplug publish to %s failed: %v", msg.topic, token.Error())
+			}
+		}
+	}
+	return nil
+}
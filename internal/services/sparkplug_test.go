@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeSparkplugMetrics(t *testing.T) {
+	payload := encodeSparkplugPayload(time.Now(), nil, []sparkplugMetric{
+		{name: "Node Control/Rebirth", datatype: spTypeBoolean, value: true},
+		{name: "bdSeq", datatype: spTypeUInt64, value: uint64(7)},
+		{name: "Probe 1/Value", datatype: spTypeDouble, value: 4.5, properties: map[string]string{"engUnit": "°C"}},
+		{name: "Probe 1/Status", datatype: spTypeString, value: "N"},
+	})
+
+	metrics, err := decodeSparkplugMetrics(payload)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []sparkplugMetric{
+		{name: "Node Control/Rebirth", datatype: spTypeBoolean, value: true},
+		{name: "bdSeq", datatype: spTypeUInt64, value: uint64(7)},
+		{name: "Probe 1/Value", datatype: spTypeDouble, value: 4.5},
+		{name: "Probe 1/Status", datatype: spTypeString, value: "N"},
+	}
+	if len(metrics) != len(want) {
+		t.Fatalf("got %d metrics, want %d", len(metrics), len(want))
+	}
+	for i, m := range metrics {
+		if m.name != want[i].name || m.datatype != want[i].datatype || m.value != want[i].value {
+			t.Errorf("metric %d = %+v, want %+v", i, m, want[i])
+		}
+	}
+
+	if _, err := decodeSparkplugMetrics([]byte{0x12, 0x05, 0x0a}); err == nil {
+		t.Error("truncated payload decoded without error")
+	}
+}
+
+func TestSparkplugSessionNeedsNodeBirth(t *testing.T) {
+	e := &SparkplugEncoder{groupID: "TMS", edgeNodeID: "edge", birthed: make(map[string]string)}
+
+	e.newSession()
+	if e.isNodeBirthed() {
+		t.Fatal("new session is birthed before NBIRTH")
+	}
+	e.nodeBirth()
+	e.setNodeBirthed()
+	msgs := e.deviceMessages(SparkplugDevice{DeviceID: "10.0.0.1", Probes: []SparkplugProbe{{ProbeNo: 1}}})
+	if len(msgs) != 2 || msgs[0].topic != "spBv1.0/TMS/DBIRTH/edge/10.0.0.1" {
+		t.Fatalf("first publish after NBIRTH = %v, want DBIRTH then DDATA", msgs)
+	}
+
+	// A reconnect starts over: no DDATA until the new NBIRTH is out, then DBIRTH again
+	e.newSession()
+	if e.isNodeBirthed() {
+		t.Fatal("reconnected session still counts the previous NBIRTH")
+	}
+	e.nodeBirth()
+	e.setNodeBirthed()
+	msgs = e.deviceMessages(SparkplugDevice{DeviceID: "10.0.0.1", Probes: []SparkplugProbe{{ProbeNo: 1}}})
+	if len(msgs) != 2 {
+		t.Fatalf("device not birthed again after reconnect: %v", msgs)
+	}
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// Stats bucket sizes
+const (
+	StatsBucketHour = "hour"
+	StatsBucketDay  = "day"
+	StatsBucketWeek = "week"
+)
+
+// statsMaxReadingGap caps how long one reading is assumed to hold. A longer gap to the
+// next reading means the probe was offline, and that time counts neither in nor out of range.
+const statsMaxReadingGap = 15 * time.Minute
+
+// statsBucketExpr truncates a datetime column to the bucket start (weeks start on Monday)
+var statsBucketExpr = map[string]func(column string) string{
+	StatsBucketHour: database.HourBucket,
+	StatsBucketDay:  database.DayBucket,
+	StatsBucketWeek: database.WeekBucket,
+}
+
+// IsValidStatsBucket reports whether bucket is hour, day or week
+func IsValidStatsBucket(bucket string) bool {
+	_, ok := statsBucketExpr[bucket]
+	return ok
+}
+
+// StatsQuery selects the probes and period for ProbeStatistics
+type StatsQuery struct {
+	From       time.Time // inclusive
+	To         time.Time // exclusive
+	Bucket     string
+	Devices    []string // "ip" or "ip:probe"; empty = all
+	AllowedIPs []string // the caller's device scope; nil = unrestricted
+}
+
+// StatsValues are the aggregates for one probe over a bucket or the whole period
+type StatsValues struct {
+	Readings         int      `json:"readings"`
+	Min              *float64 `json:"min"`
+	Max              *float64 `json:"max"`
+	Mean             *float64 `json:"mean"`
+	StdDev           *float64 `json:"stddev"`
+	InRangePercent   *float64 `json:"inRangePercent"` // share of covered time inside min/max
+	CoveredMinutes   float64  `json:"coveredMinutes"` // time with readings, gaps over 15 minutes excluded
+	Excursions       int      `json:"excursions"`     // temp_error rows raised
+	ExcursionMinutes float64  `json:"excursionMinutes"`
+
+	sum   float64
+	sumSq float64
+}
+
+// StatsBucket is one bucket of a probe's statistics
+type StatsBucket struct {
+	Start time.Time `json:"start"`
+	StatsValues
+}
+
+// ProbeStats holds a probe's statistics per bucket and for the whole period
+type ProbeStats struct {
+	MachineIP   string        `json:"machineIp"`
+	ProbeNo     int           `json:"probeNo"`
+	MachineName string        `json:"machineName"`
+	Unit        string        `json:"unit"`
+	MinTemp     float64       `json:"minTemp"`
+	MaxTemp     float64       `json:"maxTemp"`
+	Summary     StatsValues   `json:"summary"`
+	Buckets     []StatsBucket `json:"buckets"`
+}
+
+// statsRow is one (probe, bucket) aggregate row from temp_log
+type statsRow struct {
+	MachineIP        string   `gorm:"column:machine_ip"`
+	ProbeNo          int      `gorm:"column:probe_no"`
+	Bucket           string   `gorm:"column:bucket"`
+	Readings         int      `gorm:"column:readings"`
+	MinValue         *float64 `gorm:"column:min_value"`
+	MaxValue         *float64 `gorm:"column:max_value"`
+	SumValue         float64  `gorm:"column:sum_value"`
+	SumSqValue       float64  `gorm:"column:sum_sq_value"`
+	CoveredSeconds   float64  `gorm:"column:covered_seconds"`
+	ExcursionSeconds float64  `gorm:"column:excursion_seconds"`
+}
+
+// ProbeStatistics aggregates temp_log and temp_error in SQL per probe and bucket.
+// Time in range is weighted by how long each reading held until the next one
+// (window functions: MariaDB 10.2+ / MySQL 8 / PostgreSQL / SQLite 3.25+). Probe names,
+// units and limits come from devices.
+func ProbeStatistics(db *gorm.DB, devices repository.DeviceRepo, q StatsQuery) ([]ProbeStats, error) {
+	bucketExpr, ok := statsBucketExpr[q.Bucket]
+	if !ok {
+		return nil, fmt.Errorf("unknown bucket %q", q.Bucket)
+	}
+
+	// Seconds until the probe's next reading, capped at statsMaxReadingGap
+	untilNext := database.SecondsBetween("t.insert_time",
+		"LEAD(t.insert_time) OVER (PARTITION BY t.machine_ip, t.probe_no ORDER BY t.insert_time)")
+	seconds := database.Least(fmt.Sprintf("COALESCE(%s, 0)", untilNext), "?")
+
+	readings := db.Table("temp_log t").
+		Select(fmt.Sprintf(`t.machine_ip, t.probe_no, t.insert_time, t.temp_value,
+			CASE WHEN t.temp_value < COALESCE(m.min_temp, 0) OR t.temp_value > COALESCE(m.max_temp, 100) THEN 1 ELSE 0 END AS out_of_range,
+			%s AS seconds`, seconds),
+			int(statsMaxReadingGap.Seconds())).
+		Joins("JOIN master_machine m ON m.machine_ip = t.machine_ip AND m.probe_no = t.probe_no").
+		Where("t.insert_time >= ? AND t.insert_time < ? AND t.temp_value IS NOT NULL", q.From, q.To)
+	readings = q.filter(readings, "t.")
+
+	var rows []statsRow
+	if err := db.Table("(?) AS r", readings).
+		Select(fmt.Sprintf(`machine_ip, probe_no, %s AS bucket,
+			COUNT(*) AS readings,
+			MIN(temp_value) AS min_value,
+			MAX(temp_value) AS max_value,
+			SUM(temp_value) AS sum_value,
+			SUM(temp_value * temp_value) AS sum_sq_value,
+			SUM(seconds) AS covered_seconds,
+			SUM(CASE WHEN out_of_range = 1 THEN seconds ELSE 0 END) AS excursion_seconds`,
+			bucketExpr("insert_time"))).
+		Group("machine_ip, probe_no, bucket").
+		Order("machine_ip, probe_no, bucket").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to aggregate temp_log: %w", err)
+	}
+
+	var excursions []struct {
+		MachineIP string `gorm:"column:machine_ip"`
+		ProbeNo   int    `gorm:"column:probe_no"`
+		Bucket    string `gorm:"column:bucket"`
+		Count     int    `gorm:"column:count"`
+	}
+	errorsQuery := db.Model(&models.TempError{}).
+		Select(fmt.Sprintf("machine_ip, probe_no, %s AS bucket, COUNT(*) AS count", bucketExpr("error_time"))).
+		Where("error_time >= ? AND error_time < ?", q.From, q.To)
+	if err := q.filter(errorsQuery, "").
+		Group("machine_ip, probe_no, bucket").
+		Scan(&excursions).Error; err != nil {
+		return nil, fmt.Errorf("failed to count temp_error: %w", err)
+	}
+
+	machines, err := LoadReportProbes(devices)
+	if err != nil {
+		return nil, err
+	}
+
+	excursionCounts := make(map[string]int, len(excursions))
+	for _, e := range excursions {
+		excursionCounts[fmt.Sprintf("%s:%d|%s", e.MachineIP, e.ProbeNo, e.Bucket)] = e.Count
+	}
+
+	loc := q.From.Location()
+	result := make([]ProbeStats, 0)
+	index := make(map[string]int)
+	addBucket := func(ip string, probeNo int, bucket string, values StatsValues) error {
+		start, err := time.ParseInLocation("2006-01-02 15:04:05", bucket, loc)
+		if err != nil {
+			return fmt.Errorf("unexpected bucket %q: %w", bucket, err)
+		}
+		key := fmt.Sprintf("%s:%d", ip, probeNo)
+		i, ok := index[key]
+		if !ok {
+			m := machines[key]
+			i = len(result)
+			index[key] = i
+			result = append(result, ProbeStats{
+				MachineIP:   ip,
+				ProbeNo:     probeNo,
+				MachineName: m.MachineName,
+				Unit:        m.Unit,
+				MinTemp:     m.MinTemp,
+				MaxTemp:     m.MaxTemp,
+				Buckets:     make([]StatsBucket, 0),
+			})
+		}
+		result[i].Buckets = append(result[i].Buckets, StatsBucket{Start: start, StatsValues: values})
+		return nil
+	}
+
+	for _, r := range rows {
+		key := fmt.Sprintf("%s:%d|%s", r.MachineIP, r.ProbeNo, r.Bucket)
+		err := addBucket(r.MachineIP, r.ProbeNo, r.Bucket, StatsValues{
+			Readings:         r.Readings,
+			Min:              r.MinValue,
+			Max:              r.MaxValue,
+			CoveredMinutes:   r.CoveredSeconds / 60,
+			Excursions:       excursionCounts[key],
+			ExcursionMinutes: r.ExcursionSeconds / 60,
+			sum:              r.SumValue,
+			sumSq:            r.SumSqValue,
+		})
+		if err != nil {
+			return nil, err
+		}
+		delete(excursionCounts, key)
+	}
+	// Alerts raised in a bucket without readings still get a bucket of their own
+	for _, e := range excursions {
+		if _, ok := excursionCounts[fmt.Sprintf("%s:%d|%s", e.MachineIP, e.ProbeNo, e.Bucket)]; !ok {
+			continue
+		}
+		if err := addBucket(e.MachineIP, e.ProbeNo, e.Bucket, StatsValues{Excursions: e.Count}); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].MachineIP != result[j].MachineIP {
+			return result[i].MachineIP < result[j].MachineIP
+		}
+		return result[i].ProbeNo < result[j].ProbeNo
+	})
+	for i := range result {
+		p := &result[i]
+		sort.SliceStable(p.Buckets, func(a, b int) bool { return p.Buckets[a].Start.Before(p.Buckets[b].Start) })
+		for j := range p.Buckets {
+			p.Buckets[j].finish()
+			p.Summary.add(p.Buckets[j].StatsValues)
+		}
+		p.Summary.finish()
+	}
+	return result, nil
+}
+
+// filter applies the device selectors and the caller's scope; prefix qualifies the columns
+func (q StatsQuery) filter(db *gorm.DB, prefix string) *gorm.DB {
+	if q.AllowedIPs != nil {
+		db = db.Where(prefix+"machine_ip IN ?", q.AllowedIPs)
+	}
+	if len(q.Devices) == 0 {
+		return db
+	}
+
+	conds := make([]string, 0, len(q.Devices))
+	args := make([]interface{}, 0, 2*len(q.Devices))
+	for _, d := range q.Devices {
+		if ip, probe, ok := strings.Cut(d, ":"); ok {
+			probeNo, _ := strconv.Atoi(probe)
+			conds = append(conds, fmt.Sprintf("(%smachine_ip = ? AND %sprobe_no = ?)", prefix, prefix))
+			args = append(args, ip, probeNo)
+		} else {
+			conds = append(conds, prefix+"machine_ip = ?")
+			args = append(args, d)
+		}
+	}
+	return db.Where("("+strings.Join(conds, " OR ")+")", args...)
+}
+
+// add merges a bucket into a running total
+func (v *StatsValues) add(b StatsValues) {
+	if b.Readings > 0 {
+		if v.Min == nil || *b.Min < *v.Min {
+			v.Min = b.Min
+		}
+		if v.Max == nil || *b.Max > *v.Max {
+			v.Max = b.Max
+		}
+	}
+	v.Readings += b.Readings
+	v.sum += b.sum
+	v.sumSq += b.sumSq
+	v.CoveredMinutes += b.CoveredMinutes
+	v.Excursions += b.Excursions
+	v.ExcursionMinutes += b.ExcursionMinutes
+}
+
+// finish derives mean, population standard deviation and time in range from the sums
+func (v *StatsValues) finish() {
+	if v.Readings > 0 {
+		mean := v.sum / float64(v.Readings)
+		stddev := math.Sqrt(math.Max(0, v.sumSq/float64(v.Readings)-mean*mean))
+		v.Mean, v.StdDev = &mean, &stddev
+	}
+	if v.CoveredMinutes > 0 {
+		inRange := 100 * (v.CoveredMinutes - v.ExcursionMinutes) / v.CoveredMinutes
+		v.InRangePercent = &inRange
+	}
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"tms-backend/internal/database"
+	"tms-backend/internal/database/dbtest"
+	"tms-backend/internal/models"
+	"tms-backend/internal/repository"
+)
+
+// bucketValues builds the per-bucket values the SQL query returns for readings
+func bucketValues(covered, excursion float64, readings ...float64) StatsValues {
+	v := StatsValues{Readings: len(readings), CoveredMinutes: covered, ExcursionMinutes: excursion}
+	for i := range readings {
+		r := readings[i]
+		if v.Min == nil || r < *v.Min {
+			v.Min = &readings[i]
+		}
+		if v.Max == nil || r > *v.Max {
+			v.Max = &readings[i]
+		}
+		v.sum += r
+		v.sumSq += r * r
+	}
+	return v
+}
+
+func TestStatsValuesSummary(t *testing.T) {
+	// Two buckets of 2, 4 and 4, 4, 5, 5, 7, 9: mean 5, population stddev 2
+	var summary StatsValues
+	summary.add(bucketValues(60, 0, 2, 4))
+	summary.add(bucketValues(180, 30, 4, 4, 5, 5, 7, 9))
+	summary.add(StatsValues{Excursions: 2}) // a bucket with alerts but no readings
+	summary.finish()
+
+	if summary.Readings != 8 || *summary.Min != 2 || *summary.Max != 9 {
+		t.Errorf("readings/min/max = %d/%v/%v, want 8/2/9", summary.Readings, *summary.Min, *summary.Max)
+	}
+	if math.Abs(*summary.Mean-5) > 1e-9 || math.Abs(*summary.StdDev-2) > 1e-9 {
+		t.Errorf("mean/stddev = %v/%v, want 5/2", *summary.Mean, *summary.StdDev)
+	}
+	if summary.CoveredMinutes != 240 || summary.ExcursionMinutes != 30 || summary.Excursions != 2 {
+		t.Errorf("covered/excursion minutes/excursions = %v/%v/%d, want 240/30/2",
+			summary.CoveredMinutes, summary.ExcursionMinutes, summary.Excursions)
+	}
+	if *summary.InRangePercent != 87.5 {
+		t.Errorf("inRangePercent = %v, want 87.5", *summary.InRangePercent)
+	}
+}
+
+func TestStatsValuesFinish(t *testing.T) {
+	v := bucketValues(0, 0, 1, 3)
+	v.finish()
+	if *v.Mean != 2 || *v.StdDev != 1 {
+		t.Errorf("mean/stddev = %v/%v, want 2/1", *v.Mean, *v.StdDev)
+	}
+	if v.InRangePercent != nil {
+		t.Errorf("inRangePercent = %v without covered time, want nil", *v.InRangePercent)
+	}
+
+	// Rounding must not produce NaN for a constant series
+	v = bucketValues(10, 10, 0.1, 0.1, 0.1)
+	v.finish()
+	if math.IsNaN(*v.StdDev) || *v.StdDev > 1e-6 || *v.InRangePercent != 0 {
+		t.Errorf("stddev/inRangePercent = %v/%v, want 0/0", *v.StdDev, *v.InRangePercent)
+	}
+
+	var empty StatsValues
+	empty.finish()
+	if empty.Mean != nil || empty.StdDev != nil || empty.InRangePercent != nil {
+		t.Errorf("empty values = %+v, want no mean, stddev or percentage", empty)
+	}
+}
+
+func TestIsValidStatsBucket(t *testing.T) {
+	for bucket, want := range map[string]bool{"hour": true, "day": true, "week": true, "month": false, "": false, "Day": false} {
+		if got := IsValidStatsBucket(bucket); got != want {
+			t.Errorf("IsValidStatsBucket(%q) = %v, want %v", bucket, got, want)
+		}
+	}
+}
+
+func TestStatsQueryFilter(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user@tcp(localhost:3306)/tms", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query StatsQuery
+		where string
+		vars  []interface{}
+	}{
+		{"all devices", StatsQuery{}, "", nil},
+		{"ip and ip:probe", StatsQuery{Devices: []string{"10.0.0.5", "10.0.0.6:2"}},
+			"WHERE (t.machine_ip = ? OR (t.machine_ip = ? AND t.probe_no = ?))",
+			[]interface{}{"10.0.0.5", "10.0.0.6", 2}},
+		{"scope", StatsQuery{AllowedIPs: []string{"10.0.0.5"}, Devices: []string{"10.0.0.5:1"}},
+			"WHERE t.machine_ip IN (?) AND (((t.machine_ip = ? AND t.probe_no = ?)))",
+			[]interface{}{"10.0.0.5", "10.0.0.5", 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var rows []statsRow
+			stmt := tc.query.filter(db.Table("temp_log t"), "t.").Find(&rows).Statement
+			sql := stmt.SQL.String()
+			want := "SELECT * FROM temp_log t"
+			if tc.where != "" {
+				want += " " + tc.where
+			}
+			if sql != want {
+				t.Errorf("SQL = %s\nwant  %s", sql, want)
+			}
+			if len(tc.vars) > 0 && !reflect.DeepEqual(stmt.Vars, tc.vars) {
+				t.Errorf("vars = %v, want %v", stmt.Vars, tc.vars)
+			}
+		})
+	}
+}
+
+func TestProbeStatistics(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, db *gorm.DB) {
+		minTemp, maxTemp := 2.0, 8.0
+		for _, m := range []models.MasterMachine{
+			{MachineIP: "10.0.0.5", ProbeNo: 1, ProbeAll: 2, MachineName: "Fridge", MinTemp: &minTemp, MaxTemp: &maxTemp},
+			{MachineIP: "10.0.0.5", ProbeNo: 2, ProbeAll: 2, MachineName: "Fridge", MinTemp: &minTemp, MaxTemp: &maxTemp},
+			{MachineIP: "10.0.0.6", ProbeNo: 1, ProbeAll: 1, MachineName: "Freezer"},
+		} {
+			if err := db.Create(&m).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		day := time.Date(2025, 1, 6, 0, 0, 0, 0, database.SiteLocation())
+		at := func(hour, minute int) time.Time {
+			return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+		}
+		for _, r := range []struct {
+			ip    string
+			probe int
+			at    time.Time
+			value float64
+		}{
+			{"10.0.0.5", 1, at(8, 0), 4},   // 10 minutes in range
+			{"10.0.0.5", 1, at(8, 10), 10}, // 10 minutes out of range
+			{"10.0.0.5", 1, at(8, 20), 6},  // 40 minutes to the next reading: counted as 15, the rest is a gap
+			{"10.0.0.5", 1, at(9, 0), 5},   // last reading holds for 0 minutes
+			{"10.0.0.5", 1, at(-1, 0), 20}, // the day before
+			{"10.0.0.6", 1, at(8, 0), -18}, // another device
+			{"10.0.0.6", 1, at(8, 5), -18.5},
+		} {
+			value := r.value
+			if err := db.Create(&models.TempLog{MachineIP: r.ip, ProbeNo: r.probe, TempValue: &value, InsertTime: r.at}).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Create(&models.TempError{MachineIP: "10.0.0.5", ProbeNo: 1, ErrorTime: at(8, 10)}).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := ProbeStatistics(db, repository.NewGorm(db).Devices, StatsQuery{From: day, To: day.AddDate(0, 0, 1), Bucket: StatsBucketHour, Devices: []string{"10.0.0.5"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 1 || stats[0].MachineIP != "10.0.0.5" || stats[0].ProbeNo != 1 || stats[0].MachineName != "Fridge" {
+			t.Fatalf("stats = %+v, want probe 10.0.0.5:1 only", stats)
+		}
+		p := stats[0]
+		if len(p.Buckets) != 2 || !p.Buckets[0].Start.Equal(at(8, 0)) || !p.Buckets[1].Start.Equal(at(9, 0)) {
+			t.Fatalf("buckets = %+v, want 08:00 and 09:00", p.Buckets)
+		}
+
+		eight := p.Buckets[0]
+		if eight.Readings != 3 || *eight.Min != 4 || *eight.Max != 10 || eight.Excursions != 1 {
+			t.Errorf("08:00 readings/min/max/excursions = %d/%v/%v/%d, want 3/4/10/1", eight.Readings, *eight.Min, *eight.Max, eight.Excursions)
+		}
+		if eight.CoveredMinutes != 35 || eight.ExcursionMinutes != 10 {
+			t.Errorf("08:00 covered/excursion minutes = %v/%v, want 35/10", eight.CoveredMinutes, eight.ExcursionMinutes)
+		}
+
+		s := p.Summary
+		if s.Readings != 4 || *s.Min != 4 || *s.Max != 10 || s.Excursions != 1 {
+			t.Errorf("summary readings/min/max/excursions = %d/%v/%v/%d, want 4/4/10/1", s.Readings, *s.Min, *s.Max, s.Excursions)
+		}
+		// 4, 10, 6, 5: mean 6.25, population variance 5.1875
+		if math.Abs(*s.Mean-6.25) > 1e-9 || math.Abs(*s.StdDev-math.Sqrt(5.1875)) > 1e-9 {
+			t.Errorf("summary mean/stddev = %v/%v, want 6.25/%v", *s.Mean, *s.StdDev, math.Sqrt(5.1875))
+		}
+		if math.Abs(*s.InRangePercent-100*25.0/35) > 1e-9 {
+			t.Errorf("summary inRangePercent = %v, want %v", *s.InRangePercent, 100*25.0/35)
+		}
+
+		// Selectors by probe, by IP and the caller's scope
+		tests := []struct {
+			query StatsQuery
+			want  []string
+		}{
+			{StatsQuery{Devices: []string{"10.0.0.6:1", "10.0.0.5:2"}}, []string{"10.0.0.6:1"}},
+			{StatsQuery{}, []string{"10.0.0.5:1", "10.0.0.6:1"}},
+			{StatsQuery{AllowedIPs: []string{"10.0.0.6"}}, []string{"10.0.0.6:1"}},
+			{StatsQuery{AllowedIPs: []string{"10.0.0.6"}, Devices: []string{"10.0.0.5"}}, []string{}},
+		}
+		for _, tc := range tests {
+			tc.query.From, tc.query.To, tc.query.Bucket = day, day.AddDate(0, 0, 1), StatsBucketDay
+			stats, err := ProbeStatistics(db, repository.NewGorm(db).Devices, tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(stats))
+			for _, p := range stats {
+				got = append(got, fmt.Sprintf("%s:%d", p.MachineIP, p.ProbeNo))
+				if len(p.Buckets) != 1 || !p.Buckets[0].Start.Equal(day) {
+					t.Errorf("%+v: day buckets of %s:%d = %+v", tc.query, p.MachineIP, p.ProbeNo, p.Buckets)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("devices %v, scope %v: probes = %v, want %v", tc.query.Devices, tc.query.AllowedIPs, got, tc.want)
+			}
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -14,6 +15,7 @@ import (
 
 	"tms-backend/internal/database"
 	"tms-backend/internal/handlers"
+	"tms-backend/internal/repository"
 	"tms-backend/internal/services"
 	"tms-backend/internal/tray"
 	"tms-backend/internal/utils"
@@ -51,6 +53,13 @@ func startServer() {
 		log.Println("No .env file found, using environment variables")
 	}
 
+	// Site timezone first: everything that reads the clock or the database depends on it
+	if err := database.SetupSiteTimezone(); err != nil {
+		log.Printf("Invalid timezone configuration: %v", err)
+		tray.SetError("Invalid SITE_TIMEZONE")
+		return
+	}
+
 	// Initialize error logger
 	if err := utils.InitLogger(); err != nil {
 		log.Printf("Failed to initialize error logger: %v", err)
@@ -82,6 +91,31 @@ func startServer() {
 	}
 	log.Println("Database connected successfully")
 
+	// Apply pending schema migrations (DB_AUTO_MIGRATE=false to run them with "tms-backend migrate")
+	if autoMigrate, err := strconv.ParseBool(os.Getenv("DB_AUTO_MIGRATE")); err != nil || autoMigrate {
+		if err := database.Migrate(); err != nil {
+			utils.LogError("Failed to migrate database schema: %v", err)
+			log.Printf("Schema migration failed: %v (continuing)", err)
+		}
+	}
+
+	// Device, reading, incident, config and user storage goes through the repositories
+	repos := repository.NewGorm(database.DB)
+
+	// Refuse to start without a JWT secret: a generated one would log everyone out on restart
+	authService, err := services.NewAuthService(repos.Users)
+	if err != nil {
+		utils.LogError("Failed to start API: %v", err)
+		log.Printf("Failed to start API: %v (set JWT_SECRET in .env)", err)
+		tray.SetError("JWT_SECRET not configured")
+		return
+	}
+
+	// Promote the first admin on databases that only have legacy (role-less) users
+	if err := handlers.BootstrapAdmin(repos.Users, os.Getenv("BOOTSTRAP_ADMIN")); err != nil {
+		utils.LogError("Failed to bootstrap admin user: %v", err)
+	}
+
 	// Initialize MQTT service with retry
 	log.Println("Initializing MQTT service...")
 	services.GlobalMQTTService = services.NewMQTTService()
@@ -103,7 +137,12 @@ func startServer() {
 
 	// Initialize Polling service (after MQTT is ready)
 	log.Println("Initializing polling service...")
-	services.GlobalPollingService = services.NewPollingService()
+	services.GlobalReadingJournal = services.NewReadingJournal(repos.Readings)
+	services.GlobalPollingService = services.NewPollingService(database.DB, repos, services.GlobalReadingJournal)
+	services.GlobalReportScheduler = services.NewReportScheduler(database.DB, repos)
+	services.GlobalRollupService = services.NewRollupService(database.DB, repos.Config)
+	services.GlobalRetentionService = services.NewRetentionService(database.DB, services.GlobalRollupService)
+	h := handlers.New(database.DB, repos, authService)
 
 	// Initialize Fiber app
 	fiberApp = fiber.New(fiber.Config{
@@ -113,44 +152,122 @@ func startServer() {
 
 	// Middleware
 	fiberApp.Use(fiberlogger.New())
-	fiberApp.Use(cors.New())
+	fiberApp.Use(cors.New(cors.Config{
+		ExposeHeaders: "X-Next-Cursor, X-Total-Count, Content-Disposition",
+	}))
 
 	// Health check
 	fiberApp.Get("/health", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"status": "ok"})
+		backlog := 0
+		if services.GlobalReadingJournal != nil {
+			backlog = services.GlobalReadingJournal.Backlog()
+		}
+		return c.JSON(fiber.Map{"status": "ok", "journalBacklog": backlog})
 	})
 
 	// API routes
 	api := fiberApp.Group("/api")
 
+	// Public routes
+	api.Post("/auth/login", h.Login)
+	api.Post("/auth/refresh", h.RefreshToken)
+
+	// Push ingestion from IoT gateways (authenticated by per-gateway API key)
+	api.Post("/ingest", handlers.IngestAPIKeyAuth, h.IngestReadings)
+
+	// SSE for real-time updates (EventSource may pass the token as ?access_token=)
+	api.Get("/temperature-stream", h.RequireStreamAuth, h.TemperatureStream)
+
+	// Everything registered below requires a valid access token
+	api.Use(h.RequireAuth)
+
+	// Role requirements: viewers read, operators acknowledge and poll, admins change configuration
+	operator := handlers.RequireRole(handlers.RoleOperator)
+	admin := handlers.RequireRole(handlers.RoleAdmin)
+
+	// Session
+	api.Get("/auth/me", h.GetMe)
+	api.Put("/auth/password", h.ChangePassword)
+
+	// User management
+	api.Get("/users", admin, h.GetUsers)
+	api.Post("/users", admin, h.CreateUser)
+	api.Put("/users/:id", admin, h.UpdateUser)
+	api.Delete("/users/:id", admin, h.DeleteUser)
+	api.Get("/users/:id/devices", admin, h.GetUserDevices)
+	api.Put("/users/:id/devices", admin, h.SetUserDevices)
+
 	// Device routes
-	api.Get("/devices", handlers.GetDevices)
-	api.Get("/devices/:id", handlers.GetDevice)
-	api.Post("/devices", handlers.CreateDevice)
-	api.Put("/devices/:id", handlers.UpdateDevice)
-	api.Delete("/devices/:id", handlers.DeleteDevice)
+	api.Get("/devices", h.GetDevices)
+	api.Get("/devices/:id", h.GetDevice)
+	api.Post("/devices", admin, h.CreateDevice)
+	api.Put("/devices/:id", admin, h.UpdateDevice)
+	api.Delete("/devices/:id", admin, h.DeleteDevice)
+
+	// Device group routes (one entry per IP with all probes)
+	api.Get("/device-groups", h.GetDeviceGroups)
+	api.Get("/device-groups/:ip", h.GetDeviceGroup)
+	api.Put("/device-groups/:ip", admin, h.UpdateDeviceGroup)
+	api.Delete("/device-groups/:ip", admin, h.DeleteDeviceGroup)
 
 	// Machine routes (legacy compatibility)
-	api.Get("/machines", handlers.GetMachines)
-	api.Put("/machines/:machineIp/:probeNo", handlers.UpdateMachine)
+	api.Get("/machines", h.GetMachines)
+	api.Put("/machines/:machineIp/:probeNo", admin, h.UpdateMachine)
+
+	// Configuration audit trail
+	api.Get("/audit", h.GetAuditLogs)
+
+	// Live readings served from memory
+	api.Get("/readings/live", h.GetLiveReadings)
 
 	// Temperature logs
-	api.Get("/temp-logs", handlers.GetTempLogs)
-	api.Get("/reports/templog", handlers.GetTempLogReport)
+	api.Get("/temp-logs", h.GetTempLogs)
+	api.Get("/reports/templog", h.GetTempLogReport)
+	api.Get("/reports/compliance.pdf", h.GetComplianceReportPDF)
+	api.Get("/stats", h.GetStats)
+	api.Post("/rollups/rebuild", admin, h.RebuildRollups)
+
+	// Retention / archived temp_log months
+	api.Get("/archives", admin, h.GetArchives)
+	api.Post("/archives/run", admin, h.RunRetention)
+	api.Post("/archives/:id/restore", admin, h.RestoreArchive)
+
+	// Mean Kinetic Temperature
+	api.Get("/mkt", h.GetMKT)
+	api.Get("/mkt/settings", h.GetMKTSettings)
+	api.Put("/mkt/settings", admin, h.UpdateMKTSettings)
+
+	// Scheduled report delivery
+	api.Get("/report-schedules", admin, h.GetReportSchedules)
+	api.Post("/report-schedules", admin, h.CreateReportSchedule)
+	api.Put("/report-schedules/:id", admin, h.UpdateReportSchedule)
+	api.Delete("/report-schedules/:id", admin, h.DeleteReportSchedule)
+	api.Post("/report-schedules/:id/run", admin, h.RunReportSchedule)
+	api.Get("/report-schedules/:id/runs", admin, h.GetReportRuns)
 
 	// Temperature errors
-	api.Get("/temp-errors", handlers.GetTempErrors)
+	api.Get("/temp-errors", h.GetTempErrors)
+	api.Post("/temp-errors/ack", operator, h.AcknowledgeTempError)
 
 	// Polling control
-	api.Get("/poll", handlers.TriggerPoll)
-
-	// SSE for real-time updates
-	api.Get("/temperature-stream", handlers.TemperatureStream)
+	api.Get("/poll", operator, h.TriggerPoll)
 
 	// Start polling service
 	log.Println("Starting polling service...")
 	go services.GlobalPollingService.Start()
 
+	// Start reading journal replay (readings buffered while the database was down)
+	services.GlobalReadingJournal.Start()
+
+	// Start report scheduler
+	services.GlobalReportScheduler.Start()
+
+	// Start hourly/daily rollups (backfills existing history on first run)
+	services.GlobalRollupService.Start()
+
+	// Start data retention (archives old temp_log months when configured)
+	services.GlobalRetentionService.Start()
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -175,6 +292,18 @@ func cleanup() {
 	if services.GlobalPollingService != nil {
 		services.GlobalPollingService.Stop()
 	}
+	if services.GlobalReadingJournal != nil {
+		services.GlobalReadingJournal.Stop()
+	}
+	if services.GlobalReportScheduler != nil {
+		services.GlobalReportScheduler.Stop()
+	}
+	if services.GlobalRetentionService != nil {
+		services.GlobalRetentionService.Stop()
+	}
+	if services.GlobalRollupService != nil {
+		services.GlobalRollupService.Stop()
+	}
 	if services.GlobalMQTTService != nil {
 		services.GlobalMQTTService.Disconnect()
 	}
@@ -185,6 +314,11 @@ func cleanup() {
 }
 
 func main() {
+	// Maintenance commands run once in the console instead of starting the tray app
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// --- STARTUP DIAGNOSTIC ---
 	// Write a debug file to diagnose startup issues
 	// This runs before anything else so we can tell if the program starts at all